@@ -54,6 +54,7 @@ func main() {
 		flagSet.BoolVarP(&cliOptions.DisableHTTPFallback, "no-http-fallback", "nf", false, "disable http fallback registration"),
 		flagSet.IntVarP(&cliOptions.CorrelationIdLength, "correlation-id-length", "cidl", settings.CorrelationIdLengthDefault, fmt.Sprintf("length of the correlation id preamble (min %d, default %d)", settings.CorrelationIdLengthMinimum, settings.CorrelationIdLengthDefault)),
 		flagSet.IntVarP(&cliOptions.CorrelationIdNonceLength, "correlation-id-nonce-length", "cidn", settings.CorrelationIdNonceLengthDefault, fmt.Sprintf("length of the correlation id nonce (min %d, default %d)", settings.CorrelationIdNonceLengthMinimum, settings.CorrelationIdNonceLengthDefault)),
+		flagSet.StringVarP(&cliOptions.CorrelationSalt, "correlation-salt", "csalt", "", "correlation salt of the interactsh server to register with"),
 		flagSet.StringVarP(&cliOptions.SessionFile, "session-file", "sf", "", "store/read from session file"),
 		flagSet.DurationVarP(&cliOptions.KeepAliveInterval, "keep-alive-interval", "kai", time.Minute, "keep alive interval"),
 	)
@@ -164,6 +165,7 @@ func main() {
 		DisableHTTPFallback:      cliOptions.DisableHTTPFallback,
 		CorrelationIdLength:      cliOptions.CorrelationIdLength,
 		CorrelationIdNonceLength: cliOptions.CorrelationIdNonceLength,
+		CorrelationSalt:          cliOptions.CorrelationSalt,
 		SessionInfo:              sessionInfo,
 	})
 	if err != nil {
@@ -215,9 +217,13 @@ func main() {
 			builder := &bytes.Buffer{}
 
 			switch interaction.Protocol {
-			case "dns":
+			case "dns", "doh":
 				if noFilter || cliOptions.DNSOnly {
-					fmt.Fprintf(builder, "[%s] Received DNS interaction (%s) from %s at %s", interaction.FullId, interaction.QType, interaction.RemoteAddress, interaction.Timestamp.Format("2006-01-02 15:04:05"))
+					kind := "DNS"
+					if interaction.DoH {
+						kind = "DNS over HTTPS"
+					}
+					fmt.Fprintf(builder, "[%s] Received %s interaction (%s) from %s at %s", interaction.FullId, kind, interaction.QType, interaction.RemoteAddress, interaction.Timestamp.Format("2006-01-02 15:04:05"))
 					if cliOptions.Verbose {
 						fmt.Fprintf(builder, "\n-----------\nDNS Request\n-----------\n\n%s\n\n------------\nDNS Response\n------------\n\n%s\n\n", interaction.RawRequest, interaction.RawResponse)
 					}
@@ -231,6 +237,14 @@ func main() {
 					}
 					writeOutput(outputFile, builder)
 				}
+			case "websocket":
+				if noFilter || cliOptions.HTTPOnly {
+					fmt.Fprintf(builder, "[%s] Received WebSocket interaction from %s at %s", interaction.FullId, interaction.RemoteAddress, interaction.Timestamp.Format("2006-01-02 15:04:05"))
+					if cliOptions.Verbose {
+						fmt.Fprintf(builder, "\n------------\nHTTP Request\n------------\n\n%s\n\n----------------\nWebSocket Frames\n----------------\n\n%s\n\n", interaction.RawRequest, strings.Join(interaction.WebSocketData, "\n"))
+					}
+					writeOutput(outputFile, builder)
+				}
 			case "smtp":
 				if noFilter || cliOptions.SmtpOnly {
 					fmt.Fprintf(builder, "[%s] Received SMTP interaction from %s at %s", interaction.FullId, interaction.RemoteAddress, interaction.Timestamp.Format("2006-01-02 15:04:05"))
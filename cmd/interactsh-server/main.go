@@ -13,6 +13,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	_ "net/http/pprof"
@@ -38,6 +39,9 @@ var (
 	pprofServerAddress    = "127.0.0.1:8086"
 )
 
+// certificateReloadInterval is how often certificate files are checked for changes
+const certificateReloadInterval = time.Minute
+
 func main() {
 	cliOptions := &options.CLIServerOptions{}
 	flagSet := goflags.NewFlagSet()
@@ -45,20 +49,63 @@ func main() {
 
 	flagSet.CreateGroup("input", "Input",
 		flagSet.StringSliceVarP(&cliOptions.Domains, "domain", "d", []string{}, "single/multiple configured domain to use for server", goflags.CommaSeparatedStringSliceOptions),
+		flagSet.StringSliceVarP(&cliOptions.DeprecatedDomains, "deprecated-domain", "dd", []string{}, "configured domain(s) being migrated away from, interactions are flagged as deprecated", goflags.CommaSeparatedStringSliceOptions),
+		flagSet.BoolVarP(&cliOptions.WarnDeprecatedDomains, "warn-deprecated", "wdd", false, "log interactions on deprecated domains and add a warning header to http responses"),
 		flagSet.StringSliceVarP(&cliOptions.IPAddresses, "ip", "i", []string{}, "public IP address(es) to use for interactsh server (comma-separated, supports both IPv4 & IPv6)", goflags.CommaSeparatedStringSliceOptions),
+		flagSet.StringSliceVarP(&cliOptions.DNSAnswerPool, "dns-answer-pool", "dap", []string{}, "IP address(es) to answer wildcard subdomain dns queries with (comma-separated)", goflags.CommaSeparatedStringSliceOptions),
+		flagSet.StringVarP(&cliOptions.DNSAnswerStrategy, "dns-answer-strategy", "das", server.DNSAnswerStrategyRoundRobin, "selection of the dns answer pool addresses (roundrobin, random, all)"),
 		flagSet.StringVarP(&cliOptions.ListenIP, "listen-ip", "lip", "0.0.0.0", "public ip address to listen on"),
 		flagSet.IntVarP(&cliOptions.Eviction, "eviction", "e", 30, "number of days to persist interaction data in memory"),
 		flagSet.BoolVarP(&cliOptions.NoEviction, "no-eviction", "ne", false, "disable periodic data eviction from memory"),
 		flagSet.StringVarP(&cliOptions.EvictionStrategy, "eviction-strategy", "es", "sliding", "eviction strategy for interactions (sliding, fixed)"),
+		flagSet.StringSliceVarP(&cliOptions.PinnedCorrelationIDs, "pinned-id", "pin", []string{}, "correlation id(s) exempt from eviction (comma-separated)", goflags.CommaSeparatedStringSliceOptions),
+		flagSet.IntVarP(&cliOptions.MaxStorageMemory, "max-storage-memory", "msm", 0, "max bytes of interactions buffered in memory (0 = no limit)"),
+		flagSet.StringVarP(&cliOptions.StorageMemoryPolicy, "storage-memory-policy", "smp", "reject", "policy once the storage memory limit is reached (reject, evict)"),
+		flagSet.DurationVarP(&cliOptions.MaxRetention, "max-retention", "mr", 24*time.Hour, "maximum interaction retention a client can request at registration (0 for no bound)"),
+		flagSet.DurationVarP(&cliOptions.DeregisterGracePeriod, "deregister-grace-period", "dgp", 0, "keep deregistered sessions pollable for the given duration before removing them"),
+		flagSet.IntVarP(&cliOptions.MaxConcurrentPolls, "max-concurrent-polls", "mcp", 0, "maximum number of simultaneous polls per correlation id (0 for no limit)"),
+		flagSet.IntVarP(&cliOptions.PollRateLimit, "poll-rate-limit", "prl", 0, "maximum number of polls per second per correlation id (0 for no limit)"),
+		flagSet.IntVarP(&cliOptions.PollRateBurst, "poll-rate-burst", "prb", 0, "number of polls per correlation id allowed above the rate limit (defaults to the rate limit)"),
+		flagSet.IntVarP(&cliOptions.MaxPollBatch, "max-poll-batch", "mpb", 0, "maximum number of interactions returned per poll (0 for no limit)"),
+		flagSet.BoolVar(&cliOptions.HTTPDisableCompression, "http-disable-compression", false, "send poll and metrics responses without gzip/deflate compression"),
+		flagSet.IntVarP(&cliOptions.RegisterRateLimit, "register-rate-limit", "rrl", 0, "maximum number of registrations per minute from a source ip (0 for no limit)"),
+		flagSet.BoolVarP(&cliOptions.RequireTLSForAPI, "require-tls-api", "rta", false, "reject client api requests (register, poll, keys, deregister) received over plain http"),
+		flagSet.DurationVarP(&cliOptions.AESKeyRotationInterval, "aes-key-rotation", "akr", 0, "maximum lifetime of a session aes key before it is rotated on poll (0 to disable)"),
+		flagSet.DurationVarP(&cliOptions.MaxKeyAge, "max-key-age", "mka", 0, "maximum age of a session registration before it must be registered again (0 to disable)"),
+		flagSet.DurationVarP(&cliOptions.DedupWindow, "dedup-window", "dw", 0, "drop http and dns interactions repeating one of the same correlation id within the window (0 to disable)"),
+		flagSet.StringVarP(&cliOptions.DedupPath, "dedup-path", "dp", "", "directory persisting the dedup fingerprints across restarts"),
 		flagSet.BoolVarP(&cliOptions.Auth, "auth", "a", false, "enable authentication to server using random generated token"),
 		flagSet.StringVarP(&cliOptions.Token, "token", "t", "", "enable authentication to server using given token"),
-		flagSet.StringVar(&cliOptions.OriginURL, "acao-url", "*", "origin url to send in acao header to use web-client)"), // cli flag set to deprecate
+		flagSet.StringSliceVarP(&cliOptions.ClientTokens, "client-token", "ct", nil, "additional client token(s) as label:token, revocable per user (comma-separated)", goflags.CommaSeparatedStringSliceOptions),
+		flagSet.StringVarP(&cliOptions.AdminToken, "admin-token", "at", "", "enable admin endpoints using given token (must differ from the client token)"),
+		flagSet.StringVarP(&cliOptions.AdminListenAddr, "admin-listen", "al", "", "serve admin and metrics endpoints only on a dedicated listener address (eg. 127.0.0.1:8080)"),
+		flagSet.IntVarP(&cliOptions.ReplayCacheSize, "replay-cache-size", "rcs", 0, "number of captured http requests kept to be replayed to a target with the /admin/replay endpoint"),
+		flagSet.StringSliceVar(&cliOptions.OriginURLs, "acao-url", []string{"*"}, "origin url(s) allowed in the acao header to use web-client, supports * wildcards (comma-separated)", goflags.CommaSeparatedStringSliceOptions), // cli flag set to deprecate
 		flagSet.BoolVarP(&cliOptions.SkipAcme, "skip-acme", "sa", false, "skip acme registration (certificate checks/handshake + TLS protocols will be disabled)"),
 		flagSet.BoolVarP(&cliOptions.ScanEverywhere, "scan-everywhere", "se", false, "scan canary token everywhere"),
+		flagSet.StringVarP(&cliOptions.CorrelationPosition, "correlation-position", "cp", server.CorrelationPositionAnywhere, "position of the correlation id in requests (anywhere, leftmost)"),
+		flagSet.IntVarP(&cliOptions.FuzzyDistance, "fuzzy-correlation-distance", "fcd", 0, "maximum edit distance of corrupted correlation ids matched to the closest registered one (0 to disable)"),
+		flagSet.StringSliceVarP(&cliOptions.ScanCookies, "scan-cookie", "sck", nil, "cookie names to scan for canary token", goflags.StringSliceOptions),
+		flagSet.BoolVarP(&cliOptions.ScanFormFields, "scan-form-fields", "sff", false, "scan post form field values for canary token"),
+		flagSet.BoolVarP(&cliOptions.DecodeExfil, "decode-exfil", "dex", false, "base64 decode the subdomain labels preceding the canary token"),
+		flagSet.BoolVarP(&cliOptions.ClassifyInteractions, "classify-interactions", "ci", false, "classify http interactions as likely canary, data or scan requests"),
+		flagSet.BoolVarP(&cliOptions.DecodeJWT, "decode-jwt", "djwt", false, "decode the claims of jwt bearer tokens in http interactions (signature redacted)"),
+		flagSet.StringSliceVarP(&cliOptions.RecordMethods, "record-methods", "rm", nil, "http methods recorded as interactions, other requests are only answered (default all)", goflags.CommaSeparatedStringSliceOptions),
+		flagSet.BoolVarP(&cliOptions.IncludeServerInfo, "include-server-info", "isi", false, "add the hostname, pid and start time of the server to interactions"),
+		flagSet.BoolVarP(&cliOptions.SessionMetadata, "session-metadata", "sm", false, "attach the metadata sent by clients at registration to their interactions"),
+		flagSet.BoolVarP(&cliOptions.RequireCorrelationID, "require-correlation-id", "rci", false, "reject http requests without canary token"),
+		flagSet.StringVarP(&cliOptions.UnmatchedResponse, "unmatched-response", "umr", "", "body of the 404 response to rejected http requests (connection is closed if empty)"),
+		flagSet.BoolVarP(&cliOptions.LogUnmatched, "log-unmatched", "lum", false, "log http requests rejected for missing a canary token"),
 		flagSet.IntVarP(&cliOptions.CorrelationIdLength, "correlation-id-length", "cidl", settings.CorrelationIdLengthDefault, fmt.Sprintf("length of the correlation id preamble (min %d, default %d)", settings.CorrelationIdLengthMinimum, settings.CorrelationIdLengthDefault)),
 		flagSet.IntVarP(&cliOptions.CorrelationIdNonceLength, "correlation-id-nonce-length", "cidn", settings.CorrelationIdNonceLengthDefault, fmt.Sprintf("length of the correlation id nonce (min %d, default %d)", settings.CorrelationIdNonceLengthMinimum, settings.CorrelationIdNonceLengthDefault)),
+		flagSet.StringVarP(&cliOptions.CorrelationSalt, "correlation-salt", "csalt", "", "require clients to register with the hmac of their correlation id under given salt"),
 		flagSet.StringVar(&cliOptions.CertificatePath, "cert", "", "custom certificate path"),
 		flagSet.StringVar(&cliOptions.PrivateKeyPath, "privkey", "", "custom private key path"),
+		flagSet.BoolVarP(&cliOptions.DisableSessionTickets, "disable-session-tickets", "dst", false, "disable tls session ticket resumption for https"),
+		flagSet.BoolVarP(&cliOptions.CaptureClientCert, "capture-client-cert", "ccc", false, "request tls client certificates for https and record their subject and fingerprint"),
+		flagSet.StringVarP(&cliOptions.OCSPStapleFile, "ocsp-staple", "ocsp", "", "DER encoded OCSP response file to staple on https handshakes"),
+		flagSet.BoolVarP(&cliOptions.TLSALPNChallenge, "acme-tls-alpn", "ata", false, "answer acme tls-alpn-01 challenges on the https listener"),
+		flagSet.StringSliceVarP(&cliOptions.InvalidCertSNIs, "invalid-cert-sni", "ics", nil, "server name(s) and their subdomains served a self-signed certificate for another hostname (comma-separated)", goflags.CommaSeparatedStringSliceOptions),
 		flagSet.StringVarP(&cliOptions.OriginIPHeader, "origin-ip-header", "oih", "", "HTTP header containing origin ip (interactsh behind a reverse proxy)"),
 	)
 
@@ -67,13 +114,43 @@ func main() {
 		flagSet.StringVar(&cliOptions.Config, "config", defaultConfigLocation, "flag configuration file"),
 		flagSet.BoolVarP(&cliOptions.DynamicResp, "dynamic-resp", "dr", false, "enable setting up arbitrary response data"),
 		flagSet.StringVarP(&cliOptions.CustomRecords, "custom-records", "cr", "", "custom dns records YAML file for DNS server"),
+		flagSet.StringVarP(&cliOptions.TaggingRules, "tagging-rules", "tr", "", "YAML file of rules (match-field, regex, tag) tagging matching interactions"),
+		flagSet.StringVarP(&cliOptions.UserAgentResponses, "user-agent-responses", "uar", "", "YAML file of http responses (ua-regex, status, body, headers) served to matching user agents"),
+		flagSet.StringVarP(&cliOptions.DNSVersionString, "dns-version", "dnv", "", "answer chaos version.bind and hostname.bind dns queries with given string"),
+		flagSet.StringVarP(&cliOptions.DNSWildcardCNAMESuffix, "dns-cname-suffix", "dcs", "", "answer correlation subdomains with a cname to the subdomain suffixed with given label (two-hop detection)"),
 		flagSet.StringVarP(&cliOptions.HTTPIndex, "http-index", "hi", "", "custom index file for http server"),
+		flagSet.StringVarP(&cliOptions.SitemapXML, "sitemap-xml", "sx", "", "custom sitemap.xml file for http server"),
 		flagSet.StringVarP(&cliOptions.HTTPDirectory, "http-directory", "hd", "", "directory with files to serve with http server"),
 		flagSet.StringVarP(&cliOptions.DefaultHTTPResponseFile, "default-http-response", "dhr", "", "file to serve for all http requests (takes priority over other options)"),
 		flagSet.BoolVarP(&cliOptions.DiskStorage, "disk", "ds", false, "disk based storage"),
 		flagSet.StringVarP(&cliOptions.DiskStoragePath, "disk-path", "dsp", "", "disk storage path"),
+		flagSet.StringVarP(&cliOptions.StorageFallback, "storage-fallback", "sf", "", "folder to spill interactions to while the storage is unavailable"),
 		flagSet.StringVarP(&cliOptions.HeaderServer, "server-header", "csh", "", "custom value of Server header in response"),
+		flagSet.StringSliceVarP(&cliOptions.ServerHeaderPool, "server-header-pool", "shp", []string{}, "values of Server header selected per response (comma-separated)", goflags.CommaSeparatedStringSliceOptions),
+		flagSet.StringVarP(&cliOptions.ServerHeaderStrategy, "server-header-strategy", "shs", server.ServerHeaderStrategyRoundRobin, "selection of the server header pool values (roundrobin, random)"),
 		flagSet.BoolVarP(&cliOptions.NoVersionHeader, "disable-version", "dv", false, "disable publishing interactsh version in response header"),
+		flagSet.IntVarP(&cliOptions.MaxURLLength, "max-url-length", "mul", 0, "maximum http request url length before rejecting with 414 (0 = unlimited)"),
+		flagSet.IntVarP(&cliOptions.MaxDynamicHeaders, "max-dynamic-headers", "mdh", 0, "maximum number of dynamic response headers applied per request (0 = unlimited)"),
+		flagSet.IntVarP(&cliOptions.MaxDynamicResponseSize, "max-dynamic-response-size", "mdrs", 10*1024*1024, "maximum size in bytes of streamed dynamic http responses"),
+		flagSet.DurationVarP(&cliOptions.MaxResponseDelay, "max-response-delay", "mrd", 0, "maximum delay of dynamic http responses, longer delays are clamped (0 = unlimited)"),
+		flagSet.BoolVarP(&cliOptions.EnableTrace, "enable-trace", "et", false, "echo http trace requests back to the client"),
+		flagSet.BoolVarP(&cliOptions.CaptureWebSocket, "capture-websocket", "cws", false, "complete websocket upgrades and record the frames sent by clients"),
+		flagSet.BoolVarP(&cliOptions.ApidocsIndex, "apidocs-index", "adi", false, "list registered dynamic endpoint suburls at /apidocs/"),
+		flagSet.StringVarP(&cliOptions.DynamicEndpoints, "dynamic-endpoints", "de", "", "YAML file of dynamic endpoints (suburl, body, content-type) served at /apidocs/"),
+		flagSet.DurationVarP(&cliOptions.DynamicEndpointsReload, "dynamic-endpoints-reload", "der", 10*time.Second, "interval to check the dynamic endpoints file for changes (0 to disable reloading)"),
+		flagSet.DurationVarP(&cliOptions.DynamicEndpointTTL, "dynamic-endpoint-ttl", "det", 0, "duration endpoints registered through /storerequest are served for (0 to never expire them)"),
+		flagSet.DurationVarP(&cliOptions.DynamicEndpointCooldown, "dynamic-endpoint-cooldown", "dec", 24*time.Hour, "minimum interval between updates of a dynamic endpoint suburl"),
+		flagSet.StringSliceVarP(&cliOptions.CacheHeaders, "cache-header", "ch", nil, "default caching header to set in http responses (e.g. 'Cache-Control: no-store')", goflags.StringSliceOptions),
+	)
+
+	flagSet.CreateGroup("output", "Output",
+		flagSet.StringSliceVar(&cliOptions.KafkaBrokers, "kafka-broker", nil, "kafka brokers to publish interactions to", goflags.CommaSeparatedStringSliceOptions),
+		flagSet.StringVar(&cliOptions.KafkaTopic, "kafka-topic", "", "kafka topic to publish interactions to"),
+		flagSet.StringVar(&cliOptions.KafkaUsername, "kafka-username", "", "kafka sasl/plain username"),
+		flagSet.StringVar(&cliOptions.KafkaPassword, "kafka-password", "", "kafka sasl/plain password"),
+		flagSet.BoolVar(&cliOptions.KafkaTLS, "kafka-tls", false, "use tls to connect to the kafka brokers"),
+		flagSet.StringVarP(&cliOptions.AccessLog, "access-log", "alog", "", "file to append a json line to for every http request (reopened on SIGHUP)"),
+		flagSet.StringVar(&cliOptions.CEFCollector, "cef-collector", "", "http(s) url or udp/tcp syslog address to forward interactions to in cef format (eg. udp://127.0.0.1:514)"),
 	)
 
 	flagSet.CreateGroup("update", "Update",
@@ -83,14 +160,31 @@ func main() {
 
 	flagSet.CreateGroup("services", "Services",
 		flagSet.IntVar(&cliOptions.DnsPort, "dns-port", 53, "port to use for dns service"),
+		flagSet.BoolVar(&cliOptions.DNSTCPOnly, "dns-tcp-only", false, "serve dns over tcp only, dropping udp queries"),
+		flagSet.BoolVar(&cliOptions.DNSTruncateUDP, "dns-truncate-udp", false, "answer udp queries truncated to force a tcp retry (requires -dns-tcp-only)"),
+		flagSet.IntVar(&cliOptions.DNSEDNSPadding, "dns-edns-padding", 0, "pad responses to edns0 queries to a multiple of the block size (eg. 468)"),
+		flagSet.BoolVar(&cliOptions.DNSRefuseMultiQuestion, "dns-refuse-multi-question", false, "record and refuse dns queries with more than one question"),
+		flagSet.StringVar(&cliOptions.DNSUpstream, "dns-upstream", "", "forward dns queries for names outside the configured domains to the upstream resolver (eg. 1.1.1.1:53) (authenticated)"),
+		flagSet.BoolVar(&cliOptions.DNSDisableCompression, "dns-disable-compression", false, "send dns responses without name compression"),
 		flagSet.IntVar(&cliOptions.HttpPort, "http-port", 80, "port to use for http service"),
 		flagSet.IntVar(&cliOptions.HttpsPort, "https-port", 443, "port to use for https service"),
 		flagSet.IntVar(&cliOptions.SmtpPort, "smtp-port", 25, "port to use for smtp service"),
 		flagSet.IntVar(&cliOptions.SmtpsPort, "smtps-port", 587, "port to use for smtps service"),
 		flagSet.IntVar(&cliOptions.SmtpAutoTLSPort, "smtp-autotls-port", 465, "port to use for smtps autotls service"),
+		flagSet.BoolVar(&cliOptions.SMTPAdvertiseSTARTTLS, "smtp-starttls", false, "advertise starttls on the plain smtp ports"),
+		flagSet.BoolVar(&cliOptions.SMTPRequireTLS, "smtp-require-tls", false, "require starttls on the plain smtp ports before accepting mail"),
+		flagSet.BoolVar(&cliOptions.SMTPCaptureAuth, "smtp-capture-auth", false, "record smtp auth mechanism and username in interactions"),
+		flagSet.BoolVar(&cliOptions.SMTPCapturePassword, "smtp-capture-password", false, "also record smtp auth password in interactions (requires -smtp-capture-auth)"),
+		flagSet.IntVar(&cliOptions.SMTPTranscriptSize, "smtp-transcript-size", 65536, "maximum size in bytes of the smtp transcript recorded in interactions (0 records only the message data)"),
+		flagSet.BoolVarP(&cliOptions.DetectProtocolMismatch, "detect-protocol-mismatch", "dpm", false, "record requests of other protocols (eg. http) sent to the plain http, smtp and ftp ports"),
+		flagSet.BoolVar(&cliOptions.MeasureRTT, "measure-rtt", false, "record the round trip time estimated for the connections of http and tcp interactions"),
+		flagSet.BoolVarP(&cliOptions.SanitizeNonPrintable, "sanitize-non-printable", "snp", false, "escape the non-printable bytes of the raw requests and responses of interactions as \\xHH"),
+		flagSet.StringVarP(&cliOptions.ProtocolScriptDir, "protocol-script-dir", "psd", "", "directory of YAML scripts (smtp.yaml, ftp.yaml, ldap.yaml) of the banner and responses of these services"),
+		flagSet.BoolVar(&cliOptions.DoH, "doh", false, "answer dns over https queries on the /dns-query http endpoint"),
 		flagSet.IntVar(&cliOptions.LdapPort, "ldap-port", 389, "port to use for ldap service"),
 		flagSet.BoolVar(&cliOptions.LdapWithFullLogger, "ldap", false, "enable ldap server with full logging (authenticated)"),
 		flagSet.BoolVarP(&cliOptions.RootTLD, "wildcard", "wc", false, "enable wildcard interaction for interactsh domain (authenticated)"),
+		flagSet.BoolVar(&cliOptions.RecordACMEChallenges, "record-acme", false, "record acme http challenge requests as acme interactions (wildcard stream and outputs)"),
 		flagSet.BoolVar(&cliOptions.Smb, "smb", false, "start smb agent - impacket and python 3 must be installed (authenticated)"),
 		flagSet.BoolVar(&cliOptions.Responder, "responder", false, "start responder agent - docker must be installed (authenticated)"),
 		flagSet.BoolVar(&cliOptions.Ftp, "ftp", false, "start ftp agent (authenticated)"),
@@ -98,6 +192,10 @@ func main() {
 		flagSet.IntVar(&cliOptions.FtpPort, "ftp-port", 21, "port to use for ftp service"),
 		flagSet.IntVar(&cliOptions.FtpsPort, "ftps-port", 990, "port to use for ftps service"),
 		flagSet.StringVar(&cliOptions.FTPDirectory, "ftp-dir", "", "ftp directory - temporary if not specified"),
+		flagSet.BoolVar(&cliOptions.TCP, "tcp", false, "start generic tcp agent recording every connection (authenticated)"),
+		flagSet.IntVar(&cliOptions.TCPPort, "tcp-port", 9999, "port to use for generic tcp service"),
+		flagSet.StringVar(&cliOptions.TCPBanner, "tcp-banner", "", "banner sent to the clients of the generic tcp service"),
+		flagSet.IntVar(&cliOptions.TCPMaxSize, "tcp-max-size", 4096, "maximum size in bytes of the data recorded from a tcp connection"),
 	)
 
 	flagSet.CreateGroup("debug", "Debug",
@@ -106,7 +204,9 @@ func main() {
 		flagSet.BoolVarP(&cliOptions.EnablePprof, "enable-pprof", "ep", false, "enable pprof debugging server"),
 		flagSet.BoolVarP(&healthcheck, "hc", "health-check", false, "run diagnostic check up"),
 		flagSet.BoolVar(&cliOptions.EnableMetrics, "metrics", false, "enable metrics endpoint"),
+		flagSet.DurationVarP(&cliOptions.MetricsCacheTTL, "metrics-cache-ttl", "mct", 0, "duration the system metrics of the metrics endpoint are reused for (0 = gather on every request)"),
 		flagSet.BoolVarP(&cliOptions.Verbose, "verbose", "v", false, "display verbose interaction"),
+		flagSet.IntVarP(&cliOptions.PollErrorPercent, "poll-error-percent", "pep", 0, "percentage of poll requests failing with a simulated error (chaos testing)"),
 	)
 
 	if err := flagSet.Parse(); err != nil {
@@ -151,6 +251,17 @@ func main() {
 	if cliOptions.CorrelationIdNonceLength < settings.CorrelationIdNonceLengthMinimum {
 		gologger.Fatal().Msgf("CorrelationIdNonceLength (cidn) must be at least %d\n", settings.CorrelationIdNonceLengthMinimum)
 	}
+	switch cliOptions.DNSAnswerStrategy {
+	case server.DNSAnswerStrategyRoundRobin, server.DNSAnswerStrategyRandom, server.DNSAnswerStrategyAll:
+	default:
+		gologger.Fatal().Msgf("invalid dns answer strategy '%s', must be '%s', '%s' or '%s'\n", cliOptions.DNSAnswerStrategy, server.DNSAnswerStrategyRoundRobin, server.DNSAnswerStrategyRandom, server.DNSAnswerStrategyAll)
+	}
+	if cliOptions.ServerHeaderStrategy != server.ServerHeaderStrategyRoundRobin && cliOptions.ServerHeaderStrategy != server.ServerHeaderStrategyRandom {
+		gologger.Fatal().Msgf("invalid server header strategy '%s', must be '%s' or '%s'\n", cliOptions.ServerHeaderStrategy, server.ServerHeaderStrategyRoundRobin, server.ServerHeaderStrategyRandom)
+	}
+	if cliOptions.CorrelationPosition != server.CorrelationPositionAnywhere && cliOptions.CorrelationPosition != server.CorrelationPositionLeftmost {
+		gologger.Fatal().Msgf("invalid correlation position '%s', must be '%s' or '%s'\n", cliOptions.CorrelationPosition, server.CorrelationPositionAnywhere, server.CorrelationPositionLeftmost)
+	}
 
 	if len(cliOptions.IPAddresses) == 0 && cliOptions.ListenIP == "0.0.0.0" {
 		publicIP, _ := getPublicIP()
@@ -199,13 +310,49 @@ func main() {
 		gologger.DefaultLogger.SetMaxLevel(levels.LevelDebug)
 	}
 
+	if cliOptions.SitemapXML != "" {
+		data, err := os.ReadFile(cliOptions.SitemapXML)
+		if err != nil {
+			gologger.Fatal().Msgf("Could not read sitemap file: %s\n", err)
+		}
+		serverOptions.SitemapXML = string(data)
+	}
+	if cliOptions.TaggingRules != "" {
+		rules, err := server.LoadTaggingRules(cliOptions.TaggingRules)
+		if err != nil {
+			gologger.Fatal().Msgf("Could not read tagging rules: %s\n", err)
+		}
+		serverOptions.TaggingRules = rules
+	}
+	if cliOptions.UserAgentResponses != "" {
+		responses, err := server.LoadUserAgentResponses(cliOptions.UserAgentResponses)
+		if err != nil {
+			gologger.Fatal().Msgf("Could not read user agent responses: %s\n", err)
+		}
+		serverOptions.UserAgentResponses = responses
+	}
+
 	// responder and smb can't be active at the same time
 	if cliOptions.Responder && cliOptions.Smb {
 		gologger.Fatal().Msgf("responder and smb can't be active at the same time\n")
 	}
 
+	for _, item := range cliOptions.ClientTokens {
+		label, token, ok := strings.Cut(item, ":")
+		if !ok || label == "" || token == "" {
+			gologger.Fatal().Msgf("invalid client token '%s', must be label:token\n", item)
+		}
+		if _, exists := serverOptions.Tokens[token]; exists || token == serverOptions.Token {
+			gologger.Fatal().Msgf("duplicate client token for '%s'\n", label)
+		}
+		if serverOptions.Tokens == nil {
+			serverOptions.Tokens = make(map[string]string)
+		}
+		serverOptions.Tokens[token] = label
+	}
+
 	// Requires auth if token is specified or enables it automatically for responder and smb options
-	if serverOptions.Token != "" || cliOptions.Responder || cliOptions.Smb || cliOptions.Ftp || cliOptions.LdapWithFullLogger {
+	if serverOptions.Token != "" || len(serverOptions.Tokens) > 0 || cliOptions.Responder || cliOptions.Smb || cliOptions.Ftp || cliOptions.TCP || cliOptions.LdapWithFullLogger || serverOptions.DNSUpstream != "" {
 		serverOptions.Auth = true
 	}
 
@@ -219,6 +366,17 @@ func main() {
 		serverOptions.Auth = true
 	}
 
+	if _, ok := serverOptions.Tokens[serverOptions.AdminToken]; serverOptions.AdminToken != "" && (serverOptions.AdminToken == serverOptions.Token || ok) {
+		gologger.Fatal().Msgf("admin token must be different from the client token\n")
+	}
+
+	if cliOptions.PollErrorPercent < 0 || cliOptions.PollErrorPercent > 100 {
+		gologger.Fatal().Msgf("poll error percent must be between 0 and 100\n")
+	}
+	if serverOptions.PollErrorRate > 0 {
+		gologger.Warning().Msgf("%d%% of poll requests will fail with a simulated error\n", cliOptions.PollErrorPercent)
+	}
+
 	if serverOptions.Auth && serverOptions.Token == "" {
 		b := make([]byte, 32)
 		if _, err := rand.Read(b); err != nil {
@@ -244,10 +402,26 @@ func main() {
 		gologger.Fatal().Msgf("invalid eviction strategy '%s', must be 'sliding' or 'fixed'\n", cliOptions.EvictionStrategy)
 	}
 
+	// Parse storage memory policy
+	var memoryPolicy storage.MemoryPolicy
+	switch strings.ToLower(cliOptions.StorageMemoryPolicy) {
+	case "reject":
+		memoryPolicy = storage.MemoryPolicyReject
+	case "evict":
+		memoryPolicy = storage.MemoryPolicyEvict
+	default:
+		gologger.Fatal().Msgf("invalid storage memory policy '%s', must be 'reject' or 'evict'\n", cliOptions.StorageMemoryPolicy)
+	}
+
 	var store storage.Storage
 	storeOptions := storage.DefaultOptions
 	storeOptions.EvictionTTL = evictionTTL
 	storeOptions.EvictionStrategy = evictionStrategy
+	storeOptions.AESKeyRotationInterval = cliOptions.AESKeyRotationInterval
+	storeOptions.MaxKeyAge = cliOptions.MaxKeyAge
+	storeOptions.PinnedCorrelationIDs = cliOptions.PinnedCorrelationIDs
+	storeOptions.MaxStorageMemoryBytes = int64(cliOptions.MaxStorageMemory)
+	storeOptions.MemoryPolicy = memoryPolicy
 	if cliOptions.DiskStorage {
 		if cliOptions.DiskStoragePath == "" {
 			gologger.Fatal().Msgf("disk storage path must be specified\n")
@@ -265,6 +439,12 @@ func main() {
 	if err != nil {
 		gologger.Fatal().Msgf("couldn't create storage: %s\n", err)
 	}
+	if cliOptions.StorageFallback != "" {
+		store, err = storage.NewFallbackStorage(store, cliOptions.StorageFallback)
+		if err != nil {
+			gologger.Fatal().Msgf("couldn't create fallback storage: %s\n", err)
+		}
+	}
 
 	serverOptions.Storage = store
 
@@ -274,6 +454,28 @@ func main() {
 
 	serverOptions.Stats = &server.Metrics{}
 
+	if len(serverOptions.KafkaBrokers) > 0 {
+		kafkaPublisher, err := server.NewKafkaPublisher(serverOptions)
+		if err != nil {
+			gologger.Fatal().Msgf("Could not create kafka publisher: %s\n", err)
+		}
+		serverOptions.Kafka = kafkaPublisher
+	}
+	if serverOptions.CEFCollector != "" {
+		cefExporter, err := server.NewCEFExporter(serverOptions)
+		if err != nil {
+			gologger.Fatal().Msgf("Could not create cef exporter: %s\n", err)
+		}
+		serverOptions.CEF = cefExporter
+	}
+	if serverOptions.DedupWindow > 0 {
+		dedup, err := server.NewDeduplicator(serverOptions)
+		if err != nil {
+			gologger.Fatal().Msgf("Could not create deduplicator: %s\n", err)
+		}
+		serverOptions.Dedup = dedup
+	}
+
 	// If root-tld is enabled create a singleton unencrypted record in the store
 	if serverOptions.RootTLD {
 		for _, domain := range serverOptions.Domains {
@@ -295,6 +497,8 @@ func main() {
 		tlsConfig   *tls.Config
 		domainCerts []tls.Certificate
 		certFiles   []acme.CertificateFiles
+		// reloadFiles are the certificate files of every domain reloaded on change
+		reloadFiles []acme.CertificateFiles
 	)
 	switch {
 	case cliOptions.CertificatePath != "" && cliOptions.PrivateKeyPath != "":
@@ -307,6 +511,7 @@ func main() {
 			gologger.Error().Msgf("https will be disabled: %s", acmeErr)
 		} else {
 			tlsConfig = acmeManagerTLS
+			reloadFiles = []acme.CertificateFiles{{CertPath: cliOptions.CertificatePath, PrivKeyPath: cliOptions.PrivateKeyPath}}
 		}
 	case !cliOptions.SkipAcme && len(cliOptions.Domains) > 0:
 		var certs []tls.Certificate
@@ -320,6 +525,7 @@ func main() {
 				gologger.Error().Msgf("Could not generate certs for auto TLS, https will be disabled")
 			} else {
 				certs = append(certs, domainCerts...)
+				reloadFiles = append(reloadFiles, certFiles...)
 			}
 		}
 		var tlsErr error
@@ -332,6 +538,16 @@ func main() {
 	serverOptions.Certificates = domainCerts
 	serverOptions.CertFiles = certFiles
 
+	// certificates are served from a store so they can be rotated without restart
+	if tlsConfig != nil {
+		certificateStore := acme.NewCertificateStore(reloadFiles, tlsConfig.Certificates...)
+		tlsConfig = certificateStore.TLSConfig(tlsConfig)
+		serverOptions.CertificateStore = certificateStore
+		if len(reloadFiles) > 0 {
+			go certificateStore.Watch(certificateReloadInterval)
+		}
+	}
+
 	// manually cleans up stale OCSP from storage
 	acme.CleanupStorage()
 
@@ -373,6 +589,16 @@ func main() {
 		go ftpServer.ListenAndServe(tlsConfig, ftpAlive, ftpsAlive) //nolint
 	}
 
+	tcpAlive := make(chan bool)
+	if cliOptions.TCP {
+		tcpServer, err := server.NewTCPServer(serverOptions)
+		if err != nil {
+			gologger.Fatal().Msgf("Could not create TCP server: %s", err)
+		}
+		go tcpServer.ListenAndServe(tcpAlive)
+		defer tcpServer.Close()
+	}
+
 	responderAlive := make(chan bool)
 	if cliOptions.Responder {
 		responderServer, err := server.NewResponderServer(serverOptions)
@@ -436,6 +662,10 @@ func main() {
 				service = "FTPS"
 				network = "TCP"
 				port = serverOptions.FtpsPort
+			case status = <-tcpAlive:
+				service = "TCP"
+				network = "TCP"
+				port = serverOptions.TCPPort
 			case status = <-responderAlive:
 				service = "Responder"
 				network = "TCP"
@@ -472,9 +702,38 @@ func main() {
 		}()
 	}
 
+	// the access log is reopened on SIGHUP so that it can be rotated
+	if cliOptions.AccessLog != "" {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		go func() {
+			for range hup {
+				if err := httpServer.ReopenAccessLog(); err != nil {
+					gologger.Warning().Msgf("Couldn't reopen the access log: %s\n", err)
+				}
+			}
+		}()
+	}
+
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt)
 	for range c {
+		if err := httpServer.CloseAccessLog(); err != nil {
+			gologger.Warning().Msgf("Couldn't close the access log: %s\n", err)
+		}
+		if serverOptions.Kafka != nil {
+			if err := serverOptions.Kafka.Close(); err != nil {
+				gologger.Warning().Msgf("Couldn't close the kafka publisher: %s\n", err)
+			}
+		}
+		if serverOptions.CEF != nil {
+			serverOptions.CEF.Close()
+		}
+		if serverOptions.Dedup != nil {
+			if err := serverOptions.Dedup.Close(); err != nil {
+				gologger.Warning().Msgf("Couldn't close the deduplicator: %s\n", err)
+			}
+		}
 		if err := store.Close(); err != nil {
 			gologger.Warning().Msgf("Couldn't close the storage: %s\n", err)
 		}
@@ -68,6 +68,7 @@ type Client struct {
 	token                    string
 	correlationIdLength      int
 	CorrelationIdNonceLength int
+	correlationSalt          string
 }
 
 // Options contains configuration options for interactsh client
@@ -82,6 +83,8 @@ type Options struct {
 	CorrelationIdLength int
 	// CorrelationIdNonceLengthLength of the nonce
 	CorrelationIdNonceLength int
+	// CorrelationSalt of the server, used to sign the correlation id on registration
+	CorrelationSalt string
 	// HTTPClient use a custom http client
 	HTTPClient *retryablehttp.Client
 	// SessionInfo to resume an existing session
@@ -167,6 +170,7 @@ func New(options *Options) (*Client, error) {
 		disableHTTPFallback:      options.DisableHTTPFallback,
 		correlationIdLength:      options.CorrelationIdLength,
 		CorrelationIdNonceLength: options.CorrelationIdNonceLength,
+		correlationSalt:          options.CorrelationSalt,
 	}
 
 	if options.SessionInfo != nil {
@@ -183,7 +187,7 @@ func New(options *Options) (*Client, error) {
 			client.serverURL = serverURL
 		}
 		// attempts to re-register - server will reject is already existing
-		registrationRequest, err := encodeRegistrationRequest(options.SessionInfo.PublicKey, options.SessionInfo.SecretKey, options.SessionInfo.CorrelationID)
+		registrationRequest, err := encodeRegistrationRequest(options.SessionInfo.PublicKey, options.SessionInfo.SecretKey, options.SessionInfo.CorrelationID, options.CorrelationSalt)
 		if err != nil {
 			return nil, err
 		}
@@ -218,7 +222,7 @@ func New(options *Options) (*Client, error) {
 						return
 					}
 					// attempts to re-register - server will reject is already existing
-					registrationRequest, err := encodeRegistrationRequest(pubKeyData, client.secretKey, client.correlationID)
+					registrationRequest, err := encodeRegistrationRequest(pubKeyData, client.secretKey, client.correlationID, client.correlationSalt)
 					if err != nil {
 						return
 					}
@@ -251,15 +255,18 @@ func (c *Client) initializeRSAKeys() ([]byte, error) {
 		return nil, err
 	}
 
-	return encodeRegistrationRequest(pubKeyData, c.secretKey, c.correlationID)
+	return encodeRegistrationRequest(pubKeyData, c.secretKey, c.correlationID, c.correlationSalt)
 }
 
-func encodeRegistrationRequest(publicKey, secretkey, correlationID string) ([]byte, error) {
+func encodeRegistrationRequest(publicKey, secretkey, correlationID, correlationSalt string) ([]byte, error) {
 	register := server.RegisterRequest{
 		PublicKey:     publicKey,
 		SecretKey:     secretkey,
 		CorrelationID: correlationID,
 	}
+	if correlationSalt != "" {
+		register.CorrelationHMAC = server.CorrelationHMAC(correlationSalt, correlationID)
+	}
 
 	data, err := jsoniter.Marshal(register)
 	if err != nil {
@@ -386,12 +393,18 @@ func (c *Client) StartPolling(duration time.Duration, callback InteractionCallba
 			}
 			select {
 			case <-ticker.C:
-				err := c.getInteractions(callback)
+				// the interactions left out by the server poll batch limit are polled right away
+				more, err := c.getInteractions(callback)
+				for err == nil && more && c.State.Load() == Polling {
+					more, err = c.getInteractions(callback)
+				}
 				if err != nil {
 					if errkit.Is(err, errAuth) {
 						gologger.Error().Msgf("Could not authenticate to the server %v", err)
 					} else if errkit.Is(err, storage.ErrCorrelationIdNotFound) {
 						gologger.Error().Msgf("The correlation id was not found (probably evicted due to inactivity): %v", err)
+					} else if errkit.Is(err, storage.ErrKeyExpired) {
+						gologger.Error().Msgf("The correlation id registration expired and must be registered again: %v", err)
 					}
 				}
 			case <-c.quitChan:
@@ -404,8 +417,9 @@ func (c *Client) StartPolling(duration time.Duration, callback InteractionCallba
 	return nil
 }
 
-// getInteractions returns the interactions from the server.
-func (c *Client) getInteractions(callback InteractionCallback) error {
+// getInteractions returns the interactions from the server along with whether
+// more of them are pending on it.
+func (c *Client) getInteractions(callback InteractionCallback) (bool, error) {
 	c.busy.RLock()
 	defer c.busy.RUnlock()
 
@@ -417,7 +431,7 @@ func (c *Client) getInteractions(callback InteractionCallback) error {
 	builder.WriteString(c.secretKey)
 	req, err := retryablehttp.NewRequest("GET", builder.String(), nil)
 	if err != nil {
-		return err
+		return false, err
 	}
 
 	if c.token != "" {
@@ -432,29 +446,39 @@ func (c *Client) getInteractions(callback InteractionCallback) error {
 		}
 	}()
 	if err != nil {
-		return err
+		return false, err
 	}
 	if resp.StatusCode != http.StatusOK {
 		if resp.StatusCode == http.StatusUnauthorized {
-			return errAuth
+			return false, errAuth
 		}
 		data, err := io.ReadAll(resp.Body)
 		if err != nil {
-			return errkit.Wrap(err, "could not read response body")
+			return false, errkit.Wrap(err, "could not read response body")
 		}
 		if stringsutil.ContainsAny(string(data), storage.ErrCorrelationIdNotFound.Error()) {
-			return storage.ErrCorrelationIdNotFound
+			return false, storage.ErrCorrelationIdNotFound
+		}
+		if stringsutil.ContainsAny(string(data), storage.ErrKeyExpired.Error()) {
+			return false, storage.ErrKeyExpired
 		}
-		return fmt.Errorf("could not poll interactions: %s", string(data))
+		return false, fmt.Errorf("could not poll interactions: %s", string(data))
 	}
 	response := &server.PollResponse{}
 	if err := jsoniter.NewDecoder(resp.Body).Decode(response); err != nil {
 		gologger.Error().Msgf("Could not decode interactions: %v\n", err)
-		return err
+		return false, err
 	}
 
+	// sessions with several public keys return the aes key wrapped under each of them
+	aesKey := response.AESKey
+	if fingerprint, err := storage.PublicKeyFingerprint(c.pubKey); err == nil {
+		if wrapped, ok := response.AESKeys[fingerprint]; ok {
+			aesKey = wrapped
+		}
+	}
 	for _, data := range response.Data {
-		plaintext, err := c.decryptMessage(response.AESKey, data)
+		plaintext, err := c.decryptMessage(aesKey, data)
 		if err != nil {
 			gologger.Error().Msgf("Could not decrypt interaction: %v\n", err)
 			continue
@@ -490,7 +514,7 @@ func (c *Client) getInteractions(callback InteractionCallback) error {
 		callback(interaction)
 	}
 
-	return nil
+	return response.More, nil
 }
 
 // TryGetAsnInfo attempts to enrich interaction with asn data
@@ -26,6 +26,7 @@ type CLIClientOptions struct {
 	DisableHTTPFallback      bool
 	CorrelationIdLength      int
 	CorrelationIdNonceLength int
+	CorrelationSalt          string
 	SessionFile              string
 	Asn                      bool
 	DisableUpdateCheck       bool
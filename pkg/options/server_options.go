@@ -2,6 +2,9 @@ package options
 
 import (
 	"net"
+	"net/http"
+	"strings"
+	"time"
 
 	"github.com/projectdiscovery/goflags"
 	"github.com/projectdiscovery/gologger"
@@ -14,8 +17,18 @@ type CLIServerOptions struct {
 	Version                  bool
 	Debug                    bool
 	Domains                  goflags.StringSlice
+	DeprecatedDomains        goflags.StringSlice
+	WarnDeprecatedDomains    bool
 	DnsPort                  int
+	DNSTCPOnly               bool
+	DNSTruncateUDP           bool
+	DNSEDNSPadding           int
+	DNSRefuseMultiQuestion   bool
+	DNSUpstream              string
+	DNSDisableCompression    bool
 	IPAddresses              goflags.StringSlice
+	DNSAnswerPool            goflags.StringSlice
+	DNSAnswerStrategy        string
 	ListenIP                 string
 	HttpPort                 int
 	HttpsPort                int
@@ -24,63 +37,153 @@ type CLIServerOptions struct {
 	Eviction                 int
 	NoEviction               bool
 	EvictionStrategy         string
+	PinnedCorrelationIDs     goflags.StringSlice
+	MaxStorageMemory         int
+	StorageMemoryPolicy      string
 	Responder                bool
 	Smb                      bool
 	SmbPort                  int
 	SmtpPort                 int
 	SmtpsPort                int
 	SmtpAutoTLSPort          int
+	SMTPAdvertiseSTARTTLS    bool
+	SMTPRequireTLS           bool
+	SMTPCaptureAuth          bool
+	SMTPCapturePassword      bool
+	SMTPTranscriptSize       int
+	DetectProtocolMismatch   bool
+	MeasureRTT               bool
+	SanitizeNonPrintable     bool
+	ProtocolScriptDir        string
 	FtpPort                  int
 	FtpsPort                 int
 	LdapPort                 int
 	Ftp                      bool
 	Auth                     bool
 	HTTPIndex                string
+	SitemapXML               string
 	HTTPDirectory            string
 	Token                    string
-	OriginURL                string
+	ClientTokens             goflags.StringSlice
+	AdminToken               string
+	AdminListenAddr          string
+	ReplayCacheSize          int
+	PollErrorPercent         int
+	MaxURLLength             int
+	MaxRetention             time.Duration
+	DeregisterGracePeriod    time.Duration
+	MaxConcurrentPolls       int
+	PollRateLimit            int
+	PollRateBurst            int
+	MaxPollBatch             int
+	HTTPDisableCompression   bool
+	RegisterRateLimit        int
+	RequireTLSForAPI         bool
+	ApidocsIndex             bool
+	DynamicEndpoints         string
+	DynamicEndpointsReload   time.Duration
+	DynamicEndpointTTL       time.Duration
+	DynamicEndpointCooldown  time.Duration
+	KafkaBrokers             goflags.StringSlice
+	KafkaTopic               string
+	KafkaUsername            string
+	KafkaPassword            string
+	KafkaTLS                 bool
+	CEFCollector             string
+	StorageFallback          string
+	MaxDynamicHeaders        int
+	MaxDynamicResponseSize   int
+	MaxResponseDelay         time.Duration
+	CaptureWebSocket         bool
+	EnableTrace              bool
+	OriginURLs               goflags.StringSlice
 	RootTLD                  bool
+	RecordACMEChallenges     bool
 	FTPDirectory             string
+	TCP                      bool
+	TCPPort                  int
+	TCPBanner                string
+	TCPMaxSize               int
 	SkipAcme                 bool
 	DynamicResp              bool
 	CorrelationIdLength      int
 	CorrelationIdNonceLength int
+	CorrelationSalt          string
 	ScanEverywhere           bool
+	ScanCookies              goflags.StringSlice
+	ScanFormFields           bool
+	DecodeExfil              bool
+	ClassifyInteractions     bool
+	DecodeJWT                bool
+	RecordMethods            goflags.StringSlice
+	IncludeServerInfo        bool
+	SessionMetadata          bool
+	RequireCorrelationID     bool
+	UnmatchedResponse        string
+	LogUnmatched             bool
+	CorrelationPosition      string
+	FuzzyDistance            int
 	CertificatePath          string
 	CustomRecords            string
+	TaggingRules             string
+	UserAgentResponses       string
+	DNSVersionString         string
+	DNSWildcardCNAMESuffix   string
+	DoH                      bool
 	PrivateKeyPath           string
 	OriginIPHeader           string
+	AccessLog                string
 	DiskStorage              bool
 	DiskStoragePath          string
 	EnablePprof              bool
 	EnableMetrics            bool
+	MetricsCacheTTL          time.Duration
 	Verbose                  bool
 	DisableUpdateCheck       bool
 	NoVersionHeader          bool
 	HeaderServer             string
+	ServerHeaderPool         goflags.StringSlice
+	ServerHeaderStrategy     string
 	DefaultHTTPResponseFile  string
+	DisableSessionTickets    bool
+	CaptureClientCert        bool
+	OCSPStapleFile           string
+	TLSALPNChallenge         bool
+	InvalidCertSNIs          goflags.StringSlice
+	AESKeyRotationInterval   time.Duration
+	MaxKeyAge                time.Duration
+	DedupWindow              time.Duration
+	DedupPath                string
+	CacheHeaders             goflags.StringSlice
 }
 
 func (cliServerOptions *CLIServerOptions) AsServerOptions() *server.Options {
-	var ipAddresses []net.IP
+	ipAddresses := uniqueIPs(cliServerOptions.parseIPAddresses(cliServerOptions.IPAddresses))
+	dnsAnswerPool := uniqueIPs(cliServerOptions.parseIPAddresses(cliServerOptions.DNSAnswerPool))
 
-	for _, ipAddress := range cliServerOptions.IPAddresses {
-		parsedIP := net.ParseIP(ipAddress)
-		if parsedIP != nil {
-			ipAddresses = append(ipAddresses, parsedIP)
-		} else {
-			if cliServerOptions.Debug {
-				gologger.Warning().Msgf("Invalid IP address '%s' will be ignored\n", ipAddress)
-			}
+	cacheHeaders := make(map[string]string)
+	for _, cacheHeader := range cliServerOptions.CacheHeaders {
+		if headerParts := strings.SplitN(cacheHeader, ":", 2); len(headerParts) == 2 {
+			cacheHeaders[http.CanonicalHeaderKey(strings.TrimSpace(headerParts[0]))] = strings.TrimSpace(headerParts[1])
+		} else if cliServerOptions.Debug {
+			gologger.Warning().Msgf("Invalid cache header '%s' will be ignored\n", cacheHeader)
 		}
 	}
 
-	ipAddresses = uniqueIPs(ipAddresses)
-
-	return &server.Options{
+	options := &server.Options{
 		Domains:                  cliServerOptions.Domains,
+		DeprecatedDomains:        cliServerOptions.DeprecatedDomains,
+		WarnDeprecatedDomains:    cliServerOptions.WarnDeprecatedDomains,
 		DnsPort:                  cliServerOptions.DnsPort,
+		DNSTCPOnly:               cliServerOptions.DNSTCPOnly,
+		DNSTruncateUDP:           cliServerOptions.DNSTruncateUDP,
+		DNSEDNSPadding:           cliServerOptions.DNSEDNSPadding,
+		DNSRefuseMultiQuestion:   cliServerOptions.DNSRefuseMultiQuestion,
+		DNSUpstream:              cliServerOptions.DNSUpstream,
+		DNSDisableCompression:    cliServerOptions.DNSDisableCompression,
 		IPAddresses:              ipAddresses,
+		DNSAnswerPool:            dnsAnswerPool,
+		DNSAnswerStrategy:        cliServerOptions.DNSAnswerStrategy,
 		ListenIP:                 cliServerOptions.ListenIP,
 		HttpPort:                 cliServerOptions.HttpPort,
 		HttpsPort:                cliServerOptions.HttpsPort,
@@ -89,6 +192,15 @@ func (cliServerOptions *CLIServerOptions) AsServerOptions() *server.Options {
 		SmtpPort:                 cliServerOptions.SmtpPort,
 		SmtpsPort:                cliServerOptions.SmtpsPort,
 		SmtpAutoTLSPort:          cliServerOptions.SmtpAutoTLSPort,
+		SMTPAdvertiseSTARTTLS:    cliServerOptions.SMTPAdvertiseSTARTTLS,
+		SMTPRequireTLS:           cliServerOptions.SMTPRequireTLS,
+		SMTPCaptureAuth:          cliServerOptions.SMTPCaptureAuth,
+		SMTPCapturePassword:      cliServerOptions.SMTPCapturePassword,
+		SMTPTranscriptSize:       cliServerOptions.SMTPTranscriptSize,
+		DetectProtocolMismatch:   cliServerOptions.DetectProtocolMismatch,
+		MeasureRTT:               cliServerOptions.MeasureRTT,
+		SanitizeNonPrintable:     cliServerOptions.SanitizeNonPrintable,
+		ProtocolScriptDir:        cliServerOptions.ProtocolScriptDir,
 		FtpPort:                  cliServerOptions.FtpPort,
 		FtpsPort:                 cliServerOptions.FtpsPort,
 		LdapPort:                 cliServerOptions.LdapPort,
@@ -96,25 +208,89 @@ func (cliServerOptions *CLIServerOptions) AsServerOptions() *server.Options {
 		HTTPIndex:                cliServerOptions.HTTPIndex,
 		HTTPDirectory:            cliServerOptions.HTTPDirectory,
 		Token:                    cliServerOptions.Token,
+		AdminToken:               cliServerOptions.AdminToken,
+		AdminListenAddr:          cliServerOptions.AdminListenAddr,
+		ReplayCacheSize:          cliServerOptions.ReplayCacheSize,
+		PollErrorRate:            float64(cliServerOptions.PollErrorPercent) / 100,
+		MaxURLLength:             cliServerOptions.MaxURLLength,
+		MaxRetention:             cliServerOptions.MaxRetention,
+		DedupWindow:              cliServerOptions.DedupWindow,
+		DedupPath:                cliServerOptions.DedupPath,
+		DeregisterGracePeriod:    cliServerOptions.DeregisterGracePeriod,
+		MaxConcurrentPolls:       cliServerOptions.MaxConcurrentPolls,
+		PollRateLimit:            cliServerOptions.PollRateLimit,
+		PollRateBurst:            cliServerOptions.PollRateBurst,
+		MaxPollBatch:             cliServerOptions.MaxPollBatch,
+		HTTPDisableCompression:   cliServerOptions.HTTPDisableCompression,
+		RegisterRateLimit:        cliServerOptions.RegisterRateLimit,
+		RequireTLSForAPI:         cliServerOptions.RequireTLSForAPI,
+		ApidocsIndex:             cliServerOptions.ApidocsIndex,
+		DynamicEndpointsFile:     cliServerOptions.DynamicEndpoints,
+		DynamicEndpointsReload:   cliServerOptions.DynamicEndpointsReload,
+		DynamicEndpointTTL:       cliServerOptions.DynamicEndpointTTL,
+		DynamicEndpointCooldown:  cliServerOptions.DynamicEndpointCooldown,
+		KafkaBrokers:             cliServerOptions.KafkaBrokers,
+		KafkaTopic:               cliServerOptions.KafkaTopic,
+		KafkaUsername:            cliServerOptions.KafkaUsername,
+		KafkaPassword:            cliServerOptions.KafkaPassword,
+		KafkaTLS:                 cliServerOptions.KafkaTLS,
+		CEFCollector:             cliServerOptions.CEFCollector,
+		MaxDynamicHeaders:        cliServerOptions.MaxDynamicHeaders,
+		MaxDynamicResponseSize:   int64(cliServerOptions.MaxDynamicResponseSize),
+		MaxResponseDelay:         cliServerOptions.MaxResponseDelay,
+		CaptureWebSocket:         cliServerOptions.CaptureWebSocket,
+		EnableTrace:              cliServerOptions.EnableTrace,
 		Version:                  Version,
 		DynamicResp:              cliServerOptions.DynamicResp,
-		OriginURL:                cliServerOptions.OriginURL,
+		OriginURLs:               cliServerOptions.OriginURLs,
 		RootTLD:                  cliServerOptions.RootTLD,
+		RecordACMEChallenges:     cliServerOptions.RecordACMEChallenges,
 		FTPDirectory:             cliServerOptions.FTPDirectory,
+		TCPPort:                  cliServerOptions.TCPPort,
+		TCPBanner:                cliServerOptions.TCPBanner,
+		TCPMaxSize:               cliServerOptions.TCPMaxSize,
 		CorrelationIdLength:      cliServerOptions.CorrelationIdLength,
 		CorrelationIdNonceLength: cliServerOptions.CorrelationIdNonceLength,
+		CorrelationSalt:          cliServerOptions.CorrelationSalt,
 		ScanEverywhere:           cliServerOptions.ScanEverywhere,
+		ScanCookies:              cliServerOptions.ScanCookies,
+		ScanFormFields:           cliServerOptions.ScanFormFields,
+		DecodeExfil:              cliServerOptions.DecodeExfil,
+		ClassifyInteractions:     cliServerOptions.ClassifyInteractions,
+		DecodeJWT:                cliServerOptions.DecodeJWT,
+		RecordMethods:            cliServerOptions.RecordMethods,
+		IncludeServerInfo:        cliServerOptions.IncludeServerInfo,
+		SessionMetadata:          cliServerOptions.SessionMetadata,
+		RequireCorrelationID:     cliServerOptions.RequireCorrelationID,
+		UnmatchedResponse:        cliServerOptions.UnmatchedResponse,
+		LogUnmatched:             cliServerOptions.LogUnmatched,
+		CorrelationPosition:      cliServerOptions.CorrelationPosition,
+		FuzzyCorrelationDistance: cliServerOptions.FuzzyDistance,
 		CertificatePath:          cliServerOptions.CertificatePath,
 		CustomRecords:            cliServerOptions.CustomRecords,
+		DNSVersionString:         cliServerOptions.DNSVersionString,
+		DNSWildcardCNAMESuffix:   cliServerOptions.DNSWildcardCNAMESuffix,
+		DoH:                      cliServerOptions.DoH,
 		PrivateKeyPath:           cliServerOptions.PrivateKeyPath,
 		OriginIPHeader:           cliServerOptions.OriginIPHeader,
+		AccessLogPath:            cliServerOptions.AccessLog,
 		DiskStorage:              cliServerOptions.DiskStorage,
 		DiskStoragePath:          cliServerOptions.DiskStoragePath,
 		EnableMetrics:            cliServerOptions.EnableMetrics,
+		MetricsCacheTTL:          cliServerOptions.MetricsCacheTTL,
 		NoVersionHeader:          cliServerOptions.NoVersionHeader,
 		HeaderServer:             cliServerOptions.HeaderServer,
+		ServerHeaderPool:         cliServerOptions.ServerHeaderPool,
+		ServerHeaderStrategy:     cliServerOptions.ServerHeaderStrategy,
 		DefaultHTTPResponseFile:  cliServerOptions.DefaultHTTPResponseFile,
+		CaptureClientCert:        cliServerOptions.CaptureClientCert,
+		OCSPStapleFile:           cliServerOptions.OCSPStapleFile,
+		TLSALPNChallenge:         cliServerOptions.TLSALPNChallenge,
+		InvalidCertSNIs:          cliServerOptions.InvalidCertSNIs,
+		DefaultCacheHeaders:      cacheHeaders,
 	}
+	options.TLSSessionTicketsDisabled = cliServerOptions.DisableSessionTickets
+	return options
 }
 
 // uniqueIPs removes duplicate IP addresses from a slice
@@ -132,3 +308,19 @@ func uniqueIPs(ips []net.IP) []net.IP {
 
 	return result
 }
+
+// parseIPAddresses returns the valid ip addresses of values, ignoring the others
+func (cliServerOptions *CLIServerOptions) parseIPAddresses(values []string) []net.IP {
+	var ipAddresses []net.IP
+	for _, ipAddress := range values {
+		parsedIP := net.ParseIP(ipAddress)
+		if parsedIP != nil {
+			ipAddresses = append(ipAddresses, parsedIP)
+		} else {
+			if cliServerOptions.Debug {
+				gologger.Warning().Msgf("Invalid IP address '%s' will be ignored\n", ipAddress)
+			}
+		}
+	}
+	return ipAddresses
+}
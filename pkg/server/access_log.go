@@ -0,0 +1,192 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/pkg/errors"
+	"github.com/projectdiscovery/gologger"
+)
+
+// accessLogFlushInterval is how often the buffered access log entries are written to the file
+const accessLogFlushInterval = time.Second
+
+// accessLogEntry is the json line written to the access log for every http request
+type accessLogEntry struct {
+	Timestamp     time.Time `json:"timestamp"`
+	Method        string    `json:"method"`
+	Host          string    `json:"host"`
+	Path          string    `json:"path"`
+	RemoteAddress string    `json:"remote-address"`
+	CorrelationID string    `json:"correlation-id,omitempty"`
+}
+
+// accessLog appends the entries to a file through a buffer flushed periodically,
+// so that requests are never blocked on the file writes
+type accessLog struct {
+	path string
+
+	mu     sync.Mutex
+	file   *os.File
+	writer *bufio.Writer
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newAccessLog(path string) (*accessLog, error) {
+	log := &accessLog{path: path, stop: make(chan struct{}), done: make(chan struct{})}
+	if err := log.open(); err != nil {
+		return nil, err
+	}
+	go log.run(accessLogFlushInterval)
+	return log, nil
+}
+
+// open opens the file of the access log for appending. The caller must hold the lock.
+func (l *accessLog) open() error {
+	file, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return errors.Wrap(err, "could not open access log")
+	}
+	l.file = file
+	l.writer = bufio.NewWriter(file)
+	return nil
+}
+
+func (l *accessLog) run(interval time.Duration) {
+	defer close(l.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := l.flush(); err != nil {
+				gologger.Warning().Msgf("Could not write access log: %s\n", err)
+			}
+		case <-l.stop:
+			return
+		}
+	}
+}
+
+// write buffers an entry, it's written to the file on the next flush
+func (l *accessLog) write(entry *accessLogEntry) {
+	data, err := jsoniter.Marshal(entry)
+	if err != nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.writer == nil {
+		return
+	}
+	_, _ = l.writer.Write(append(data, '\n'))
+}
+
+// flush writes the buffered entries to the file
+func (l *accessLog) flush() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.writer == nil {
+		return nil
+	}
+	return l.writer.Flush()
+}
+
+// reopen flushes the buffered entries and reopens the file, for rotations moving it away
+func (l *accessLog) reopen() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file != nil {
+		_ = l.writer.Flush()
+		_ = l.file.Close()
+		l.file, l.writer = nil, nil
+	}
+	return l.open()
+}
+
+// close stops the periodic flushes and closes the file once the buffered entries are written
+func (l *accessLog) close() error {
+	close(l.stop)
+	<-l.done
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+	flushErr := l.writer.Flush()
+	closeErr := l.file.Close()
+	l.file, l.writer = nil, nil
+	if flushErr != nil {
+		return flushErr
+	}
+	return closeErr
+}
+
+// accessLogEntryKey is the context key of the access log entry of an http request
+type accessLogEntryKey struct{}
+
+// accessLogMiddleware writes an access log entry for every request served by the router,
+// the correlation id being filled in by the logger for the requests it matches
+func (h *HTTPServer) accessLogMiddleware(next http.Handler) http.Handler {
+	if h.accessLog == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		host, _, _ := net.SplitHostPort(req.RemoteAddr)
+		if originIP := req.Header.Get(h.options.OriginIPHeader); h.options.OriginIPHeader != "" && originIP != "" {
+			host = originIP
+		}
+		entry := &accessLogEntry{
+			Timestamp:     time.Now(),
+			Method:        req.Method,
+			Host:          req.Host,
+			Path:          req.URL.Path,
+			RemoteAddress: host,
+		}
+		next.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), accessLogEntryKey{}, entry)))
+		h.accessLog.write(entry)
+	})
+}
+
+// logAccessCorrelation sets the first correlation id matched in a request on its access log entry
+func (h *HTTPServer) logAccessCorrelation(r *http.Request, matches []correlationMatch) {
+	entry, ok := r.Context().Value(accessLogEntryKey{}).(*accessLogEntry)
+	if !ok || len(matches) == 0 {
+		return
+	}
+	entry.CorrelationID = matches[0].correlationID
+	if entry.CorrelationID == "" {
+		entry.CorrelationID = matches[0].uniqueID[:h.options.CorrelationIdLength]
+	}
+}
+
+// ReopenAccessLog reopens the access log file, to be called once it's rotated
+func (h *HTTPServer) ReopenAccessLog() error {
+	if h.accessLog == nil {
+		return nil
+	}
+	return h.accessLog.reopen()
+}
+
+// CloseAccessLog writes the buffered access log entries and closes the file
+func (h *HTTPServer) CloseAccessLog() error {
+	if h.accessLog == nil {
+		return nil
+	}
+	return h.accessLog.close()
+}
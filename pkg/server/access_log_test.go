@@ -0,0 +1,74 @@
+package server
+
+import (
+	"bufio"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccessLog(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "access.log")
+	opts := &Options{Domains: []string{"example.com"}, CorrelationIdLength: 10, CorrelationIdNonceLength: 3, OriginIPHeader: "X-Forwarded-For", AccessLogPath: logFile}
+	newTestStore(t, opts, "abcdefghij")
+	h, err := NewHTTPServer(opts)
+	require.NoError(t, err)
+
+	send := func(host, path, forwardedFor string) {
+		req := httptest.NewRequest("POST", path, nil)
+		req.Host = host
+		if forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		h.nontlsserver.Handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	readEntries := func() []*accessLogEntry {
+		file, err := os.Open(logFile)
+		require.NoError(t, err)
+		defer file.Close()
+		var entries []*accessLogEntry
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			entry := &accessLogEntry{}
+			require.NoError(t, jsoniter.Unmarshal(scanner.Bytes(), entry))
+			entries = append(entries, entry)
+		}
+		return entries
+	}
+
+	send("abcdefghijklm.example.com", "/login", "")
+	send("example.com", "/unmatched", "203.0.113.7")
+	send("example.com", "/poll", "")
+	require.NoError(t, h.accessLog.flush())
+
+	entries := readEntries()
+	require.Len(t, entries, 3)
+	require.Equal(t, "POST", entries[0].Method)
+	require.Equal(t, "abcdefghijklm.example.com", entries[0].Host)
+	require.Equal(t, "/login", entries[0].Path)
+	require.Equal(t, "192.0.2.1", entries[0].RemoteAddress)
+	require.Equal(t, "abcdefghij", entries[0].CorrelationID)
+	require.False(t, entries[0].Timestamp.IsZero())
+	require.Equal(t, "/unmatched", entries[1].Path)
+	require.Equal(t, "203.0.113.7", entries[1].RemoteAddress, "the origin ip header should be respected")
+	require.Empty(t, entries[1].CorrelationID)
+	require.Equal(t, "/poll", entries[2].Path, "requests to the api endpoints should be logged as well")
+	require.Empty(t, entries[2].CorrelationID)
+
+	// the log is reopened at its path once rotated
+	require.NoError(t, os.Rename(logFile, logFile+".1"))
+	send("example.com", "/rotated", "")
+	require.NoError(t, h.ReopenAccessLog())
+	send("example.com", "/reopened", "")
+	require.NoError(t, h.CloseAccessLog())
+	entries = readEntries()
+	require.Len(t, entries, 1)
+	require.Equal(t, "/reopened", entries[0].Path)
+	rotated, err := os.ReadFile(logFile + ".1")
+	require.NoError(t, err)
+	require.Contains(t, string(rotated), `"path":"/rotated"`, "buffered entries should be flushed to the rotated file")
+}
@@ -0,0 +1,111 @@
+package acme
+
+import (
+	"crypto/tls"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/projectdiscovery/gologger"
+)
+
+// CertificateStore serves certificates through tls.Config.GetCertificate so
+// they can be swapped atomically without restarting the listeners.
+type CertificateStore struct {
+	certificates atomic.Pointer[[]tls.Certificate]
+
+	mu      sync.Mutex
+	files   []CertificateFiles
+	modTime time.Time
+}
+
+// NewCertificateStore returns a store serving certs, reloaded from files when requested.
+func NewCertificateStore(files []CertificateFiles, certs ...tls.Certificate) *CertificateStore {
+	store := &CertificateStore{files: files, modTime: latestModTime(files)}
+	store.Set(certs...)
+	return store
+}
+
+// Set replaces the served certificates, new handshakes use them immediately.
+func (s *CertificateStore) Set(certs ...tls.Certificate) {
+	s.certificates.Store(&certs)
+}
+
+// GetCertificate returns the certificate matching the client hello,
+// defaulting to the first one. It is meant to be used as tls.Config.GetCertificate.
+func (s *CertificateStore) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	certs := *s.certificates.Load()
+	if len(certs) == 0 {
+		return nil, errors.New("no certificates available")
+	}
+	for i := range certs {
+		if hello.SupportsCertificate(&certs[i]) == nil {
+			return &certs[i], nil
+		}
+	}
+	return &certs[0], nil
+}
+
+// Reload loads the certificates again from their files. The served
+// certificates are left unchanged if any of them can't be loaded.
+func (s *CertificateStore) Reload() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.files) == 0 {
+		return errors.New("no certificate files to reload")
+	}
+	certs := make([]tls.Certificate, 0, len(s.files))
+	for _, file := range s.files {
+		cert, err := tls.LoadX509KeyPair(file.CertPath, file.PrivKeyPath)
+		if err != nil {
+			return errors.Wrapf(err, "could not load certificate %s", file.CertPath)
+		}
+		certs = append(certs, cert)
+	}
+	s.Set(certs...)
+	s.modTime = latestModTime(s.files)
+	return nil
+}
+
+// Watch reloads the certificates whenever their files change on disk, checking every interval.
+func (s *CertificateStore) Watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.mu.Lock()
+		changed := latestModTime(s.files).After(s.modTime)
+		s.mu.Unlock()
+		if !changed {
+			continue
+		}
+		if err := s.Reload(); err != nil {
+			gologger.Warning().Msgf("Could not reload certificates: %s\n", err)
+			continue
+		}
+		gologger.Info().Msgf("Reloaded certificates from disk\n")
+	}
+}
+
+// TLSConfig returns a copy of tlsConfig serving the certificates of the store.
+func (s *CertificateStore) TLSConfig(tlsConfig *tls.Config) *tls.Config {
+	config := tlsConfig.Clone()
+	config.Certificates = nil
+	config.GetCertificate = s.GetCertificate
+	return config
+}
+
+func latestModTime(files []CertificateFiles) time.Time {
+	var latest time.Time
+	for _, file := range files {
+		for _, path := range []string{file.CertPath, file.PrivKeyPath} {
+			if info, err := os.Stat(path); err == nil && info.ModTime().After(latest) {
+				latest = info.ModTime()
+			}
+		}
+	}
+	return latest
+}
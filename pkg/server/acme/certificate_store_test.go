@@ -0,0 +1,87 @@
+package acme
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// writeTestCertificate writes a self-signed certificate with the given serial to files
+func writeTestCertificate(t *testing.T, files CertificateFiles, serial int64) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		DNSNames:     []string{"example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(files.CertPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, os.WriteFile(files.PrivKeyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+}
+
+// servedSerial returns the serial of the certificate served on a new handshake
+func servedSerial(t *testing.T, addr string) int64 {
+	conn, err := tls.Dial("tcp", addr, &tls.Config{ServerName: "example.com", InsecureSkipVerify: true})
+	require.NoError(t, err)
+	defer conn.Close()
+	return conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64()
+}
+
+func TestCertificateStoreReload(t *testing.T) {
+	dir := t.TempDir()
+	files := CertificateFiles{CertPath: filepath.Join(dir, "cert.pem"), PrivKeyPath: filepath.Join(dir, "key.pem")}
+	writeTestCertificate(t, files, 1)
+
+	cert, err := tls.LoadX509KeyPair(files.CertPath, files.PrivKeyPath)
+	require.NoError(t, err)
+	tlsConfig, err := BuildTlsConfigWithCerts("", cert)
+	require.NoError(t, err)
+	store := NewCertificateStore([]CertificateFiles{files}, tlsConfig.Certificates...)
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", store.TLSConfig(tlsConfig))
+	require.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				_ = conn.(*tls.Conn).Handshake()
+				_ = conn.Close()
+			}(conn)
+		}
+	}()
+	addr := listener.Addr().String()
+	require.Equal(t, int64(1), servedSerial(t, addr))
+
+	writeTestCertificate(t, files, 2)
+	require.Equal(t, int64(1), servedSerial(t, addr), "certificate should not change before reload")
+	require.NoError(t, store.Reload())
+	require.Equal(t, int64(2), servedSerial(t, addr), "new certificate should be served after reload")
+
+	// invalid files keep the current certificate
+	require.NoError(t, os.WriteFile(files.CertPath, []byte("invalid"), 0600))
+	require.Error(t, store.Reload())
+	require.Equal(t, int64(2), servedSerial(t, addr))
+}
@@ -4,11 +4,14 @@ package acme
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"strings"
 	"sync"
 
 	"github.com/libdns/libdns"
+	"github.com/mholt/acmez/v3"
+	acmeapi "github.com/mholt/acmez/v3/acme"
 )
 
 type RecordStore struct {
@@ -18,10 +21,14 @@ type RecordStore struct {
 type Provider struct {
 	sync.Mutex
 	recordMap map[string]*RecordStore
+	// httpChallenges maps HTTP-01 challenge tokens to their key authorization
+	httpChallenges map[string]string
+	// tlsALPNChallenges maps TLS-ALPN-01 challenge domains to their challenge certificate
+	tlsALPNChallenges map[string]*tls.Certificate
 }
 
 func NewProvider() *Provider {
-	return &Provider{Mutex: sync.Mutex{}, recordMap: make(map[string]*RecordStore)}
+	return &Provider{Mutex: sync.Mutex{}, recordMap: make(map[string]*RecordStore), httpChallenges: make(map[string]string), tlsALPNChallenges: make(map[string]*tls.Certificate)}
 }
 
 func (p *Provider) getZoneRecords(_ context.Context, zoneName string) *RecordStore {
@@ -87,6 +94,59 @@ func (p *Provider) GetRecords(ctx context.Context, zoneName string) ([]libdns.Re
 	return records.entries, nil
 }
 
+// PresentHTTPChallenge stores the key authorization served for an HTTP-01 challenge token
+func (p *Provider) PresentHTTPChallenge(token, keyAuthorization string) {
+	p.Lock()
+	defer p.Unlock()
+	p.httpChallenges[token] = keyAuthorization
+}
+
+// CleanUpHTTPChallenge removes an HTTP-01 challenge token
+func (p *Provider) CleanUpHTTPChallenge(token string) {
+	p.Lock()
+	defer p.Unlock()
+	delete(p.httpChallenges, token)
+}
+
+// GetHTTPChallenge returns the key authorization for an HTTP-01 challenge token
+func (p *Provider) GetHTTPChallenge(token string) (string, bool) {
+	p.Lock()
+	defer p.Unlock()
+	keyAuthorization, ok := p.httpChallenges[token]
+	return keyAuthorization, ok
+}
+
+// PresentTLSALPNChallenge generates and stores the certificate served on
+// acme-tls/1 handshakes for a TLS-ALPN-01 challenge of the domain
+func (p *Provider) PresentTLSALPNChallenge(domain, keyAuthorization string) error {
+	certificate, err := acmez.TLSALPN01ChallengeCert(acmeapi.Challenge{
+		Identifier:       acmeapi.Identifier{Type: "dns", Value: domain},
+		KeyAuthorization: keyAuthorization,
+	})
+	if err != nil {
+		return err
+	}
+	p.Lock()
+	defer p.Unlock()
+	p.tlsALPNChallenges[strings.ToLower(domain)] = certificate
+	return nil
+}
+
+// CleanUpTLSALPNChallenge removes the TLS-ALPN-01 challenge of the domain
+func (p *Provider) CleanUpTLSALPNChallenge(domain string) {
+	p.Lock()
+	defer p.Unlock()
+	delete(p.tlsALPNChallenges, strings.ToLower(domain))
+}
+
+// GetTLSALPNChallenge returns the challenge certificate for the TLS-ALPN-01 challenge of the domain
+func (p *Provider) GetTLSALPNChallenge(domain string) (*tls.Certificate, bool) {
+	p.Lock()
+	defer p.Unlock()
+	certificate, ok := p.tlsALPNChallenges[strings.ToLower(domain)]
+	return certificate, ok
+}
+
 var (
 	_ libdns.RecordGetter   = (*Provider)(nil)
 	_ libdns.RecordAppender = (*Provider)(nil)
@@ -96,4 +156,6 @@ var (
 const (
 	DNSChallengeString   = "_acme-challenge."
 	CertificateAuthority = "letsencrypt.org."
+	HTTPChallengePath    = "/.well-known/acme-challenge/"
+	TLSALPNProtocol      = acmez.ACMETLS1Protocol
 )
@@ -0,0 +1,226 @@
+package server
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/projectdiscovery/gologger"
+)
+
+// maxCampaignRemoteAddresses is the maximum number of distinct remote addresses kept per session of a campaign
+const maxCampaignRemoteAddresses = 256
+
+// campaignTracker groups the correlation ids registered under a campaign
+// and aggregates the metadata of their interactions
+type campaignTracker struct {
+	mu sync.RWMutex
+	// sessions holds the sessions of the campaigns by correlation id
+	sessions map[string]*campaignSession
+	// campaigns holds the correlation ids of each campaign
+	campaigns map[string]map[string]struct{}
+}
+
+// campaignSession is the interaction metadata of a correlation id of a campaign
+type campaignSession struct {
+	campaign        string
+	interactions    int
+	protocols       map[string]int
+	remoteAddresses map[string]struct{}
+	first, last     time.Time
+}
+
+func newCampaignTracker() *campaignTracker {
+	return &campaignTracker{
+		sessions:  make(map[string]*campaignSession),
+		campaigns: make(map[string]map[string]struct{}),
+	}
+}
+
+// campaignTracker returns the campaign tracker shared by the servers
+func (options *Options) campaignTracker() *campaignTracker {
+	options.campaignsOnce.Do(func() {
+		options.campaigns = newCampaignTracker()
+	})
+	return options.campaigns
+}
+
+// register adds a correlation id to a campaign, moving it from its previous one
+func (t *campaignTracker) register(campaign, correlationID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if session, ok := t.sessions[correlationID]; ok {
+		if session.campaign == campaign {
+			return
+		}
+		t.removeLocked(correlationID)
+	}
+	t.sessions[correlationID] = &campaignSession{
+		campaign:        campaign,
+		protocols:       make(map[string]int),
+		remoteAddresses: make(map[string]struct{}),
+	}
+	ids, ok := t.campaigns[campaign]
+	if !ok {
+		ids = make(map[string]struct{})
+		t.campaigns[campaign] = ids
+	}
+	ids[correlationID] = struct{}{}
+}
+
+// remove drops a correlation id from its campaign
+func (t *campaignTracker) remove(correlationID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.removeLocked(correlationID)
+}
+
+func (t *campaignTracker) removeLocked(correlationID string) {
+	session, ok := t.sessions[correlationID]
+	if !ok {
+		return
+	}
+	delete(t.sessions, correlationID)
+	ids := t.campaigns[session.campaign]
+	delete(ids, correlationID)
+	if len(ids) == 0 {
+		delete(t.campaigns, session.campaign)
+	}
+}
+
+// track records the metadata of an interaction of a correlation id belonging to a campaign
+func (t *campaignTracker) track(correlationID string, interaction *Interaction) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	session, ok := t.sessions[correlationID]
+	if !ok {
+		return
+	}
+	session.interactions++
+	session.protocols[interaction.Protocol]++
+	if interaction.RemoteAddress != "" && len(session.remoteAddresses) < maxCampaignRemoteAddresses {
+		session.remoteAddresses[interaction.RemoteAddress] = struct{}{}
+	}
+	if session.first.IsZero() || interaction.Timestamp.Before(session.first) {
+		session.first = interaction.Timestamp
+	}
+	if interaction.Timestamp.After(session.last) {
+		session.last = interaction.Timestamp
+	}
+}
+
+// CampaignResponse is the interaction metadata aggregated across the correlation ids of a campaign
+type CampaignResponse struct {
+	Campaign string `json:"campaign"`
+	// CorrelationIDs are the correlation ids registered under the campaign
+	CorrelationIDs []string `json:"correlation-ids"`
+	CampaignMetadata
+	// Sessions is the metadata of each correlation id
+	Sessions []CampaignSessionMetadata `json:"sessions"`
+}
+
+// CampaignMetadata is the metadata of the interactions of a campaign or one of its correlation ids
+type CampaignMetadata struct {
+	Interactions     int            `json:"interactions"`
+	Protocols        map[string]int `json:"protocols"`
+	RemoteAddresses  []string       `json:"remote-addresses"`
+	FirstInteraction *time.Time     `json:"first-interaction,omitempty"`
+	LastInteraction  *time.Time     `json:"last-interaction,omitempty"`
+}
+
+// CampaignSessionMetadata is the metadata of the interactions of a correlation id of a campaign
+type CampaignSessionMetadata struct {
+	CorrelationID string `json:"correlation-id"`
+	CampaignMetadata
+}
+
+// aggregate returns the metadata of a campaign, or false when no correlation id is registered under it
+func (t *campaignTracker) aggregate(campaign string) (*CampaignResponse, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	ids, ok := t.campaigns[campaign]
+	if !ok {
+		return nil, false
+	}
+	response := &CampaignResponse{Campaign: campaign, CampaignMetadata: newCampaignMetadata()}
+	remoteAddresses := make(map[string]struct{})
+	for correlationID := range ids {
+		session := t.sessions[correlationID]
+		metadata := newCampaignMetadata()
+		metadata.add(session)
+		metadata.RemoteAddresses = sortedKeys(session.remoteAddresses)
+		for remoteAddress := range session.remoteAddresses {
+			remoteAddresses[remoteAddress] = struct{}{}
+		}
+		response.CorrelationIDs = append(response.CorrelationIDs, correlationID)
+		response.Sessions = append(response.Sessions, CampaignSessionMetadata{CorrelationID: correlationID, CampaignMetadata: metadata})
+		response.CampaignMetadata.add(session)
+	}
+	response.RemoteAddresses = sortedKeys(remoteAddresses)
+	sort.Strings(response.CorrelationIDs)
+	sort.Slice(response.Sessions, func(i, j int) bool {
+		return response.Sessions[i].CorrelationID < response.Sessions[j].CorrelationID
+	})
+	return response, true
+}
+
+func newCampaignMetadata() CampaignMetadata {
+	return CampaignMetadata{Protocols: make(map[string]int)}
+}
+
+// add merges the interaction counts and times of a session into the metadata
+func (m *CampaignMetadata) add(session *campaignSession) {
+	m.Interactions += session.interactions
+	for protocol, count := range session.protocols {
+		m.Protocols[protocol] += count
+	}
+	if session.interactions == 0 {
+		return
+	}
+	if m.FirstInteraction == nil || session.first.Before(*m.FirstInteraction) {
+		first := session.first
+		m.FirstInteraction = &first
+	}
+	if m.LastInteraction == nil || session.last.After(*m.LastInteraction) {
+		last := session.last
+		m.LastInteraction = &last
+	}
+}
+
+func sortedKeys(set map[string]struct{}) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// campaignHandler returns the interaction metadata aggregated across the correlation ids of a campaign
+func (h *HTTPServer) campaignHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	campaign := strings.TrimPrefix(req.URL.Path, "/admin/campaign/")
+	if campaign == "" {
+		jsonError(w, "no campaign specified", http.StatusBadRequest)
+		return
+	}
+	response, ok := h.options.campaignTracker().aggregate(campaign)
+	if !ok {
+		jsonError(w, "campaign not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := jsoniter.NewEncoder(w).Encode(response); err != nil {
+		gologger.Warning().Msgf("Could not encode campaign %s: %s\n", campaign, err)
+	}
+}
@@ -0,0 +1,220 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/projectdiscovery/gologger"
+)
+
+const (
+	cefQueueSize    = 4096
+	cefWriteTimeout = 10 * time.Second
+	cefVendor       = "ProjectDiscovery"
+	cefProduct      = "Interactsh"
+	cefSeverity     = 5
+	// cefSyslogPriority is the user facility with notice severity
+	cefSyslogPriority = 13
+)
+
+var (
+	cefHeaderEscaper    = strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\r", " ", "\n", " ")
+	cefExtensionEscaper = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\r\n", `\n`, "\r", `\r`, "\n", `\n`)
+)
+
+// formatCEF returns the interaction as a Common Event Format record
+func formatCEF(interaction *Interaction, correlationIdLength int, version string) string {
+	correlationID := interaction.CorrelationID
+	if correlationID == "" && correlationIdLength > 0 && len(interaction.UniqueID) >= correlationIdLength {
+		correlationID = interaction.UniqueID[:correlationIdLength]
+	}
+	source := interaction.RemoteAddress
+	if host, _, err := net.SplitHostPort(source); err == nil {
+		source = host
+	}
+
+	extensions := [][2]string{
+		{"rt", strconv.FormatInt(interaction.Timestamp.UnixMilli(), 10)},
+		{"app", interaction.Protocol},
+		{"src", source},
+		{"cs1Label", "correlationId"},
+		{"cs1", correlationID},
+		{"cs2Label", "uniqueId"},
+		{"cs2", interaction.UniqueID},
+		{"cs3Label", "fullId"},
+		{"cs3", interaction.FullId},
+	}
+	if interaction.QType != "" {
+		extensions = append(extensions, [2]string{"cs4Label", "qType"}, [2]string{"cs4", interaction.QType})
+	}
+
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "CEF:0|%s|%s|%s|%s|%s|%d|",
+		cefHeaderEscaper.Replace(cefVendor),
+		cefHeaderEscaper.Replace(cefProduct),
+		cefHeaderEscaper.Replace(version),
+		cefHeaderEscaper.Replace(interaction.Protocol),
+		cefHeaderEscaper.Replace(strings.ToUpper(interaction.Protocol)+" interaction"),
+		cefSeverity,
+	)
+	for i, extension := range extensions {
+		if i > 0 {
+			builder.WriteByte(' ')
+		}
+		builder.WriteString(extension[0])
+		builder.WriteByte('=')
+		builder.WriteString(cefExtensionEscaper.Replace(extension[1]))
+	}
+	return builder.String()
+}
+
+// CEFExporter forwards interactions as CEF records to an http collector or
+// a syslog server asynchronously. Records are queued in a bounded buffer and
+// dropped when it's full, so protocol handlers are never blocked.
+type CEFExporter struct {
+	collector           *url.URL
+	correlationIdLength int
+	version             string
+	hostname            string
+	stats               *Metrics
+	client              *http.Client
+	queue               chan string
+	stop                chan struct{}
+	done                chan struct{}
+
+	// mu guards closed, so that nothing is queued once the exporter is closed
+	mu     sync.RWMutex
+	closed bool
+}
+
+// NewCEFExporter returns an exporter forwarding to the cef collector of the options,
+// either http(s)://host/path or udp://host:port and tcp://host:port for syslog.
+func NewCEFExporter(options *Options) (*CEFExporter, error) {
+	collector, err := url.Parse(options.CEFCollector)
+	if err != nil {
+		return nil, err
+	}
+	switch collector.Scheme {
+	case "http", "https", "udp", "tcp":
+	default:
+		return nil, errors.New("cef collector scheme must be one of http, https, udp or tcp")
+	}
+	stats := options.Stats
+	if stats == nil {
+		stats = &Metrics{}
+	}
+	hostname, _ := os.Hostname()
+	exporter := &CEFExporter{
+		collector:           collector,
+		correlationIdLength: options.CorrelationIdLength,
+		version:             options.Version,
+		hostname:            hostname,
+		stats:               stats,
+		client:              &http.Client{Timeout: cefWriteTimeout},
+		queue:               make(chan string, cefQueueSize),
+		stop:                make(chan struct{}),
+		done:                make(chan struct{}),
+	}
+	go exporter.run()
+	return exporter, nil
+}
+
+// Export enqueues the interaction for forwarding without blocking. Interactions
+// exported once the exporter is closed are dropped.
+func (c *CEFExporter) Export(interaction *Interaction) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.closed {
+		atomic.AddUint64(&c.stats.CEFDropped, 1)
+		return
+	}
+	select {
+	case c.queue <- formatCEF(interaction, c.correlationIdLength, c.version):
+	default:
+		atomic.AddUint64(&c.stats.CEFDropped, 1)
+		gologger.Warning().Msgf("CEF queue is full, dropping interaction\n")
+	}
+}
+
+// Close forwards the queued records and stops the exporter.
+func (c *CEFExporter) Close() {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return
+	}
+	c.closed = true
+	c.mu.Unlock()
+
+	close(c.stop)
+	<-c.done
+}
+
+func (c *CEFExporter) run() {
+	defer close(c.done)
+
+	for {
+		select {
+		case record := <-c.queue:
+			c.forward(record)
+		case <-c.stop:
+			// the records queued before closing are still forwarded
+			for {
+				select {
+				case record := <-c.queue:
+					c.forward(record)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (c *CEFExporter) forward(record string) {
+	var err error
+	if c.collector.Scheme == "http" || c.collector.Scheme == "https" {
+		err = c.post(record)
+	} else {
+		err = c.syslog(record)
+	}
+	if err != nil {
+		atomic.AddUint64(&c.stats.CEFFailed, 1)
+		gologger.Warning().Msgf("Could not forward cef record: %s\n", err)
+	}
+}
+
+func (c *CEFExporter) post(record string) error {
+	resp, err := c.client.Post(c.collector.String(), "text/plain", bytes.NewBufferString(record))
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (c *CEFExporter) syslog(record string) error {
+	conn, err := net.DialTimeout(c.collector.Scheme, c.collector.Host, cefWriteTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_ = conn.SetWriteDeadline(time.Now().Add(cefWriteTimeout))
+	_, err = fmt.Fprintf(conn, "<%d>%s %s interactsh: %s\n", cefSyslogPriority, time.Now().Format(time.Stamp), c.hostname, record)
+	return err
+}
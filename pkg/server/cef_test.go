@@ -0,0 +1,86 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormatCEF(t *testing.T) {
+	interaction := &Interaction{
+		Protocol:      "dns",
+		UniqueID:      "abcdefghijklm",
+		FullId:        "abcdefghijklm.a=b",
+		QType:         "A",
+		RemoteAddress: "192.0.2.1:53123",
+		Timestamp:     time.UnixMilli(1700000000123),
+	}
+	record := formatCEF(interaction, 10, "1.0|beta")
+	require.Equal(t, `CEF:0|ProjectDiscovery|Interactsh|1.0\|beta|dns|DNS interaction|5|rt=1700000000123 app=dns src=192.0.2.1 cs1Label=correlationId cs1=abcdefghij cs2Label=uniqueId cs2=abcdefghijklm cs3Label=fullId cs3=abcdefghijklm.a\=b cs4Label=qType cs4=A`, record)
+}
+
+func TestCEFExporterHTTP(t *testing.T) {
+	records := make(chan string, 1)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		records <- string(body)
+	}))
+	defer collector.Close()
+
+	exporter, err := NewCEFExporter(&Options{CEFCollector: collector.URL, CorrelationIdLength: 10, Version: "1.0"})
+	require.NoError(t, err)
+	exporter.Export(&Interaction{Protocol: "http", UniqueID: "abcdefghijklm", RemoteAddress: "192.0.2.1", Timestamp: time.UnixMilli(0)})
+	exporter.Close()
+
+	select {
+	case record := <-records:
+		require.Equal(t, "CEF:0|ProjectDiscovery|Interactsh|1.0|http|HTTP interaction|5|rt=0 app=http src=192.0.2.1 cs1Label=correlationId cs1=abcdefghij cs2Label=uniqueId cs2=abcdefghijklm cs3Label=fullId cs3=", record)
+	default:
+		t.Fatal("collector did not receive the record")
+	}
+
+	// interactions exported by the handlers still running once closed are dropped
+	exporter.Export(&Interaction{Protocol: "http", UniqueID: "abcdefghijklm"})
+	require.Equal(t, uint64(1), exporter.stats.CEFDropped)
+	exporter.Close()
+
+	_, err = NewCEFExporter(&Options{CEFCollector: "ftp://127.0.0.1"})
+	require.Error(t, err)
+}
+
+func TestCEFExporterRootTLDInteractions(t *testing.T) {
+	records := make(chan string, 2)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		records <- string(body)
+	}))
+	defer collector.Close()
+
+	opts := newTestOptions([]string{"192.0.2.1"}, "127.0.0.1")
+	opts.RootTLD = true
+	newTestStore(t, opts, "example.com")
+	var results int
+	opts.OnResult = func(interface{}) { results++ }
+	exporter, err := NewCEFExporter(&Options{CEFCollector: collector.URL, CorrelationIdLength: 10, Version: "1.0"})
+	require.NoError(t, err)
+	opts.CEF = exporter
+
+	req := new(dns.Msg)
+	req.SetQuestion("www.example.com.", dns.TypeA)
+	NewDNSServer("udp", opts).ServeDNS(&testResponseWriter{}, req)
+	exporter.Close()
+
+	require.Equal(t, 1, results, "the interaction should be passed once to the callbacks")
+	select {
+	case record := <-records:
+		require.Contains(t, record, "app=dns")
+		require.Contains(t, record, "cs2=www.example.com.")
+	default:
+		t.Fatal("collector did not receive the record")
+	}
+}
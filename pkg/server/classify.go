@@ -0,0 +1,51 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+)
+
+const (
+	// InteractionCanary is a bare hit of the registered subdomain, typically a warmup request
+	InteractionCanary = "canary"
+	// InteractionData is a hit carrying data in the subdomain labels, path, query or body
+	InteractionData = "data"
+	// InteractionScan is a probe of a well known path or method unrelated to the test
+	InteractionScan = "scan"
+)
+
+// scanPaths are the path prefixes probed by crawlers and vulnerability scanners
+var scanPaths = []string{
+	"/robots.txt",
+	"/favicon.ico",
+	"/sitemap.xml",
+	"/.well-known/",
+	"/.env",
+	"/.git/",
+	"/wp-login.php",
+	"/wp-admin",
+	"/xmlrpc.php",
+	"/phpmyadmin",
+	"/server-status",
+}
+
+// classifyHTTPRequest returns whether a http interaction is likely a canary, data or scan request
+func classifyHTTPRequest(r *http.Request, uniqueID string) string {
+	path := strings.ToLower(r.URL.Path)
+	if r.Method == http.MethodHead || r.Method == http.MethodOptions {
+		return InteractionScan
+	}
+	for _, scanPath := range scanPaths {
+		if strings.HasPrefix(path, scanPath) {
+			return InteractionScan
+		}
+	}
+	// labels preceding the correlation id carry data
+	if strings.Index(strings.ToLower(r.Host), uniqueID) > 0 {
+		return InteractionData
+	}
+	if (path != "" && path != "/") || r.URL.RawQuery != "" || r.ContentLength != 0 {
+		return InteractionData
+	}
+	return InteractionCanary
+}
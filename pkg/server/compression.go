@@ -0,0 +1,108 @@
+package server
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/projectdiscovery/gologger"
+)
+
+// compressMiddleware compresses the responses with gzip or deflate, as accepted by
+// the Accept-Encoding header of the client, unless HTTPDisableCompression is set
+func (h *HTTPServer) compressMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if h.options.HTTPDisableCompression {
+			next.ServeHTTP(w, req)
+			return
+		}
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		var encoder io.WriteCloser
+		encoding := responseEncoding(req)
+		switch encoding {
+		case "gzip":
+			encoder = gzip.NewWriter(w)
+		case "deflate":
+			// the deflate content coding is the zlib format
+			encoder = zlib.NewWriter(w)
+		default:
+			next.ServeHTTP(w, req)
+			return
+		}
+		cw := &compressResponseWriter{ResponseWriter: w, encoder: encoder, encoding: encoding}
+		next.ServeHTTP(cw, req)
+		if err := cw.close(); err != nil {
+			gologger.Warning().Msgf("Could not write compressed response: %s\n", err)
+		}
+	})
+}
+
+// responseEncoding returns the content coding preferred by the client among gzip
+// and deflate, or an empty string when it accepts none of them. gzip is preferred
+// when both have the same quality.
+func responseEncoding(req *http.Request) string {
+	var gzipQ, deflateQ float64
+	for _, value := range req.Header.Values("Accept-Encoding") {
+		for _, part := range strings.Split(value, ",") {
+			encoding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+			q := 1.0
+			if name, value, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.EqualFold(strings.TrimSpace(name), "q") {
+				if parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+					q = parsed
+				}
+			}
+			switch strings.ToLower(strings.TrimSpace(encoding)) {
+			case "gzip":
+				gzipQ = max(gzipQ, q)
+			case "deflate":
+				deflateQ = max(deflateQ, q)
+			}
+		}
+	}
+	switch {
+	case gzipQ > 0 && gzipQ >= deflateQ:
+		return "gzip"
+	case deflateQ > 0:
+		return "deflate"
+	default:
+		return ""
+	}
+}
+
+// compressResponseWriter compresses the body written by a handler
+type compressResponseWriter struct {
+	http.ResponseWriter
+	encoder     io.WriteCloser
+	encoding    string
+	wroteHeader bool
+}
+
+func (w *compressResponseWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	w.Header().Set("Content-Encoding", w.encoding)
+	// the length of the compressed body is not known in advance
+	w.Header().Del("Content-Length")
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *compressResponseWriter) Write(data []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.encoder.Write(data)
+}
+
+// close writes the remaining compressed data, responses without a body are left as is
+func (w *compressResponseWriter) close() error {
+	if !w.wroteHeader {
+		return nil
+	}
+	return w.encoder.Close()
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"encoding/binary"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/projectdiscovery/gologger"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// Deduplicator drops the interactions repeating the fingerprint of one recorded for
+// the same correlation id within the window. The fingerprints are persisted to a
+// leveldb database when a path is set, so that the window survives restarts.
+type Deduplicator struct {
+	window time.Duration
+	db     *leveldb.DB
+
+	mu     sync.Mutex
+	seen   map[string]time.Time
+	pruned time.Time
+}
+
+// NewDeduplicator returns a deduplicator for the dedup window of the options,
+// loading the fingerprints still in the window from the dedup path if set.
+func NewDeduplicator(options *Options) (*Deduplicator, error) {
+	d := &Deduplicator{
+		window: options.DedupWindow,
+		seen:   make(map[string]time.Time),
+		pruned: time.Now(),
+	}
+	if options.DedupPath == "" {
+		return d, nil
+	}
+	db, err := leveldb.OpenFile(options.DedupPath, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not open dedup database")
+	}
+	d.db = db
+
+	iter := db.NewIterator(nil, nil)
+	defer iter.Release()
+	for iter.Next() {
+		if len(iter.Value()) != 8 {
+			continue
+		}
+		seenAt := time.Unix(0, int64(binary.BigEndian.Uint64(iter.Value())))
+		if time.Since(seenAt) >= d.window {
+			_ = db.Delete(iter.Key(), nil)
+			continue
+		}
+		d.seen[string(iter.Key())] = seenAt
+	}
+	if err := iter.Error(); err != nil {
+		_ = db.Close()
+		return nil, errors.Wrap(err, "could not read dedup database")
+	}
+	return d, nil
+}
+
+// duplicate returns true if the key was seen within the window, otherwise
+// records it as seen now
+func (d *Deduplicator) duplicate(key string) bool {
+	now := time.Now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.prune(now)
+	if seenAt, ok := d.seen[key]; ok && now.Sub(seenAt) < d.window {
+		return true
+	}
+	d.seen[key] = now
+	if d.db != nil {
+		value := make([]byte, 8)
+		binary.BigEndian.PutUint64(value, uint64(now.UnixNano()))
+		if err := d.db.Put([]byte(key), value, nil); err != nil {
+			gologger.Warning().Msgf("Could not persist dedup fingerprint: %s\n", err)
+		}
+	}
+	return false
+}
+
+// prune drops the keys seen before the window once per window. The caller must hold the lock.
+func (d *Deduplicator) prune(now time.Time) {
+	if now.Sub(d.pruned) < d.window {
+		return
+	}
+	d.pruned = now
+	for key, seenAt := range d.seen {
+		if now.Sub(seenAt) < d.window {
+			continue
+		}
+		delete(d.seen, key)
+		if d.db != nil {
+			_ = d.db.Delete([]byte(key), nil)
+		}
+	}
+}
+
+// Close closes the dedup database
+func (d *Deduplicator) Close() error {
+	if d.db == nil {
+		return nil
+	}
+	return d.db.Close()
+}
+
+// isDuplicate returns true if the interaction repeats one recorded for
+// the same correlation id within the dedup window
+func (options *Options) isDuplicate(interaction *Interaction) bool {
+	if options.Dedup == nil || interaction.Fingerprint == "" {
+		return false
+	}
+	return options.Dedup.duplicate(options.interactionCorrelationID(interaction) + ":" + interaction.Fingerprint)
+}
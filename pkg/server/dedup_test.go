@@ -0,0 +1,56 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeduplicatorRestart(t *testing.T) {
+	opts := newTestOptions([]string{"192.0.2.50"}, "127.0.0.1")
+	opts.CorrelationIdLength = 10
+	opts.DedupWindow = time.Hour
+	opts.DedupPath = t.TempDir()
+	store := newTestStore(t, opts, "abcdefghij")
+
+	query := func() int {
+		req := new(dns.Msg)
+		req.SetQuestion("abcdefghij.example.com.", dns.TypeA)
+		NewDNSServer("udp", opts).ServeDNS(&testResponseWriter{}, req)
+		data, err := store.GetInteractionsWithIdForConsumer("abcdefghij", "consumer")
+		require.NoError(t, err)
+		return len(data)
+	}
+
+	dedup, err := NewDeduplicator(opts)
+	require.NoError(t, err)
+	opts.Dedup = dedup
+	require.Equal(t, 1, query())
+	require.Equal(t, 0, query(), "duplicate should be dropped")
+
+	// the fingerprints recorded before the restart are still deduplicated
+	require.NoError(t, dedup.Close())
+	dedup, err = NewDeduplicator(opts)
+	require.NoError(t, err)
+	opts.Dedup = dedup
+	require.Equal(t, 0, query(), "duplicate should be dropped after restart")
+	require.NoError(t, dedup.Close())
+
+	// fingerprints older than the window are dropped on load
+	opts.DedupWindow = time.Nanosecond
+	dedup, err = NewDeduplicator(opts)
+	require.NoError(t, err)
+	require.Empty(t, dedup.seen)
+	require.NoError(t, dedup.Close())
+
+	// without a path the fingerprints are kept in memory only
+	opts.DedupWindow = time.Hour
+	opts.DedupPath = ""
+	dedup, err = NewDeduplicator(opts)
+	require.NoError(t, err)
+	opts.Dedup = dedup
+	require.Equal(t, 1, query())
+	require.Equal(t, 0, query())
+}
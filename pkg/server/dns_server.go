@@ -3,13 +3,13 @@ package server
 import (
 	"context"
 	"fmt"
+	"math/rand"
 	"net"
 	"os"
 	"strings"
 	"sync/atomic"
 	"time"
 
-	jsoniter "github.com/json-iterator/go"
 	"github.com/miekg/dns"
 	"github.com/pkg/errors"
 	"github.com/projectdiscovery/gologger"
@@ -18,12 +18,25 @@ import (
 	"gopkg.in/yaml.v3"
 )
 
+const (
+	// DNSAnswerStrategyRoundRobin answers each query with the next address of the pool
+	DNSAnswerStrategyRoundRobin = "roundrobin"
+	// DNSAnswerStrategyRandom answers each query with a random address of the pool
+	DNSAnswerStrategyRandom = "random"
+	// DNSAnswerStrategyAll answers each query with all the addresses of the pool
+	DNSAnswerStrategyAll = "all"
+)
+
+// dnsUpstreamTimeout is the maximum time waited for the answer of the upstream resolver
+const dnsUpstreamTimeout = 5 * time.Second
+
 // DNSServer is a DNS server instance that listens on port 53.
 type DNSServer struct {
 	options       *Options
 	mxDomains     map[string]string
 	nsDomains     map[string][]string
 	ipAddresses   []net.IP
+	poolIndex     uint64
 	timeToLive    uint32
 	server        *dns.Server
 	customRecords *customDNSRecords
@@ -64,6 +77,10 @@ func NewDNSServer(network string, options *Options) *DNSServer {
 
 // ListenAndServe listens on dns ports for the server.
 func (h *DNSServer) ListenAndServe(dnsAlive chan bool) {
+	// udp queries are dropped when serving dns over tcp only, unless they are answered truncated
+	if h.server.Net == "udp" && h.options.DNSTCPOnly && !h.options.DNSTruncateUDP {
+		return
+	}
 	dnsAlive <- true
 	if err := h.server.ListenAndServe(); err != nil {
 		gologger.Error().Msgf("Could not listen for %s DNS on %s (%s)\n", strings.ToUpper(h.server.Net), h.server.Addr, err)
@@ -73,19 +90,44 @@ func (h *DNSServer) ListenAndServe(dnsAlive chan bool) {
 
 // ServeDNS is the default handler for DNS queries.
 func (h *DNSServer) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	receivedAt := time.Now()
 	atomic.AddUint64(&h.options.Stats.Dns, 1)
 
 	m := new(dns.Msg)
 	m.SetReply(r)
 	m.Authoritative = true
+	// names are compressed unless disabled for clients mishandling compression pointers
+	m.Compress = !h.options.DNSDisableCompression
 
 	// bail early for no queries.
 	if len(r.Question) == 0 {
 		return
 	}
 
+	// udp queries are answered truncated so that clients retry them over tcp
+	if h.options.DNSTCPOnly && h.server.Net == "udp" {
+		m.Truncated = true
+		_ = w.WriteMsg(m)
+		return
+	}
+
+	// queries for names outside the configured domains are relayed to the upstream resolver
+	if h.options.DNSUpstream != "" && !h.isServedName(r.Question[0].Name) {
+		h.forward(w, r, receivedAt)
+		return
+	}
+
+	// all the questions are recorded, the refused multi-question queries are recorded but not answered
+	refused := len(r.Question) > 1 && h.options.DNSRefuseMultiQuestion
+	if refused {
+		m.Rcode = dns.RcodeRefused
+	}
+
 	isDNSChallenge := false
 	for _, question := range r.Question {
+		if refused {
+			break
+		}
 		domain := question.Name
 
 		// Handle DNS server cases for ACME server
@@ -110,6 +152,10 @@ func (h *DNSServer) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
 			}
 
 			gologger.Debug().Msgf("Got acme dns response: \n%s\n", m.String())
+		} else if question.Qclass == dns.ClassCHAOS && h.options.DNSVersionString != "" {
+			h.handleChaos(domain, question.Qtype, m)
+		} else if question.Qtype != dns.TypeDNAME && h.handleDNAME(domain, m) {
+			gologger.Debug().Msgf("Redirected %s with a custom DNAME record\n", domain)
 		} else {
 			switch question.Qtype {
 			case dns.TypeA, dns.TypeAAAA, dns.TypeCNAME, dns.TypeANY:
@@ -122,12 +168,21 @@ func (h *DNSServer) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
 				h.handleSOA(domain, m)
 			case dns.TypeTXT:
 				h.handleTXT(domain, m)
+			case dns.TypePTR:
+				h.handlePTR(domain, m)
+			case dns.TypeHTTPS, dns.TypeSVCB, dns.TypeDNAME:
+				h.handleSVCB(domain, question.Qtype, m)
 			}
+			h.addSectionRecords(domain, m)
 		}
 	}
+	if h.options.DNSEDNSPadding > 0 && r.IsEdns0() != nil {
+		padResponse(m, h.options.DNSEDNSPadding)
+	}
+
 	if !isDNSChallenge {
 		// Write interaction for first question and dns request
-		h.handleInteraction(r.Question[0].Name, w, r, m)
+		h.handleInteraction(r.Question[0].Name, w, r, m, receivedAt)
 	}
 
 	if err := w.WriteMsg(m); err != nil {
@@ -135,6 +190,81 @@ func (h *DNSServer) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
 	}
 }
 
+// isServedName returns true if the name is below a configured domain
+// or is the reverse lookup name of a server ip address
+func (h *DNSServer) isServedName(name string) bool {
+	for _, domain := range h.options.Domains {
+		if stringsutil.HasSuffixI(name, dns.Fqdn(domain)) {
+			return true
+		}
+	}
+	for _, ip := range uniqueIPs(h.ipAddresses) {
+		if reverseZone, err := dns.ReverseAddr(ip.String()); err == nil && strings.EqualFold(reverseZone, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// forward relays the query to the upstream resolver and its answer to the client,
+// recording the forwarded query for the authenticated clients
+func (h *DNSServer) forward(w dns.ResponseWriter, r *dns.Msg, receivedAt time.Time) {
+	network := h.server.Net
+	// dns over https queries are forwarded over udp
+	if network != "tcp" {
+		network = "udp"
+	}
+	upstream := h.options.DNSUpstream
+	if _, _, err := net.SplitHostPort(upstream); err != nil {
+		upstream = net.JoinHostPort(upstream, "53")
+	}
+
+	client := &dns.Client{Net: network, Timeout: dnsUpstreamTimeout}
+	m, _, err := client.Exchange(r, upstream)
+	if err != nil {
+		gologger.Warning().Msgf("Could not forward DNS query for %s to %s: %s\n", r.Question[0].Name, upstream, err)
+		m = new(dns.Msg)
+		m.SetRcode(r, dns.RcodeServerFailure)
+	}
+	m.Compress = !h.options.DNSDisableCompression
+	h.recordForwarded(w, r, m, receivedAt)
+
+	if err := w.WriteMsg(m); err != nil {
+		gologger.Warning().Msgf("Could not write DNS response: \n%s\n %s\n", m.String(), err)
+	}
+}
+
+// recordForwarded records a query forwarded to the upstream resolver under the
+// auth token, which is always set with an upstream as the query carries no correlation id
+func (h *DNSServer) recordForwarded(w dns.ResponseWriter, r *dns.Msg, m *dns.Msg, receivedAt time.Time) {
+	_, doh := w.(*dohResponseWriter)
+	host, _, _ := net.SplitHostPort(w.RemoteAddr().String())
+	interaction := &Interaction{
+		Protocol:      dnsProtocol(doh),
+		FullId:        r.Question[0].Name,
+		QType:         toQType(r.Question[0].Qtype),
+		DNSClass:      dns.ClassToString[r.Question[0].Qclass],
+		DoH:           doh,
+		DNSOpcode:     dns.OpcodeToString[r.Opcode],
+		DNSFlags:      dnsFlags(r.MsgHdr),
+		DNSForwarded:  true,
+		RawRequest:    r.String(),
+		RawResponse:   m.String(),
+		RemoteAddress: host,
+		ReceivedAt:    receivedAt,
+		Timestamp:     time.Now(),
+	}
+	data, err := h.options.encodeInteraction(interaction)
+	if err != nil {
+		gologger.Warning().Msgf("Could not encode forwarded dns interaction: %s\n", err)
+		return
+	}
+	gologger.Debug().Msgf("Forwarded DNS Interaction: \n%s\n", string(data))
+	if err := h.options.storeInteractionWithId(h.options.Token, interaction, data); err != nil {
+		gologger.Warning().Msgf("Could not store forwarded dns interaction: %s\n", err)
+	}
+}
+
 // handleACMETXTChallenge handles solving of ACME TXT challenge with the given provider
 func (h *DNSServer) handleACMETXTChallenge(zone string, m *dns.Msg) error {
 	records, err := h.options.ACMEStore.GetRecords(context.Background(), strings.ToLower(zone))
@@ -171,9 +301,88 @@ func (h *DNSServer) handleACNAMEANY(zone string, m *dns.Msg) {
 		return
 	}
 
+	// Correlation subdomains are answered with a CNAME to be followed for a second hop
+	if target, hop := h.wildcardCNAMEHop(zone); hop == 1 {
+		m.Answer = append(m.Answer, &dns.CNAME{Hdr: dns.RR_Header{Name: zone, Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: h.timeToLive}, Target: target})
+		return
+	}
+
 	// No custom records, use default IP
 	nsHeader := dns.RR_Header{Name: zone, Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: h.timeToLive}
-	h.resultFunction(nsHeader, zone, h.ipAddresses, m)
+	h.resultFunction(nsHeader, zone, h.answerIPs(zone), m)
+}
+
+// answerIPs returns the addresses the zone is answered with, selected from
+// the answer pool with the configured strategy for wildcard subdomains.
+func (h *DNSServer) answerIPs(zone string) []net.IP {
+	pool := h.options.DNSAnswerPool
+	if len(pool) == 0 || !h.isWildcardSubdomain(zone) {
+		return h.ipAddresses
+	}
+	switch h.options.DNSAnswerStrategy {
+	case DNSAnswerStrategyAll:
+		return pool
+	case DNSAnswerStrategyRandom:
+		return []net.IP{pool[rand.Intn(len(pool))]}
+	default:
+		index := atomic.AddUint64(&h.poolIndex, 1) - 1
+		return []net.IP{pool[index%uint64(len(pool))]}
+	}
+}
+
+// isWildcardSubdomain returns true if the zone is a subdomain of a configured
+// domain other than its name servers
+func (h *DNSServer) isWildcardSubdomain(zone string) bool {
+	for _, configuredDomain := range h.options.Domains {
+		dotDomain := dns.Fqdn(configuredDomain)
+		if !stringsutil.HasSuffixI(zone, "."+dotDomain) {
+			continue
+		}
+		for _, nsDomain := range h.nsDomains[dotDomain] {
+			if strings.EqualFold(zone, nsDomain) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// wildcardCNAMEHop returns the hop of the wildcard CNAME resolution for the zone
+// along with the CNAME target for the first hop. The hop is 0 when the zone is
+// not a correlation subdomain or DNSWildcardCNAMESuffix is not set.
+func (h *DNSServer) wildcardCNAMEHop(zone string) (string, int) {
+	suffix := strings.ToLower(strings.Trim(h.options.DNSWildcardCNAMESuffix, "."))
+	if suffix == "" {
+		return "", 0
+	}
+	for _, configuredDomain := range h.options.Domains {
+		dotDomain := "." + dns.Fqdn(configuredDomain)
+		if !stringsutil.HasSuffixI(zone, dotDomain) {
+			continue
+		}
+		prefix := zone[:len(zone)-len(dotDomain)]
+		if !h.hasCorrelationID(prefix) {
+			return "", 0
+		}
+		if lowerPrefix := strings.ToLower(prefix); lowerPrefix == suffix || strings.HasSuffix(lowerPrefix, "."+suffix) {
+			return "", 2
+		}
+		return prefix + "." + suffix + dotDomain, 1
+	}
+	return "", 0
+}
+
+// hasCorrelationID returns true if a label of the name contains a correlation id
+func (h *DNSServer) hasCorrelationID(name string) bool {
+	for _, label := range strings.Split(name, ".") {
+		for chunk := range stringsutil.SlideWithLength(label, h.options.GetIdLength()) {
+			if h.options.isCorrelationID(strings.ToLower(chunk)) {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 func (h *DNSServer) resultFunction(nsHeader dns.RR_Header, zone string, ipAddresses []net.IP, m *dns.Msg) {
@@ -277,6 +486,88 @@ func (h *DNSServer) handleTXT(zone string, m *dns.Msg) {
 	m.Answer = append(m.Answer, &dns.TXT{Hdr: dns.RR_Header{Name: zone, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 0}, Txt: []string{h.TxtRecord}})
 }
 
+// handleSVCB answers HTTPS, SVCB and DNAME queries with the custom records configured
+// for the zone, otherwise with no answer so that clients fall back to A/AAAA
+func (h *DNSServer) handleSVCB(zone string, qtype uint16, m *dns.Msg) {
+	for _, record := range h.customRecords.checkCustomResponse(zone, qtype) {
+		if err := h.addCustomRecordToMessage(record, zone, m); err != nil {
+			gologger.Warning().Msgf("Could not add custom %s record for %s: %s", record.Type, zone, err)
+		}
+	}
+}
+
+// handleDNAME answers the names below the owner of a custom DNAME record with
+// the DNAME record and the CNAME synthesized for the name (RFC 6672)
+func (h *DNSServer) handleDNAME(zone string, m *dns.Msg) bool {
+	owner, record, ok := h.customRecords.checkDNAMEResponse(zone)
+	if !ok {
+		return false
+	}
+	if err := h.addCustomRecordToMessage(record, owner, m); err != nil {
+		gologger.Warning().Msgf("Could not add custom %s record for %s: %s", record.Type, owner, err)
+		return false
+	}
+
+	ttl := m.Answer[len(m.Answer)-1].Header().Ttl
+	target := zone[:len(zone)-len(owner)] + dns.Fqdn(record.Value)
+	// the synthesized name can't exceed the maximum domain name length
+	if _, ok := dns.IsDomainName(target); !ok {
+		m.Rcode = dns.RcodeYXDomain
+		return true
+	}
+	m.Answer = append(m.Answer, &dns.CNAME{
+		Hdr:    dns.RR_Header{Name: zone, Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: ttl},
+		Target: target,
+	})
+	return true
+}
+
+// handleChaos answers the CHAOS class version.bind and hostname.bind
+// server identification queries with the configured version string
+func (h *DNSServer) handleChaos(zone string, qtype uint16, m *dns.Msg) {
+	if qtype != dns.TypeTXT && qtype != dns.TypeANY {
+		return
+	}
+	switch strings.ToLower(zone) {
+	case "version.bind.", "hostname.bind.":
+		m.Answer = append(m.Answer, &dns.TXT{Hdr: dns.RR_Header{Name: zone, Rrtype: dns.TypeTXT, Class: dns.ClassCHAOS, Ttl: 0}, Txt: []string{h.options.DNSVersionString}})
+	}
+}
+
+// handlePTR answers reverse lookups of the server ip addresses with the
+// PTR records configured for them in the custom records. The queries carry no
+// correlation id and are only recorded with root-tld, under the answered domain.
+func (h *DNSServer) handlePTR(zone string, m *dns.Msg) {
+	for _, ip := range uniqueIPs(h.ipAddresses) {
+		reverseZone, err := dns.ReverseAddr(ip.String())
+		if err != nil || !strings.EqualFold(reverseZone, zone) {
+			continue
+		}
+		for _, record := range h.customRecords.checkPTRResponse(ip) {
+			if err := h.addCustomRecordToMessage(record, zone, m); err != nil {
+				gologger.Warning().Msgf("Could not add custom %s record for %s: %s", record.Type, zone, err)
+			}
+		}
+		return
+	}
+}
+
+// ptrAnswerDomain returns the configured domain the PTR answers of m point to
+func (h *DNSServer) ptrAnswerDomain(m *dns.Msg) string {
+	for _, rr := range m.Answer {
+		ptr, ok := rr.(*dns.PTR)
+		if !ok {
+			continue
+		}
+		for _, configuredDomain := range h.options.Domains {
+			if stringsutil.HasSuffixI(ptr.Ptr, dns.Fqdn(configuredDomain)) {
+				return configuredDomain
+			}
+		}
+	}
+	return ""
+}
+
 func toQType(ttype uint16) (rtype string) {
 	switch ttype {
 	case dns.TypeA:
@@ -295,18 +586,89 @@ func toQType(ttype uint16) (rtype string) {
 		rtype = "TXT"
 	case dns.TypeAAAA:
 		rtype = "AAAA"
+	case dns.TypeSVCB:
+		rtype = "SVCB"
+	case dns.TypeHTTPS:
+		rtype = "HTTPS"
+	case dns.TypeDNAME:
+		rtype = "DNAME"
 	}
 	return
 }
 
+// dnsFlags returns the names of the flags set in a DNS message header
+func dnsFlags(hdr dns.MsgHdr) []string {
+	var flags []string
+	for _, flag := range []struct {
+		name string
+		set  bool
+	}{
+		{"qr", hdr.Response},
+		{"aa", hdr.Authoritative},
+		{"tc", hdr.Truncated},
+		{"rd", hdr.RecursionDesired},
+		{"ra", hdr.RecursionAvailable},
+		{"z", hdr.Zero},
+		{"ad", hdr.AuthenticatedData},
+		{"cd", hdr.CheckingDisabled},
+	} {
+		if flag.set {
+			flags = append(flags, flag.name)
+		}
+	}
+	return flags
+}
+
+// dnsQuestions returns the names of all the questions of a multi-question query
+func dnsQuestions(r *dns.Msg) []string {
+	if len(r.Question) < 2 {
+		return nil
+	}
+	names := make([]string, 0, len(r.Question))
+	for _, question := range r.Question {
+		names = append(names, question.Name)
+	}
+	return names
+}
+
+// padResponse adds an EDNS0 padding option to the response so that
+// its packed length is a multiple of the block size (RFC 8467)
+func padResponse(m *dns.Msg, blockSize int) {
+	opt := m.IsEdns0()
+	if opt == nil {
+		m.SetEdns0(dns.DefaultMsgSize, false)
+		opt = m.IsEdns0()
+	}
+	padding := &dns.EDNS0_PADDING{}
+	opt.Option = append(opt.Option, padding)
+	if remainder := m.Len() % blockSize; remainder != 0 {
+		padding.Padding = make([]byte, blockSize-remainder)
+	}
+}
+
+// isPadded returns true if the response carries an EDNS0 padding option
+func isPadded(m *dns.Msg) bool {
+	opt := m.IsEdns0()
+	if opt == nil {
+		return false
+	}
+	for _, option := range opt.Option {
+		if option.Option() == dns.EDNS0PADDING {
+			return true
+		}
+	}
+	return false
+}
+
 // handleInteraction handles an interaction for the DNS server
-func (h *DNSServer) handleInteraction(domain string, w dns.ResponseWriter, r *dns.Msg, m *dns.Msg) {
+func (h *DNSServer) handleInteraction(domain string, w dns.ResponseWriter, r *dns.Msg, m *dns.Msg, receivedAt time.Time) {
 	var uniqueID, fullID string
 
 	requestMsg := r.String()
 	responseMsg := m.String()
 
 	gologger.Debug().Msgf("New DNS request: %s\n", requestMsg)
+	_, doh := w.(*dohResponseWriter)
 
 	var foundDomain string
 	for _, configuredDomain := range h.options.Domains {
@@ -316,32 +678,40 @@ func (h *DNSServer) handleInteraction(domain string, w dns.ResponseWriter, r *dn
 			break
 		}
 	}
+	// reverse lookups of the server ips are attributed to the domain they resolve to
+	if foundDomain == "" && r.Question[0].Qtype == dns.TypePTR {
+		foundDomain = h.ptrAnswerDomain(m)
+	}
 
 	// if root-tld is enabled stores any interaction towards the main domain
 	if h.options.RootTLD && foundDomain != "" {
 		correlationID := foundDomain
 		host, _, _ := net.SplitHostPort(w.RemoteAddr().String())
 		interaction := &Interaction{
-			Protocol:      "dns",
-			UniqueID:      domain,
-			FullId:        domain,
-			QType:         toQType(r.Question[0].Qtype),
-			RawRequest:    requestMsg,
-			RawResponse:   responseMsg,
-			RemoteAddress: host,
-			Timestamp:     time.Now(),
-		}
-
-		if nil != h.options.OnResult {
-			h.options.OnResult(interaction)
+			Protocol:         dnsProtocol(doh),
+			UniqueID:         domain,
+			FullId:           domain,
+			QType:            toQType(r.Question[0].Qtype),
+			DNSClass:         dns.ClassToString[r.Question[0].Qclass],
+			DoH:              doh,
+			DNSOpcode:        dns.OpcodeToString[r.Opcode],
+			DNSFlags:         dnsFlags(r.MsgHdr),
+			DNSPadded:        isPadded(m),
+			DNSQuestions:     dnsQuestions(r),
+			DNSQuestionCount: len(r.Question),
+			RawRequest:       requestMsg,
+			RawResponse:      responseMsg,
+			RemoteAddress:    host,
+			ReceivedAt:       receivedAt,
+			Timestamp:        time.Now(),
 		}
 
-		data, err := jsoniter.Marshal(interaction)
+		data, err := h.options.encodeInteraction(interaction)
 		if err != nil {
 			gologger.Warning().Msgf("Could not encode root tld dns interaction: %s\n", err)
 		} else {
 			gologger.Debug().Msgf("Root TLD DNS Interaction: \n%s\n", string(data))
-			if err := h.options.Storage.AddInteractionWithId(correlationID, data); err != nil {
+			if err := h.options.storeInteractionWithId(correlationID, interaction, data); err != nil {
 				gologger.Warning().Msgf("Could not store dns interaction: %s\n", err)
 			}
 		}
@@ -359,6 +729,11 @@ func (h *DNSServer) handleInteraction(domain string, w dns.ResponseWriter, r *dn
 					}
 				}
 			}
+		} else if h.options.CorrelationPosition == CorrelationPositionLeftmost {
+			if leftmostID, label, ok := h.options.leftmostCorrelationID(domain, "."); ok {
+				uniqueID = leftmostID
+				fullID = label
+			}
 		} else {
 			parts := strings.Split(domain, ".")
 			for i, part := range parts {
@@ -376,25 +751,69 @@ func (h *DNSServer) handleInteraction(domain string, w dns.ResponseWriter, r *dn
 		}
 	}
 
+	var correlationID string
+	if uniqueID != "" {
+		correlationID = uniqueID[:h.options.CorrelationIdLength]
+	}
+	// correlation ids corrupted on the way are matched to the closest registered one
+	fuzzy := false
+	if foundDomain != "" && h.options.FuzzyCorrelationDistance > 0 && !h.options.isRegistered(correlationID) {
+		parts := strings.Split(domain, ".")
+		if matchedID, i, ok := h.options.fuzzyCorrelationMatch(parts); ok {
+			uniqueID, fullID, correlationID, fuzzy = strings.ToLower(parts[i]), strings.Join(parts[:i+1], "."), matchedID, true
+		}
+	}
+
 	if uniqueID != "" {
-		correlationID := uniqueID[:h.options.CorrelationIdLength]
 		host, _, _ := net.SplitHostPort(w.RemoteAddr().String())
+		qType := toQType(r.Question[0].Qtype)
 		interaction := &Interaction{
-			Protocol:      "dns",
-			UniqueID:      uniqueID,
-			FullId:        fullID,
-			QType:         toQType(r.Question[0].Qtype),
-			RawRequest:    requestMsg,
-			RawResponse:   responseMsg,
-			RemoteAddress: host,
-			Timestamp:     time.Now(),
-		}
-		data, err := jsoniter.Marshal(interaction)
+			Protocol:         dnsProtocol(doh),
+			UniqueID:         uniqueID,
+			FullId:           fullID,
+			FuzzyMatched:     fuzzy,
+			Fingerprint:      interactionFingerprint("dns", host, strings.ToLower(domain), qType),
+			QType:            qType,
+			DNSClass:         dns.ClassToString[r.Question[0].Qclass],
+			DoH:              doh,
+			DNSOpcode:        dns.OpcodeToString[r.Opcode],
+			DNSFlags:         dnsFlags(r.MsgHdr),
+			DNSPadded:        isPadded(m),
+			DNSQuestions:     dnsQuestions(r),
+			DNSQuestionCount: len(r.Question),
+			RawRequest:       requestMsg,
+			RawResponse:      responseMsg,
+			RemoteAddress:    host,
+			ReceivedAt:       receivedAt,
+			Timestamp:        time.Now(),
+		}
+		if fuzzy {
+			interaction.CorrelationID = correlationID
+		}
+		if h.options.DecodeExfil {
+			interaction.DecodedData = decodeExfil(domain, uniqueID)
+		}
+		if _, hop := h.wildcardCNAMEHop(domain); hop > 0 {
+			interaction.DNSHop = hop
+		}
+		if deprecated := h.options.deprecatedDomain(domain); deprecated != "" {
+			interaction.Deprecated = true
+			if h.options.WarnDeprecatedDomains {
+				gologger.Warning().Msgf("DNS interaction for %s received on deprecated domain %s\n", correlationID, deprecated)
+			}
+		}
+		if h.options.isDuplicate(interaction) {
+			gologger.Debug().Msgf("Dropped duplicate dns interaction for %s\n", correlationID)
+			return
+		}
+		h.options.tagInteraction(interaction)
+		data, err := h.options.encodeInteraction(interaction)
 		if err != nil {
 			gologger.Warning().Msgf("Could not encode dns interaction: %s\n", err)
 		} else {
 			gologger.Debug().Msgf("DNS Interaction: \n%s\n", string(data))
-			if err := h.options.Storage.AddInteraction(correlationID, data); err != nil {
+			h.options.publishInteraction(interaction, data)
+			if err := h.options.storeInteraction(correlationID, data); err != nil {
 				gologger.Warning().Msgf("Could not store dns interaction: %s\n", err)
 			}
 		}
@@ -407,8 +826,23 @@ type CustomRecordConfig struct {
 	Value    string `yaml:"value"`
 	TTL      uint32 `yaml:"ttl,omitempty"`
 	Priority uint16 `yaml:"priority,omitempty"` // for MX records
+	// Section is the response section of the record (answer, authority or additional).
+	// Authority and additional records are attached to every response for the subdomain.
+	Section string `yaml:"section,omitempty"`
+	// Name is the owner name of authority and additional records (eg. a nameserver
+	// of glue records), the queried name if empty
+	Name string `yaml:"name,omitempty"`
 }
 
+const (
+	// SectionAnswer is the answer section of dns responses
+	SectionAnswer = "answer"
+	// SectionAuthority is the authority section of dns responses
+	SectionAuthority = "authority"
+	// SectionAdditional is the additional section of dns responses
+	SectionAdditional = "additional"
+)
+
 // DNSRecordsConfig represents the structured DNS records configuration (YAML format)
 type DNSRecordsConfig map[string][]CustomRecordConfig
 
@@ -529,6 +963,12 @@ func (c *customDNSRecords) readRecordsFromFile(input string) error {
 
 				// Normalize type to uppercase
 				entry.Type = strings.ToUpper(entry.Type)
+				entry.Section = strings.ToLower(entry.Section)
+				switch entry.Section {
+				case "", SectionAnswer, SectionAuthority, SectionAdditional:
+				default:
+					return fmt.Errorf("invalid record section: %s", entry.Section)
+				}
 				c.records[subdomainLower] = append(c.records[subdomainLower], entry)
 			}
 		}
@@ -550,8 +990,8 @@ func (c *customDNSRecords) readRecordsFromFile(input string) error {
 	return nil
 }
 
-// checkCustomResponse returns custom DNS records for the given zone and record type
-func (c *customDNSRecords) checkCustomResponse(zone string, recordType uint16) []CustomRecordConfig {
+// subdomainRecords returns the custom DNS records of the subdomain of a configured domain the zone is
+func (c *customDNSRecords) subdomainRecords(zone string) []CustomRecordConfig {
 	// Normalize zone (remove trailing dot if present)
 	zone = strings.TrimSuffix(zone, ".")
 	zoneLower := strings.ToLower(zone)
@@ -576,15 +1016,23 @@ func (c *customDNSRecords) checkCustomResponse(zone string, recordType uint16) [
 	if subdomain == "" {
 		return nil
 	}
+	return c.records[subdomain]
+}
 
-	configs, ok := c.records[subdomain]
-	if !ok {
+// checkCustomResponse returns custom DNS records for the given zone and record type
+func (c *customDNSRecords) checkCustomResponse(zone string, recordType uint16) []CustomRecordConfig {
+	configs := c.subdomainRecords(zone)
+	if len(configs) == 0 {
 		return nil
 	}
 
 	// Filter by record type
 	var filtered []CustomRecordConfig
 	for _, config := range configs {
+		// authority and additional records are never answers
+		if config.Section != "" && config.Section != SectionAnswer {
+			continue
+		}
 		// Match the requested type
 		switch recordType {
 		case dns.TypeA:
@@ -611,6 +1059,18 @@ func (c *customDNSRecords) checkCustomResponse(zone string, recordType uint16) [
 			if config.Type == "NS" {
 				filtered = append(filtered, config)
 			}
+		case dns.TypeSVCB:
+			if config.Type == "SVCB" {
+				filtered = append(filtered, config)
+			}
+		case dns.TypeHTTPS:
+			if config.Type == "HTTPS" {
+				filtered = append(filtered, config)
+			}
+		case dns.TypeDNAME:
+			if config.Type == "DNAME" {
+				filtered = append(filtered, config)
+			}
 		case dns.TypeANY:
 			// Return all records for ANY query
 			filtered = append(filtered, config)
@@ -620,8 +1080,74 @@ func (c *customDNSRecords) checkCustomResponse(zone string, recordType uint16) [
 	return filtered
 }
 
-// addCustomRecordToMessage adds a custom DNS record to the DNS message
+// checkSectionRecords returns the custom authority and additional records of the given zone
+func (c *customDNSRecords) checkSectionRecords(zone string) []CustomRecordConfig {
+	var filtered []CustomRecordConfig
+	for _, config := range c.subdomainRecords(zone) {
+		if config.Section == SectionAuthority || config.Section == SectionAdditional {
+			filtered = append(filtered, config)
+		}
+	}
+	return filtered
+}
+
+// checkDNAMEResponse returns the owner name and the custom DNAME record of the
+// closest subdomain above the zone, whose subtree the zone is redirected with
+func (c *customDNSRecords) checkDNAMEResponse(zone string) (string, CustomRecordConfig, bool) {
+	zone = dns.Fqdn(zone)
+	labels := dns.SplitDomainName(zone)
+	// the owner name itself is not redirected, so the search starts at its parent
+	for i := 1; i < len(labels); i++ {
+		owner := strings.Join(labels[i:], ".") + "."
+		if configs := c.checkCustomResponse(owner, dns.TypeDNAME); len(configs) > 0 {
+			return zone[len(zone)-len(owner):], configs[0], true
+		}
+	}
+	return "", CustomRecordConfig{}, false
+}
+
+// checkPTRResponse returns the custom PTR records configured for the given ip address
+func (c *customDNSRecords) checkPTRResponse(ip net.IP) []CustomRecordConfig {
+	var filtered []CustomRecordConfig
+	for _, config := range c.records[strings.ToLower(ip.String())] {
+		if config.Type == "PTR" {
+			filtered = append(filtered, config)
+		}
+	}
+	return filtered
+}
+
+// addCustomRecordToMessage adds a custom DNS record to its section of the DNS message
 func (h *DNSServer) addCustomRecordToMessage(record CustomRecordConfig, zone string, m *dns.Msg) error {
+	if record.Name != "" && record.Section != "" && record.Section != SectionAnswer {
+		zone = dns.Fqdn(record.Name)
+	}
+	rr, err := h.customRecordRR(record, zone)
+	if err != nil {
+		return err
+	}
+	switch record.Section {
+	case SectionAuthority:
+		m.Ns = append(m.Ns, rr)
+	case SectionAdditional:
+		m.Extra = append(m.Extra, rr)
+	default:
+		m.Answer = append(m.Answer, rr)
+	}
+	return nil
+}
+
+// addSectionRecords adds the custom authority and additional records of the zone to the DNS message
+func (h *DNSServer) addSectionRecords(zone string, m *dns.Msg) {
+	for _, record := range h.customRecords.checkSectionRecords(zone) {
+		if err := h.addCustomRecordToMessage(record, zone, m); err != nil {
+			gologger.Warning().Msgf("Could not add custom %s %s record for %s: %s", record.Section, record.Type, zone, err)
+		}
+	}
+}
+
+// customRecordRR returns the DNS record of a custom record for the zone
+func (h *DNSServer) customRecordRR(record CustomRecordConfig, zone string) (dns.RR, error) {
 	// Determine TTL (use custom if set, otherwise use server default)
 	ttl := h.timeToLive
 	if record.TTL > 0 {
@@ -629,53 +1155,74 @@ func (h *DNSServer) addCustomRecordToMessage(record CustomRecordConfig, zone str
 	}
 
 	// Create the appropriate DNS record based on type
+	var rr dns.RR
 	switch record.Type {
 	case "A":
 		ip := net.ParseIP(record.Value)
 		if ip == nil {
-			return fmt.Errorf("invalid IPv4 address for A record: %s", record.Value)
+			return nil, fmt.Errorf("invalid IPv4 address for A record: %s", record.Value)
 		}
-		m.Answer = append(m.Answer, &dns.A{
+		rr = &dns.A{
 			Hdr: dns.RR_Header{Name: zone, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl},
 			A:   ip.To4(),
-		})
+		}
 	case "AAAA":
 		ip := net.ParseIP(record.Value)
 		if ip == nil {
-			return fmt.Errorf("invalid IPv6 address for AAAA record: %s", record.Value)
+			return nil, fmt.Errorf("invalid IPv6 address for AAAA record: %s", record.Value)
 		}
-		m.Answer = append(m.Answer, &dns.AAAA{
+		rr = &dns.AAAA{
 			Hdr:  dns.RR_Header{Name: zone, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: ttl},
 			AAAA: ip.To16(),
-		})
+		}
 	case "CNAME":
-		m.Answer = append(m.Answer, &dns.CNAME{
+		rr = &dns.CNAME{
 			Hdr:    dns.RR_Header{Name: zone, Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: ttl},
 			Target: dns.Fqdn(record.Value),
-		})
+		}
 	case "MX":
 		priority := record.Priority
 		if priority == 0 {
 			priority = 10 // default priority if not specified
 		}
-		m.Answer = append(m.Answer, &dns.MX{
+		rr = &dns.MX{
 			Hdr:        dns.RR_Header{Name: zone, Rrtype: dns.TypeMX, Class: dns.ClassINET, Ttl: ttl},
 			Mx:         dns.Fqdn(record.Value),
 			Preference: priority,
-		})
+		}
 	case "TXT":
-		m.Answer = append(m.Answer, &dns.TXT{
+		rr = &dns.TXT{
 			Hdr: dns.RR_Header{Name: zone, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: ttl},
 			Txt: []string{record.Value},
-		})
+		}
 	case "NS":
-		m.Answer = append(m.Answer, &dns.NS{
+		rr = &dns.NS{
 			Hdr: dns.RR_Header{Name: zone, Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: ttl},
 			Ns:  dns.Fqdn(record.Value),
-		})
+		}
+	case "PTR":
+		rr = &dns.PTR{
+			Hdr: dns.RR_Header{Name: zone, Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: ttl},
+			Ptr: dns.Fqdn(record.Value),
+		}
+	case "DNAME":
+		rr = &dns.DNAME{
+			Hdr:    dns.RR_Header{Name: zone, Rrtype: dns.TypeDNAME, Class: dns.ClassINET, Ttl: ttl},
+			Target: dns.Fqdn(record.Value),
+		}
+	case "SVCB", "HTTPS":
+		// the value is the presentation format of the record data (eg. "1 . alpn=h2,h3")
+		parsed, err := dns.NewRR(fmt.Sprintf("%s %d IN %s %s", zone, ttl, record.Type, record.Value))
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s record: %s", record.Type, err)
+		}
+		if parsed == nil {
+			return nil, fmt.Errorf("invalid %s record: %s", record.Type, record.Value)
+		}
+		rr = parsed
 	default:
-		return fmt.Errorf("unsupported record type: %s", record.Type)
+		return nil, fmt.Errorf("unsupported record type: %s", record.Type)
 	}
 
-	return nil
+	return rr, nil
 }
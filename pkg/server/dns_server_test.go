@@ -1,11 +1,19 @@
 package server
 
 import (
+	"bytes"
 	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
+	jsoniter "github.com/json-iterator/go"
 	"github.com/miekg/dns"
 	"github.com/projectdiscovery/interactsh/pkg/server/acme"
+	"github.com/projectdiscovery/interactsh/pkg/storage"
 	"github.com/stretchr/testify/require"
 )
 
@@ -171,6 +179,488 @@ func TestDNSServerMixedIPv4AndIPv6(t *testing.T) {
 	require.True(t, hasRecord(msg.Answer, dns.TypeAAAA, "2001:db8::2"))
 }
 
+func TestDNSServerHandlePTR(t *testing.T) {
+	opts := newTestOptions([]string{"192.0.2.50"}, "127.0.0.1")
+	opts.RootTLD = true
+	store := newTestStore(t, opts, "example.com")
+
+	dnsServer := NewDNSServer("udp", opts)
+	dnsServer.customRecords.records["192.0.2.50"] = []CustomRecordConfig{{Type: "PTR", Value: "ns1.example.com"}}
+
+	req := new(dns.Msg)
+	req.SetQuestion("50.2.0.192.in-addr.arpa.", dns.TypePTR)
+	w := &testResponseWriter{}
+	dnsServer.ServeDNS(w, req)
+
+	require.NotNil(t, w.msg)
+	require.Len(t, w.msg.Answer, 1)
+	record, ok := w.msg.Answer[0].(*dns.PTR)
+	require.True(t, ok)
+	require.Equal(t, "ns1.example.com.", record.Ptr)
+
+	data, err := store.GetInteractionsWithIdForConsumer("example.com", "consumer")
+	require.NoError(t, err)
+	require.Len(t, data, 1)
+	interaction := &Interaction{}
+	require.NoError(t, jsoniter.UnmarshalFromString(data[0], interaction))
+	require.Equal(t, "PTR", interaction.QType)
+	require.Equal(t, "50.2.0.192.in-addr.arpa.", interaction.UniqueID)
+}
+
+func TestDNSServerHandlePTRWithoutRootTLD(t *testing.T) {
+	opts := newTestOptions([]string{"192.0.2.50"}, "127.0.0.1")
+	store := newTestStore(t, opts, "example.com")
+
+	dnsServer := NewDNSServer("udp", opts)
+	dnsServer.customRecords.records["192.0.2.50"] = []CustomRecordConfig{{Type: "PTR", Value: "ns1.example.com"}}
+
+	req := new(dns.Msg)
+	req.SetQuestion("50.2.0.192.in-addr.arpa.", dns.TypePTR)
+	w := &testResponseWriter{}
+	dnsServer.ServeDNS(w, req)
+
+	// the query is answered but only recorded with root-tld
+	require.NotNil(t, w.msg)
+	require.Len(t, w.msg.Answer, 1)
+	data, err := store.GetInteractionsWithIdForConsumer("example.com", "consumer")
+	require.NoError(t, err)
+	require.Empty(t, data)
+}
+
+func TestDNSServerHandlePTRUnknownIP(t *testing.T) {
+	opts := newTestOptions([]string{"192.0.2.50"}, "127.0.0.1")
+	dnsServer := NewDNSServer("udp", opts)
+	dnsServer.customRecords.records["192.0.2.51"] = []CustomRecordConfig{{Type: "PTR", Value: "ns1.example.com"}}
+
+	msg := new(dns.Msg)
+	dnsServer.handlePTR("51.2.0.192.in-addr.arpa.", msg)
+
+	require.Empty(t, msg.Answer, "expected no answer for foreign ip")
+}
+
+func TestDNSServerHTTPSRecord(t *testing.T) {
+	opts := newTestOptions([]string{"192.0.2.50"}, "127.0.0.1")
+	opts.CorrelationIdLength = 10
+	store := newTestStore(t, opts, "abcdefghij")
+	dnsServer := NewDNSServer("udp", opts)
+	dnsServer.customRecords.records["abcdefghij"] = []CustomRecordConfig{{Type: "HTTPS", Value: "1 . alpn=h2,h3 ipv4hint=192.0.2.60"}}
+
+	req := new(dns.Msg)
+	req.SetQuestion("abcdefghij.example.com.", dns.TypeHTTPS)
+	w := &testResponseWriter{}
+	dnsServer.ServeDNS(w, req)
+
+	require.Len(t, w.msg.Answer, 1)
+	record, ok := w.msg.Answer[0].(*dns.HTTPS)
+	require.True(t, ok)
+	require.Equal(t, uint16(1), record.Priority)
+	require.Equal(t, ".", record.Target)
+	require.Len(t, record.Value, 2)
+	require.Equal(t, "h2,h3", record.Value[0].String())
+	require.Equal(t, "192.0.2.60", record.Value[1].String())
+
+	// zones without a custom record are answered without data
+	req.SetQuestion("abcdefghij.example.com.", dns.TypeSVCB)
+	dnsServer.ServeDNS(w, req)
+	require.Empty(t, w.msg.Answer)
+	require.Equal(t, dns.RcodeSuccess, w.msg.Rcode)
+
+	data, err := store.GetInteractionsWithIdForConsumer("abcdefghij", "consumer")
+	require.NoError(t, err)
+	require.Len(t, data, 2)
+	var qtypes []string
+	for _, item := range data {
+		interaction := &Interaction{}
+		require.NoError(t, jsoniter.UnmarshalFromString(item, interaction))
+		qtypes = append(qtypes, interaction.QType)
+	}
+	require.Equal(t, []string{"HTTPS", "SVCB"}, qtypes)
+}
+
+func TestDNSServerSectionRecords(t *testing.T) {
+	recordsFile := filepath.Join(t.TempDir(), "records.yaml")
+	require.NoError(t, os.WriteFile(recordsFile, []byte(`delegated:
+  - type: A
+    value: 192.0.2.80
+  - type: NS
+    value: ns1.attacker.example.org
+    section: authority
+  - type: A
+    value: 198.51.100.53
+    name: ns1.attacker.example.org
+    section: additional
+`), 0o600))
+
+	opts := newTestOptions([]string{"192.0.2.50"}, "127.0.0.1")
+	opts.CustomRecords = recordsFile
+	newTestStore(t, opts)
+	dnsServer := NewDNSServer("udp", opts)
+
+	req := new(dns.Msg)
+	req.SetQuestion("delegated.example.com.", dns.TypeA)
+	w := &testResponseWriter{}
+	dnsServer.ServeDNS(w, req)
+
+	// the answer is unchanged, the configured records are attached to their sections
+	require.Len(t, w.msg.Answer, 1)
+	require.True(t, hasRecord(w.msg.Answer, dns.TypeA, "192.0.2.80"))
+	require.Len(t, w.msg.Ns, 1)
+	ns, ok := w.msg.Ns[0].(*dns.NS)
+	require.True(t, ok)
+	require.Equal(t, "delegated.example.com.", ns.Hdr.Name)
+	require.Equal(t, "ns1.attacker.example.org.", ns.Ns)
+	require.Len(t, w.msg.Extra, 1)
+	glue, ok := w.msg.Extra[0].(*dns.A)
+	require.True(t, ok)
+	require.Equal(t, "ns1.attacker.example.org.", glue.Hdr.Name)
+	require.Equal(t, "198.51.100.53", glue.A.String())
+
+	// the section records are attached for every query type but never answered
+	req.SetQuestion("delegated.example.com.", dns.TypeANY)
+	dnsServer.ServeDNS(w, req)
+	require.Len(t, w.msg.Answer, 1)
+	require.Len(t, w.msg.Ns, 1)
+	require.Len(t, w.msg.Extra, 1)
+
+	// other names are answered without them
+	req.SetQuestion("other.example.com.", dns.TypeA)
+	dnsServer.ServeDNS(w, req)
+	require.NotContains(t, w.msg.String(), "attacker")
+
+	records := newCustomDNSRecordsServer("", opts.Domains)
+	require.NoError(t, os.WriteFile(recordsFile, []byte("delegated:\n  - type: A\n    value: 192.0.2.80\n    section: footer\n"), 0o600))
+	require.Error(t, records.readRecordsFromFile(recordsFile))
+}
+
+func TestDNSServerDNAMERecord(t *testing.T) {
+	opts := newTestOptions([]string{"192.0.2.50"}, "127.0.0.1")
+	opts.CorrelationIdLength = 10
+	store := newTestStore(t, opts, "abcdefghij")
+	dnsServer := NewDNSServer("udp", opts)
+	dnsServer.customRecords.records["redirect"] = []CustomRecordConfig{{Type: "DNAME", Value: "target.example.org", TTL: 300}}
+
+	// names below the owner get the dname and the synthesized cname
+	req := new(dns.Msg)
+	req.SetQuestion("abcdefghij.sub.redirect.example.com.", dns.TypeA)
+	w := &testResponseWriter{}
+	dnsServer.ServeDNS(w, req)
+
+	require.Len(t, w.msg.Answer, 2)
+	dname, ok := w.msg.Answer[0].(*dns.DNAME)
+	require.True(t, ok)
+	require.Equal(t, "redirect.example.com.", dname.Hdr.Name)
+	require.Equal(t, "target.example.org.", dname.Target)
+	cname, ok := w.msg.Answer[1].(*dns.CNAME)
+	require.True(t, ok)
+	require.Equal(t, "abcdefghij.sub.redirect.example.com.", cname.Hdr.Name)
+	require.Equal(t, "abcdefghij.sub.target.example.org.", cname.Target)
+	require.Equal(t, uint32(300), cname.Hdr.Ttl)
+
+	// the dname record itself is answered at the owner
+	req.SetQuestion("redirect.example.com.", dns.TypeDNAME)
+	dnsServer.ServeDNS(w, req)
+	require.Len(t, w.msg.Answer, 1)
+	dname, ok = w.msg.Answer[0].(*dns.DNAME)
+	require.True(t, ok)
+	require.Equal(t, "target.example.org.", dname.Target)
+
+	// the owner name is not redirected
+	req.SetQuestion("redirect.example.com.", dns.TypeA)
+	dnsServer.ServeDNS(w, req)
+	require.True(t, hasRecord(w.msg.Answer, dns.TypeA, "192.0.2.50"))
+
+	// synthesized names exceeding the maximum length are refused
+	long := strings.Repeat(strings.Repeat("a", 60)+".", 3)
+	dnsServer.customRecords.records["redirect"][0].Value = strings.Repeat("b", 60) + ".target.example.org"
+	req.SetQuestion(long+"redirect.example.com.", dns.TypeA)
+	dnsServer.ServeDNS(w, req)
+	require.Equal(t, dns.RcodeYXDomain, w.msg.Rcode)
+
+	data, err := store.GetInteractionsWithIdForConsumer("abcdefghij", "consumer")
+	require.NoError(t, err)
+	require.Len(t, data, 1)
+	interaction := &Interaction{}
+	require.NoError(t, jsoniter.UnmarshalFromString(data[0], interaction))
+	require.Equal(t, "A", interaction.QType)
+	require.Contains(t, interaction.RawResponse, "DNAME")
+}
+
+func TestDNSServerInteractionOpcodeAndFlags(t *testing.T) {
+	opts := newTestOptions([]string{"192.0.2.50"}, "127.0.0.1")
+	opts.CorrelationIdLength = 10
+	store := newTestStore(t, opts, "abcdefghij")
+	dnsServer := NewDNSServer("udp", opts)
+
+	req := new(dns.Msg)
+	req.SetQuestion("abcdefghij.example.com.", dns.TypeA)
+	req.Opcode = dns.OpcodeStatus
+	req.RecursionDesired = true
+	req.CheckingDisabled = true
+	dnsServer.ServeDNS(&testResponseWriter{}, req)
+
+	data, err := store.GetInteractionsWithIdForConsumer("abcdefghij", "consumer")
+	require.NoError(t, err)
+	require.Len(t, data, 1)
+	interaction := &Interaction{}
+	require.NoError(t, jsoniter.UnmarshalFromString(data[0], interaction))
+	require.Equal(t, "STATUS", interaction.DNSOpcode)
+	require.Equal(t, []string{"rd", "cd"}, interaction.DNSFlags)
+}
+
+func TestDNSServerCorrelationPositionLeftmost(t *testing.T) {
+	opts := newTestOptions([]string{"192.0.2.50"}, "127.0.0.1")
+	opts.CorrelationIdLength = 10
+	opts.CorrelationIdNonceLength = 3
+	opts.CorrelationPosition = CorrelationPositionLeftmost
+	store := newTestStore(t, opts, "abcdefghij")
+	dnsServer := NewDNSServer("udp", opts)
+
+	for _, name := range []string{"foo.abcdefghijklm.example.com.", "abcdefghijklm.example.com."} {
+		req := new(dns.Msg)
+		req.SetQuestion(name, dns.TypeA)
+		dnsServer.ServeDNS(&testResponseWriter{}, req)
+	}
+
+	data, err := store.GetInteractionsWithIdForConsumer("abcdefghij", "consumer")
+	require.NoError(t, err)
+	require.Len(t, data, 1, "only the left-most label should be matched")
+	interaction := &Interaction{}
+	require.NoError(t, jsoniter.UnmarshalFromString(data[0], interaction))
+	require.Equal(t, "abcdefghijklm", interaction.UniqueID)
+	require.Equal(t, "abcdefghijklm", interaction.FullId)
+}
+
+func TestDNSServerInteractionClass(t *testing.T) {
+	opts := newTestOptions([]string{"192.0.2.50"}, "127.0.0.1")
+	opts.CorrelationIdLength = 10
+	store := newTestStore(t, opts, "abcdefghij")
+	dnsServer := NewDNSServer("udp", opts)
+
+	req := new(dns.Msg)
+	req.SetQuestion("abcdefghij.example.com.", dns.TypeA)
+	dnsServer.ServeDNS(&testResponseWriter{}, req)
+
+	data, err := store.GetInteractionsWithIdForConsumer("abcdefghij", "consumer")
+	require.NoError(t, err)
+	require.Len(t, data, 1)
+	interaction := &Interaction{}
+	require.NoError(t, jsoniter.UnmarshalFromString(data[0], interaction))
+	require.Equal(t, "IN", interaction.DNSClass)
+}
+
+func TestDNSServerEDNSPadding(t *testing.T) {
+	opts := newTestOptions([]string{"192.0.2.50"}, "127.0.0.1")
+	opts.CorrelationIdLength = 10
+	opts.DNSEDNSPadding = 128
+	store := newTestStore(t, opts, "abcdefghij")
+	dnsServer := NewDNSServer("udp", opts)
+
+	req := new(dns.Msg)
+	req.SetQuestion("abcdefghij.example.com.", dns.TypeA)
+	req.SetEdns0(1232, false)
+	w := &testResponseWriter{}
+	dnsServer.ServeDNS(w, req)
+
+	require.NotNil(t, w.msg)
+	opt := w.msg.IsEdns0()
+	require.NotNil(t, opt, "response should carry an opt record")
+	require.True(t, isPadded(w.msg))
+	packed, err := w.msg.Pack()
+	require.NoError(t, err)
+	require.Zero(t, len(packed)%128, "response should be padded to the block size")
+
+	data, err := store.GetInteractionsWithIdForConsumer("abcdefghij", "consumer")
+	require.NoError(t, err)
+	require.Len(t, data, 1)
+	interaction := &Interaction{}
+	require.NoError(t, jsoniter.UnmarshalFromString(data[0], interaction))
+	require.True(t, interaction.DNSPadded)
+
+	// queries without edns0 are answered without padding
+	req = new(dns.Msg)
+	req.SetQuestion("abcdefghij.example.com.", dns.TypeA)
+	w = &testResponseWriter{}
+	dnsServer.ServeDNS(w, req)
+	require.Nil(t, w.msg.IsEdns0())
+}
+
+func TestDNSServerMultiQuestion(t *testing.T) {
+	opts := newTestOptions([]string{"192.0.2.50"}, "127.0.0.1")
+	opts.CorrelationIdLength = 10
+	store := newTestStore(t, opts, "abcdefghij")
+	dnsServer := NewDNSServer("udp", opts)
+
+	newRequest := func() *dns.Msg {
+		req := new(dns.Msg)
+		req.SetQuestion("abcdefghij.example.com.", dns.TypeA)
+		req.Question = append(req.Question, dns.Question{Name: "other.example.com.", Qtype: dns.TypeTXT, Qclass: dns.ClassINET})
+		return req
+	}
+
+	w := &testResponseWriter{}
+	dnsServer.ServeDNS(w, newRequest())
+	require.Equal(t, dns.RcodeSuccess, w.msg.Rcode)
+	require.True(t, hasRecord(w.msg.Answer, dns.TypeA, "192.0.2.50"))
+
+	opts.DNSRefuseMultiQuestion = true
+	w = &testResponseWriter{}
+	dnsServer.ServeDNS(w, newRequest())
+	require.Equal(t, dns.RcodeRefused, w.msg.Rcode)
+	require.Empty(t, w.msg.Answer)
+
+	data, err := store.GetInteractionsWithIdForConsumer("abcdefghij", "consumer")
+	require.NoError(t, err)
+	require.Len(t, data, 2)
+	for _, item := range data {
+		interaction := &Interaction{}
+		require.NoError(t, jsoniter.UnmarshalFromString(item, interaction))
+		require.Equal(t, 2, interaction.DNSQuestionCount)
+		require.Equal(t, []string{"abcdefghij.example.com.", "other.example.com."}, interaction.DNSQuestions)
+	}
+}
+
+func TestDNSServerUpstreamForwarding(t *testing.T) {
+	// stub upstream resolver answering every A query with a fixed address
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	upstream := &dns.Server{PacketConn: conn, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.Answer = append(m.Answer, &dns.A{Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.ParseIP("203.0.113.5")})
+		_ = w.WriteMsg(m)
+	})}
+	started := make(chan struct{})
+	upstream.NotifyStartedFunc = func() { close(started) }
+	go func() { _ = upstream.ActivateAndServe() }()
+	defer func() { _ = upstream.Shutdown() }()
+	<-started
+
+	opts := newTestOptions([]string{"192.0.2.50"}, "127.0.0.1")
+	opts.CorrelationIdLength = 10
+	opts.Token = "token"
+	opts.DNSUpstream = conn.LocalAddr().String()
+	store := newTestStore(t, opts, "abcdefghij", "token")
+	dnsServer := NewDNSServer("udp", opts)
+
+	// names outside the configured domains are answered by the upstream
+	req := new(dns.Msg)
+	req.SetQuestion("unrelated.example.org.", dns.TypeA)
+	w := &testResponseWriter{}
+	dnsServer.ServeDNS(w, req)
+	require.Equal(t, req.Id, w.msg.Id)
+	require.True(t, hasRecord(w.msg.Answer, dns.TypeA, "203.0.113.5"))
+
+	data, err := store.GetInteractionsWithIdForConsumer("token", "consumer")
+	require.NoError(t, err)
+	require.Len(t, data, 1)
+	interaction := &Interaction{}
+	require.NoError(t, jsoniter.UnmarshalFromString(data[0], interaction))
+	require.True(t, interaction.DNSForwarded)
+	require.Equal(t, "unrelated.example.org.", interaction.FullId)
+	require.Contains(t, interaction.RawResponse, "203.0.113.5")
+
+	// names of the configured domains are still answered locally
+	req.SetQuestion("abcdefghij.example.com.", dns.TypeA)
+	dnsServer.ServeDNS(w, req)
+	require.True(t, hasRecord(w.msg.Answer, dns.TypeA, "192.0.2.50"))
+	data, err = store.GetInteractionsWithIdForConsumer("abcdefghij", "consumer")
+	require.NoError(t, err)
+	require.Len(t, data, 1)
+}
+
+func TestDNSServerDisableCompression(t *testing.T) {
+	opts := newTestOptions([]string{"192.0.2.50", "192.0.2.51"}, "127.0.0.1")
+	newTestStore(t, opts)
+	dnsServer := NewDNSServer("udp", opts)
+
+	// 0xc00c is a pointer to the question name, which answers reuse when compressed
+	questionPointer := []byte{0xc0, 0x0c}
+	query := func() []byte {
+		req := new(dns.Msg)
+		req.SetQuestion("www.example.com.", dns.TypeA)
+		w := &testResponseWriter{}
+		dnsServer.ServeDNS(w, req)
+		require.Len(t, w.msg.Answer, 2)
+		packed, err := w.msg.Pack()
+		require.NoError(t, err)
+		return packed
+	}
+	require.True(t, bytes.Contains(query(), questionPointer))
+
+	opts.DNSDisableCompression = true
+	packed := query()
+	require.False(t, bytes.Contains(packed, questionPointer))
+	// the name is written in full in the question, the two answers and the two authority records
+	require.Equal(t, 5, bytes.Count(packed, []byte("\x03www\x07example\x03com\x00")))
+}
+
+func TestDNSServerChaosVersionBind(t *testing.T) {
+	opts := newTestOptions([]string{"192.0.2.50"}, "127.0.0.1")
+	newTestStore(t, opts)
+	dnsServer := NewDNSServer("udp", opts)
+
+	query := func(name string) *dns.Msg {
+		req := new(dns.Msg)
+		req.SetQuestion(name, dns.TypeTXT)
+		req.Question[0].Qclass = dns.ClassCHAOS
+		w := &testResponseWriter{}
+		dnsServer.ServeDNS(w, req)
+		return w.msg
+	}
+	for _, rr := range query("version.bind.").Answer {
+		require.NotEqual(t, uint16(dns.ClassCHAOS), rr.Header().Class, "chaos queries should not be answered by default")
+	}
+
+	opts.DNSVersionString = "9.18.24"
+	for _, name := range []string{"version.bind.", "HOSTNAME.BIND."} {
+		resp := query(name)
+		require.Len(t, resp.Answer, 1)
+		txt, ok := resp.Answer[0].(*dns.TXT)
+		require.True(t, ok)
+		require.Equal(t, uint16(dns.ClassCHAOS), txt.Hdr.Class)
+		require.Equal(t, []string{"9.18.24"}, txt.Txt)
+	}
+	require.Empty(t, query("other.bind.").Answer)
+}
+
+// newTestStore attaches an in-memory storage with the given ids registered to opts
+func newTestStore(t *testing.T, opts *Options, ids ...string) *storage.StorageDB {
+	t.Helper()
+	store, err := storage.New(&storage.Options{})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = store.Close() })
+	for _, id := range ids {
+		require.NoError(t, store.SetID(id))
+	}
+	opts.Storage = store
+	opts.Stats = &Metrics{}
+	return store
+}
+
+// testResponseWriter is a dns.ResponseWriter recording the written message
+type testResponseWriter struct {
+	msg *dns.Msg
+}
+
+func (w *testResponseWriter) LocalAddr() net.Addr {
+	return &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 53}
+}
+
+func (w *testResponseWriter) RemoteAddr() net.Addr {
+	return &net.UDPAddr{IP: net.ParseIP("198.51.100.7"), Port: 34567}
+}
+
+func (w *testResponseWriter) WriteMsg(m *dns.Msg) error {
+	w.msg = m
+	return nil
+}
+
+func (w *testResponseWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w *testResponseWriter) Close() error                { return nil }
+func (w *testResponseWriter) TsigStatus() error           { return nil }
+func (w *testResponseWriter) TsigTimersOnly(bool)         {}
+func (w *testResponseWriter) Hijack()                     {}
+
 func hasRecord(rrs []dns.RR, rrtype uint16, expectedValue string) bool {
 	for _, rr := range rrs {
 		switch rec := rr.(type) {
@@ -202,3 +692,193 @@ func newTestOptions(ips []string, listenIP string) *Options {
 		ListenIP:    listenIP,
 	}
 }
+
+func TestDNSServerWildcardCNAMETwoHops(t *testing.T) {
+	opts := newTestOptions([]string{"192.0.2.50"}, "127.0.0.1")
+	opts.CorrelationIdLength = 10
+	opts.DNSWildcardCNAMESuffix = "hop"
+	store := newTestStore(t, opts, "abcdefghij")
+	dnsServer := NewDNSServer("udp", opts)
+
+	query := func(name string) *dns.Msg {
+		req := new(dns.Msg)
+		req.SetQuestion(name, dns.TypeA)
+		w := &testResponseWriter{}
+		dnsServer.ServeDNS(w, req)
+		return w.msg
+	}
+
+	// first hop is answered with a cname to be followed
+	resp := query("data.abcdefghij.example.com.")
+	require.Len(t, resp.Answer, 1)
+	cname, ok := resp.Answer[0].(*dns.CNAME)
+	require.True(t, ok)
+	require.Equal(t, "data.abcdefghij.hop.example.com.", cname.Target)
+
+	// second hop resolves to the server ip
+	resp = query(cname.Target)
+	require.True(t, hasRecord(resp.Answer, dns.TypeA, "192.0.2.50"))
+
+	// names without a correlation id are answered normally
+	resp = query("www.example.com.")
+	require.True(t, hasRecord(resp.Answer, dns.TypeA, "192.0.2.50"))
+
+	data, err := store.GetInteractionsWithIdForConsumer("abcdefghij", "consumer")
+	require.NoError(t, err)
+	require.Len(t, data, 2)
+	for i, expected := range []int{1, 2} {
+		interaction := &Interaction{}
+		require.NoError(t, jsoniter.UnmarshalFromString(data[i], interaction))
+		require.Equal(t, expected, interaction.DNSHop)
+		require.Equal(t, "abcdefghij", interaction.UniqueID)
+	}
+}
+
+func TestDNSServerDeprecatedDomain(t *testing.T) {
+	opts := newTestOptions([]string{"192.0.2.50"}, "127.0.0.1")
+	opts.Domains = []string{"example.com", "old.example.net"}
+	opts.DeprecatedDomains = []string{"old.example.net"}
+	opts.CorrelationIdLength = 10
+	store := newTestStore(t, opts, "abcdefghij")
+	dnsServer := NewDNSServer("udp", opts)
+
+	for _, name := range []string{"abcdefghij.example.com.", "abcdefghij.OLD.example.net."} {
+		req := new(dns.Msg)
+		req.SetQuestion(name, dns.TypeA)
+		dnsServer.ServeDNS(&testResponseWriter{}, req)
+	}
+
+	data, err := store.GetInteractionsWithIdForConsumer("abcdefghij", "consumer")
+	require.NoError(t, err)
+	require.Len(t, data, 2)
+	for i, deprecated := range []bool{false, true} {
+		interaction := &Interaction{}
+		require.NoError(t, jsoniter.UnmarshalFromString(data[i], interaction))
+		require.Equal(t, deprecated, interaction.Deprecated)
+	}
+}
+
+func TestDNSServerAnswerPoolRoundRobin(t *testing.T) {
+	opts := newTestOptions([]string{"192.0.2.50"}, "127.0.0.1")
+	opts.DNSAnswerPool = []net.IP{net.ParseIP("198.51.100.1"), net.ParseIP("198.51.100.2"), net.ParseIP("198.51.100.3")}
+	opts.DNSAnswerStrategy = DNSAnswerStrategyRoundRobin
+	dnsServer := NewDNSServer("udp", opts)
+
+	for _, expected := range []string{"198.51.100.1", "198.51.100.2", "198.51.100.3", "198.51.100.1"} {
+		msg := new(dns.Msg)
+		dnsServer.handleACNAMEANY(dns.Fqdn("pool.example.com"), msg)
+		require.Len(t, msg.Answer, 1)
+		require.True(t, hasRecord(msg.Answer, dns.TypeA, expected), "expected %s answer", expected)
+	}
+
+	// name servers keep being answered with the server addresses
+	msg := new(dns.Msg)
+	dnsServer.handleACNAMEANY(dns.Fqdn("ns1.example.com"), msg)
+	require.True(t, hasRecord(msg.Answer, dns.TypeA, "192.0.2.50"))
+}
+
+func TestDNSServerAnswerPoolRandom(t *testing.T) {
+	opts := newTestOptions([]string{"192.0.2.50"}, "127.0.0.1")
+	opts.DNSAnswerPool = []net.IP{net.ParseIP("198.51.100.1"), net.ParseIP("198.51.100.2")}
+	opts.DNSAnswerStrategy = DNSAnswerStrategyRandom
+	dnsServer := NewDNSServer("udp", opts)
+
+	seen := make(map[string]struct{})
+	for i := 0; i < 100; i++ {
+		msg := new(dns.Msg)
+		dnsServer.handleACNAMEANY(dns.Fqdn("pool.example.com"), msg)
+		require.Len(t, msg.Answer, 1)
+		record, ok := msg.Answer[0].(*dns.A)
+		require.True(t, ok)
+		seen[record.A.String()] = struct{}{}
+	}
+	require.Equal(t, map[string]struct{}{"198.51.100.1": {}, "198.51.100.2": {}}, seen)
+}
+
+func TestDNSServerAnswerPoolAll(t *testing.T) {
+	opts := newTestOptions([]string{"192.0.2.50"}, "127.0.0.1")
+	opts.CorrelationIdLength = 10
+	opts.DNSAnswerPool = []net.IP{net.ParseIP("198.51.100.1"), net.ParseIP("2001:db8::1")}
+	opts.DNSAnswerStrategy = DNSAnswerStrategyAll
+	store := newTestStore(t, opts, "abcdefghij")
+	dnsServer := NewDNSServer("udp", opts)
+
+	for i := 0; i < 3; i++ {
+		req := new(dns.Msg)
+		req.SetQuestion("abcdefghij.example.com.", dns.TypeA)
+		w := &testResponseWriter{}
+		dnsServer.ServeDNS(w, req)
+		require.True(t, hasRecord(w.msg.Answer, dns.TypeA, "198.51.100.1"))
+		require.True(t, hasRecord(w.msg.Answer, dns.TypeAAAA, "2001:db8::1"))
+		require.False(t, hasRecord(w.msg.Answer, dns.TypeA, "192.0.2.50"))
+	}
+
+	data, err := store.GetInteractionsWithIdForConsumer("abcdefghij", "consumer")
+	require.NoError(t, err)
+	require.Len(t, data, 3)
+}
+
+func TestDNSServerInteractionReceivedAt(t *testing.T) {
+	opts := newTestOptions([]string{"192.0.2.50"}, "127.0.0.1")
+	opts.CorrelationIdLength = 10
+	store := newTestStore(t, opts, "abcdefghij")
+	dnsServer := NewDNSServer("udp", opts)
+
+	req := new(dns.Msg)
+	req.SetQuestion("abcdefghij.example.com.", dns.TypeA)
+	dnsServer.ServeDNS(&testResponseWriter{}, req)
+
+	data, err := store.GetInteractionsWithIdForConsumer("abcdefghij", "consumer")
+	require.NoError(t, err)
+	require.Len(t, data, 1)
+	interaction := &Interaction{}
+	require.NoError(t, jsoniter.UnmarshalFromString(data[0], interaction))
+	require.False(t, interaction.ReceivedAt.IsZero())
+	require.False(t, interaction.ReceivedAt.After(interaction.Timestamp))
+}
+
+func TestDNSServerTCPOnly(t *testing.T) {
+	for name, truncateUDP := range map[string]bool{"drop": false, "truncate": true} {
+		t.Run(name, func(t *testing.T) {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			require.NoError(t, err)
+			port := listener.Addr().(*net.TCPAddr).Port
+			require.NoError(t, listener.Close())
+
+			opts := newTestOptions([]string{"192.0.2.50"}, "127.0.0.1")
+			opts.DnsPort = port
+			opts.DNSTCPOnly = true
+			opts.DNSTruncateUDP = truncateUDP
+			newTestStore(t, opts)
+			for _, network := range []string{"tcp", "udp"} {
+				dnsServer := NewDNSServer(network, opts)
+				alive := make(chan bool, 2)
+				go dnsServer.ListenAndServe(alive)
+				t.Cleanup(func() { _ = dnsServer.server.Shutdown() })
+			}
+
+			query := func(network string) (*dns.Msg, error) {
+				req := new(dns.Msg)
+				req.SetQuestion("test.example.com.", dns.TypeA)
+				client := &dns.Client{Net: network, Timeout: 200 * time.Millisecond}
+				resp, _, err := client.Exchange(req, net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+				return resp, err
+			}
+
+			require.Eventually(t, func() bool {
+				resp, err := query("tcp")
+				return err == nil && hasRecord(resp.Answer, dns.TypeA, "192.0.2.50")
+			}, 5*time.Second, 50*time.Millisecond, "tcp queries should be answered")
+
+			if truncateUDP {
+				require.Eventually(t, func() bool {
+					resp, err := query("udp")
+					return err == nil && resp.Truncated && len(resp.Answer) == 0
+				}, 5*time.Second, 50*time.Millisecond, "udp answers should be truncated")
+			} else {
+				_, err := query("udp")
+				require.Error(t, err, "udp queries should not be answered")
+			}
+		})
+	}
+}
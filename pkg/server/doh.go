@@ -0,0 +1,192 @@
+package server
+
+import (
+	"encoding/base64"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/miekg/dns"
+)
+
+const (
+	dohPath           = "/dns-query"
+	dohMessageType    = "application/dns-message"
+	dohJSONType       = "application/dns-json"
+	dohMaxMessageSize = dns.MaxMsgSize
+)
+
+// dnsProtocol returns the protocol of the interactions of dns queries, doh for the ones
+// received over HTTPS. They also have the DoH field set for the matchers of either protocol.
+func dnsProtocol(doh bool) string {
+	if doh {
+		return "doh"
+	}
+	return "dns"
+}
+
+// dohResponseWriter is a dns.ResponseWriter capturing the answer to a DNS over HTTPS query
+type dohResponseWriter struct {
+	remoteAddr net.Addr
+	msg        *dns.Msg
+}
+
+func (w *dohResponseWriter) LocalAddr() net.Addr  { return &net.TCPAddr{} }
+func (w *dohResponseWriter) RemoteAddr() net.Addr { return w.remoteAddr }
+func (w *dohResponseWriter) WriteMsg(m *dns.Msg) error {
+	w.msg = m
+	return nil
+}
+func (w *dohResponseWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w *dohResponseWriter) Close() error                { return nil }
+func (w *dohResponseWriter) TsigStatus() error           { return nil }
+func (w *dohResponseWriter) TsigTimersOnly(bool)         {}
+func (w *dohResponseWriter) Hijack()                     {}
+
+// dohJSONQuestion is a question of the DNS JSON format
+type dohJSONQuestion struct {
+	Name string `json:"name"`
+	Type uint16 `json:"type"`
+}
+
+// dohJSONRecord is a resource record of the DNS JSON format
+type dohJSONRecord struct {
+	Name string `json:"name"`
+	Type uint16 `json:"type"`
+	TTL  uint32 `json:"TTL"`
+	Data string `json:"data"`
+}
+
+// dohJSONResponse is a DNS response in the JSON format used by public DoH resolvers
+type dohJSONResponse struct {
+	Status    int               `json:"Status"`
+	TC        bool              `json:"TC"`
+	RD        bool              `json:"RD"`
+	RA        bool              `json:"RA"`
+	AD        bool              `json:"AD"`
+	CD        bool              `json:"CD"`
+	Question  []dohJSONQuestion `json:"Question"`
+	Answer    []dohJSONRecord   `json:"Answer,omitempty"`
+	Authority []dohJSONRecord   `json:"Authority,omitempty"`
+}
+
+// dohHandler answers DNS over HTTPS queries (RFC 8484 wire format and JSON format)
+// with the same logic as the dns server, recording them as doh interactions.
+func (h *HTTPServer) dohHandler(w http.ResponseWriter, req *http.Request) {
+	query, jsonFormat, err := parseDoHQuery(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writer := &dohResponseWriter{remoteAddr: h.dohRemoteAddr(req)}
+	h.dohServer.ServeDNS(writer, query)
+	if writer.msg == nil {
+		http.Error(w, "no dns response", http.StatusBadRequest)
+		return
+	}
+
+	if jsonFormat {
+		w.Header().Set("Content-Type", dohJSONType)
+		_ = jsoniter.NewEncoder(w).Encode(newDoHJSONResponse(writer.msg))
+		return
+	}
+	packed, err := writer.msg.Pack()
+	if err != nil {
+		http.Error(w, "could not pack dns response", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", dohMessageType)
+	_, _ = w.Write(packed)
+}
+
+// parseDoHQuery returns the DNS query of the request and whether it uses the JSON format
+func parseDoHQuery(req *http.Request) (*dns.Msg, bool, error) {
+	var packed []byte
+	switch req.Method {
+	case http.MethodGet:
+		values := req.URL.Query()
+		if name := values.Get("name"); name != "" {
+			qtype := uint16(dns.TypeA)
+			if value := values.Get("type"); value != "" {
+				if parsed, err := strconv.ParseUint(value, 10, 16); err == nil {
+					qtype = uint16(parsed)
+				} else if parsed, ok := dns.StringToType[strings.ToUpper(value)]; ok {
+					qtype = parsed
+				} else {
+					return nil, false, errors.New("invalid query type")
+				}
+			}
+			query := new(dns.Msg)
+			query.SetQuestion(dns.Fqdn(name), qtype)
+			return query, true, nil
+		}
+		var err error
+		packed, err = base64.RawURLEncoding.DecodeString(strings.TrimRight(values.Get("dns"), "="))
+		if err != nil || len(packed) == 0 {
+			return nil, false, errors.New("invalid dns parameter")
+		}
+	case http.MethodPost:
+		if !strings.HasPrefix(req.Header.Get("Content-Type"), dohMessageType) {
+			return nil, false, errors.New("unsupported content type")
+		}
+		var err error
+		packed, err = io.ReadAll(io.LimitReader(req.Body, dohMaxMessageSize))
+		if err != nil {
+			return nil, false, errors.New("could not read dns message")
+		}
+	default:
+		return nil, false, errors.New("unsupported method")
+	}
+
+	query := new(dns.Msg)
+	if err := query.Unpack(packed); err != nil {
+		return nil, false, errors.New("invalid dns message")
+	}
+	return query, false, nil
+}
+
+// dohRemoteAddr returns the address of the DoH client, honoring the origin ip header
+func (h *HTTPServer) dohRemoteAddr(req *http.Request) net.Addr {
+	host, port, _ := net.SplitHostPort(req.RemoteAddr)
+	if originIP := req.Header.Get(h.options.OriginIPHeader); h.options.OriginIPHeader != "" && originIP != "" {
+		host = originIP
+	}
+	parsedPort, _ := strconv.Atoi(port)
+	return &net.TCPAddr{IP: net.ParseIP(host), Port: parsedPort}
+}
+
+func newDoHJSONResponse(msg *dns.Msg) *dohJSONResponse {
+	response := &dohJSONResponse{
+		Status: msg.Rcode,
+		TC:     msg.Truncated,
+		RD:     msg.RecursionDesired,
+		RA:     msg.RecursionAvailable,
+		AD:     msg.AuthenticatedData,
+		CD:     msg.CheckingDisabled,
+	}
+	for _, question := range msg.Question {
+		response.Question = append(response.Question, dohJSONQuestion{Name: question.Name, Type: question.Qtype})
+	}
+	response.Answer = dohJSONRecords(msg.Answer)
+	response.Authority = dohJSONRecords(msg.Ns)
+	return response
+}
+
+func dohJSONRecords(rrs []dns.RR) []dohJSONRecord {
+	var records []dohJSONRecord
+	for _, rr := range rrs {
+		header := rr.Header()
+		records = append(records, dohJSONRecord{
+			Name: header.Name,
+			Type: header.Rrtype,
+			TTL:  header.Ttl,
+			Data: strings.TrimPrefix(rr.String(), header.String()),
+		})
+	}
+	return records
+}
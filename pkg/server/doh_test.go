@@ -0,0 +1,90 @@
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDoHWireFormat(t *testing.T) {
+	opts := newTestOptions([]string{"192.0.2.50"}, "127.0.0.1")
+	opts.CorrelationIdLength = 10
+	opts.DoH = true
+	store := newTestStore(t, opts, "abcdefghij")
+	h, err := NewHTTPServer(opts)
+	require.NoError(t, err)
+
+	query := new(dns.Msg)
+	query.SetQuestion("abcdefghij.example.com.", dns.TypeA)
+	query.Id = 0
+	packed, err := query.Pack()
+	require.NoError(t, err)
+
+	answer := func(req *http.Request) *dns.Msg {
+		w := httptest.NewRecorder()
+		h.nontlsserver.Handler.ServeHTTP(w, req)
+		resp := w.Result()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Equal(t, "application/dns-message", resp.Header.Get("Content-Type"))
+		body, _ := io.ReadAll(resp.Body)
+		msg := new(dns.Msg)
+		require.NoError(t, msg.Unpack(body))
+		return msg
+	}
+
+	req := httptest.NewRequest("POST", "/dns-query", bytes.NewReader(packed))
+	req.Header.Set("Content-Type", "application/dns-message")
+	msg := answer(req)
+	require.True(t, hasRecord(msg.Answer, dns.TypeA, "192.0.2.50"))
+
+	req = httptest.NewRequest("GET", "/dns-query?dns="+base64.RawURLEncoding.EncodeToString(packed), nil)
+	msg = answer(req)
+	require.True(t, hasRecord(msg.Answer, dns.TypeA, "192.0.2.50"))
+
+	data, err := store.GetInteractionsWithIdForConsumer("abcdefghij", "consumer")
+	require.NoError(t, err)
+	require.Len(t, data, 2)
+	interaction := &Interaction{}
+	require.NoError(t, jsoniter.UnmarshalFromString(data[0], interaction))
+	require.Equal(t, "doh", interaction.Protocol)
+	require.True(t, interaction.DoH)
+	require.Equal(t, "A", interaction.QType)
+	require.Equal(t, "192.0.2.1", interaction.RemoteAddress)
+}
+
+func TestDoHJSONFormat(t *testing.T) {
+	opts := newTestOptions([]string{"192.0.2.50"}, "127.0.0.1")
+	opts.CorrelationIdLength = 10
+	opts.DoH = true
+	store := newTestStore(t, opts, "abcdefghij")
+	h, err := NewHTTPServer(opts)
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	h.nontlsserver.Handler.ServeHTTP(w, httptest.NewRequest("GET", "/dns-query?name=abcdefghij.example.com&type=A", nil))
+	resp := w.Result()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "application/dns-json", resp.Header.Get("Content-Type"))
+	response := &dohJSONResponse{}
+	require.NoError(t, jsoniter.NewDecoder(resp.Body).Decode(response))
+	require.Equal(t, dns.RcodeSuccess, response.Status)
+	require.Equal(t, []dohJSONQuestion{{Name: "abcdefghij.example.com.", Type: dns.TypeA}}, response.Question)
+	require.NotEmpty(t, response.Answer)
+	require.Equal(t, "192.0.2.50", response.Answer[0].Data)
+
+	data, err := store.GetInteractionsWithIdForConsumer("abcdefghij", "consumer")
+	require.NoError(t, err)
+	require.Len(t, data, 1)
+
+	// malformed queries are rejected
+	w = httptest.NewRecorder()
+	h.nontlsserver.Handler.ServeHTTP(w, httptest.NewRequest("GET", "/dns-query?dns=invalid!", nil))
+	require.Equal(t, http.StatusBadRequest, w.Result().StatusCode)
+}
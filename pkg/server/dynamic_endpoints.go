@@ -0,0 +1,144 @@
+package server
+
+import (
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/projectdiscovery/gologger"
+	"gopkg.in/yaml.v3"
+)
+
+// DynamicEndpointConfig is a dynamic endpoint preloaded from a file
+type DynamicEndpointConfig struct {
+	// SubURL is the path the endpoint is served at under /apidocs/
+	SubURL string `yaml:"suburl"`
+	// Body is the response body of the endpoint
+	Body string `yaml:"body"`
+	// ContentType is the Content-Type header of the response
+	ContentType string `yaml:"content-type"`
+}
+
+// LoadDynamicEndpoints reads a YAML list of dynamic endpoints from a file
+func LoadDynamicEndpoints(input string) ([]DynamicEndpointConfig, error) {
+	data, err := os.ReadFile(input)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read file")
+	}
+	var endpoints []DynamicEndpointConfig
+	if err := yaml.Unmarshal(data, &endpoints); err != nil {
+		return nil, errors.Wrap(err, "could not decode dynamic endpoints")
+	}
+	for _, endpoint := range endpoints {
+		if endpoint.SubURL == "" {
+			return nil, errors.New("dynamic endpoint suburl is required")
+		}
+	}
+	return endpoints, nil
+}
+
+// reloadDynamicEndpoints replaces the endpoints preloaded from the
+// DynamicEndpointsFile with its current content. Endpoints registered
+// through /storerequest are preserved and take priority over the file.
+func (h *HTTPServer) reloadDynamicEndpoints() error {
+	info, err := os.Stat(h.options.DynamicEndpointsFile)
+	if err != nil {
+		return errors.Wrap(err, "could not stat file")
+	}
+	configs, err := LoadDynamicEndpoints(h.options.DynamicEndpointsFile)
+	if err != nil {
+		return err
+	}
+
+	h.dynMu.Lock()
+	defer h.dynMu.Unlock()
+
+	endpoints := make(map[string]dynamicEndpoint, len(h.dynamicEndpoints)+len(configs))
+	for suburl, de := range h.dynamicEndpoints {
+		if !de.fromFile {
+			endpoints[suburl] = de
+		}
+	}
+	for _, config := range configs {
+		if _, ok := endpoints[config.SubURL]; ok {
+			continue
+		}
+		endpoints[config.SubURL] = dynamicEndpoint{
+			Body:        []byte(config.Body),
+			ContentType: config.ContentType,
+			LastUpdated: info.ModTime(),
+			fromFile:    true,
+		}
+	}
+	h.dynamicEndpoints = endpoints
+	h.dynamicEndpointsModTime = info.ModTime()
+	return nil
+}
+
+// watchDynamicEndpoints reloads the DynamicEndpointsFile whenever it changes on disk, checking every interval
+func (h *HTTPServer) watchDynamicEndpoints(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		info, err := os.Stat(h.options.DynamicEndpointsFile)
+		if err != nil {
+			continue
+		}
+		h.dynMu.RLock()
+		changed := !info.ModTime().Equal(h.dynamicEndpointsModTime)
+		h.dynMu.RUnlock()
+		if !changed {
+			continue
+		}
+		if err := h.reloadDynamicEndpoints(); err != nil {
+			gologger.Warning().Msgf("Could not reload dynamic endpoints: %s\n", err)
+			continue
+		}
+		gologger.Info().Msgf("Reloaded dynamic endpoints from %s\n", h.options.DynamicEndpointsFile)
+	}
+}
+
+// defaultDynamicEndpointCooldown is the minimum interval between updates of a suburl when not configured
+const defaultDynamicEndpointCooldown = 24 * time.Hour
+
+// dynamicEndpointCooldown returns the minimum interval between updates of a suburl
+func (options *Options) dynamicEndpointCooldown() time.Duration {
+	if options.DynamicEndpointCooldown > 0 {
+		return options.DynamicEndpointCooldown
+	}
+	return defaultDynamicEndpointCooldown
+}
+
+// dynamicEndpointExpired returns true if the endpoint was registered through
+// /storerequest more than DynamicEndpointTTL ago. Preloaded endpoints never expire.
+func (h *HTTPServer) dynamicEndpointExpired(de dynamicEndpoint, now time.Time) bool {
+	return h.options.DynamicEndpointTTL > 0 && !de.fromFile && now.Sub(de.LastUpdated) >= h.options.DynamicEndpointTTL
+}
+
+// pruneDynamicEndpoints removes the expired endpoints, returning their number
+func (h *HTTPServer) pruneDynamicEndpoints(now time.Time) int {
+	h.dynMu.Lock()
+	defer h.dynMu.Unlock()
+
+	pruned := 0
+	for suburl, de := range h.dynamicEndpoints {
+		if h.dynamicEndpointExpired(de, now) {
+			delete(h.dynamicEndpoints, suburl)
+			pruned++
+		}
+	}
+	return pruned
+}
+
+// evictDynamicEndpoints removes the expired endpoints every interval
+func (h *HTTPServer) evictDynamicEndpoints(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		if pruned := h.pruneDynamicEndpoints(now); pruned > 0 {
+			gologger.Debug().Msgf("Evicted %d expired dynamic endpoints\n", pruned)
+		}
+	}
+}
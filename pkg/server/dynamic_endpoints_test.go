@@ -0,0 +1,114 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDynamicEndpointsFileReload(t *testing.T) {
+	endpointsFile := filepath.Join(t.TempDir(), "endpoints.yaml")
+	writeEndpoints := func(content string, modTime time.Time) {
+		require.NoError(t, os.WriteFile(endpointsFile, []byte(content), 0o600))
+		require.NoError(t, os.Chtimes(endpointsFile, modTime, modTime))
+	}
+	writeEndpoints(`- suburl: config
+  body: '{"version":1}'
+  content-type: application/json
+- suburl: removed
+  body: old
+`, time.Now().Add(-time.Hour))
+
+	h, err := NewHTTPServer(&Options{Domains: []string{"example.com"}, DynamicEndpointsFile: endpointsFile, DynamicEndpointsReload: 10 * time.Millisecond})
+	require.NoError(t, err)
+
+	fetch := func(suburl string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.apidocsHandler(w, httptest.NewRequest("GET", "/apidocs/"+suburl, nil))
+		return w
+	}
+	w := fetch("config")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, `{"version":1}`, w.Body.String())
+	require.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+	// endpoints registered at runtime survive the reloads
+	h.dynMu.Lock()
+	h.dynamicEndpoints["runtime"] = dynamicEndpoint{Body: []byte("runtime"), LastUpdated: time.Now()}
+	h.dynMu.Unlock()
+
+	writeEndpoints(`- suburl: config
+  body: '{"version":2}'
+  content-type: application/json
+`, time.Now())
+	require.Eventually(t, func() bool {
+		return fetch("config").Body.String() == `{"version":2}`
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, http.StatusNotFound, fetch("removed").Code, "endpoints removed from the file should not be served")
+	require.Equal(t, "runtime", fetch("runtime").Body.String())
+}
+
+func TestLoadDynamicEndpointsInvalid(t *testing.T) {
+	endpointsFile := filepath.Join(t.TempDir(), "endpoints.yaml")
+	require.NoError(t, os.WriteFile(endpointsFile, []byte("- body: missing suburl\n"), 0o600))
+	_, err := LoadDynamicEndpoints(endpointsFile)
+	require.Error(t, err)
+
+	_, err = NewHTTPServer(&Options{DynamicEndpointsFile: filepath.Join(t.TempDir(), "missing.yaml")})
+	require.Error(t, err)
+}
+
+func TestDynamicEndpointTTL(t *testing.T) {
+	h, err := NewHTTPServer(&Options{Domains: []string{"example.com"}, DynamicEndpointTTL: time.Hour, DynamicEndpointCooldown: time.Minute, ApidocsIndex: true})
+	require.NoError(t, err)
+
+	store := func(suburl, body string) int {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/storerequest", strings.NewReader(`{"body":"`+body+`","suburl":"`+suburl+`"}`))
+		h.storeHandler(w, req)
+		return w.Code
+	}
+	fetch := func(suburl string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.apidocsHandler(w, httptest.NewRequest("GET", "/apidocs/"+suburl, nil))
+		return w
+	}
+	age := func(suburl string, d time.Duration) {
+		h.dynMu.Lock()
+		de := h.dynamicEndpoints[suburl]
+		de.LastUpdated = de.LastUpdated.Add(-d)
+		h.dynamicEndpoints[suburl] = de
+		h.dynMu.Unlock()
+	}
+
+	require.Equal(t, http.StatusOK, store("expiring", "first"))
+	require.Equal(t, http.StatusOK, store("fresh", "fresh"))
+	require.Equal(t, http.StatusTooManyRequests, store("expiring", "second"), "updates are locked out for the cooldown")
+	age("expiring", 2*time.Minute)
+	require.Equal(t, http.StatusOK, store("expiring", "second"), "the cooldown is configurable")
+	require.Equal(t, "second", fetch("expiring").Body.String())
+
+	// expired endpoints are not served nor listed before being evicted
+	age("expiring", time.Hour)
+	require.Equal(t, http.StatusNotFound, fetch("expiring").Code)
+	require.Equal(t, `{"suburls":["fresh"]}`+"\n", fetch("").Body.String())
+
+	require.Equal(t, 1, h.pruneDynamicEndpoints(time.Now()))
+	h.dynMu.RLock()
+	_, ok := h.dynamicEndpoints["expiring"]
+	h.dynMu.RUnlock()
+	require.False(t, ok, "expired endpoints should be evicted")
+	require.Equal(t, "fresh", fetch("fresh").Body.String())
+
+	// an expired suburl can be registered again
+	require.Equal(t, http.StatusTooManyRequests, store("fresh", "again"))
+	age("fresh", 2*time.Hour)
+	require.Equal(t, http.StatusOK, store("fresh", "renewed"))
+	require.Equal(t, "renewed", fetch("fresh").Body.String())
+}
@@ -0,0 +1,169 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/projectdiscovery/gologger"
+)
+
+// eventsKeepAliveInterval is how often idle event streams receive a keep-alive
+// comment. The interactions not notified to the stream are also sent then.
+var eventsKeepAliveInterval = 15 * time.Second
+
+// interactionNotifier wakes up the event streams of a correlation id when one
+// of its interactions is stored
+type interactionNotifier struct {
+	mu          sync.Mutex
+	subscribers map[string]map[chan struct{}]struct{}
+}
+
+// interactionNotifier returns the interaction notifier shared by the servers
+func (options *Options) interactionNotifier() *interactionNotifier {
+	options.notifierOnce.Do(func() {
+		options.notifier = &interactionNotifier{subscribers: make(map[string]map[chan struct{}]struct{})}
+	})
+	return options.notifier
+}
+
+// subscribe returns a channel signaled when interactions of the correlation id
+// are stored, along with the function to unsubscribe it
+func (n *interactionNotifier) subscribe(correlationID string) (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+	n.mu.Lock()
+	if n.subscribers[correlationID] == nil {
+		n.subscribers[correlationID] = make(map[chan struct{}]struct{})
+	}
+	n.subscribers[correlationID][ch] = struct{}{}
+	n.mu.Unlock()
+
+	return ch, func() {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+
+		delete(n.subscribers[correlationID], ch)
+		if len(n.subscribers[correlationID]) == 0 {
+			delete(n.subscribers, correlationID)
+		}
+	}
+}
+
+// notify signals the subscribers of the correlation id, pending signals are coalesced
+func (n *interactionNotifier) notify(correlationID string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for ch := range n.subscribers[correlationID] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// storeInteraction stores an interaction of the correlation id and notifies its event streams
+func (options *Options) storeInteraction(correlationID string, data []byte) error {
+	if err := options.Storage.AddInteraction(correlationID, data); err != nil {
+		return err
+	}
+	options.interactionNotifier().notify(correlationID)
+	return nil
+}
+
+// storeInteractionWithId publishes an interaction without correlation id, such as the
+// root tld and auth token ones, and stores it in the stream of the id
+func (options *Options) storeInteractionWithId(id string, interaction *Interaction, data []byte) error {
+	options.publishInteraction(interaction, data)
+	return options.Storage.AddInteractionWithId(id, data)
+}
+
+// eventsHandler streams the interactions of a correlation id as server-sent events.
+// Each event carries a PollResponse, encrypted the same way as the /poll ones, and
+// is sent as soon as interactions are stored until the client disconnects.
+func (h *HTTPServer) eventsHandler(w http.ResponseWriter, req *http.Request) {
+	ID := req.URL.Query().Get("id")
+	if ID == "" {
+		jsonError(w, "no id specified for events", http.StatusBadRequest)
+		return
+	}
+	secret := req.URL.Query().Get("secret")
+	if secret == "" {
+		jsonError(w, "no secret specified for events", http.StatusBadRequest)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		jsonError(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	// subscribing before the first drain ensures no interaction is missed in between
+	notified, unsubscribe := h.options.interactionNotifier().subscribe(ID)
+	defer unsubscribe()
+
+	// the first drain validates the session before the stream is started
+	response, err := h.collectInteractions(ID, secret, false, 0)
+	if err != nil {
+		gologger.Warning().Msgf("Could not get interactions for %s: %s\n", ID, err)
+		jsonError(w, fmt.Sprintf("could not get interactions: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	gologger.Debug().Msgf("Started event stream for %s correlationID\n", ID)
+
+	keepAlive := time.NewTicker(eventsKeepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		if err := writeInteractionsEvent(w, response); err != nil {
+			gologger.Debug().Msgf("Closed event stream for %s correlationID: %s\n", ID, err)
+			return
+		}
+		flusher.Flush()
+
+		// the interactions left out by the poll batch limit are sent right away
+		if !response.More {
+			select {
+			case <-req.Context().Done():
+				gologger.Debug().Msgf("Closed event stream for %s correlationID\n", ID)
+				return
+			case <-notified:
+			case <-keepAlive.C:
+				if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+					return
+				}
+			}
+		}
+
+		response, err = h.collectInteractions(ID, secret, false, 0)
+		if err != nil {
+			// the session was deregistered or expired
+			data, _ := jsoniter.Marshal(map[string]string{"error": fmt.Sprintf("could not get interactions: %s", err)})
+			_, _ = fmt.Fprintf(w, "event: error\ndata: %s\n\n", data)
+			flusher.Flush()
+			return
+		}
+	}
+}
+
+// writeInteractionsEvent writes the interactions of the response as an event, if any
+func writeInteractionsEvent(w http.ResponseWriter, response *PollResponse) error {
+	if len(response.Data) == 0 && len(response.Extra) == 0 && len(response.TLDData) == 0 {
+		return nil
+	}
+	data, err := jsoniter.Marshal(response)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: interactions\ndata: %s\n\n", data)
+	return err
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventsHandler(t *testing.T) {
+	opts := &Options{}
+	store := newTestStore(t, opts)
+	require.NoError(t, store.SetIDPublicKey("abcdefghij", "secret", newTestPublicKey(t)))
+	require.NoError(t, opts.storeInteraction("abcdefghij", []byte("before")))
+	h := &HTTPServer{options: opts}
+	ts := httptest.NewServer(http.HandlerFunc(h.eventsHandler))
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/events?id=abcdefghij&secret=invalid")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	_ = resp.Body.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", ts.URL+"/events?id=abcdefghij&secret=secret", nil)
+	require.NoError(t, err)
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	reader := bufio.NewReader(resp.Body)
+	nextEvent := func() *PollResponse {
+		var event, data string
+		for {
+			line, err := reader.ReadString('\n')
+			require.NoError(t, err)
+			line = strings.TrimSuffix(line, "\n")
+			switch {
+			case strings.HasPrefix(line, "event: "):
+				event = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				data = strings.TrimPrefix(line, "data: ")
+			case line == "" && event != "":
+				require.Equal(t, "interactions", event)
+				response := &PollResponse{}
+				require.NoError(t, jsoniter.UnmarshalFromString(data, response))
+				return response
+			}
+		}
+	}
+
+	// interactions stored before the stream are sent first
+	response := nextEvent()
+	require.Len(t, response.Data, 1)
+	require.NotEmpty(t, response.AESKey)
+	require.NotEqual(t, "before", response.Data[0], "interactions should be encrypted as in polls")
+
+	require.NoError(t, opts.storeInteraction("abcdefghij", []byte("after")))
+	response = nextEvent()
+	require.Len(t, response.Data, 1)
+
+	// the subscription is released once the client disconnects
+	cancel()
+	require.Eventually(t, func() bool {
+		notifier := opts.interactionNotifier()
+		notifier.mu.Lock()
+		defer notifier.mu.Unlock()
+		return len(notifier.subscribers) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+}
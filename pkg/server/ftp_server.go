@@ -4,12 +4,13 @@ import (
 	"crypto/tls"
 	"fmt"
 	"io"
+	"net"
 	"os"
+	"strconv"
 	"strings"
 	"sync/atomic"
 	"time"
 
-	jsoniter "github.com/json-iterator/go"
 	"github.com/projectdiscovery/gologger"
 	ftpserver "goftp.io/server/v2"
 	"goftp.io/server/v2/driver/file"
@@ -20,12 +21,20 @@ type FTPServer struct {
 	options    *Options
 	ftpServer  *ftpserver.Server
 	ftpsServer *ftpserver.Server
+	// script holds the scripted responses of the plain ftp listener
+	script *ProtocolScript
 }
 
 // NewFTPServer returns a new TLS & Non-TLS FTP server.
 func NewFTPServer(options *Options) (*FTPServer, error) {
 	server := &FTPServer{options: options}
 
+	script, err := options.loadProtocolScript("ftp")
+	if err != nil {
+		return nil, err
+	}
+	server.script = script
+
 	ftpFolder := options.FTPDirectory
 	if ftpFolder == "" {
 		var err error
@@ -105,12 +114,31 @@ func (h *FTPServer) ListenAndServe(tlsConfig *tls.Config, ftpAlive chan bool, ft
 	}()
 
 	ftpAlive <- true
-	if err := h.ftpServer.ListenAndServe(); err != nil {
+	if err := h.listenAndServePlain(); err != nil {
 		gologger.Error().Msgf("Could not serve ftp on port 21: %s\n", err)
 		ftpAlive <- false
 	}
 }
 
+// listenAndServePlain serves the plain ftp server, answering with the scripted responses
+// and detecting the other protocols sent to it when enabled
+func (h *FTPServer) listenAndServePlain() error {
+	if !h.options.DetectProtocolMismatch && h.script == nil {
+		return h.ftpServer.ListenAndServe()
+	}
+	ln, err := net.Listen("tcp", net.JoinHostPort("", strconv.Itoa(h.options.FtpPort)))
+	if err != nil {
+		return err
+	}
+	if h.script != nil {
+		ln = &scriptListener{Listener: ln, script: h.script}
+	}
+	if h.options.DetectProtocolMismatch {
+		ln = &mismatchListener{Listener: ln, options: h.options, expected: "ftp"}
+	}
+	return h.ftpServer.Serve(ln)
+}
+
 func (h *FTPServer) Close() {
 	_ = h.ftpServer.Shutdown()
 	if h.ftpsServer != nil {
@@ -124,18 +152,20 @@ func (h *FTPServer) recordInteraction(remoteAddress, data string) {
 	if data == "" {
 		return
 	}
+	now := time.Now()
 	interaction := &Interaction{
 		RemoteAddress: remoteAddress,
 		Protocol:      "ftp",
 		RawRequest:    data,
-		Timestamp:     time.Now(),
+		ReceivedAt:    now,
+		Timestamp:     now,
 	}
-	dataBytes, err := jsoniter.Marshal(interaction)
+	dataBytes, err := h.options.encodeInteraction(interaction)
 	if err != nil {
 		gologger.Warning().Msgf("Could not encode ftp interaction: %s\n", err)
 	} else {
 		gologger.Debug().Msgf("FTP Interaction: \n%s\n", string(dataBytes))
-		if err := h.options.Storage.AddInteractionWithId(h.options.Token, dataBytes); err != nil {
+		if err := h.options.storeInteractionWithId(h.options.Token, interaction, dataBytes); err != nil {
 			gologger.Warning().Msgf("Could not store ftp interaction: %s\n", err)
 		}
 	}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"strings"
+
+	"github.com/asaskevich/govalidator"
+)
+
+// isRegistered returns true if the correlation id is registered on the storage
+func (options *Options) isRegistered(correlationID string) bool {
+	if correlationID == "" {
+		return false
+	}
+	_, err := options.Storage.GetCacheItem(correlationID)
+	return err == nil
+}
+
+// fuzzyCorrelationMatch returns the registered correlation id closest to one of
+// the labels within the fuzzy correlation distance, along with the index of the
+// label it was matched by. Labels are compared to the correlation ids by their
+// prefix, the nonce following a correlation id being unknown.
+func (options *Options) fuzzyCorrelationMatch(labels []string) (string, int, bool) {
+	maxDistance := options.FuzzyCorrelationDistance
+	if maxDistance <= 0 {
+		return "", -1, false
+	}
+	var candidates []string
+	for _, id := range options.Storage.CorrelationIDs() {
+		if len(id) == options.CorrelationIdLength {
+			candidates = append(candidates, id)
+		}
+	}
+
+	bestID, bestIndex, bestDistance := "", -1, maxDistance+1
+	for i, label := range labels {
+		label = strings.ToLower(label)
+		if len(label) < options.CorrelationIdLength-maxDistance || len(label) > options.GetIdLength()+maxDistance || !govalidator.IsAlphanumeric(label) {
+			continue
+		}
+		for _, id := range candidates {
+			if distance := prefixEditDistance(id, label); distance < bestDistance {
+				bestID, bestIndex, bestDistance = id, i, distance
+			}
+		}
+	}
+	if bestIndex == -1 || !options.isRegistered(bestID) {
+		return "", -1, false
+	}
+	return bestID, bestIndex, true
+}
+
+// prefixEditDistance returns the smallest edit distance between a and the prefixes
+// of b. Insertions, deletions, substitutions and transpositions of adjacent
+// characters each count as a single edit.
+func prefixEditDistance(a, b string) int {
+	// rows holds the last three rows of the distance matrix of a[:i] and b[:j]
+	rows := [3][]int{make([]int, len(b)+1), make([]int, len(b)+1), make([]int, len(b)+1)}
+	for j := range rows[0] {
+		rows[0][j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		prev2, prev, cur := rows[(i+1)%3], rows[(i+2)%3], rows[i%3]
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+		}
+	}
+	last := rows[len(a)%3]
+	distance := last[0]
+	for _, d := range last[1:] {
+		distance = min(distance, d)
+	}
+	return distance
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrefixEditDistance(t *testing.T) {
+	require.Equal(t, 0, prefixEditDistance("abcdefghij", "abcdefghijklm"))
+	require.Equal(t, 1, prefixEditDistance("abcdefghij", "abcdfghijklm"), "dropped character")
+	require.Equal(t, 1, prefixEditDistance("abcdefghij", "abcdxefghijklm"), "inserted character")
+	require.Equal(t, 1, prefixEditDistance("abcdefghij", "abcdefgxijklm"), "substituted character")
+	require.Equal(t, 1, prefixEditDistance("abcdefghij", "abcedfghijklm"), "transposed characters")
+	require.Equal(t, 3, prefixEditDistance("abcdefghij", "abxyzfghijklm"))
+}
+
+func TestFuzzyCorrelationMatch(t *testing.T) {
+	opts := newTestOptions([]string{"192.0.2.50"}, "127.0.0.1")
+	opts.CorrelationIdLength = 10
+	opts.CorrelationIdNonceLength = 3
+	opts.FuzzyCorrelationDistance = 1
+	store := newTestStore(t, opts, "abcdefghij", "zyxwvutsrq")
+	dnsServer := NewDNSServer("udp", opts)
+
+	interactions := func() []*Interaction {
+		data, err := store.GetInteractionsWithIdForConsumer("abcdefghij", "consumer")
+		require.NoError(t, err)
+		var interactions []*Interaction
+		for _, item := range data {
+			interaction := &Interaction{}
+			require.NoError(t, jsoniter.UnmarshalFromString(item, interaction))
+			interactions = append(interactions, interaction)
+		}
+		return interactions
+	}
+	query := func(name string) []*Interaction {
+		req := new(dns.Msg)
+		req.SetQuestion(name, dns.TypeA)
+		dnsServer.ServeDNS(&testResponseWriter{}, req)
+		return interactions()
+	}
+
+	// exact matches are not flagged
+	recorded := query("abcdefghijklm.example.com.")
+	require.Len(t, recorded, 1)
+	require.False(t, recorded[0].FuzzyMatched)
+	require.Equal(t, "abcdefghijklm", recorded[0].UniqueID)
+
+	// a dropped character is matched to the closest registered id
+	recorded = query("data.abcdfghijklm.example.com.")
+	require.Len(t, recorded, 1)
+	require.True(t, recorded[0].FuzzyMatched)
+	require.Equal(t, "abcdefghij", recorded[0].CorrelationID)
+	require.Equal(t, "abcdfghijklm", recorded[0].UniqueID)
+	require.Equal(t, "data.abcdfghijklm", recorded[0].FullId)
+
+	// ids too distant from the registered ones are not matched
+	require.Empty(t, query("abxyzfghijklm.example.com."))
+
+	// without a distance corrupted ids are never matched
+	opts.FuzzyCorrelationDistance = 0
+	require.Empty(t, query("abcdfghijklm.example.com."))
+
+	// http requests are matched the same way
+	opts.FuzzyCorrelationDistance = 1
+	h := &HTTPServer{options: opts}
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Host = "abcdefghixklm.example.com"
+	h.logger(http.HandlerFunc(h.defaultHandler)).ServeHTTP(httptest.NewRecorder(), req)
+	recorded = interactions()
+	require.Len(t, recorded, 1)
+	require.True(t, recorded[0].FuzzyMatched)
+	require.Equal(t, "abcdefghij", recorded[0].CorrelationID)
+}
@@ -1,24 +1,36 @@
 package server
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"crypto/tls"
 	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"math"
+	"math/rand"
+	"mime"
 	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/http/httputil"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/goburrow/cache"
 	jsoniter "github.com/json-iterator/go"
 	"github.com/projectdiscovery/gologger"
+	"github.com/projectdiscovery/interactsh/pkg/server/acme"
 	stringsutil "github.com/projectdiscovery/utils/strings"
 )
 
@@ -28,9 +40,59 @@ type HTTPServer struct {
 	options         *Options
 	tlsserver       http.Server
 	nontlsserver    http.Server
+	adminserver     *http.Server
+	systemMetrics   *systemMetricsCache
+	dohServer       *DNSServer
 	customBanner    string
 	defaultResponse string
 	staticHandler   http.Handler
+	ocspStaple      []byte
+
+	dynMu                   sync.RWMutex
+	dynamicEndpoints        map[string]dynamicEndpoint
+	dynamicEndpointsModTime time.Time
+
+	pollMu      sync.Mutex
+	activePolls map[string]int
+
+	originsOnce sync.Once
+	origins     []*regexp.Regexp
+
+	pollRateMu        sync.Mutex
+	pollBuckets       map[string]*pollBucket
+	pollBucketsPruned time.Time
+
+	registerMu      sync.Mutex
+	registrations   map[string]*registrationWindow
+	registersPruned time.Time
+
+	deregisterOnce sync.Once
+	deregisterMu   sync.Mutex
+	deregistered   map[string]pendingDeregistration
+
+	// accessLog records every request served by the router when AccessLogPath is set
+	accessLog *accessLog
+
+	// replayRequests holds the raw requests of the http interactions by id for /admin/replay
+	replayRequests cache.Cache
+
+	serverHeaderIndex uint64
+}
+
+// pendingDeregistration is a deregistered session kept for the grace period
+type pendingDeregistration struct {
+	secretKey string
+	deadline  time.Time
+}
+
+// dynamicEndpoint is a response registered through /storerequest
+// or preloaded from a file and served under /apidocs/.
+type dynamicEndpoint struct {
+	Body        []byte
+	ContentType string
+	LastUpdated time.Time
+
+	fromFile bool
 }
 
 type noopLogger struct {
@@ -53,7 +115,7 @@ func disableDirectoryListing(next http.Handler) http.Handler {
 
 // NewHTTPServer returns a new TLS & Non-TLS HTTP server.
 func NewHTTPServer(options *Options) (*HTTPServer, error) {
-	server := &HTTPServer{options: options}
+	server := &HTTPServer{options: options, systemMetrics: newSystemMetricsCache(options.MetricsCacheTTL)}
 
 	// If a static directory is specified, also serve it.
 	if options.HTTPDirectory != "" {
@@ -80,31 +142,100 @@ func NewHTTPServer(options *Options) (*HTTPServer, error) {
 			server.defaultResponse = string(data)
 		}
 	}
+	// If an OCSP staple file is specified, read it and staple it on TLS handshakes.
+	if options.OCSPStapleFile != "" {
+		abs, _ := filepath.Abs(options.OCSPStapleFile)
+		gologger.Info().Msgf("Using OCSP staple file: %s", abs)
+		data, err := os.ReadFile(options.OCSPStapleFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read ocsp staple file: %w", err)
+		}
+		server.ocspStaple = data
+	}
+	server.replayRequests = newReplayCache(options)
+	if options.AccessLogPath != "" {
+		accessLog, err := newAccessLog(options.AccessLogPath)
+		if err != nil {
+			return nil, err
+		}
+		server.accessLog = accessLog
+	}
 	router := &http.ServeMux{}
 
 	server.dynamicEndpoints = make(map[string]dynamicEndpoint)
+	if options.DynamicEndpointsFile != "" {
+		abs, _ := filepath.Abs(options.DynamicEndpointsFile)
+		gologger.Info().Msgf("Loading dynamic endpoints from: %s", abs)
+		if err := server.reloadDynamicEndpoints(); err != nil {
+			return nil, fmt.Errorf("could not load dynamic endpoints file: %w", err)
+		}
+		if options.DynamicEndpointsReload > 0 {
+			go server.watchDynamicEndpoints(options.DynamicEndpointsReload)
+		}
+	}
+	if options.DynamicEndpointTTL > 0 {
+		go server.evictDynamicEndpoints(min(options.DynamicEndpointTTL, time.Minute))
+	}
 	router.Handle("/storerequest", server.corsMiddleware(server.authMiddleware(http.HandlerFunc(server.storeHandler))))
 	router.Handle("/apidocs/", server.corsMiddleware(http.HandlerFunc(server.apidocsHandler)))
+	// ACME HTTP-01 challenges are answered from the ACME store and are never scanned for correlation ids
+	router.Handle(acme.HTTPChallengePath, http.HandlerFunc(server.acmeChallengeHandler))
 	router.Handle("/", server.logger(server.corsMiddleware(http.HandlerFunc(server.defaultHandler))))
-	router.Handle("/register", server.corsMiddleware(server.authMiddleware(http.HandlerFunc(server.registerHandler))))
-	router.Handle("/serve/", server.corsMiddleware(server.authMiddleware(http.HandlerFunc(server.deregisterHandler))))
-	router.Handle("/deregister", server.corsMiddleware(server.authMiddleware(http.HandlerFunc(server.deregisterHandler))))
-	router.Handle("/poll", server.corsMiddleware(server.authMiddleware(http.HandlerFunc(server.pollHandler))))
+	router.Handle("/register", server.corsMiddleware(server.tlsMiddleware(server.authMiddleware(http.HandlerFunc(server.registerHandler)))))
+	router.Handle("/serve/", server.corsMiddleware(server.tlsMiddleware(server.authMiddleware(http.HandlerFunc(server.deregisterHandler)))))
+	router.Handle("/deregister", server.corsMiddleware(server.tlsMiddleware(server.authMiddleware(http.HandlerFunc(server.deregisterHandler)))))
+	router.Handle("/poll", server.corsMiddleware(server.tlsMiddleware(server.authMiddleware(server.compressMiddleware(http.HandlerFunc(server.pollHandler))))))
+	router.Handle("/events", server.corsMiddleware(server.tlsMiddleware(server.authMiddleware(http.HandlerFunc(server.eventsHandler)))))
+	router.Handle("/poll/ack", server.corsMiddleware(server.tlsMiddleware(server.authMiddleware(http.HandlerFunc(server.pollAckHandler)))))
+	router.Handle("/keys", server.corsMiddleware(server.tlsMiddleware(server.authMiddleware(http.HandlerFunc(server.publicKeyHandler)))))
+	if options.DoH {
+		server.dohServer = NewDNSServer("doh", options)
+		router.Handle(dohPath, server.corsMiddleware(http.HandlerFunc(server.dohHandler)))
+	}
+
+	// admin endpoints are moved to a dedicated listener when configured,
+	// so they are not reachable at all on the public ones
+	adminRouter := router
+	if options.AdminListenAddr != "" {
+		adminRouter = &http.ServeMux{}
+		server.adminserver = &http.Server{Addr: options.AdminListenAddr, Handler: adminRouter, ErrorLog: log.New(&noopLogger{}, "", 0)}
+	}
+	if server.options.AdminToken != "" {
+		adminRouter.Handle("/admin/correlation/", server.corsMiddleware(server.adminMiddleware(http.HandlerFunc(server.purgeHandler))))
+		adminRouter.Handle("/admin/campaign/", server.corsMiddleware(server.adminMiddleware(http.HandlerFunc(server.campaignHandler))))
+	}
+	if server.options.Auth && server.options.Token != "" {
+		adminRouter.Handle("/admin/flush", server.corsMiddleware(server.serverTokenMiddleware(http.HandlerFunc(server.flushHandler))))
+	}
+	if server.options.AdminToken != "" && server.replayRequests != nil {
+		adminRouter.Handle("/admin/replay", server.corsMiddleware(server.adminMiddleware(http.HandlerFunc(server.replayHandler))))
+	}
+	if server.options.AdminToken != "" && server.options.CertificateStore != nil {
+		adminRouter.Handle("/admin/certificates/reload", server.corsMiddleware(server.adminMiddleware(http.HandlerFunc(server.certificateReloadHandler))))
+	}
 	if server.options.EnableMetrics {
-		router.Handle("/metrics", server.corsMiddleware(server.authMiddleware(http.HandlerFunc(server.metricsHandler))))
+		adminRouter.Handle("/metrics", server.corsMiddleware(server.authMiddleware(server.compressMiddleware(http.HandlerFunc(server.metricsHandler)))))
 	}
-	server.tlsserver = http.Server{Addr: formatAddress(options.ListenIP, options.HttpsPort), Handler: router, ErrorLog: log.New(&noopLogger{}, "", 0)}
-	server.nontlsserver = http.Server{Addr: formatAddress(options.ListenIP, options.HttpPort), Handler: router, ErrorLog: log.New(&noopLogger{}, "", 0)}
+	handler := server.accessLogMiddleware(server.connectMiddleware(router))
+	server.tlsserver = http.Server{Addr: formatAddress(options.ListenIP, options.HttpsPort), Handler: handler, ConnContext: server.rttConnContext, ErrorLog: log.New(&noopLogger{}, "", 0)}
+	server.nontlsserver = http.Server{Addr: formatAddress(options.ListenIP, options.HttpPort), Handler: handler, ConnContext: server.rttConnContext, ErrorLog: log.New(&noopLogger{}, "", 0)}
 	return server, nil
 }
 
 // ListenAndServe listens on http and/or https ports for the server.
 func (h *HTTPServer) ListenAndServe(tlsConfig *tls.Config, httpAlive, httpsAlive chan bool) {
+	if h.adminserver != nil {
+		go func() {
+			if err := h.adminserver.ListenAndServe(); err != nil {
+				gologger.Error().Msgf("Could not serve admin http: %s\n", err)
+			}
+		}()
+	}
 	go func() {
 		if tlsConfig == nil {
 			return
 		}
-		h.tlsserver.TLSConfig = tlsConfig
+		h.tlsserver.TLSConfig = h.applyTLSOptions(tlsConfig)
 
 		httpsAlive <- true
 		if err := h.tlsserver.ListenAndServeTLS("", ""); err != nil {
@@ -114,31 +245,117 @@ func (h *HTTPServer) ListenAndServe(tlsConfig *tls.Config, httpAlive, httpsAlive
 	}()
 
 	httpAlive <- true
-	if err := h.nontlsserver.ListenAndServe(); err != nil {
+	if err := h.listenAndServePlain(); err != nil {
 		httpAlive <- false
 		gologger.Error().Msgf("Could not serve http: %s\n", err)
 	}
 }
 
+// listenAndServePlain serves the plain http server, detecting the other protocols sent to it when enabled
+func (h *HTTPServer) listenAndServePlain() error {
+	if !h.options.DetectProtocolMismatch {
+		return h.nontlsserver.ListenAndServe()
+	}
+	ln, err := net.Listen("tcp", h.nontlsserver.Addr)
+	if err != nil {
+		return err
+	}
+	return h.nontlsserver.Serve(&mismatchListener{Listener: ln, options: h.options, expected: "http"})
+}
+
+// applyTLSOptions returns a copy of tlsConfig with the session ticket, client certificate,
+// OCSP stapling, invalid certificate and TLS-ALPN-01 challenge options of the server applied.
+func (h *HTTPServer) applyTLSOptions(tlsConfig *tls.Config) *tls.Config {
+	config := tlsConfig.Clone()
+	config.SessionTicketsDisabled = h.options.TLSSessionTicketsDisabled
+	// client certificates are requested without being verified, so that
+	// the self-signed ones are recorded as well
+	if h.options.CaptureClientCert {
+		config.ClientAuth = tls.RequestClientCert
+	}
+	if len(h.ocspStaple) > 0 {
+		certificates := make([]tls.Certificate, len(config.Certificates))
+		for i, certificate := range config.Certificates {
+			certificate.OCSPStaple = h.ocspStaple
+			certificates[i] = certificate
+		}
+		config.Certificates = certificates
+		// certificates served dynamically are stapled on each handshake
+		if getCertificate := config.GetCertificate; getCertificate != nil {
+			config.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+				certificate, err := getCertificate(hello)
+				if err != nil || certificate == nil {
+					return certificate, err
+				}
+				stapled := *certificate
+				stapled.OCSPStaple = h.ocspStaple
+				return &stapled, nil
+			}
+		}
+	}
+	if len(h.options.InvalidCertSNIs) > 0 {
+		invalidCertificate, err := newInvalidCertificate()
+		if err != nil {
+			gologger.Warning().Msgf("Could not generate invalid certificate: %s\n", err)
+		} else {
+			getCertificate := config.GetCertificate
+			config.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+				if h.options.isInvalidCertSNI(hello.ServerName) {
+					h.recordInvalidCertHandshake(hello)
+					return invalidCertificate, nil
+				}
+				if getCertificate != nil {
+					return getCertificate(hello)
+				}
+				return nil, nil
+			}
+		}
+	}
+	if h.options.TLSALPNChallenge && h.options.ACMEStore != nil {
+		config.NextProtos = append(config.NextProtos, acme.TLSALPNProtocol)
+		getCertificate := config.GetCertificate
+		config.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			// validation handshakes only offer the acme-tls/1 protocol
+			if len(hello.SupportedProtos) == 1 && hello.SupportedProtos[0] == acme.TLSALPNProtocol {
+				certificate, ok := h.options.ACMEStore.GetTLSALPNChallenge(hello.ServerName)
+				if !ok {
+					return nil, fmt.Errorf("no tls-alpn-01 challenge for %s", hello.ServerName)
+				}
+				gologger.Debug().Msgf("Serving acme tls-alpn challenge for %s\n", hello.ServerName)
+				return certificate, nil
+			}
+			if getCertificate != nil {
+				return getCertificate(hello)
+			}
+			return nil, nil
+		}
+	}
+	return config
+}
+
 func (h *HTTPServer) logger(handler http.Handler) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		req, _ := httputil.DumpRequest(r, true)
-		reqString := string(req)
+		receivedAt := time.Now()
+		body := &timedReader{ReadCloser: r.Body}
+		if r.Body != nil {
+			r.Body = body
+		}
+		requestURL := r.URL.String()
+		overLength := h.options.MaxURLLength > 0 && len(requestURL) > h.options.MaxURLLength
+
+		var reqString string
+		if overLength {
+			// only the truncated request line is recorded for over-length urls
+			requestURL = requestURL[:h.options.MaxURLLength]
+			reqString = fmt.Sprintf("%s %s %s\r\nHost: %s\r\n\r\n", r.Method, requestURL, r.Proto, r.Host)
+		} else {
+			req, _ := httputil.DumpRequest(r, true)
+			reqString = string(req)
+		}
 
 		gologger.Debug().Msgf("New HTTP request: \n\n%s\n", reqString)
-		rec := httptest.NewRecorder()
-		handler.ServeHTTP(rec, r)
 
-		resp, _ := httputil.DumpResponse(rec.Result(), true)
-		respString := string(resp)
-
-		for k, v := range rec.Header() {
-			w.Header()[k] = v
-		}
-		data := rec.Body.Bytes()
-
-		w.WriteHeader(rec.Result().StatusCode)
-		_, _ = w.Write(data)
+		matches := h.correlationMatches(r, reqString, requestURL)
 
 		var host string
 		// Check if the client's ip should be taken from a custom header (eg reverse proxy)
@@ -147,6 +364,45 @@ func (h *HTTPServer) logger(handler http.Handler) http.HandlerFunc {
 		} else {
 			host, _, _ = net.SplitHostPort(r.RemoteAddr)
 		}
+		h.logAccessCorrelation(r, matches)
+
+		if h.options.RequireCorrelationID && len(matches) == 0 {
+			if h.options.LogUnmatched {
+				gologger.Info().Msgf("Rejected HTTP request without correlation id from %s: %s %s%s\n", r.RemoteAddr, r.Method, r.Host, requestURL)
+			}
+			h.rejectUnmatched(w)
+			return
+		}
+
+		var (
+			respString string
+			websocket  *websocketSession
+		)
+		// websocket upgrades are completed only when capturing frames,
+		// otherwise the attempt is recorded with the default response
+		if !overLength && h.options.CaptureWebSocket && isWebSocketUpgrade(r) {
+			respString, websocket = serveWebSocket(w, r)
+		}
+		// streamed responses are written directly to the client as they can't be recorded
+		if respString == "" && !overLength && h.isStreamRequest(r) {
+			respString = h.serveStream(w, r)
+		}
+		if respString == "" {
+			rec := httptest.NewRecorder()
+			if overLength {
+				http.Error(rec, "request url too long", http.StatusRequestURITooLong)
+			} else {
+				handler.ServeHTTP(rec, r)
+			}
+
+			respString = writeRecordedResponse(w, r, rec)
+		}
+
+		if !h.options.recordsMethod(r.Method) {
+			atomic.AddUint64(&h.options.Stats.HttpSkipped, 1)
+			gologger.Debug().Msgf("Skipped recording HTTP %s request from %s\n", r.Method, r.RemoteAddr)
+			return
+		}
 
 		// if root-tld is enabled stores any interaction towards the main domain
 		if h.options.RootTLD {
@@ -160,15 +416,19 @@ func (h *HTTPServer) logger(handler http.Handler) http.HandlerFunc {
 						FullId:        r.Host,
 						RawRequest:    reqString,
 						RawResponse:   respString,
+						QueryParams:   h.queryParams(r),
+						SNI:           tlsServerName(r),
 						RemoteAddress: host,
+						ReceivedAt:    receivedAt,
+						RequestReadMs: body.elapsed.Milliseconds(),
 						Timestamp:     time.Now(),
 					}
-					data, err := jsoniter.Marshal(interaction)
+					data, err := h.options.encodeInteraction(interaction)
 					if err != nil {
 						gologger.Warning().Msgf("Could not encode root tld http interaction: %s\n", err)
 					} else {
 						gologger.Debug().Msgf("Root TLD HTTP Interaction: \n%s\n", string(data))
-						if err := h.options.Storage.AddInteractionWithId(ID, data); err != nil {
+						if err := h.options.storeInteractionWithId(ID, interaction, data); err != nil {
 							gologger.Warning().Msgf("Could not store root tld http interaction: %s\n", err)
 						}
 					}
@@ -176,20 +436,118 @@ func (h *HTTPServer) logger(handler http.Handler) http.HandlerFunc {
 			}
 		}
 
-		if h.options.ScanEverywhere {
-			chunks := stringsutil.SplitAny(reqString, "\n\t\"'/")
-			for _, chunk := range chunks {
-				for part := range stringsutil.SlideWithLength(chunk, h.options.GetIdLength()) {
-					normalizedPart := strings.ToLower(part)
-					if h.options.isCorrelationID(normalizedPart) {
-						fullID := chunk
-						h.handleInteraction(r, normalizedPart, fullID, reqString, respString, host)
-					}
+		for _, match := range matches {
+			h.handleInteraction(r, match, reqString, respString, host, websocket, receivedAt, body.elapsed)
+		}
+	}
+}
+
+// recordsMethod returns true if the requests of the http method are recorded as interactions
+func (options *Options) recordsMethod(method string) bool {
+	if len(options.RecordMethods) == 0 {
+		return true
+	}
+	for _, recorded := range options.RecordMethods {
+		if strings.EqualFold(recorded, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// timedReader accumulates the time spent reading the request body
+type timedReader struct {
+	io.ReadCloser
+	elapsed time.Duration
+}
+
+func (r *timedReader) Read(p []byte) (int, error) {
+	start := time.Now()
+	n, err := r.ReadCloser.Read(p)
+	r.elapsed += time.Since(start)
+	return n, err
+}
+
+// writeRecordedResponse writes the recorded response to the client and returns
+// its dump. Headers otherwise added by net/http (Date, Content-Length and
+// Content-Type) are set explicitly and the dump is written the way net/http
+// serializes responses, so that it matches the bytes received by the client.
+func writeRecordedResponse(w http.ResponseWriter, r *http.Request, rec *httptest.ResponseRecorder) string {
+	resp := rec.Result()
+	body := rec.Body.Bytes()
+
+	// the live header map is used as some handlers set headers after writing the body
+	header := rec.Header().Clone()
+	if header.Get("Date") == "" {
+		header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	}
+	bodyAllowed := resp.StatusCode >= 200 && resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusNotModified
+	if bodyAllowed && header.Get("Transfer-Encoding") == "" {
+		if header.Get("Content-Length") == "" {
+			header.Set("Content-Length", strconv.Itoa(len(body)))
+		}
+		if _, ok := header["Content-Type"]; !ok && len(body) > 0 {
+			header.Set("Content-Type", http.DetectContentType(body))
+		}
+	}
+	for k, v := range header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(resp.StatusCode)
+	if r.Method != http.MethodHead {
+		_, _ = w.Write(body)
+	}
+
+	proto := "HTTP/1.1"
+	if !r.ProtoAtLeast(1, 1) {
+		proto = "HTTP/1.0"
+	}
+	var dump bytes.Buffer
+	_, _ = fmt.Fprintf(&dump, "%s %s\r\n", proto, resp.Status)
+	_ = header.Write(&dump)
+	dump.WriteString("\r\n")
+	if r.Method != http.MethodHead {
+		dump.Write(body)
+	}
+	return dump.String()
+}
+
+// correlationMatch is a correlation id found in a request
+type correlationMatch struct {
+	uniqueID string
+	fullID   string
+	// correlationID is the registered correlation id of fuzzy matches
+	correlationID string
+	fuzzy         bool
+}
+
+// correlationMatches returns the correlation ids found in the request
+func (h *HTTPServer) correlationMatches(r *http.Request, reqString, requestURL string) []correlationMatch {
+	var matches []correlationMatch
+	if h.options.ScanEverywhere {
+		chunks := stringsutil.SplitAny(reqString, "\n\t\"'/")
+		for _, chunk := range chunks {
+			for part := range stringsutil.SlideWithLength(chunk, h.options.GetIdLength()) {
+				normalizedPart := strings.ToLower(part)
+				if h.options.isCorrelationID(normalizedPart) {
+					fullID := chunk
+					matches = append(matches, correlationMatch{uniqueID: normalizedPart, fullID: fullID})
 				}
 			}
+		}
+	} else {
+		// requests without a host header (eg. HTTP/1.0) are only scanned by path
+		url := r.Host + requestURL
+		// CONNECT requests only carry the authority
+		if r.Method == http.MethodConnect {
+			url = r.Host
+		}
+		gologger.Debug().Msgf("Scanning in url %s, host %s, urlhost: %s, path %s\n", url, r.Host, r.URL.Host, r.URL.Path)
+		if h.options.CorrelationPosition == CorrelationPositionLeftmost {
+			if uniqueID, fullID, ok := h.options.leftmostCorrelationID(url, ".\n\t/"); ok {
+				matches = append(matches, correlationMatch{uniqueID: uniqueID, fullID: fullID})
+			}
 		} else {
-			url := r.Host + r.URL.String()
-			gologger.Debug().Msgf("Scanning in url %s, host %s, urlhost: %s, path %s\n", url, r.Host, r.URL.Host, r.URL.Path)
 			parts := stringsutil.SplitAny(url, ".\n\t/")
 			for i, part := range parts {
 				for partChunk := range stringsutil.SlideWithLength(part, h.options.GetIdLength()) {
@@ -199,12 +557,55 @@ func (h *HTTPServer) logger(handler http.Handler) http.HandlerFunc {
 						if i+1 <= len(parts) {
 							fullID = strings.Join(parts[:i+1], ".")
 						}
-						h.handleInteraction(r, normalizedPartChunk, fullID, reqString, respString, host)
+						matches = append(matches, correlationMatch{uniqueID: normalizedPartChunk, fullID: fullID})
 					}
 				}
 			}
 		}
+		// cookies are already covered when scanning everywhere
+		for _, name := range h.options.ScanCookies {
+			cookie, err := r.Cookie(name)
+			if err != nil {
+				continue
+			}
+			for part := range stringsutil.SlideWithLength(cookie.Value, h.options.GetIdLength()) {
+				normalizedPart := strings.ToLower(part)
+				if h.options.isCorrelationID(normalizedPart) {
+					matches = append(matches, correlationMatch{uniqueID: normalizedPart, fullID: cookie.Value})
+				}
+			}
+		}
+		// form fields are a lighter alternative to scanning the whole body
+		if h.options.ScanFormFields {
+			for _, value := range formFieldValues(r) {
+				for part := range stringsutil.SlideWithLength(value, h.options.GetIdLength()) {
+					normalizedPart := strings.ToLower(part)
+					if h.options.isCorrelationID(normalizedPart) {
+						matches = append(matches, correlationMatch{uniqueID: normalizedPart, fullID: value})
+					}
+				}
+			}
+		}
+	}
+	if h.options.FuzzyCorrelationDistance > 0 && !h.hasRegisteredMatch(matches) {
+		// correlation ids corrupted on the way are matched to the closest registered one
+		parts := stringsutil.SplitAny(r.Host+requestURL, ".\n\t/")
+		if correlationID, i, ok := h.options.fuzzyCorrelationMatch(parts); ok {
+			fullID := strings.Join(parts[:i+1], ".")
+			matches = append(matches, correlationMatch{uniqueID: strings.ToLower(parts[i]), fullID: fullID, correlationID: correlationID, fuzzy: true})
+		}
 	}
+	return matches
+}
+
+// hasRegisteredMatch returns true if the correlation id of one of the matches is registered
+func (h *HTTPServer) hasRegisteredMatch(matches []correlationMatch) bool {
+	for _, match := range matches {
+		if h.options.isRegistered(match.uniqueID[:h.options.CorrelationIdLength]) {
+			return true
+		}
+	}
+	return false
 }
 
 func httpProtocol(r *http.Request) string {
@@ -214,25 +615,161 @@ func httpProtocol(r *http.Request) string {
 	return "http"
 }
 
-func (h *HTTPServer) handleInteraction(r *http.Request, uniqueID, fullID, reqString, respString, hostPort string) {
-	correlationID := uniqueID[:h.options.CorrelationIdLength]
+// formMaxMemory is the maximum size of multipart form fields kept in memory
+const formMaxMemory = 1 << 20
+
+// formFieldValues returns the field values of urlencoded and multipart form bodies.
+// The request body is left unconsumed.
+func formFieldValues(r *http.Request) []string {
+	if r.Body == nil {
+		return nil
+	}
+	body, err := io.ReadAll(r.Body)
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil || len(body) == 0 {
+		return nil
+	}
+
+	form := r.Clone(r.Context())
+	form.Body = io.NopCloser(bytes.NewReader(body))
+	form.Form, form.PostForm, form.MultipartForm = nil, nil, nil
+
+	var fields map[string][]string
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/x-www-form-urlencoded":
+		if err := form.ParseForm(); err != nil {
+			return nil
+		}
+		fields = form.PostForm
+	case "multipart/form-data":
+		if err := form.ParseMultipartForm(formMaxMemory); err != nil {
+			return nil
+		}
+		defer func() {
+			_ = form.MultipartForm.RemoveAll()
+		}()
+		fields = form.MultipartForm.Value
+	}
+
+	var values []string
+	for _, fieldValues := range fields {
+		values = append(values, fieldValues...)
+	}
+	return values
+}
+
+// rejectUnmatched answers requests without correlation id with the configured
+// unmatched response, closing the connection when it's empty
+func (h *HTTPServer) rejectUnmatched(w http.ResponseWriter) {
+	if h.options.UnmatchedResponse == "" {
+		if hijacker, ok := w.(http.Hijacker); ok {
+			if conn, _, err := hijacker.Hijack(); err == nil {
+				_ = conn.Close()
+				return
+			}
+		}
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusNotFound)
+	_, _ = fmt.Fprint(w, h.options.UnmatchedResponse)
+}
+
+// queryParams returns the query parameters of the request, omitted
+// for over-length urls as only their truncated request line is recorded
+func (h *HTTPServer) queryParams(r *http.Request) map[string][]string {
+	if r.URL.RawQuery == "" || (h.options.MaxURLLength > 0 && len(r.URL.String()) > h.options.MaxURLLength) {
+		return nil
+	}
+	return r.URL.Query()
+}
+
+// tlsServerName returns the SNI sent by the client for TLS requests
+func tlsServerName(r *http.Request) string {
+	if r.TLS == nil {
+		return ""
+	}
+	return r.TLS.ServerName
+}
+
+// tlsClientCertificate returns the subject and the sha256 fingerprint of
+// the certificate presented by the client for TLS requests
+func tlsClientCertificate(r *http.Request) (string, string) {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return "", ""
+	}
+	certificate := r.TLS.PeerCertificates[0]
+	fingerprint := sha256.Sum256(certificate.Raw)
+	return certificate.Subject.String(), hex.EncodeToString(fingerprint[:])
+}
+
+func (h *HTTPServer) handleInteraction(r *http.Request, match correlationMatch, reqString, respString, hostPort string, websocket *websocketSession, receivedAt time.Time, requestRead time.Duration) {
+	uniqueID := match.uniqueID
+	correlationID := match.correlationID
+	if correlationID == "" {
+		correlationID = uniqueID[:h.options.CorrelationIdLength]
+	}
+	protocol := httpProtocol(r)
 
 	interaction := &Interaction{
-		Protocol:      httpProtocol(r),
+		Protocol:      protocol,
 		UniqueID:      uniqueID,
-		FullId:        fullID,
+		FullId:        match.fullID,
+		CorrelationID: correlationID,
+		FuzzyMatched:  match.fuzzy,
+		Fingerprint:   interactionFingerprint(protocol, hostPort, r.URL.Path, ""),
 		RawRequest:    reqString,
 		RawResponse:   respString,
+		QueryParams:   h.queryParams(r),
+		SNI:           tlsServerName(r),
+		NoHost:        r.Host == "",
+		WebSocket:     isWebSocketUpgrade(r),
 		RemoteAddress: hostPort,
+		ReceivedAt:    receivedAt,
+		RequestReadMs: requestRead.Milliseconds(),
+		RTTMs:         h.requestRTT(r.Context()),
 		Timestamp:     time.Now(),
 	}
-	data, err := jsoniter.Marshal(interaction)
+	// completed upgrades are recorded as websocket interactions along with the captured frames
+	if websocket != nil {
+		interaction.Protocol = "websocket"
+		interaction.WebSocketData = websocket.frames
+	}
+	if h.options.DecodeExfil {
+		interaction.DecodedData = decodeExfil(r.Host, uniqueID)
+	}
+	if h.options.ClassifyInteractions {
+		interaction.Likely = classifyHTTPRequest(r, uniqueID)
+	}
+	if h.options.DecodeJWT {
+		if token := bearerToken(r); token != "" {
+			if claims := decodeJWT(token); claims != nil {
+				interaction.JWTClaims = claims
+				interaction.RawRequest = redactJWTSignature(interaction.RawRequest, token)
+			}
+		}
+	}
+	interaction.ClientCertSubject, interaction.ClientCertFingerprint = tlsClientCertificate(r)
+	if deprecated := h.options.deprecatedDomain(r.Host); deprecated != "" {
+		interaction.Deprecated = true
+		if h.options.WarnDeprecatedDomains {
+			gologger.Warning().Msgf("HTTP interaction for %s received on deprecated domain %s\n", correlationID, deprecated)
+		}
+	}
+	if h.options.isDuplicate(interaction) {
+		gologger.Debug().Msgf("Dropped duplicate http interaction for %s\n", correlationID)
+		return
+	}
+	h.keepForReplay(interaction)
+	h.options.tagInteraction(interaction)
+	data, err := h.options.encodeInteraction(interaction)
 	if err != nil {
 		gologger.Warning().Msgf("Could not encode http interaction: %s\n", err)
 	} else {
 		gologger.Debug().Msgf("HTTP Interaction: \n%s\n", string(data))
 
-		if err := h.options.Storage.AddInteraction(correlationID, data); err != nil {
+		h.options.publishInteraction(interaction, data)
+		if err := h.options.storeInteraction(correlationID, data); err != nil {
 			gologger.Warning().Msgf("Could not store http interaction: %s\n", err)
 		}
 	}
@@ -247,6 +784,34 @@ If you notice any interactions from <b>*.%s</b> in your logs, it's possible that
 You should investigate the sites where these interactions were generated from, and if a vulnerability exists, examine the root cause and take the necessary steps to mitigate the issue.
 `
 
+const sitemap = `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+<url><loc>https://%s/</loc></url>
+<!-- %s -->
+</urlset>
+`
+
+const (
+	// ServerHeaderStrategyRoundRobin answers each request with the next value of the server header pool
+	ServerHeaderStrategyRoundRobin = "roundrobin"
+	// ServerHeaderStrategyRandom answers each request with a random value of the server header pool
+	ServerHeaderStrategyRandom = "random"
+)
+
+// serverHeader returns the Server header of a response, selected from the
+// server header pool with the configured strategy, otherwise the domain
+func (h *HTTPServer) serverHeader(domain string) string {
+	pool := h.options.ServerHeaderPool
+	if len(pool) == 0 {
+		return domain
+	}
+	if h.options.ServerHeaderStrategy == ServerHeaderStrategyRandom {
+		return pool[rand.Intn(len(pool))]
+	}
+	index := atomic.AddUint64(&h.serverHeaderIndex, 1) - 1
+	return pool[index%uint64(len(pool))]
+}
+
 func extractServerDomain(h *HTTPServer, req *http.Request) string {
 	if h.options.HeaderServer != "" {
 		return h.options.HeaderServer
@@ -275,10 +840,27 @@ func (h *HTTPServer) defaultHandler(w http.ResponseWriter, req *http.Request) {
 	atomic.AddUint64(&h.options.Stats.Http, 1)
 
 	domain := extractServerDomain(h, req)
-	w.Header().Set("Server", domain)
+	w.Header().Set("Server", h.serverHeader(domain))
 	if !h.options.NoVersionHeader {
 		w.Header().Set("X-Interactsh-Version", h.options.Version)
 	}
+	if deprecated := h.options.deprecatedDomain(req.Host); deprecated != "" && h.options.WarnDeprecatedDomains {
+		w.Header().Set("Warning", fmt.Sprintf(`299 - "domain %s is deprecated"`, deprecated))
+	}
+	for header, value := range h.options.DefaultCacheHeaders {
+		w.Header().Set(header, value)
+	}
+
+	switch req.Method {
+	case http.MethodTrace:
+		h.traceHandler(w, req)
+		return
+	case http.MethodConnect:
+		// tunnels are never established, the attempt is only recorded
+		w.Header().Set("Connection", "close")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
 
 	reflection := h.options.URLReflection(req.Host)
 
@@ -287,6 +869,10 @@ func (h *HTTPServer) defaultHandler(w http.ResponseWriter, req *http.Request) {
 		_, _ = fmt.Fprint(w, strings.ReplaceAll(h.defaultResponse, "{DOMAIN}", domain))
 		return
 	}
+	if response := h.options.userAgentResponse(req.UserAgent()); response != nil {
+		response.write(w, domain)
+		return
+	}
 
 	if stringsutil.HasPrefixI(req.URL.Path, "/s/") && h.staticHandler != nil {
 		if h.options.DynamicResp && len(req.URL.Query()) > 0 {
@@ -300,7 +886,7 @@ func (h *HTTPServer) defaultHandler(w http.ResponseWriter, req *http.Request) {
 			}
 			if delay := values.Get("delay"); delay != "" {
 				if parsed, err := strconv.Atoi(delay); err == nil {
-					time.Sleep(time.Duration(parsed) * time.Second)
+					time.Sleep(responseDelay(parsed, h.options.MaxResponseDelay))
 				}
 			}
 			if status := values.Get("status"); status != "" {
@@ -318,6 +904,13 @@ func (h *HTTPServer) defaultHandler(w http.ResponseWriter, req *http.Request) {
 		}
 	} else if strings.EqualFold(req.URL.Path, "/robots.txt") {
 		_, _ = fmt.Fprintf(w, "User-agent: *\nDisallow: / # %s", reflection)
+	} else if strings.EqualFold(req.URL.Path, "/sitemap.xml") {
+		w.Header().Set("Content-Type", "application/xml")
+		if h.options.SitemapXML != "" {
+			_, _ = fmt.Fprint(w, strings.NewReplacer("{DOMAIN}", domain, "{REFLECTION}", reflection).Replace(h.options.SitemapXML))
+		} else {
+			_, _ = fmt.Fprintf(w, sitemap, domain, reflection)
+		}
 	} else if stringsutil.HasSuffixI(req.URL.Path, ".json") {
 		_, _ = fmt.Fprintf(w, "{\"data\":\"%s\"}", reflection)
 		w.Header().Set("Content-Type", "application/json")
@@ -326,13 +919,23 @@ func (h *HTTPServer) defaultHandler(w http.ResponseWriter, req *http.Request) {
 		w.Header().Set("Content-Type", "application/xml")
 	} else {
 		if h.options.DynamicResp && (len(req.URL.Query()) > 0 || stringsutil.HasPrefixI(req.URL.Path, "/b64_body:")) {
-			writeResponseFromDynamicRequest(w, req)
+			writeResponseFromDynamicRequest(w, req, h.options.MaxDynamicHeaders, h.options.MaxResponseDelay)
 			return
 		}
 		_, _ = fmt.Fprintf(w, "<html><head></head><body>%s</body></html>", reflection)
 	}
 }
 
+// responseDelay returns the delay for a requested number of seconds clamped to maxDelay (0 for no limit)
+func responseDelay(seconds int, maxDelay time.Duration) time.Duration {
+	delay := time.Duration(seconds) * time.Second
+	if maxDelay > 0 && delay > maxDelay {
+		gologger.Debug().Msgf("Clamping response delay of %s to %s\n", delay, maxDelay)
+		return maxDelay
+	}
+	return delay
+}
+
 // writeResponseFromDynamicRequest writes a response to http.ResponseWriter
 // based on dynamic data from HTTP URL Query parameters.
 //
@@ -342,7 +945,13 @@ func (h *HTTPServer) defaultHandler(w http.ResponseWriter, req *http.Request) {
 //	header (response header)
 //	status (response status code)
 //	delay (response time)
-func writeResponseFromDynamicRequest(w http.ResponseWriter, req *http.Request) {
+//	cache_control (response Cache-Control header)
+//	etag (response ETag header)
+//	expires (response Expires header)
+//
+// The stream_bytes and stream parameters are served by serveStream.
+// At most maxHeaders header params are applied (0 for no limit).
+func writeResponseFromDynamicRequest(w http.ResponseWriter, req *http.Request, maxHeaders int, maxDelay time.Duration) {
 	values := req.URL.Query()
 
 	if stringsutil.HasPrefixI(req.URL.Path, "/b64_body:") {
@@ -354,15 +963,30 @@ func writeResponseFromDynamicRequest(w http.ResponseWriter, req *http.Request) {
 
 	}
 	if headers := values["header"]; len(headers) > 0 {
+		applied := 0
 		for _, header := range headers {
 			if headerParts := strings.SplitN(header, ":", 2); len(headerParts) == 2 {
+				if maxHeaders > 0 && applied >= maxHeaders {
+					gologger.Debug().Msgf("Ignoring dynamic headers over the limit of %d\n", maxHeaders)
+					break
+				}
 				w.Header().Add(headerParts[0], headerParts[1])
+				applied++
 			}
 		}
 	}
+	if cacheControl := values.Get("cache_control"); cacheControl != "" {
+		w.Header().Set("Cache-Control", cacheControl)
+	}
+	if etag := values.Get("etag"); etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	if expires := values.Get("expires"); expires != "" {
+		w.Header().Set("Expires", expires)
+	}
 	if delay := values.Get("delay"); delay != "" {
 		parsed, _ := strconv.Atoi(delay)
-		time.Sleep(time.Duration(parsed) * time.Second)
+		time.Sleep(responseDelay(parsed, maxDelay))
 	}
 	if status := values.Get("status"); status != "" {
 		parsed, _ := strconv.Atoi(status)
@@ -386,10 +1010,41 @@ type RegisterRequest struct {
 	SecretKey string `json:"secret-key"`
 	// CorrelationID is an ID for correlation with requests.
 	CorrelationID string `json:"correlation-id"`
+	// RetentionSeconds is the maximum age of unpolled interactions (bounded by the server max retention)
+	RetentionSeconds int `json:"retention-seconds,omitempty"`
+	// CorrelationHMAC is the HMAC of the correlation ID under the server correlation salt
+	CorrelationHMAC string `json:"correlation-hmac,omitempty"`
+	// Campaign groups the correlation ID with the others registered under the same label
+	Campaign string `json:"campaign,omitempty"`
+	// Metadata is attached to the interactions of the correlation ID when the server enables it
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// traceHandler echoes the received request back when trace is enabled
+func (h *HTTPServer) traceHandler(w http.ResponseWriter, req *http.Request) {
+	if !h.options.EnableTrace {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	dump, err := httputil.DumpRequest(req, true)
+	if err != nil {
+		http.Error(w, "could not read request", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "message/http")
+	_, _ = w.Write(dump)
 }
 
 // registerHandler is a handler for client register requests
 func (h *HTTPServer) registerHandler(w http.ResponseWriter, req *http.Request) {
+	host, _, _ := net.SplitHostPort(req.RemoteAddr)
+	if !h.allowRegistration(host) {
+		gologger.Warning().Msgf("Throttled registration from %s\n", host)
+		w.Header().Set("Retry-After", strconv.Itoa(int(registrationWindowSize.Seconds())))
+		jsonError(w, "too many registrations", http.StatusTooManyRequests)
+		return
+	}
+
 	r := &RegisterRequest{}
 	if err := jsoniter.NewDecoder(req.Body).Decode(r); err != nil {
 		gologger.Warning().Msgf("Could not decode json body: %s\n", err)
@@ -397,13 +1052,38 @@ func (h *HTTPServer) registerHandler(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	// interactions are only stored for registered ids, so forged ids are never stored
+	if !h.options.validCorrelationHMAC(r.CorrelationID, r.CorrelationHMAC) {
+		gologger.Warning().Msgf("Rejected registration of %s with an invalid correlation hmac\n", r.CorrelationID)
+		jsonError(w, "invalid correlation hmac", http.StatusForbidden)
+		return
+	}
+
 	atomic.AddInt64(&h.options.Stats.Sessions, 1)
+	h.cancelDeregistration(r.CorrelationID)
 
 	if err := h.options.Storage.SetIDPublicKey(r.CorrelationID, r.SecretKey, r.PublicKey); err != nil {
 		gologger.Warning().Msgf("Could not set id and public key for %s: %s\n", r.CorrelationID, err)
 		jsonError(w, fmt.Sprintf("could not set id and public key: %s", err), http.StatusBadRequest)
 		return
 	}
+	if r.RetentionSeconds > 0 {
+		retention := time.Duration(r.RetentionSeconds) * time.Second
+		if h.options.MaxRetention > 0 && retention > h.options.MaxRetention {
+			retention = h.options.MaxRetention
+		}
+		if err := h.options.Storage.SetRetention(r.CorrelationID, retention); err != nil {
+			gologger.Warning().Msgf("Could not set retention for %s: %s\n", r.CorrelationID, err)
+		}
+	}
+	if r.Campaign != "" {
+		h.options.campaignTracker().register(r.Campaign, r.CorrelationID)
+	}
+	if h.options.SessionMetadata && len(r.Metadata) > 0 {
+		if err := h.options.Storage.SetMetadata(r.CorrelationID, r.Metadata); err != nil {
+			gologger.Warning().Msgf("Could not set metadata for %s: %s\n", r.CorrelationID, err)
+		}
+	}
 	jsonMsg(w, "registration successful", http.StatusOK)
 	gologger.Debug().Msgf("Registered correlationID %s for key\n", r.CorrelationID)
 }
@@ -427,21 +1107,105 @@ func (h *HTTPServer) deregisterHandler(w http.ResponseWriter, req *http.Request)
 		return
 	}
 
-	if err := h.options.Storage.RemoveID(r.CorrelationID, r.SecretKey); err != nil {
+	if h.options.DeregisterGracePeriod > 0 {
+		if err := h.scheduleDeregistration(r.CorrelationID, r.SecretKey); err != nil {
+			gologger.Warning().Msgf("Could not remove id for %s: %s\n", r.CorrelationID, err)
+			jsonError(w, fmt.Sprintf("could not remove id: %s", err), http.StatusBadRequest)
+			return
+		}
+		jsonMsg(w, "deregistration successful", http.StatusOK)
+		gologger.Debug().Msgf("Scheduled deregistration of correlationID %s in %s\n", r.CorrelationID, h.options.DeregisterGracePeriod)
+		return
+	}
+	if err := h.removeSession(r.CorrelationID, r.SecretKey); err != nil {
 		gologger.Warning().Msgf("Could not remove id for %s: %s\n", r.CorrelationID, err)
 		jsonError(w, fmt.Sprintf("could not remove id: %s", err), http.StatusBadRequest)
 		return
 	}
+	jsonMsg(w, "deregistration successful", http.StatusOK)
+	gologger.Debug().Msgf("Deregistered correlationID %s for key\n", r.CorrelationID)
+}
+
+// removeSession removes a correlation ID and its consumers from the storage
+func (h *HTTPServer) removeSession(correlationID, secretKey string) error {
+	if err := h.options.Storage.RemoveID(correlationID, secretKey); err != nil {
+		return err
+	}
+	h.removeConsumers(correlationID)
+	return nil
+}
+
+// removeConsumers removes the read offsets of a removed correlation ID in the
+// root tld and auth token interaction streams
+func (h *HTTPServer) removeConsumers(correlationID string) {
 	if h.options.RootTLD {
 		for _, domain := range h.options.Domains {
-			_ = h.options.Storage.RemoveConsumer(domain, r.CorrelationID)
+			_ = h.options.Storage.RemoveConsumer(domain, correlationID)
 		}
 	}
 	if h.options.Token != "" {
-		_ = h.options.Storage.RemoveConsumer(h.options.Token, r.CorrelationID)
+		_ = h.options.Storage.RemoveConsumer(h.options.Token, correlationID)
+	}
+}
+
+// scheduleDeregistration marks a session for removal once the grace period
+// elapses, so that in-flight polls can still drain its interactions.
+func (h *HTTPServer) scheduleDeregistration(correlationID, secretKey string) error {
+	item, err := h.options.Storage.GetCacheItem(correlationID)
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(item.SecretKey, secretKey) {
+		return errors.New("invalid secret key passed for deregister")
+	}
+
+	h.deregisterOnce.Do(func() {
+		go h.sweepDeregistrations()
+	})
+	h.deregisterMu.Lock()
+	if h.deregistered == nil {
+		h.deregistered = make(map[string]pendingDeregistration)
+	}
+	h.deregistered[correlationID] = pendingDeregistration{secretKey: secretKey, deadline: time.Now().Add(h.options.DeregisterGracePeriod)}
+	h.deregisterMu.Unlock()
+	return nil
+}
+
+// cancelDeregistration keeps a session scheduled for removal when it registers again
+func (h *HTTPServer) cancelDeregistration(correlationID string) {
+	h.deregisterMu.Lock()
+	delete(h.deregistered, correlationID)
+	h.deregisterMu.Unlock()
+}
+
+// sweepDeregistrations removes the deregistered sessions whose grace period elapsed
+func (h *HTTPServer) sweepDeregistrations() {
+	interval := time.Second
+	if h.options.DeregisterGracePeriod < interval {
+		interval = h.options.DeregisterGracePeriod
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		expired := make(map[string]string)
+		h.deregisterMu.Lock()
+		for correlationID, pending := range h.deregistered {
+			if now.After(pending.deadline) {
+				expired[correlationID] = pending.secretKey
+				delete(h.deregistered, correlationID)
+			}
+		}
+		h.deregisterMu.Unlock()
+
+		for correlationID, secretKey := range expired {
+			if err := h.removeSession(correlationID, secretKey); err != nil {
+				gologger.Warning().Msgf("Could not remove id for %s: %s\n", correlationID, err)
+				continue
+			}
+			gologger.Debug().Msgf("Deregistered correlationID %s after grace period\n", correlationID)
+		}
 	}
-	jsonMsg(w, "deregistration successful", http.StatusOK)
-	gologger.Debug().Msgf("Deregistered correlationID %s for key\n", r.CorrelationID)
 }
 
 // PollResponse is the response for a polling request
@@ -450,6 +1214,22 @@ type PollResponse struct {
 	Extra   []string `json:"extra"`
 	AESKey  string   `json:"aes_key"`
 	TLDData []string `json:"tlddata,omitempty"`
+	// Tokens are the delivery tokens of Data to acknowledge through /poll/ack, set for ack=false polls
+	Tokens []string `json:"tokens,omitempty"`
+	// AESKeys are the AESKey wrapped under each public key of the session by fingerprint, set for sessions with several keys
+	AESKeys map[string]string `json:"aes_keys,omitempty"`
+	// More is set when interactions were left out of Data by the batch limit, to be returned by the next polls
+	More bool `json:"more,omitempty"`
+}
+
+// PollAckRequest is a request acknowledging the receipt of polled interactions
+type PollAckRequest struct {
+	// CorrelationID is an ID for correlation with requests.
+	CorrelationID string `json:"correlation-id"`
+	// SecretKey is the secretKey for the interactsh client.
+	SecretKey string `json:"secret-key"`
+	// Tokens are the delivery tokens of the received interactions
+	Tokens []string `json:"tokens"`
 }
 
 // pollHandler is a handler for client poll requests
@@ -465,13 +1245,93 @@ func (h *HTTPServer) pollHandler(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	data, aesKey, err := h.options.Storage.GetInteractions(ID, secret)
+	// simulated errors are returned before touching the storage so no interaction is drained
+	if h.options.PollErrorRate > 0 && rand.Float64() < h.options.PollErrorRate {
+		w.Header().Set("Retry-After", "1")
+		jsonError(w, "simulated poll error", http.StatusServiceUnavailable)
+		return
+	}
+
+	limit := 0
+	if value := req.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			jsonError(w, "invalid limit specified for poll", http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+
+	// correlation ids are public, so only the polls of the session owner are charged
+	// to its bucket, the other ones are rejected by the storage below
+	if h.validSecret(ID, secret) {
+		if ok, retryAfter := h.allowPoll(ID); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			jsonError(w, "poll rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+	}
+
+	if !h.acquirePoll(ID) {
+		jsonError(w, "too many concurrent polls", http.StatusTooManyRequests)
+		return
+	}
+	defer h.releasePoll(ID)
+
+	// ack=false polls keep the interactions until they are acknowledged through /poll/ack
+	response, err := h.collectInteractions(ID, secret, req.URL.Query().Get("ack") == "false", limit)
 	if err != nil {
 		gologger.Warning().Msgf("Could not get interactions for %s: %s\n", ID, err)
 		jsonError(w, fmt.Sprintf("could not get interactions: %s", err), http.StatusBadRequest)
 		return
 	}
 
+	body, err := jsoniter.Marshal(response)
+	if err != nil {
+		gologger.Warning().Msgf("Could not encode interactions for %s: %s\n", ID, err)
+		jsonError(w, fmt.Sprintf("could not encode interactions: %s", err), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if _, err := w.Write(append(body, '\n')); err != nil {
+		gologger.Warning().Msgf("Could not write interactions for %s: %s\n", ID, err)
+		return
+	}
+	gologger.Debug().Msgf("Polled %d interactions for %s correlationID\n", len(response.Data), ID)
+}
+
+// pollBatch returns the number of interactions returned by a poll asking for limit
+// ones, capped by MaxPollBatch. Zero means no limit.
+func (options *Options) pollBatch(limit int) int {
+	if options.MaxPollBatch > 0 && (limit <= 0 || limit > options.MaxPollBatch) {
+		return options.MaxPollBatch
+	}
+	return limit
+}
+
+// collectInteractions returns at most limit interactions of the correlation id, capped
+// by MaxPollBatch, along with the root tld and auth token ones. Peeked interactions
+// are kept until acknowledged.
+func (h *HTTPServer) collectInteractions(ID, secret string, peek bool, limit int) (*PollResponse, error) {
+	var (
+		data, tokens []string
+		aesKey       string
+		more         bool
+		err          error
+	)
+	limit = h.options.pollBatch(limit)
+	if peek {
+		data, tokens, aesKey, err = h.options.Storage.PeekInteractions(ID, secret)
+		if limit > 0 && len(data) > limit {
+			data, tokens, more = data[:limit], tokens[:limit], true
+		}
+	} else {
+		data, more, aesKey, err = h.options.Storage.GetInteractionsBatch(ID, secret, limit)
+	}
+	if err != nil {
+		return nil, err
+	}
+
 	// At this point the client is authenticated, so we return also the data related to the auth token
 	var tlddata, extradata []string
 	if h.options.RootTLD {
@@ -485,29 +1345,280 @@ func (h *HTTPServer) pollHandler(w http.ResponseWriter, req *http.Request) {
 		// auth token interactions are not encrypted
 		extradata, _ = h.options.Storage.GetInteractionsWithIdForConsumer(h.options.Token, ID)
 	}
-	response := &PollResponse{Data: data, AESKey: aesKey, TLDData: tlddata, Extra: extradata}
+	response := &PollResponse{Data: data, AESKey: aesKey, TLDData: tlddata, Extra: extradata, Tokens: tokens, More: more}
+	if aesKey != "" {
+		aesKeys, err := h.options.Storage.GetAESKeys(ID, secret, aesKey)
+		if err != nil {
+			gologger.Warning().Msgf("Could not get aes keys for %s: %s\n", ID, err)
+		} else if len(aesKeys) > 1 {
+			response.AESKeys = aesKeys
+		}
+	}
+	return response, nil
+}
 
-	if err := jsoniter.NewEncoder(w).Encode(response); err != nil {
-		gologger.Warning().Msgf("Could not encode interactions for %s: %s\n", ID, err)
-		jsonError(w, fmt.Sprintf("could not encode interactions: %s", err), http.StatusBadRequest)
+// pollAckHandler removes the interactions acknowledged by the client after an ack=false poll
+func (h *HTTPServer) pollAckHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	r := &PollAckRequest{}
+	if err := jsoniter.NewDecoder(req.Body).Decode(r); err != nil {
+		gologger.Warning().Msgf("Could not decode json body: %s\n", err)
+		jsonError(w, fmt.Sprintf("could not decode json body: %s", err), http.StatusBadRequest)
+		return
+	}
+	if err := h.options.Storage.AckInteractions(r.CorrelationID, r.SecretKey, r.Tokens); err != nil {
+		gologger.Warning().Msgf("Could not acknowledge interactions for %s: %s\n", r.CorrelationID, err)
+		jsonError(w, fmt.Sprintf("could not acknowledge interactions: %s", err), http.StatusBadRequest)
+		return
+	}
+	jsonMsg(w, "acknowledgment successful", http.StatusOK)
+	gologger.Debug().Msgf("Acknowledged %d interactions for %s correlationID\n", len(r.Tokens), r.CorrelationID)
+}
+
+// PublicKeyRequest is a request adding or removing a public key of a session
+type PublicKeyRequest struct {
+	// CorrelationID is an ID for correlation with requests.
+	CorrelationID string `json:"correlation-id"`
+	// SecretKey is the secretKey for the interactsh client.
+	SecretKey string `json:"secret-key"`
+	// PublicKey is the public RSA Key to add or remove.
+	PublicKey string `json:"public-key"`
+}
+
+// publicKeyHandler adds (POST) or removes (DELETE) a public key of a session
+func (h *HTTPServer) publicKeyHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost && req.Method != http.MethodDelete {
+		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	r := &PublicKeyRequest{}
+	if err := jsoniter.NewDecoder(req.Body).Decode(r); err != nil {
+		gologger.Warning().Msgf("Could not decode json body: %s\n", err)
+		jsonError(w, fmt.Sprintf("could not decode json body: %s", err), http.StatusBadRequest)
+		return
+	}
+	if req.Method == http.MethodDelete {
+		if err := h.options.Storage.RemovePublicKey(r.CorrelationID, r.SecretKey, r.PublicKey); err != nil {
+			gologger.Warning().Msgf("Could not remove public key for %s: %s\n", r.CorrelationID, err)
+			jsonError(w, fmt.Sprintf("could not remove public key: %s", err), http.StatusBadRequest)
+			return
+		}
+		jsonMsg(w, "public key removed", http.StatusOK)
+		gologger.Debug().Msgf("Removed public key of correlationID %s\n", r.CorrelationID)
+		return
+	}
+	if err := h.options.Storage.AddPublicKey(r.CorrelationID, r.SecretKey, r.PublicKey); err != nil {
+		gologger.Warning().Msgf("Could not add public key for %s: %s\n", r.CorrelationID, err)
+		jsonError(w, fmt.Sprintf("could not add public key: %s", err), http.StatusBadRequest)
+		return
+	}
+	jsonMsg(w, "public key added", http.StatusOK)
+	gologger.Debug().Msgf("Added public key to correlationID %s\n", r.CorrelationID)
+}
+
+// acquirePoll reserves a concurrent poll slot for the correlation ID,
+// returning false when MaxConcurrentPolls polls are already in flight.
+func (h *HTTPServer) acquirePoll(correlationID string) bool {
+	if h.options.MaxConcurrentPolls <= 0 {
+		return true
+	}
+	h.pollMu.Lock()
+	defer h.pollMu.Unlock()
+
+	if h.activePolls[correlationID] >= h.options.MaxConcurrentPolls {
+		return false
+	}
+	if h.activePolls == nil {
+		h.activePolls = make(map[string]int)
+	}
+	h.activePolls[correlationID]++
+	return true
+}
+
+// pollBucket is the token bucket rate limiting the polls of a correlation id
+type pollBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// pollBucketsPruneInterval is how often the buckets of the ids that stopped polling are dropped
+const pollBucketsPruneInterval = time.Minute
+
+// validSecret returns true if the secret is the one of the registered correlation id
+func (h *HTTPServer) validSecret(correlationID, secret string) bool {
+	value, err := h.options.Storage.GetCacheItem(correlationID)
+	if err != nil {
+		return false
+	}
+	value.Lock()
+	defer value.Unlock()
+	return value.SecretKey != "" && strings.EqualFold(value.SecretKey, secret)
+}
+
+// allowPoll takes a token from the bucket of the correlation id, returning false
+// along with the time until the next token when PollRateLimit is exceeded.
+func (h *HTTPServer) allowPoll(correlationID string) (bool, time.Duration) {
+	if h.options.PollRateLimit <= 0 {
+		return true, 0
+	}
+	rate := float64(h.options.PollRateLimit)
+	burst := float64(h.options.PollRateBurst)
+	if burst <= 0 {
+		burst = rate
+	}
+	h.pollRateMu.Lock()
+	defer h.pollRateMu.Unlock()
+
+	now := time.Now()
+	if h.pollBuckets == nil {
+		h.pollBuckets = make(map[string]*pollBucket)
+	}
+	// buckets refilled up to the burst are the same as new ones
+	if now.Sub(h.pollBucketsPruned) >= pollBucketsPruneInterval {
+		for id, bucket := range h.pollBuckets {
+			if bucket.tokens+now.Sub(bucket.last).Seconds()*rate >= burst {
+				delete(h.pollBuckets, id)
+			}
+		}
+		h.pollBucketsPruned = now
+	}
+
+	bucket, ok := h.pollBuckets[correlationID]
+	if !ok {
+		bucket = &pollBucket{tokens: burst, last: now}
+		h.pollBuckets[correlationID] = bucket
+	}
+	bucket.tokens = min(burst, bucket.tokens+now.Sub(bucket.last).Seconds()*rate)
+	bucket.last = now
+	if bucket.tokens < 1 {
+		return false, time.Duration((1 - bucket.tokens) / rate * float64(time.Second))
+	}
+	bucket.tokens--
+	return true, 0
+}
+
+// registrationWindowSize is the period RegisterRateLimit registrations are counted over
+const registrationWindowSize = time.Minute
+
+// registrationWindow counts the registrations of a source ip since its start
+type registrationWindow struct {
+	start time.Time
+	count int
+}
+
+// allowRegistration counts a registration from the source ip, returning false
+// when RegisterRateLimit registrations were already made in the current window.
+func (h *HTTPServer) allowRegistration(host string) bool {
+	if h.options.RegisterRateLimit <= 0 {
+		return true
+	}
+	h.registerMu.Lock()
+	defer h.registerMu.Unlock()
+
+	now := time.Now()
+	if h.registrations == nil {
+		h.registrations = make(map[string]*registrationWindow)
+	}
+	// windows of the ips that stopped registering are dropped once in a while
+	if now.Sub(h.registersPruned) >= registrationWindowSize {
+		for ip, window := range h.registrations {
+			if now.Sub(window.start) >= registrationWindowSize {
+				delete(h.registrations, ip)
+			}
+		}
+		h.registersPruned = now
+	}
+
+	window, ok := h.registrations[host]
+	if !ok || now.Sub(window.start) >= registrationWindowSize {
+		window = &registrationWindow{start: now}
+		h.registrations[host] = window
+	}
+	if window.count >= h.options.RegisterRateLimit {
+		return false
+	}
+	window.count++
+	return true
+}
+
+// releasePoll frees a poll slot reserved with acquirePoll
+func (h *HTTPServer) releasePoll(correlationID string) {
+	if h.options.MaxConcurrentPolls <= 0 {
+		return
+	}
+	h.pollMu.Lock()
+	defer h.pollMu.Unlock()
+
+	if h.activePolls[correlationID] <= 1 {
+		delete(h.activePolls, correlationID)
 		return
 	}
-	gologger.Debug().Msgf("Polled %d interactions for %s correlationID\n", len(data), ID)
+	h.activePolls[correlationID]--
+}
+
+// allowedOrigin returns the value of the Access-Control-Allow-Origin header for the
+// origin of a request, and false when the origin is not allowed by the OriginURLs
+func (h *HTTPServer) allowedOrigin(origin string) (string, bool) {
+	origins := h.options.originURLs()
+	if h.staticOrigin() {
+		return origins[0], true
+	}
+	h.originsOnce.Do(func() {
+		for _, pattern := range origins {
+			// a bare wildcard allows any origin, not only the ones without a path separator
+			if pattern == "*" {
+				h.origins = append(h.origins, regexp.MustCompile(".*"))
+				continue
+			}
+			expr := strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, "[^/]*")
+			h.origins = append(h.origins, regexp.MustCompile("(?i)^"+expr+"$"))
+		}
+	})
+	if origin == "" {
+		return "", false
+	}
+	for _, pattern := range h.origins {
+		if pattern.MatchString(origin) {
+			return origin, true
+		}
+	}
+	return "", false
+}
+
+// staticOrigin returns true if the Access-Control-Allow-Origin header doesn't depend
+// on the request, as a single origin is sent as is unless it's a wildcard pattern
+func (h *HTTPServer) staticOrigin() bool {
+	origins := h.options.originURLs()
+	return len(origins) == 1 && (origins[0] == "*" || !strings.Contains(origins[0], "*"))
+}
+
+// originURLs returns the origins allowed by the CORS headers, falling back to the
+// deprecated OriginURL when OriginURLs is empty
+func (options *Options) originURLs() []string {
+	if len(options.OriginURLs) == 0 && options.OriginURL != "" {
+		return []string{options.OriginURL}
+	}
+	return options.OriginURLs
 }
 
 func (h *HTTPServer) corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
-		// Set CORS headers for the preflight request
-		if req.Method == http.MethodOptions {
-			w.Header().Set("Access-Control-Allow-Origin", h.options.OriginURL)
+		if origin, ok := h.allowedOrigin(req.Header.Get("Origin")); ok {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
 			w.Header().Set("Access-Control-Allow-Credentials", "true")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		}
+		if !h.staticOrigin() {
+			w.Header().Add("Vary", "Origin")
+		}
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		// the preflight request is answered with the CORS headers only
+		if req.Method == http.MethodOptions {
 			w.WriteHeader(http.StatusNoContent)
 			return
 		}
-		w.Header().Set("Access-Control-Allow-Origin", h.options.OriginURL)
-		w.Header().Set("Access-Control-Allow-Credentials", "true")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
 		next.ServeHTTP(w, req)
 	})
 }
@@ -527,6 +1638,19 @@ func jsonMsg(w http.ResponseWriter, msg string, code int) {
 	jsonBody(w, "message", msg, code)
 }
 
+// connectMiddleware sends CONNECT requests, which have no path to be routed
+// by, straight to the default handler
+func (h *HTTPServer) connectMiddleware(next http.Handler) http.Handler {
+	connect := h.logger(http.HandlerFunc(h.defaultHandler))
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodConnect {
+			connect.ServeHTTP(w, req)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
 func (h *HTTPServer) authMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 		if !h.checkToken(req) {
@@ -537,23 +1661,220 @@ func (h *HTTPServer) authMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// tlsMiddleware rejects the client api requests received over plain http when
+// tls is required, so that the session secrets are never sent in cleartext
+func (h *HTTPServer) tlsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if h.options.RequireTLSForAPI && req.TLS == nil {
+			jsonError(w, "tls is required for the api endpoints", http.StatusBadRequest)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
 func (h *HTTPServer) checkToken(req *http.Request) bool {
-	return !h.options.Auth || h.options.Auth && h.options.Token == req.Header.Get("Authorization")
+	if h.options.AuthVerifier != nil {
+		return h.options.AuthVerifier(req)
+	}
+	if !h.options.Auth {
+		return true
+	}
+	token := req.Header.Get("Authorization")
+	if token == "" {
+		return false
+	}
+	if token == h.options.Token {
+		return true
+	}
+	label, ok := h.options.Tokens[token]
+	if ok {
+		h.options.Stats.recordToken(label)
+	}
+	return ok
+}
+
+// serverTokenMiddleware rejects the requests not authenticated with the server token,
+// the per-user client tokens are not accepted
+func (h *HTTPServer) serverTokenMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !h.options.Auth || h.options.Token == "" || req.Header.Get("Authorization") != h.options.Token {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+func (h *HTTPServer) adminMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !h.checkAdminToken(req) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+func (h *HTTPServer) checkAdminToken(req *http.Request) bool {
+	return h.options.AdminToken != "" && h.options.AdminToken == req.Header.Get("Authorization")
+}
+
+// purgeHandler is a handler for admin requests removing all data of a correlation ID
+func (h *HTTPServer) purgeHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodDelete {
+		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ID := strings.TrimPrefix(req.URL.Path, "/admin/correlation/")
+	if ID == "" {
+		jsonError(w, "no id specified for purge", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.options.Storage.PurgeID(ID); err != nil {
+		gologger.Warning().Msgf("Could not purge id %s: %s\n", ID, err)
+		jsonError(w, fmt.Sprintf("could not purge id: %s", err), http.StatusNotFound)
+		return
+	}
+	atomic.AddInt64(&h.options.Stats.Sessions, -1)
+	h.removeConsumers(ID)
+	h.options.campaignTracker().remove(ID)
+	jsonMsg(w, "purge successful", http.StatusOK)
+	gologger.Debug().Msgf("Purged correlationID %s\n", ID)
+}
+
+// FlushResponse is the response of the /admin/flush endpoint
+type FlushResponse struct {
+	// Purged is the number of removed correlation IDs
+	Purged int `json:"purged"`
+}
+
+// flushHandler is a handler for admin requests removing all the registered correlation IDs
+func (h *HTTPServer) flushHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	removed, err := h.options.Storage.RemoveAll()
+	atomic.AddInt64(&h.options.Stats.Sessions, -int64(len(removed)))
+	for _, ID := range removed {
+		h.removeConsumers(ID)
+		h.options.campaignTracker().remove(ID)
+	}
+	if err != nil {
+		gologger.Warning().Msgf("Could not flush ids: %s\n", err)
+		jsonError(w, fmt.Sprintf("could not flush ids: %s", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := jsoniter.NewEncoder(w).Encode(&FlushResponse{Purged: len(removed)}); err != nil {
+		gologger.Warning().Msgf("Could not encode flush response: %s\n", err)
+	}
+	gologger.Debug().Msgf("Flushed %d correlationIDs\n", len(removed))
+}
+
+// certificateReloadHandler reloads the tls certificates from disk
+func (h *HTTPServer) certificateReloadHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := h.options.CertificateStore.Reload(); err != nil {
+		gologger.Warning().Msgf("Could not reload certificates: %s\n", err)
+		jsonError(w, fmt.Sprintf("could not reload certificates: %s", err), http.StatusInternalServerError)
+		return
+	}
+	jsonMsg(w, "certificates reloaded", http.StatusOK)
+	gologger.Info().Msgf("Reloaded certificates from disk\n")
 }
 
-// metricsHandler is a handler for /metrics endpoint
+// metricsHandler is a handler for /metrics endpoint. Metrics are encoded as json
+// unless the client accepts the Prometheus text format, see writePrometheusMetrics.
 func (h *HTTPServer) metricsHandler(w http.ResponseWriter, req *http.Request) {
 	interactMetrics := h.options.Stats
 	interactMetrics.Cache = GetCacheMetrics(h.options)
-	interactMetrics.Cpu = GetCpuMetrics()
-	interactMetrics.Memory = GetMemoryMetrics()
-	interactMetrics.Network = GetNetworkMetrics()
-
+	systemMetrics := h.systemMetrics.get()
+	interactMetrics.Cpu = systemMetrics.Cpu
+	interactMetrics.Memory = systemMetrics.Memory
+	interactMetrics.Network = systemMetrics.Network
+	interactMetrics.TokenUsage = interactMetrics.tokenUsage()
+
+	if acceptsPrometheus(req) {
+		w.Header().Set("Content-Type", prometheusContentType)
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		writePrometheusMetrics(w, interactMetrics)
+		return
+	}
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	w.Header().Set("X-Content-Type-Options", "nosniff")
 	_ = jsoniter.NewEncoder(w).Encode(interactMetrics)
 }
 
+// acmeChallengeHandler serves the key authorization of ACME HTTP-01 challenge tokens,
+// recording the requests as acme interactions when RecordACMEChallenges is set.
+func (h *HTTPServer) acmeChallengeHandler(w http.ResponseWriter, req *http.Request) {
+	receivedAt := time.Now()
+	if !h.options.RecordACMEChallenges {
+		h.serveACMEChallenge(w, req)
+		return
+	}
+	reqString, _ := httputil.DumpRequest(req, true)
+	rec := httptest.NewRecorder()
+	h.serveACMEChallenge(rec, req)
+	respString := writeRecordedResponse(w, req, rec)
+
+	host, _, _ := net.SplitHostPort(req.RemoteAddr)
+	if originIP := req.Header.Get(h.options.OriginIPHeader); h.options.OriginIPHeader != "" && originIP != "" {
+		host = originIP
+	}
+	interaction := &Interaction{
+		Protocol:      "acme",
+		UniqueID:      req.Host,
+		FullId:        req.Host,
+		RawRequest:    string(reqString),
+		RawResponse:   respString,
+		RemoteAddress: host,
+		ReceivedAt:    receivedAt,
+		Timestamp:     time.Now(),
+	}
+	data, err := h.options.encodeInteraction(interaction)
+	if err != nil {
+		gologger.Warning().Msgf("Could not encode acme interaction: %s\n", err)
+		return
+	}
+	gologger.Debug().Msgf("ACME Interaction: \n%s\n", string(data))
+	h.options.publishInteraction(interaction, data)
+	// acme interactions have no correlation id and are only stored in the root tld stream
+	if h.options.RootTLD {
+		for _, domain := range h.options.Domains {
+			if stringsutil.HasSuffixI(req.Host, domain) {
+				if err := h.options.Storage.AddInteractionWithId(domain, data); err != nil {
+					gologger.Warning().Msgf("Could not store acme interaction: %s\n", err)
+				}
+			}
+		}
+	}
+}
+
+// serveACMEChallenge writes the key authorization of the requested token
+func (h *HTTPServer) serveACMEChallenge(w http.ResponseWriter, req *http.Request) {
+	token := strings.TrimPrefix(req.URL.Path, acme.HTTPChallengePath)
+	if token == "" || h.options.ACMEStore == nil {
+		http.NotFound(w, req)
+		return
+	}
+	keyAuthorization, ok := h.options.ACMEStore.GetHTTPChallenge(token)
+	if !ok {
+		http.NotFound(w, req)
+		return
+	}
+	gologger.Debug().Msgf("Serving acme http challenge for token %s\n", token)
+	w.Header().Set("Content-Type", "text/plain")
+	_, _ = w.Write([]byte(keyAuthorization))
+}
+
 // storeHandler is a handler for /storerequest endpoint
 func (h *HTTPServer) storeHandler(w http.ResponseWriter, req *http.Request) {
 	if req.Method != http.MethodPost {
@@ -572,19 +1893,16 @@ func (h *HTTPServer) storeHandler(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	h.dynMu.RLock()
+	// the lockout check and the update are made under the same lock so concurrent stores can't both succeed
+	h.dynMu.Lock()
 	de, exists := h.dynamicEndpoints[sreq.SubURL]
 	now := time.Now()
-	if exists {
-		if now.Sub(de.LastUpdated) < 24*time.Hour {
-			jsonError(w, "suburl can only be updated every 24 hours", http.StatusTooManyRequests)
-			h.dynMu.RUnlock()
-			return
-		}
+	updateInterval := h.options.dynamicEndpointCooldown()
+	if exists && !h.dynamicEndpointExpired(de, now) && now.Sub(de.LastUpdated) < updateInterval {
+		h.dynMu.Unlock()
+		jsonError(w, fmt.Sprintf("suburl can only be updated every %s", updateInterval), http.StatusTooManyRequests)
+		return
 	}
-	h.dynMu.RUnlock()
-
-	h.dynMu.Lock()
 	h.dynamicEndpoints[sreq.SubURL] = dynamicEndpoint{
 		Body:        []byte(sreq.Body),
 		ContentType: sreq.ContentType,
@@ -595,11 +1913,34 @@ func (h *HTTPServer) storeHandler(w http.ResponseWriter, req *http.Request) {
 	jsonMsg(w, "endpoint registered", http.StatusOK)
 }
 
+// apidocsIndexHandler lists the suburls of the registered dynamic endpoints
+func (h *HTTPServer) apidocsIndexHandler(w http.ResponseWriter) {
+	h.dynMu.RLock()
+	suburls := make([]string, 0, len(h.dynamicEndpoints))
+	now := time.Now()
+	for suburl, de := range h.dynamicEndpoints {
+		if !h.dynamicEndpointExpired(de, now) {
+			suburls = append(suburls, suburl)
+		}
+	}
+	h.dynMu.RUnlock()
+	sort.Strings(suburls)
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err := jsoniter.NewEncoder(w).Encode(map[string][]string{"suburls": suburls}); err != nil {
+		gologger.Warning().Msgf("Could not encode apidocs index: %s\n", err)
+	}
+}
+
 // apidocsHandler serves registered dynamic endpoints
 func (h *HTTPServer) apidocsHandler(w http.ResponseWriter, req *http.Request) {
 	// URL: /apidocs/{suburl}
 	path := strings.TrimPrefix(req.URL.Path, "/apidocs/")
 	if path == "" {
+		if h.options.ApidocsIndex {
+			h.apidocsIndexHandler(w)
+			return
+		}
 		jsonError(w, "no suburl provided", http.StatusNotFound)
 		return
 	}
@@ -607,7 +1948,7 @@ func (h *HTTPServer) apidocsHandler(w http.ResponseWriter, req *http.Request) {
 	h.dynMu.RLock()
 	de, ok := h.dynamicEndpoints[path]
 	h.dynMu.RUnlock()
-	if !ok {
+	if !ok || h.dynamicEndpointExpired(de, time.Now()) {
 		jsonError(w, "not found", http.StatusNotFound)
 		return
 	}
@@ -616,6 +1957,6 @@ func (h *HTTPServer) apidocsHandler(w http.ResponseWriter, req *http.Request) {
 	}
 	w.WriteHeader(http.StatusOK)
 	if _, err := w.Write(de.Body); err != nil {
-      log.Printf("write error: %v", err)
-  }
+		log.Printf("write error: %v", err)
+	}
 }
@@ -1,15 +1,41 @@
 package server
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
 	"io"
+	"math/big"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	jsoniter "github.com/json-iterator/go"
+	"github.com/miekg/dns"
+	"github.com/projectdiscovery/interactsh/pkg/server/acme"
+	"github.com/projectdiscovery/interactsh/pkg/storage"
 	"github.com/stretchr/testify/require"
 )
 
@@ -29,7 +55,7 @@ func TestWriteResponseFromDynamicRequest(t *testing.T) {
 	t.Run("status", func(t *testing.T) {
 		req := httptest.NewRequest("GET", "http://example.com/?status=404", nil)
 		w := httptest.NewRecorder()
-		writeResponseFromDynamicRequest(w, req)
+		writeResponseFromDynamicRequest(w, req, 0, 0)
 
 		resp := w.Result()
 		require.Equal(t, http.StatusNotFound, resp.StatusCode, "could not get correct result")
@@ -38,15 +64,25 @@ func TestWriteResponseFromDynamicRequest(t *testing.T) {
 		req := httptest.NewRequest("GET", "http://example.com/?delay=1", nil)
 		w := httptest.NewRecorder()
 		now := time.Now()
-		writeResponseFromDynamicRequest(w, req)
+		writeResponseFromDynamicRequest(w, req, 0, 0)
 		took := time.Since(now)
 
 		require.Greater(t, took, 1*time.Second, "could not get correct delay")
 	})
+	t.Run("max_delay", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "http://example.com/?delay=30", nil)
+		w := httptest.NewRecorder()
+		now := time.Now()
+		writeResponseFromDynamicRequest(w, req, 0, 100*time.Millisecond)
+		took := time.Since(now)
+
+		require.GreaterOrEqual(t, took, 100*time.Millisecond)
+		require.Less(t, took, time.Second, "delay should be clamped to the maximum")
+	})
 	t.Run("body", func(t *testing.T) {
 		req := httptest.NewRequest("GET", "http://example.com/?body=this+is+example+body", nil)
 		w := httptest.NewRecorder()
-		writeResponseFromDynamicRequest(w, req)
+		writeResponseFromDynamicRequest(w, req, 0, 0)
 
 		resp := w.Result()
 		body, _ := io.ReadAll(resp.Body)
@@ -56,7 +92,7 @@ func TestWriteResponseFromDynamicRequest(t *testing.T) {
 	t.Run("b64_body", func(t *testing.T) {
 		req := httptest.NewRequest("GET", "http://example.com/?b64_body=dGhpcyBpcyBleGFtcGxlIGJvZHk=", nil)
 		w := httptest.NewRecorder()
-		writeResponseFromDynamicRequest(w, req)
+		writeResponseFromDynamicRequest(w, req, 0, 0)
 
 		resp := w.Result()
 		body, _ := io.ReadAll(resp.Body)
@@ -65,12 +101,53 @@ func TestWriteResponseFromDynamicRequest(t *testing.T) {
 	t.Run("header", func(t *testing.T) {
 		req := httptest.NewRequest("GET", "http://example.com/?header=Key:value&header=Test:Another", nil)
 		w := httptest.NewRecorder()
-		writeResponseFromDynamicRequest(w, req)
+		writeResponseFromDynamicRequest(w, req, 0, 0)
 
 		resp := w.Result()
 		require.Equal(t, resp.Header.Get("Key"), "value", "could not get correct result")
 		require.Equal(t, resp.Header.Get("Test"), "Another", "could not get correct result")
 	})
+	t.Run("max_headers", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "http://example.com/?header=A:1&header=invalid&header=B:2&header=C:3&header=D:4", nil)
+		w := httptest.NewRecorder()
+		writeResponseFromDynamicRequest(w, req, 2, 0)
+
+		resp := w.Result()
+		require.Equal(t, "1", resp.Header.Get("A"))
+		require.Equal(t, "2", resp.Header.Get("B"))
+		require.Empty(t, resp.Header.Get("C"), "headers over the limit should be ignored")
+		require.Empty(t, resp.Header.Get("D"), "headers over the limit should be ignored")
+	})
+	t.Run("cache", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "http://example.com/?cache_control=public,max-age=60&etag=%22abc%22&expires=Wed,+21+Oct+2015+07:28:00+GMT", nil)
+		w := httptest.NewRecorder()
+		writeResponseFromDynamicRequest(w, req, 0, 0)
+
+		resp := w.Result()
+		require.Equal(t, "public,max-age=60", resp.Header.Get("Cache-Control"), "could not get correct cache-control")
+		require.Equal(t, `"abc"`, resp.Header.Get("ETag"), "could not get correct etag")
+		require.Equal(t, "Wed, 21 Oct 2015 07:28:00 GMT", resp.Header.Get("Expires"), "could not get correct expires")
+	})
+}
+
+func TestDefaultCacheHeaders(t *testing.T) {
+	h := &HTTPServer{options: &Options{
+		Domains:             []string{"example.com"},
+		Stats:               &Metrics{},
+		DynamicResp:         true,
+		DefaultCacheHeaders: map[string]string{"Cache-Control": "no-store", "Expires": "0"},
+	}}
+
+	req := httptest.NewRequest("GET", "http://example.com/", nil)
+	w := httptest.NewRecorder()
+	h.defaultHandler(w, req)
+	require.Equal(t, "no-store", w.Result().Header.Get("Cache-Control"))
+	require.Equal(t, "0", w.Result().Header.Get("Expires"))
+
+	req = httptest.NewRequest("GET", "http://example.com/x?cache_control=max-age=60", nil)
+	w = httptest.NewRecorder()
+	h.defaultHandler(w, req)
+	require.Equal(t, "max-age=60", w.Result().Header.Get("Cache-Control"), "dynamic parameter should override default")
 }
 
 func TestApidocsDynamicEndpoint(t *testing.T) {
@@ -131,12 +208,1193 @@ func TestApidocsDynamicEndpoint(t *testing.T) {
 	require.Equal(t, http.StatusNotFound, resp6.StatusCode)
 }
 
+func TestSitemapXML(t *testing.T) {
+	opts := &Options{Domains: []string{"example.com"}, CorrelationIdLength: 10, CorrelationIdNonceLength: 3}
+	store := newTestStore(t, opts, "abcdefghij")
+	h := &HTTPServer{options: opts}
+
+	fetch := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/sitemap.xml", nil)
+		req.Host = "abcdefghijklm.example.com"
+		w := httptest.NewRecorder()
+		h.logger(http.HandlerFunc(h.defaultHandler)).ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("default", func(t *testing.T) {
+		w := fetch()
+		require.Equal(t, http.StatusOK, w.Result().StatusCode)
+		require.Equal(t, "application/xml", w.Result().Header.Get("Content-Type"))
+		require.Contains(t, w.Body.String(), "<loc>https://example.com/</loc>")
+		require.Contains(t, w.Body.String(), "mlkjihgfedcba")
+	})
+	t.Run("configured", func(t *testing.T) {
+		opts.SitemapXML = "<urlset><url><loc>https://{DOMAIN}/admin</loc></url><!-- {REFLECTION} --></urlset>"
+		w := fetch()
+		require.Equal(t, "<urlset><url><loc>https://example.com/admin</loc></url><!-- mlkjihgfedcba --></urlset>", w.Body.String())
+	})
+
+	data, err := store.GetInteractionsWithIdForConsumer("abcdefghij", "consumer")
+	require.NoError(t, err)
+	require.Len(t, data, 2, "sitemap requests should be recorded")
+}
+
+func TestApidocsIndex(t *testing.T) {
+	ts := newTestServer()
+	defer ts.Close()
+	ts.Server.options = &Options{}
+	ts.Server.dynamicEndpoints["foo"] = dynamicEndpoint{Body: []byte("secret body")}
+	ts.Server.dynamicEndpoints["bar"] = dynamicEndpoint{Body: []byte("secret body")}
+
+	t.Run("disabled", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		ts.Server.apidocsHandler(w, httptest.NewRequest("GET", "/apidocs/", nil))
+		require.Equal(t, http.StatusNotFound, w.Result().StatusCode)
+	})
+	t.Run("enabled", func(t *testing.T) {
+		ts.Server.options.ApidocsIndex = true
+		w := httptest.NewRecorder()
+		ts.Server.apidocsHandler(w, httptest.NewRequest("GET", "/apidocs/", nil))
+		require.Equal(t, http.StatusOK, w.Result().StatusCode)
+		body := w.Body.String()
+		require.JSONEq(t, `{"suburls":["bar","foo"]}`, body)
+		require.NotContains(t, body, "secret body", "bodies should not be listed")
+	})
+}
+
+func TestApplyTLSOptions(t *testing.T) {
+	certificate := newTestCertificate(t)
+	staple := []byte("ocsp-staple-response")
+
+	h := &HTTPServer{options: &Options{TLSSessionTicketsDisabled: true}, ocspStaple: staple}
+	base := &tls.Config{Certificates: []tls.Certificate{certificate}}
+	config := h.applyTLSOptions(base)
+
+	require.True(t, config.SessionTicketsDisabled, "session tickets should be disabled")
+	require.False(t, base.SessionTicketsDisabled, "shared config should not be modified")
+	require.Nil(t, base.Certificates[0].OCSPStaple, "shared certificates should not be modified")
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	require.NoError(t, err)
+	defer func() { _ = listener.Close() }()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		_ = conn.(*tls.Conn).Handshake()
+		_ = conn.Close()
+	}()
+
+	conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+	require.NoError(t, err)
+	defer func() { _ = conn.Close() }()
+	require.Equal(t, staple, conn.ConnectionState().OCSPResponse, "could not get stapled ocsp response")
+}
+
+func TestACMEChallengeHandler(t *testing.T) {
+	store := acme.NewProvider()
+	store.PresentHTTPChallenge("token123", "token123.thumbprint")
+	h := &HTTPServer{options: &Options{ACMEStore: store}}
+
+	req := httptest.NewRequest("GET", "/.well-known/acme-challenge/token123", nil)
+	w := httptest.NewRecorder()
+	h.acmeChallengeHandler(w, req)
+	resp := w.Result()
+	body, _ := io.ReadAll(resp.Body)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "token123.thumbprint", string(body))
+
+	store.CleanUpHTTPChallenge("token123")
+	w = httptest.NewRecorder()
+	h.acmeChallengeHandler(w, req)
+	require.Equal(t, http.StatusNotFound, w.Result().StatusCode)
+}
+
+func TestPurgeHandler(t *testing.T) {
+	opts := &Options{AdminToken: "admin-secret", Token: "client-secret"}
+	store := newTestStore(t, opts, "client-secret")
+	require.NoError(t, store.SetIDPublicKey("abcdefghij", "secret", newTestPublicKey(t)))
+	require.NoError(t, store.AddInteraction("abcdefghij", []byte("interaction")))
+	opts.Stats.Sessions = 1
+	h := &HTTPServer{options: opts}
+	handler := h.adminMiddleware(http.HandlerFunc(h.purgeHandler))
+
+	// the ids without secret key, such as the token one, are not sessions
+	req := httptest.NewRequest("DELETE", "/admin/correlation/client-secret", nil)
+	req.Header.Set("Authorization", "admin-secret")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	require.Equal(t, http.StatusNotFound, w.Result().StatusCode)
+	require.Equal(t, int64(1), opts.Stats.Sessions)
+	_, err := store.GetCacheItem("client-secret")
+	require.NoError(t, err, "token id should be kept")
+
+	// the regular client token is not accepted
+	req = httptest.NewRequest("DELETE", "/admin/correlation/abcdefghij", nil)
+	req.Header.Set("Authorization", "client-secret")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	require.Equal(t, http.StatusUnauthorized, w.Result().StatusCode)
+
+	req = httptest.NewRequest("DELETE", "/admin/correlation/abcdefghij", nil)
+	req.Header.Set("Authorization", "admin-secret")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Result().StatusCode)
+	require.Zero(t, opts.Stats.Sessions, "the sessions metric should account for the purged session")
+
+	_, err = store.GetCacheItem("abcdefghij")
+	require.Error(t, err, "correlation id should be removed")
+
+	// purging again reports the id as missing
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	require.Equal(t, http.StatusNotFound, w.Result().StatusCode)
+}
+
+func TestFlushHandler(t *testing.T) {
+	opts := &Options{Auth: true, Token: "server-secret", Tokens: map[string]string{"user-secret": "user"}, AdminToken: "admin-secret"}
+	store := newTestStore(t, opts, "abcdefghij", "server-secret")
+	require.NoError(t, store.SetIDPublicKey("zyxwvutsrq", "secret", newTestPublicKey(t)))
+	require.NoError(t, store.SetIDPublicKey("qrstuvwxyz", "secret", newTestPublicKey(t)))
+	require.NoError(t, store.AddInteraction("zyxwvutsrq", []byte("interaction")))
+	opts.Stats.Sessions = 2
+	h := &HTTPServer{options: opts}
+	handler := h.serverTokenMiddleware(http.HandlerFunc(h.flushHandler))
+
+	flush := func(method, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/admin/flush", nil)
+		req.Header.Set("Authorization", token)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	// only the server token is accepted
+	require.Equal(t, http.StatusUnauthorized, flush("POST", "user-secret").Code)
+	require.Equal(t, http.StatusUnauthorized, flush("POST", "admin-secret").Code)
+	require.Equal(t, http.StatusMethodNotAllowed, flush("GET", "server-secret").Code)
+
+	w := flush("POST", "server-secret")
+	require.Equal(t, http.StatusOK, w.Code)
+	response := &FlushResponse{}
+	require.NoError(t, jsoniter.NewDecoder(w.Body).Decode(response))
+	require.Equal(t, 2, response.Purged)
+	require.Zero(t, opts.Stats.Sessions, "the sessions metric should account for the flushed sessions")
+	for _, id := range []string{"zyxwvutsrq", "qrstuvwxyz"} {
+		_, err := store.GetCacheItem(id)
+		require.Error(t, err, "correlation id should be removed")
+	}
+	// the token interactions stream is kept
+	_, err := store.GetCacheItem("server-secret")
+	require.NoError(t, err)
+
+	w = flush("POST", "server-secret")
+	require.NoError(t, jsoniter.NewDecoder(w.Body).Decode(response))
+	require.Zero(t, response.Purged)
+
+	// the endpoint is served with auth and a server token, without admin token
+	opts = &Options{Domains: []string{"example.com"}, Auth: true, Token: "server-secret"}
+	newTestStore(t, opts)
+	server, err := NewHTTPServer(opts)
+	require.NoError(t, err)
+	req := httptest.NewRequest("POST", "/admin/flush", nil)
+	req.Header.Set("Authorization", "server-secret")
+	w = httptest.NewRecorder()
+	server.nontlsserver.Handler.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestAdminListener(t *testing.T) {
+	opts := &Options{Domains: []string{"example.com"}, AdminToken: "admin-secret", EnableMetrics: true, Stats: &Metrics{}}
+	store := newTestStore(t, opts)
+	require.NoError(t, store.SetIDPublicKey("abcdefghij", "secret", newTestPublicKey(t)))
+	require.NoError(t, store.AddInteraction("abcdefghij", []byte("interaction")))
+
+	serve := func(handler http.Handler, method, target string) *http.Response {
+		req := httptest.NewRequest(method, target, nil)
+		req.Header.Set("Authorization", "admin-secret")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Result()
+	}
+
+	t.Run("shared", func(t *testing.T) {
+		h, err := NewHTTPServer(opts)
+		require.NoError(t, err)
+		require.Nil(t, h.adminserver)
+		resp := serve(h.nontlsserver.Handler, "GET", "/metrics")
+		require.Contains(t, resp.Header.Get("Content-Type"), "application/json")
+	})
+	t.Run("dedicated", func(t *testing.T) {
+		opts.AdminListenAddr = "127.0.0.1:0"
+		defer func() { opts.AdminListenAddr = "" }()
+		h, err := NewHTTPServer(opts)
+		require.NoError(t, err)
+		require.NotNil(t, h.adminserver)
+
+		for _, public := range []http.Handler{h.nontlsserver.Handler, h.tlsserver.Handler} {
+			resp := serve(public, "GET", "/metrics")
+			require.NotContains(t, resp.Header.Get("Content-Type"), "application/json", "metrics should not be served publicly")
+			_ = serve(public, "DELETE", "/admin/correlation/abcdefghij")
+		}
+		_, err = store.GetCacheItem("abcdefghij")
+		require.NoError(t, err, "public listeners should not purge")
+
+		resp := serve(h.adminserver.Handler, "GET", "/metrics")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Contains(t, resp.Header.Get("Content-Type"), "application/json")
+		resp = serve(h.adminserver.Handler, "DELETE", "/admin/correlation/abcdefghij")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		resp = serve(h.adminserver.Handler, "GET", "/abcdefghij")
+		require.Equal(t, http.StatusNotFound, resp.StatusCode, "public routes should not be served on the admin listener")
+	})
+}
+
+func TestMetricsCacheTTL(t *testing.T) {
+	opts := &Options{Stats: &Metrics{}, MetricsCacheTTL: time.Hour}
+	newTestStore(t, opts)
+	gathered := 0
+	cache := newSystemMetricsCache(opts.MetricsCacheTTL)
+	cache.gather = func() *SystemMetrics {
+		gathered++
+		return &SystemMetrics{Memory: &MemoryMetrics{}, Cpu: &CpuStats{}, Network: &NetworkStats{}}
+	}
+	h := &HTTPServer{options: opts, systemMetrics: cache}
+
+	scrape := func() *Metrics {
+		w := httptest.NewRecorder()
+		h.metricsHandler(w, httptest.NewRequest("GET", "/metrics", nil))
+		metrics := &Metrics{}
+		require.NoError(t, jsoniter.NewDecoder(w.Result().Body).Decode(metrics))
+		return metrics
+	}
+
+	for i := uint64(1); i <= 3; i++ {
+		atomic.AddUint64(&opts.Stats.Http, 1)
+		require.Equal(t, i, scrape().Http, "interaction counters should stay live")
+	}
+	require.Equal(t, 1, gathered, "system metrics should not be recomputed within the ttl")
+
+	cache.updated = time.Now().Add(-2 * time.Hour)
+	scrape()
+	require.Equal(t, 2, gathered, "system metrics should be recomputed once expired")
+}
+
+func TestMetricsPrometheusFormat(t *testing.T) {
+	opts := &Options{}
+	newTestStore(t, opts)
+	opts.Stats = &Metrics{Dns: 3, Http: 5, Sessions: 2}
+	cache := newSystemMetricsCache(time.Hour)
+	cache.gather = func() *SystemMetrics {
+		return &SystemMetrics{Memory: GetMemoryMetrics(), Cpu: &CpuStats{User: 7}, Network: &NetworkStats{rxBytes: 1024}}
+	}
+	h := &HTTPServer{options: opts, systemMetrics: cache}
+
+	scrape := func(accept string) *http.Response {
+		req := httptest.NewRequest("GET", "/metrics", nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		w := httptest.NewRecorder()
+		h.metricsHandler(w, req)
+		return w.Result()
+	}
+
+	// json stays the default
+	for _, accept := range []string{"", "*/*", "application/json", "application/json, text/plain;q=0.5"} {
+		resp := scrape(accept)
+		require.Equal(t, "application/json; charset=utf-8", resp.Header.Get("Content-Type"), accept)
+		require.NoError(t, jsoniter.NewDecoder(resp.Body).Decode(&Metrics{}))
+	}
+
+	for _, accept := range []string{"text/plain", "application/openmetrics-text;version=1.0.0,text/plain;version=0.0.4;q=0.5,*/*;q=0.1"} {
+		resp := scrape(accept)
+		require.Equal(t, prometheusContentType, resp.Header.Get("Content-Type"), accept)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.Contains(t, string(body), "# HELP interactsh_http_interactions_total Total number of http interactions.\n# TYPE interactsh_http_interactions_total counter\ninteractsh_http_interactions_total 5\n")
+		require.Contains(t, string(body), "\ninteractsh_dns_interactions_total 3\n")
+		require.Contains(t, string(body), "# TYPE interactsh_sessions gauge\ninteractsh_sessions 2\n")
+		require.Contains(t, string(body), "\ninteractsh_cache_hits_total ")
+		require.Contains(t, string(body), "\ninteractsh_memory_heap_alloc_bytes ")
+		require.Contains(t, string(body), "\ninteractsh_cpu_user_ticks_total 7\n")
+		require.Contains(t, string(body), "\ninteractsh_network_received_bytes_total 1024\n")
+	}
+}
+
+func TestRequestWithoutHostHeader(t *testing.T) {
+	opts := &Options{Domains: []string{"example.com"}, CorrelationIdLength: 10}
+	store := newTestStore(t, opts, "abcdefghij")
+	h := &HTTPServer{options: opts}
+
+	req := httptest.NewRequest("GET", "/abcdefghij", nil)
+	req.Proto, req.ProtoMajor, req.ProtoMinor = "HTTP/1.0", 1, 0
+	req.Host = ""
+	w := httptest.NewRecorder()
+	h.logger(http.HandlerFunc(h.defaultHandler)).ServeHTTP(w, req)
+
+	resp := w.Result()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "example.com", resp.Header.Get("Server"), "default domain should be used")
+
+	data, err := store.GetInteractionsWithIdForConsumer("abcdefghij", "consumer")
+	require.NoError(t, err)
+	require.Len(t, data, 1)
+	interaction := &Interaction{}
+	require.NoError(t, jsoniter.UnmarshalFromString(data[0], interaction))
+	require.True(t, interaction.NoHost, "missing host header should be flagged")
+	require.Equal(t, "abcdefghij", interaction.UniqueID)
+}
+
+func TestInteractionCorrelationID(t *testing.T) {
+	opts := &Options{Domains: []string{"example.com"}, CorrelationIdLength: 10, CorrelationIdNonceLength: 3}
+	store := newTestStore(t, opts, "abcdefghij")
+	h := &HTTPServer{options: opts}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Host = "foo.abcdefghijklm.example.com"
+	w := httptest.NewRecorder()
+	h.logger(http.HandlerFunc(h.defaultHandler)).ServeHTTP(w, req)
+
+	data, err := store.GetInteractionsWithIdForConsumer("abcdefghij", "consumer")
+	require.NoError(t, err)
+	require.Len(t, data, 1)
+	interaction := &Interaction{}
+	require.NoError(t, jsoniter.UnmarshalFromString(data[0], interaction))
+	require.Equal(t, "abcdefghijklm", interaction.UniqueID)
+	require.Equal(t, "abcdefghij", interaction.CorrelationID)
+}
+
+func TestInteractionFingerprint(t *testing.T) {
+	opts := &Options{Domains: []string{"example.com"}, CorrelationIdLength: 10, CorrelationIdNonceLength: 3}
+	store := newTestStore(t, opts, "abcdefghij")
+	h := &HTTPServer{options: opts}
+
+	send := func(path, remoteAddr string) {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Host = "abcdefghijklm.example.com"
+		req.RemoteAddr = remoteAddr
+		h.logger(http.HandlerFunc(h.defaultHandler)).ServeHTTP(httptest.NewRecorder(), req)
+	}
+	send("/callback?a=1", "192.0.2.1:1234")
+	send("/callback?a=2", "192.0.2.1:5678")
+	send("/other", "192.0.2.1:1234")
+	send("/callback", "192.0.2.2:1234")
+
+	data, err := store.GetInteractionsWithIdForConsumer("abcdefghij", "consumer")
+	require.NoError(t, err)
+	require.Len(t, data, 4)
+	fingerprints := make([]string, len(data))
+	for i, item := range data {
+		interaction := &Interaction{}
+		require.NoError(t, jsoniter.UnmarshalFromString(item, interaction))
+		fingerprints[i] = interaction.Fingerprint
+	}
+	require.Equal(t, fingerprints[0], fingerprints[1], "query and source port should not change the fingerprint")
+	require.NotEqual(t, fingerprints[0], fingerprints[2], "different paths should differ")
+	require.NotEqual(t, fingerprints[0], fingerprints[3], "different remote addresses should differ")
+
+	expected := sha256.Sum256([]byte("http\n192.0.2.1\n/callback\n"))
+	require.Equal(t, hex.EncodeToString(expected[:]), fingerprints[0])
+}
+
+func TestInteractionQueryParams(t *testing.T) {
+	opts := &Options{Domains: []string{"example.com"}, CorrelationIdLength: 10, CorrelationIdNonceLength: 3}
+	store := newTestStore(t, opts, "abcdefghij")
+	h := &HTTPServer{options: opts}
+
+	for _, target := range []string{"/exfil?user=admin&token=a&token=b&empty=", "/exfil"} {
+		req := httptest.NewRequest("GET", target, nil)
+		req.Host = "abcdefghijklm.example.com"
+		h.logger(http.HandlerFunc(h.defaultHandler)).ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	data, err := store.GetInteractionsWithIdForConsumer("abcdefghij", "consumer")
+	require.NoError(t, err)
+	require.Len(t, data, 2)
+	interaction := &Interaction{}
+	require.NoError(t, jsoniter.UnmarshalFromString(data[0], interaction))
+	require.Equal(t, map[string][]string{"user": {"admin"}, "token": {"a", "b"}, "empty": {""}}, interaction.QueryParams)
+
+	require.NotContains(t, data[1], "query-params", "requests without query should omit the field")
+}
+
+func TestInteractionDecodedData(t *testing.T) {
+	opts := &Options{Domains: []string{"example.com"}, CorrelationIdLength: 10, CorrelationIdNonceLength: 3, DecodeExfil: true}
+	store := newTestStore(t, opts, "abcdefghij")
+	h := &HTTPServer{options: opts}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Host = "dGVzdA.abcdefghijklm.example.com"
+	h.logger(http.HandlerFunc(h.defaultHandler)).ServeHTTP(httptest.NewRecorder(), req)
+
+	data, err := store.GetInteractionsWithIdForConsumer("abcdefghij", "consumer")
+	require.NoError(t, err)
+	require.Len(t, data, 1)
+	interaction := &Interaction{}
+	require.NoError(t, jsoniter.UnmarshalFromString(data[0], interaction))
+	require.Equal(t, "test", interaction.DecodedData)
+}
+
+func TestInteractionReceivedAt(t *testing.T) {
+	opts := &Options{Domains: []string{"example.com"}, CorrelationIdLength: 10, CorrelationIdNonceLength: 3, DynamicResp: true, MaxResponseDelay: 100 * time.Millisecond}
+	store := newTestStore(t, opts, "abcdefghij")
+	h := &HTTPServer{options: opts}
+
+	// the delayed response is processed before the interaction is recorded
+	req := httptest.NewRequest("GET", "/?delay=1", nil)
+	req.Host = "abcdefghijklm.example.com"
+	h.logger(http.HandlerFunc(h.defaultHandler)).ServeHTTP(httptest.NewRecorder(), req)
+
+	data, err := store.GetInteractionsWithIdForConsumer("abcdefghij", "consumer")
+	require.NoError(t, err)
+	require.Len(t, data, 1)
+	interaction := &Interaction{}
+	require.NoError(t, jsoniter.UnmarshalFromString(data[0], interaction))
+	require.False(t, interaction.ReceivedAt.IsZero())
+	require.False(t, interaction.Timestamp.IsZero())
+	require.False(t, interaction.ReceivedAt.After(interaction.Timestamp))
+	require.GreaterOrEqual(t, interaction.Timestamp.Sub(interaction.ReceivedAt), 100*time.Millisecond)
+}
+
+// slowReader delays each read of the wrapped reader
+type slowReader struct {
+	io.Reader
+	delay time.Duration
+}
+
+func (r *slowReader) Read(p []byte) (int, error) {
+	time.Sleep(r.delay)
+	return r.Reader.Read(p[:min(len(p), 4)])
+}
+
+func TestInteractionRequestReadMs(t *testing.T) {
+	opts := &Options{Domains: []string{"example.com"}, CorrelationIdLength: 10, CorrelationIdNonceLength: 3}
+	store := newTestStore(t, opts, "abcdefghij")
+	h := &HTTPServer{options: opts}
+
+	req := httptest.NewRequest("POST", "/", &slowReader{Reader: strings.NewReader("slow sender body"), delay: 10 * time.Millisecond})
+	req.Host = "abcdefghijklm.example.com"
+	w := httptest.NewRecorder()
+	h.logger(http.HandlerFunc(h.defaultHandler)).ServeHTTP(w, req)
+
+	data, err := store.GetInteractionsWithIdForConsumer("abcdefghij", "consumer")
+	require.NoError(t, err)
+	require.Len(t, data, 1)
+	interaction := &Interaction{}
+	require.NoError(t, jsoniter.UnmarshalFromString(data[0], interaction))
+	require.Contains(t, interaction.RawRequest, "slow sender body")
+	// the body is read in 4 byte chunks, each delayed by 10ms
+	require.GreaterOrEqual(t, interaction.RequestReadMs, int64(40))
+}
+
+func TestInteractionSNI(t *testing.T) {
+	opts := &Options{Domains: []string{"example.com"}, CorrelationIdLength: 10, CorrelationIdNonceLength: 3}
+	store := newTestStore(t, opts, "abcdefghij")
+	h := &HTTPServer{options: opts}
+
+	ts := httptest.NewUnstartedServer(h.logger(http.HandlerFunc(h.defaultHandler)))
+	ts.TLS = &tls.Config{Certificates: []tls.Certificate{newTestCertificate(t)}}
+	ts.StartTLS()
+	defer ts.Close()
+
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{ServerName: "sni.example.net", InsecureSkipVerify: true},
+	}}
+	req, err := http.NewRequest("GET", ts.URL, nil)
+	require.NoError(t, err)
+	req.Host = "abcdefghijklm.example.com"
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	data, err := store.GetInteractionsWithIdForConsumer("abcdefghij", "consumer")
+	require.NoError(t, err)
+	require.Len(t, data, 1)
+	interaction := &Interaction{}
+	require.NoError(t, jsoniter.UnmarshalFromString(data[0], interaction))
+	require.Equal(t, "https", interaction.Protocol)
+	require.Equal(t, "sni.example.net", interaction.SNI)
+	require.Contains(t, interaction.RawRequest, "Host: abcdefghijklm.example.com")
+}
+
+func TestInteractionClientCertificate(t *testing.T) {
+	opts := &Options{Domains: []string{"example.com"}, CorrelationIdLength: 10, CorrelationIdNonceLength: 3, CaptureClientCert: true}
+	store := newTestStore(t, opts, "abcdefghij")
+	h := &HTTPServer{options: opts}
+
+	ts := httptest.NewUnstartedServer(h.logger(http.HandlerFunc(h.defaultHandler)))
+	ts.TLS = h.applyTLSOptions(&tls.Config{Certificates: []tls.Certificate{newTestCertificate(t)}})
+	ts.StartTLS()
+	defer ts.Close()
+
+	send := func(certificates []tls.Certificate) *Interaction {
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true, Certificates: certificates},
+		}}
+		req, err := http.NewRequest("GET", ts.URL, nil)
+		require.NoError(t, err)
+		req.Host = "abcdefghijklm.example.com"
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+
+		data, err := store.GetInteractionsWithIdForConsumer("abcdefghij", "consumer")
+		require.NoError(t, err)
+		require.Len(t, data, 1)
+		interaction := &Interaction{}
+		require.NoError(t, jsoniter.UnmarshalFromString(data[0], interaction))
+		return interaction
+	}
+
+	// the self-signed client certificate is recorded without being verified
+	certificate := newTestCertificate(t)
+	interaction := send([]tls.Certificate{certificate})
+	fingerprint := sha256.Sum256(certificate.Certificate[0])
+	require.Equal(t, "CN=example.com", interaction.ClientCertSubject)
+	require.Equal(t, hex.EncodeToString(fingerprint[:]), interaction.ClientCertFingerprint)
+
+	// clients without a certificate are still served
+	interaction = send(nil)
+	require.Empty(t, interaction.ClientCertSubject)
+	require.Empty(t, interaction.ClientCertFingerprint)
+}
+
+func TestInteractionJWTClaims(t *testing.T) {
+	opts := &Options{Domains: []string{"example.com"}, CorrelationIdLength: 10, CorrelationIdNonceLength: 3, DecodeJWT: true}
+	store := newTestStore(t, opts, "abcdefghij")
+	h := &HTTPServer{options: opts}
+
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+	claims := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"admin","role":"superuser"}`))
+	signature := "c2lnbmF0dXJlLXNob3VsZC1ub3QtbGVhaw"
+	token := header + "." + claims + "." + signature
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Host = "abcdefghijklm.example.com"
+	req.Header.Set("Authorization", "Bearer "+token)
+	h.logger(http.HandlerFunc(h.defaultHandler)).ServeHTTP(httptest.NewRecorder(), req)
+
+	data, err := store.GetInteractionsWithIdForConsumer("abcdefghij", "consumer")
+	require.NoError(t, err)
+	require.Len(t, data, 1)
+	require.NotContains(t, data[0], signature, "the jwt signature should be redacted")
+
+	interaction := &Interaction{}
+	require.NoError(t, jsoniter.UnmarshalFromString(data[0], interaction))
+	require.NotNil(t, interaction.JWTClaims)
+	require.Equal(t, "HS256", interaction.JWTClaims.Header["alg"])
+	require.Equal(t, "admin", interaction.JWTClaims.Claims["sub"])
+	require.Equal(t, "superuser", interaction.JWTClaims.Claims["role"])
+	require.Contains(t, interaction.RawRequest, "Bearer "+header+"."+claims+".[redacted]")
+}
+
+func TestRecordMethods(t *testing.T) {
+	opts := &Options{Domains: []string{"example.com"}, CorrelationIdLength: 10, CorrelationIdNonceLength: 3, RecordMethods: []string{"get", "POST"}}
+	store := newTestStore(t, opts, "abcdefghij")
+	h := &HTTPServer{options: opts}
+
+	send := func(method string) *http.Response {
+		req := httptest.NewRequest(method, "/", nil)
+		req.Host = "abcdefghijklm.example.com"
+		w := httptest.NewRecorder()
+		h.logger(http.HandlerFunc(h.defaultHandler)).ServeHTTP(w, req)
+		return w.Result()
+	}
+
+	// the unlisted methods are answered but not recorded
+	for _, method := range []string{http.MethodHead, http.MethodOptions} {
+		require.Equal(t, http.StatusOK, send(method).StatusCode)
+	}
+	data, err := store.GetInteractionsWithIdForConsumer("abcdefghij", "consumer")
+	require.NoError(t, err)
+	require.Empty(t, data)
+	require.Equal(t, uint64(2), opts.Stats.HttpSkipped)
+
+	require.Equal(t, http.StatusOK, send(http.MethodGet).StatusCode)
+	data, err = store.GetInteractionsWithIdForConsumer("abcdefghij", "consumer")
+	require.NoError(t, err)
+	require.Len(t, data, 1)
+	require.Contains(t, data[0], `"raw-request":"GET /`)
+}
+
+func TestServerHeaderPool(t *testing.T) {
+	opts := &Options{Domains: []string{"example.com"}, CorrelationIdLength: 10, CorrelationIdNonceLength: 3}
+	newTestStore(t, opts, "abcdefghij")
+	h := &HTTPServer{options: opts}
+
+	serverHeader := func() string {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Host = "abcdefghijklm.example.com"
+		w := httptest.NewRecorder()
+		h.defaultHandler(w, req)
+		return w.Result().Header.Get("Server")
+	}
+	require.Equal(t, "example.com", serverHeader())
+
+	pool := []string{"nginx", "Apache/2.4.41 (Ubuntu)", "Microsoft-IIS/10.0"}
+	opts.ServerHeaderPool = pool
+	opts.ServerHeaderStrategy = ServerHeaderStrategyRoundRobin
+	var headers []string
+	for i := 0; i < 4; i++ {
+		headers = append(headers, serverHeader())
+	}
+	require.Equal(t, []string{"nginx", "Apache/2.4.41 (Ubuntu)", "Microsoft-IIS/10.0", "nginx"}, headers)
+
+	opts.ServerHeaderStrategy = ServerHeaderStrategyRandom
+	seen := make(map[string]struct{})
+	for i := 0; i < 50; i++ {
+		header := serverHeader()
+		require.Contains(t, pool, header)
+		seen[header] = struct{}{}
+	}
+	require.Greater(t, len(seen), 1, "random server headers should vary")
+}
+
+func TestRequireCorrelationID(t *testing.T) {
+	opts := &Options{Domains: []string{"example.com"}, CorrelationIdLength: 10, CorrelationIdNonceLength: 3, RequireCorrelationID: true, UnmatchedResponse: "not found"}
+	store := newTestStore(t, opts, "abcdefghij")
+	h := &HTTPServer{options: opts}
+
+	send := func(host string) *http.Response {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Host = host
+		w := httptest.NewRecorder()
+		h.logger(http.HandlerFunc(h.defaultHandler)).ServeHTTP(w, req)
+		return w.Result()
+	}
+
+	resp := send("abcdefghijklm.example.com")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	body, _ := io.ReadAll(resp.Body)
+	require.Contains(t, string(body), "mlkjihgfedcba", "correlation requests should get the regular response")
+
+	for _, host := range []string{"example.com", "www.example.com"} {
+		resp = send(host)
+		require.Equal(t, http.StatusNotFound, resp.StatusCode)
+		body, _ = io.ReadAll(resp.Body)
+		require.Equal(t, "not found", string(body))
+	}
+
+	data, err := store.GetInteractionsWithIdForConsumer("abcdefghij", "consumer")
+	require.NoError(t, err)
+	require.Len(t, data, 1)
+
+	t.Run("close", func(t *testing.T) {
+		opts.UnmatchedResponse = ""
+		defer func() { opts.UnmatchedResponse = "not found" }()
+		ts := httptest.NewServer(h.logger(http.HandlerFunc(h.defaultHandler)))
+		defer ts.Close()
+
+		req, err := http.NewRequest("GET", ts.URL, nil)
+		require.NoError(t, err)
+		req.Host = "example.com"
+		_, err = http.DefaultClient.Do(req)
+		require.Error(t, err, "connection should be closed")
+
+		req.Host = "abcdefghijklm.example.com"
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+}
+
+func TestTraceAndConnect(t *testing.T) {
+	opts := &Options{Domains: []string{"example.com"}, CorrelationIdLength: 10, CorrelationIdNonceLength: 3}
+	store := newTestStore(t, opts, "abcdefghij")
+	h, err := NewHTTPServer(opts)
+	require.NoError(t, err)
+
+	interactions := func() []*Interaction {
+		data, err := store.GetInteractionsWithIdForConsumer("abcdefghij", "consumer")
+		require.NoError(t, err)
+		var interactions []*Interaction
+		for _, item := range data {
+			interaction := &Interaction{}
+			require.NoError(t, jsoniter.UnmarshalFromString(item, interaction))
+			interactions = append(interactions, interaction)
+		}
+		return interactions
+	}
+	trace := func() *http.Response {
+		req := httptest.NewRequest("TRACE", "/probe", nil)
+		req.Host = "abcdefghijklm.example.com"
+		req.Header.Set("X-Probe", "value")
+		w := httptest.NewRecorder()
+		h.nontlsserver.Handler.ServeHTTP(w, req)
+		return w.Result()
+	}
+
+	t.Run("trace refused", func(t *testing.T) {
+		require.Equal(t, http.StatusMethodNotAllowed, trace().StatusCode)
+		recorded := interactions()
+		require.Len(t, recorded, 1)
+		require.Contains(t, recorded[0].RawRequest, "TRACE /probe")
+	})
+	t.Run("trace echo", func(t *testing.T) {
+		opts.EnableTrace = true
+		defer func() { opts.EnableTrace = false }()
+		resp := trace()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Equal(t, "message/http", resp.Header.Get("Content-Type"))
+		body, _ := io.ReadAll(resp.Body)
+		require.Contains(t, string(body), "TRACE /probe HTTP/1.1")
+		require.Contains(t, string(body), "X-Probe: value")
+		recorded := interactions()
+		require.Len(t, recorded, 1)
+		require.Contains(t, recorded[0].RawResponse, "X-Probe: value")
+	})
+	t.Run("connect", func(t *testing.T) {
+		req := httptest.NewRequest("CONNECT", "abcdefghijklm.example.com:443", nil)
+		w := httptest.NewRecorder()
+		h.nontlsserver.Handler.ServeHTTP(w, req)
+		resp := w.Result()
+		require.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+		require.Equal(t, "close", resp.Header.Get("Connection"))
+		recorded := interactions()
+		require.Len(t, recorded, 1)
+		require.Equal(t, "abcdefghijklm", recorded[0].UniqueID)
+		require.Contains(t, recorded[0].RawRequest, "CONNECT abcdefghijklm.example.com:443")
+	})
+}
+
+func TestPollErrorRate(t *testing.T) {
+	opts := &Options{}
+	store := newTestStore(t, opts)
+	require.NoError(t, store.SetIDPublicKey("abcdefghij", "secret", newTestPublicKey(t)))
+	require.NoError(t, store.AddInteraction("abcdefghij", []byte("interaction")))
+	h := &HTTPServer{options: opts}
+
+	poll := func() *http.Response {
+		req := httptest.NewRequest("GET", "/poll?id=abcdefghij&secret=secret", nil)
+		w := httptest.NewRecorder()
+		h.pollHandler(w, req)
+		return w.Result()
+	}
+
+	opts.PollErrorRate = 0.3
+	failures := 0
+	const polls = 2000
+	for i := 0; i < polls; i++ {
+		resp := poll()
+		if resp.StatusCode == http.StatusServiceUnavailable {
+			require.Equal(t, "1", resp.Header.Get("Retry-After"))
+			failures++
+			continue
+		}
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+	rate := float64(failures) / polls
+	require.InDelta(t, 0.3, rate, 0.05, "observed error rate %f", rate)
+
+	// simulated errors never drain stored interactions
+	require.NoError(t, store.AddInteraction("abcdefghij", []byte("interaction")))
+	opts.PollErrorRate = 1
+	for i := 0; i < 10; i++ {
+		require.Equal(t, http.StatusServiceUnavailable, poll().StatusCode)
+	}
+	opts.PollErrorRate = 0
+	resp := poll()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	response := &PollResponse{}
+	require.NoError(t, jsoniter.NewDecoder(resp.Body).Decode(response))
+	require.Len(t, response.Data, 1)
+}
+
+func TestScanCookies(t *testing.T) {
+	opts := &Options{Domains: []string{"example.com"}, CorrelationIdLength: 10, CorrelationIdNonceLength: 3, ScanCookies: []string{"session"}}
+	store := newTestStore(t, opts, "abcdefghij")
+	h := &HTTPServer{options: opts}
+
+	send := func(cookie *http.Cookie) {
+		req := httptest.NewRequest("GET", "/callback", nil)
+		req.Host = "example.com"
+		req.AddCookie(cookie)
+		h.logger(http.HandlerFunc(h.defaultHandler)).ServeHTTP(httptest.NewRecorder(), req)
+	}
+	send(&http.Cookie{Name: "other", Value: "abcdefghijklm"})
+	data, err := store.GetInteractionsWithIdForConsumer("abcdefghij", "consumer")
+	require.NoError(t, err)
+	require.Empty(t, data, "cookies not configured should not be scanned")
+
+	send(&http.Cookie{Name: "session", Value: "xss-abcdefghijklm"})
+	data, err = store.GetInteractionsWithIdForConsumer("abcdefghij", "consumer")
+	require.NoError(t, err)
+	require.Len(t, data, 1)
+	interaction := &Interaction{}
+	require.NoError(t, jsoniter.UnmarshalFromString(data[0], interaction))
+	require.Equal(t, "abcdefghijklm", interaction.UniqueID)
+	require.Equal(t, "xss-abcdefghijklm", interaction.FullId)
+	require.Contains(t, interaction.RawRequest, "session=xss-abcdefghijklm")
+}
+
+func TestScanFormFields(t *testing.T) {
+	opts := &Options{Domains: []string{"example.com"}, CorrelationIdLength: 10, CorrelationIdNonceLength: 3, ScanFormFields: true}
+	store := newTestStore(t, opts, "abcdefghij")
+	h := &HTTPServer{options: opts}
+
+	send := func(contentType, body string) *Interaction {
+		req := httptest.NewRequest("POST", "/callback", strings.NewReader(body))
+		req.Host = "example.com"
+		req.Header.Set("Content-Type", contentType)
+		h.logger(http.HandlerFunc(h.defaultHandler)).ServeHTTP(httptest.NewRecorder(), req)
+
+		data, err := store.GetInteractionsWithIdForConsumer("abcdefghij", "consumer")
+		require.NoError(t, err)
+		if len(data) == 0 {
+			return nil
+		}
+		require.Len(t, data, 1)
+		interaction := &Interaction{}
+		require.NoError(t, jsoniter.UnmarshalFromString(data[0], interaction))
+		return interaction
+	}
+
+	interaction := send("application/x-www-form-urlencoded", "name=test&callback=xss-abcdefghijklm")
+	require.NotNil(t, interaction)
+	require.Equal(t, "abcdefghijklm", interaction.UniqueID)
+	require.Equal(t, "xss-abcdefghijklm", interaction.FullId)
+	require.Contains(t, interaction.RawRequest, "callback=xss-abcdefghijklm", "body should be recorded")
+
+	multipart := "--boundary\r\nContent-Disposition: form-data; name=\"callback\"\r\n\r\nabcdefghijklm\r\n--boundary--\r\n"
+	interaction = send("multipart/form-data; boundary=boundary", multipart)
+	require.NotNil(t, interaction)
+	require.Equal(t, "abcdefghijklm", interaction.UniqueID)
+
+	require.Nil(t, send("text/plain", "callback=abcdefghijklm"), "non form bodies should not be scanned")
+}
+
+func TestCorrelationPosition(t *testing.T) {
+	for _, tc := range []struct {
+		position string
+		host     string
+		matched  bool
+	}{
+		{CorrelationPositionAnywhere, "abcdefghijklm.example.com", true},
+		{CorrelationPositionAnywhere, "foo.abcdefghijklm.example.com", true},
+		{CorrelationPositionLeftmost, "ABCDEFGHIJKLM.example.com", true},
+		{CorrelationPositionLeftmost, "foo.abcdefghijklm.example.com", false},
+		{CorrelationPositionLeftmost, "xabcdefghijklm.example.com", false},
+	} {
+		opts := &Options{Domains: []string{"example.com"}, CorrelationIdLength: 10, CorrelationIdNonceLength: 3, CorrelationPosition: tc.position}
+		store := newTestStore(t, opts, "abcdefghij")
+		h := &HTTPServer{options: opts}
+
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Host = tc.host
+		h.logger(http.HandlerFunc(h.defaultHandler)).ServeHTTP(httptest.NewRecorder(), req)
+
+		data, err := store.GetInteractionsWithIdForConsumer("abcdefghij", "consumer")
+		require.NoError(t, err)
+		if !tc.matched {
+			require.Empty(t, data, "%s %s", tc.position, tc.host)
+			continue
+		}
+		require.Len(t, data, 1, "%s %s", tc.position, tc.host)
+		interaction := &Interaction{}
+		require.NoError(t, jsoniter.UnmarshalFromString(data[0], interaction))
+		require.Equal(t, "abcdefghijklm", interaction.UniqueID)
+	}
+}
+
+func BenchmarkCorrelationPosition(b *testing.B) {
+	labels := make([]string, 0, 32)
+	for i := 0; i < 30; i++ {
+		labels = append(labels, strings.Repeat("ab-", 13))
+	}
+	host := "abcdefghijklm." + strings.Join(labels, ".") + ".example.com"
+
+	for _, position := range []string{CorrelationPositionAnywhere, CorrelationPositionLeftmost} {
+		b.Run(position, func(b *testing.B) {
+			opts := &Options{Domains: []string{"example.com"}, CorrelationIdLength: 10, CorrelationIdNonceLength: 3, CorrelationPosition: position}
+			store, err := storage.New(&storage.Options{})
+			require.NoError(b, err)
+			defer store.Close()
+			require.NoError(b, store.SetID("abcdefghij"))
+			opts.Storage = store
+			opts.Stats = &Metrics{}
+			h := &HTTPServer{options: opts}
+			handler := h.logger(http.HandlerFunc(h.defaultHandler))
+
+			req := httptest.NewRequest("GET", "/"+strings.Join(labels, "/"), nil)
+			req.Host = host
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				handler.ServeHTTP(httptest.NewRecorder(), req)
+			}
+		})
+	}
+}
+
+func TestMaxURLLength(t *testing.T) {
+	opts := &Options{Domains: []string{"example.com"}, CorrelationIdLength: 10, CorrelationIdNonceLength: 3, MaxURLLength: 64}
+	store := newTestStore(t, opts, "abcdefghij")
+	h := &HTTPServer{options: opts}
+
+	longURL := "/abcdefghijklm/" + strings.Repeat("A", 4096)
+	req := httptest.NewRequest("GET", longURL, nil)
+	req.Host = "example.com"
+	req.Header.Set("User-Agent", "probe")
+	w := httptest.NewRecorder()
+	h.logger(http.HandlerFunc(h.defaultHandler)).ServeHTTP(w, req)
+	require.Equal(t, http.StatusRequestURITooLong, w.Result().StatusCode)
+
+	data, err := store.GetInteractionsWithIdForConsumer("abcdefghij", "consumer")
+	require.NoError(t, err)
+	require.Len(t, data, 1, "correlation id should be found in the truncated url")
+	interaction := &Interaction{}
+	require.NoError(t, jsoniter.UnmarshalFromString(data[0], interaction))
+	require.Contains(t, interaction.RawRequest, "GET "+longURL[:64]+" HTTP/1.1")
+	require.NotContains(t, interaction.RawRequest, longURL[:65])
+	require.NotContains(t, interaction.RawRequest, "probe", "headers should not be recorded")
+	require.Contains(t, interaction.RawResponse, "414")
+}
+
+func TestAuthVerifier(t *testing.T) {
+	opts := &Options{Auth: true, Token: "static-token"}
+	h := &HTTPServer{options: opts}
+	handler := h.authMiddleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	request := func(authorization string) int {
+		req := httptest.NewRequest("GET", "/poll", nil)
+		req.Header.Set("Authorization", authorization)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Result().StatusCode
+	}
+
+	// static token is used by default
+	require.Equal(t, http.StatusOK, request("static-token"))
+	require.Equal(t, http.StatusUnauthorized, request("Bearer valid-jwt"))
+
+	opts.AuthVerifier = func(req *http.Request) bool {
+		return req.Header.Get("Authorization") == "Bearer valid-jwt"
+	}
+	require.Equal(t, http.StatusOK, request("Bearer valid-jwt"))
+	require.Equal(t, http.StatusUnauthorized, request("Bearer expired-jwt"))
+	require.Equal(t, http.StatusUnauthorized, request("static-token"), "verifier should replace the static token")
+}
+
+func TestMultipleTokens(t *testing.T) {
+	opts := &Options{Auth: true, Token: "static-token", Tokens: map[string]string{"alice-token": "alice", "bob-token": "bob"}, Stats: &Metrics{}}
+	h := &HTTPServer{options: opts}
+	handler := h.authMiddleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	request := func(authorization string) int {
+		req := httptest.NewRequest("GET", "/poll", nil)
+		req.Header.Set("Authorization", authorization)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Result().StatusCode
+	}
+
+	require.Equal(t, http.StatusOK, request("static-token"), "the single token should keep working")
+	require.Equal(t, http.StatusOK, request("alice-token"))
+	require.Equal(t, http.StatusOK, request("alice-token"))
+	require.Equal(t, http.StatusOK, request("bob-token"))
+	require.Equal(t, http.StatusUnauthorized, request("eve-token"))
+	require.Equal(t, http.StatusUnauthorized, request(""))
+	require.Equal(t, map[string]uint64{"alice": 2, "bob": 1}, opts.Stats.tokenUsage())
+
+	// revoking a token only affects its user
+	delete(opts.Tokens, "alice-token")
+	require.Equal(t, http.StatusUnauthorized, request("alice-token"))
+	require.Equal(t, http.StatusOK, request("bob-token"))
+
+	var buf bytes.Buffer
+	writePrometheusMetrics(&buf, &Metrics{TokenUsage: opts.Stats.tokenUsage()})
+	require.Contains(t, buf.String(), "interactsh_token_requests_total{token=\"alice\"} 2\ninteractsh_token_requests_total{token=\"bob\"} 2\n")
+}
+
+func TestRegisterRetention(t *testing.T) {
+	opts := &Options{MaxRetention: time.Hour}
+	store := newTestStore(t, opts)
+	h := &HTTPServer{options: opts}
+	publicKey := newTestPublicKey(t)
+
+	register := func(correlationID string, retentionSeconds int) {
+		body, err := jsoniter.Marshal(&RegisterRequest{PublicKey: publicKey, SecretKey: "secret", CorrelationID: correlationID, RetentionSeconds: retentionSeconds})
+		require.NoError(t, err)
+		w := httptest.NewRecorder()
+		h.registerHandler(w, httptest.NewRequest("POST", "/register", strings.NewReader(string(body))))
+		require.Equal(t, http.StatusOK, w.Result().StatusCode)
+	}
+	register("shortretention", 60)
+	register("longretention", 7200)
+	register("noretention", 0)
+
+	for id, expected := range map[string]time.Duration{"shortretention": time.Minute, "longretention": time.Hour, "noretention": 0} {
+		item, err := store.GetCacheItem(id)
+		require.NoError(t, err)
+		require.Equal(t, expected, item.Retention, id)
+	}
+}
+
+func TestRegisterSessionMetadata(t *testing.T) {
+	opts := newTestOptions([]string{"192.0.2.50"}, "127.0.0.1")
+	opts.CorrelationIdLength = 10
+	opts.SessionMetadata = true
+	store := newTestStore(t, opts)
+	h := &HTTPServer{options: opts}
+	publicKey := newTestPublicKey(t)
+
+	metadata := map[string]string{"scan-id": "1234", "target": "https://target.example.org/"}
+	for correlationID, metadata := range map[string]map[string]string{"abcdefghij": metadata, "klmnopqrst": nil} {
+		body, err := jsoniter.Marshal(&RegisterRequest{PublicKey: publicKey, SecretKey: "secret", CorrelationID: correlationID, Metadata: metadata})
+		require.NoError(t, err)
+		w := httptest.NewRecorder()
+		h.registerHandler(w, httptest.NewRequest("POST", "/register", strings.NewReader(string(body))))
+		require.Equal(t, http.StatusOK, w.Result().StatusCode)
+	}
+
+	var interactions []*Interaction
+	opts.OnResult = func(out interface{}) {
+		interactions = append(interactions, out.(*Interaction))
+	}
+	dnsServer := NewDNSServer("udp", opts)
+	for _, name := range []string{"abcdefghij.example.com.", "klmnopqrst.example.com."} {
+		req := new(dns.Msg)
+		req.SetQuestion(name, dns.TypeA)
+		dnsServer.ServeDNS(&testResponseWriter{}, req)
+	}
+
+	require.Len(t, interactions, 2)
+	require.Equal(t, metadata, interactions[0].SessionMetadata)
+	require.Nil(t, interactions[1].SessionMetadata)
+	item, err := store.GetCacheItem("abcdefghij")
+	require.NoError(t, err)
+	require.Equal(t, metadata, item.Metadata)
+}
+
+func TestRegisterRateLimit(t *testing.T) {
+	opts := &Options{RegisterRateLimit: 3}
+	newTestStore(t, opts)
+	h := &HTTPServer{options: opts}
+	publicKey := newTestPublicKey(t)
+
+	register := func(correlationID, remoteAddr string) *http.Response {
+		body, err := jsoniter.Marshal(&RegisterRequest{PublicKey: publicKey, SecretKey: "secret", CorrelationID: correlationID})
+		require.NoError(t, err)
+		req := httptest.NewRequest("POST", "/register", strings.NewReader(string(body)))
+		req.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		h.registerHandler(w, req)
+		return w.Result()
+	}
+	for i := 0; i < 3; i++ {
+		require.Equal(t, http.StatusOK, register(fmt.Sprintf("session%d", i), fmt.Sprintf("198.51.100.7:%d", 40000+i)).StatusCode)
+	}
+	resp := register("session3", "198.51.100.7:40003")
+	require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	require.Equal(t, "60", resp.Header.Get("Retry-After"))
+
+	// other source ips are throttled separately
+	require.Equal(t, http.StatusOK, register("session4", "203.0.113.9:40000").StatusCode)
+
+	// the window of the throttled ip restarts after a minute
+	h.registrations["198.51.100.7"].start = time.Now().Add(-registrationWindowSize)
+	require.Equal(t, http.StatusOK, register("session5", "198.51.100.7:40005").StatusCode)
+}
+
+func TestRequireTLSForAPI(t *testing.T) {
+	opts := &Options{Domains: []string{"example.com"}, CorrelationIdLength: 10, CorrelationIdNonceLength: 3, RequireTLSForAPI: true}
+	newTestStore(t, opts, "abcdefghij")
+	h, err := NewHTTPServer(opts)
+	require.NoError(t, err)
+
+	plain := httptest.NewServer(h.nontlsserver.Handler)
+	defer plain.Close()
+	secure := httptest.NewUnstartedServer(h.tlsserver.Handler)
+	secure.TLS = &tls.Config{Certificates: []tls.Certificate{newTestCertificate(t)}}
+	secure.StartTLS()
+	defer secure.Close()
+
+	publicKey := newTestPublicKey(t)
+	register := func(server *httptest.Server, correlationID string) *http.Response {
+		body, err := jsoniter.Marshal(&RegisterRequest{PublicKey: publicKey, SecretKey: "secret", CorrelationID: correlationID})
+		require.NoError(t, err)
+		resp, err := server.Client().Post(server.URL+"/register", "application/json", bytes.NewReader(body))
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp
+	}
+
+	// the api is rejected over plain http and served over tls
+	require.Equal(t, http.StatusBadRequest, register(plain, "klmnopqrst").StatusCode)
+	require.Equal(t, http.StatusOK, register(secure, "klmnopqrst").StatusCode)
+	resp, err := plain.Client().Get(plain.URL + "/poll?id=klmnopqrst&secret=secret")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp, err = secure.Client().Get(secure.URL + "/poll?id=klmnopqrst&secret=secret")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// the session secret sent to /keys is protected as well
+	addKey := func(server *httptest.Server) (int, string) {
+		body, err := jsoniter.Marshal(&PublicKeyRequest{CorrelationID: "klmnopqrst", SecretKey: "secret", PublicKey: newTestPublicKey(t)})
+		require.NoError(t, err)
+		resp, err := server.Client().Post(server.URL+"/keys", "application/json", bytes.NewReader(body))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		data, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, string(data)
+	}
+	status, body := addKey(plain)
+	require.Equal(t, http.StatusBadRequest, status)
+	require.Contains(t, body, "tls is required")
+	status, _ = addKey(secure)
+	require.Equal(t, http.StatusOK, status)
+
+	// the interaction handlers stay available over plain http
+	req, err := http.NewRequest("GET", plain.URL, nil)
+	require.NoError(t, err)
+	req.Host = "abcdefghijklm.example.com"
+	resp, err = plain.Client().Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+// newTestPublicKey returns a base64 encoded PEM RSA public key as sent by clients on registration
+func newTestPublicKey(t *testing.T) string {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	pubBytes, err := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	require.NoError(t, err)
+	pubPem := pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: pubBytes})
+	return base64.StdEncoding.EncodeToString(pubPem)
+}
+
+// newTestCertificate returns a self-signed certificate for 127.0.0.1
+func newTestCertificate(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
 // newTestServer returns a minimal HTTPServer with required fields for handler testing
 func newTestServer() *struct {
 	Server *HTTPServer
 	Close  func()
 } {
-	h := &HTTPServer{}
+	h := &HTTPServer{options: &Options{}}
 	h.dynamicEndpoints = make(map[string]dynamicEndpoint)
 	h.dynMu = sync.RWMutex{}
 	return &struct {
@@ -144,3 +1402,793 @@ func newTestServer() *struct {
 		Close  func()
 	}{Server: h, Close: func() {}}
 }
+
+func TestDeregisterGracePeriod(t *testing.T) {
+	opts := &Options{DeregisterGracePeriod: 300 * time.Millisecond}
+	store := newTestStore(t, opts)
+	require.NoError(t, store.SetIDPublicKey("abcdefghij", "secret", newTestPublicKey(t)))
+	require.NoError(t, store.AddInteraction("abcdefghij", []byte("interaction")))
+	h := &HTTPServer{options: opts}
+
+	deregister := func(secret string) int {
+		body, err := jsoniter.Marshal(&DeregisterRequest{CorrelationID: "abcdefghij", SecretKey: secret})
+		require.NoError(t, err)
+		w := httptest.NewRecorder()
+		h.deregisterHandler(w, httptest.NewRequest("POST", "/deregister", strings.NewReader(string(body))))
+		return w.Result().StatusCode
+	}
+	poll := func() *http.Response {
+		w := httptest.NewRecorder()
+		h.pollHandler(w, httptest.NewRequest("GET", "/poll?id=abcdefghij&secret=secret", nil))
+		return w.Result()
+	}
+
+	require.Equal(t, http.StatusBadRequest, deregister("invalid"), "invalid secret should be rejected")
+	require.Equal(t, http.StatusOK, deregister("secret"))
+
+	// polls within the grace period still drain the buffered interactions
+	resp := poll()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	response := &PollResponse{}
+	require.NoError(t, jsoniter.NewDecoder(resp.Body).Decode(response))
+	require.Len(t, response.Data, 1)
+
+	// the session is removed once the grace period elapses
+	require.Eventually(t, func() bool {
+		_, err := store.GetCacheItem("abcdefghij")
+		return err != nil
+	}, 3*time.Second, 20*time.Millisecond)
+	require.Equal(t, http.StatusBadRequest, poll().StatusCode)
+}
+
+func TestRecordedResponseMatchesSentResponse(t *testing.T) {
+	directory := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(directory, "file.txt"), []byte("static content"), 0o600))
+	opts := &Options{Domains: []string{"example.com"}, CorrelationIdLength: 10, CorrelationIdNonceLength: 3, DynamicResp: true, HTTPDirectory: directory, RootTLD: true}
+	store := newTestStore(t, opts, "abcdefghij", "example.com")
+	h, err := NewHTTPServer(opts)
+	require.NoError(t, err)
+
+	ts := httptest.NewServer(h.nontlsserver.Handler)
+	defer ts.Close()
+	conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// send returns the raw bytes of the response received by the client
+	send := func(host, path string) string {
+		_, err := fmt.Fprintf(conn, "GET %s HTTP/1.1\r\nHost: %s\r\n\r\n", path, host)
+		require.NoError(t, err)
+		raw := &bytes.Buffer{}
+		resp, err := http.ReadResponse(bufio.NewReader(io.TeeReader(conn, raw)), nil)
+		require.NoError(t, err)
+		_, _ = io.ReadAll(resp.Body)
+		return raw.String()
+	}
+
+	// the banner is recorded through the root tld, other paths through the correlation id too
+	banner := send("example.com", "/")
+	require.Contains(t, banner, "Interactsh Server")
+	paths := []string{"/dynamic?status=201&body=created&header=X-Test:value", "/s/file.txt"}
+	var received []string
+	for _, path := range paths {
+		received = append(received, send("abcdefghijklm.example.com", path))
+	}
+
+	rootTLD, err := store.GetInteractionsWithIdForConsumer("example.com", "consumer")
+	require.NoError(t, err)
+	require.Len(t, rootTLD, len(paths)+1)
+	data, err := store.GetInteractionsWithIdForConsumer("abcdefghij", "consumer")
+	require.NoError(t, err)
+	require.Len(t, data, len(paths))
+	for i, raw := range append([]string{banner}, received...) {
+		interaction := &Interaction{}
+		require.NoError(t, jsoniter.UnmarshalFromString(rootTLD[i], interaction))
+		require.Equal(t, raw, interaction.RawResponse)
+	}
+	for i, path := range paths {
+		interaction := &Interaction{}
+		require.NoError(t, jsoniter.UnmarshalFromString(data[i], interaction))
+		require.Equal(t, received[i], interaction.RawResponse, path)
+	}
+}
+
+// blockingStorage holds GetInteractionsBatch calls until released
+type blockingStorage struct {
+	*storage.StorageDB
+	started chan struct{}
+	release chan struct{}
+}
+
+func (s *blockingStorage) GetInteractionsBatch(correlationID, secret string, limit int) ([]string, bool, string, error) {
+	s.started <- struct{}{}
+	<-s.release
+	return s.StorageDB.GetInteractionsBatch(correlationID, secret, limit)
+}
+
+func TestPollRateLimit(t *testing.T) {
+	opts := &Options{PollRateLimit: 1, PollRateBurst: 2}
+	store := newTestStore(t, opts)
+	require.NoError(t, store.SetIDPublicKey("abcdefghij", "secret", newTestPublicKey(t)))
+	require.NoError(t, store.SetIDPublicKey("zyxwvutsrq", "secret", newTestPublicKey(t)))
+	h := &HTTPServer{options: opts}
+
+	poll := func(id string) *http.Response {
+		w := httptest.NewRecorder()
+		h.pollHandler(w, httptest.NewRequest("GET", "/poll?id="+id+"&secret=secret", nil))
+		return w.Result()
+	}
+
+	require.Equal(t, http.StatusOK, poll("abcdefghij").StatusCode)
+	require.Equal(t, http.StatusOK, poll("abcdefghij").StatusCode)
+	resp := poll("abcdefghij")
+	require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	require.Equal(t, "1", resp.Header.Get("Retry-After"))
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Contains(t, string(body), "poll rate limit exceeded")
+
+	// other ids have their own bucket
+	require.Equal(t, http.StatusOK, poll("zyxwvutsrq").StatusCode)
+
+	// polls with a wrong secret don't consume the budget of the session owner
+	for i := 0; i < 5; i++ {
+		w := httptest.NewRecorder()
+		h.pollHandler(w, httptest.NewRequest("GET", "/poll?id=zyxwvutsrq&secret=wrong", nil))
+		require.Equal(t, http.StatusBadRequest, w.Code)
+	}
+	require.Equal(t, http.StatusOK, poll("zyxwvutsrq").StatusCode)
+
+	// the bucket refills over time
+	h.pollBuckets["abcdefghij"].last = time.Now().Add(-time.Second)
+	require.Equal(t, http.StatusOK, poll("abcdefghij").StatusCode)
+	require.Equal(t, http.StatusTooManyRequests, poll("abcdefghij").StatusCode)
+}
+
+func TestMaxConcurrentPolls(t *testing.T) {
+	opts := &Options{MaxConcurrentPolls: 2}
+	store := newTestStore(t, opts)
+	require.NoError(t, store.SetIDPublicKey("abcdefghij", "secret", newTestPublicKey(t)))
+	blocking := &blockingStorage{StorageDB: store, started: make(chan struct{}, 2), release: make(chan struct{})}
+	opts.Storage = blocking
+	h := &HTTPServer{options: opts}
+
+	poll := func() int {
+		w := httptest.NewRecorder()
+		h.pollHandler(w, httptest.NewRequest("GET", "/poll?id=abcdefghij&secret=secret", nil))
+		return w.Result().StatusCode
+	}
+
+	statuses := make(chan int, 2)
+	for i := 0; i < 2; i++ {
+		go func() { statuses <- poll() }()
+	}
+	for i := 0; i < 2; i++ {
+		<-blocking.started
+	}
+
+	// polls over the limit are rejected while the others are in flight
+	require.Equal(t, http.StatusTooManyRequests, poll())
+
+	close(blocking.release)
+	for i := 0; i < 2; i++ {
+		require.Equal(t, http.StatusOK, <-statuses)
+	}
+	// slots are released once the polls complete
+	blocking.started = make(chan struct{}, 1)
+	require.Equal(t, http.StatusOK, poll())
+}
+
+func TestPollAcknowledgment(t *testing.T) {
+	opts := &Options{}
+	store := newTestStore(t, opts)
+	require.NoError(t, store.SetIDPublicKey("abcdefghij", "secret", newTestPublicKey(t)))
+	require.NoError(t, store.AddInteraction("abcdefghij", []byte("first")))
+	require.NoError(t, store.AddInteraction("abcdefghij", []byte("second")))
+	h := &HTTPServer{options: opts}
+
+	poll := func() *PollResponse {
+		w := httptest.NewRecorder()
+		h.pollHandler(w, httptest.NewRequest("GET", "/poll?id=abcdefghij&secret=secret&ack=false", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		response := &PollResponse{}
+		require.NoError(t, jsoniter.NewDecoder(w.Body).Decode(response))
+		return response
+	}
+	ack := func(secret string, tokens []string) int {
+		body, _ := jsoniter.Marshal(&PollAckRequest{CorrelationID: "abcdefghij", SecretKey: secret, Tokens: tokens})
+		w := httptest.NewRecorder()
+		h.pollAckHandler(w, httptest.NewRequest("POST", "/poll/ack", bytes.NewReader(body)))
+		return w.Code
+	}
+
+	response := poll()
+	require.Len(t, response.Data, 2)
+	require.Len(t, response.Tokens, 2)
+
+	// interactions are delivered again until acknowledged
+	response = poll()
+	require.Len(t, response.Data, 2)
+	require.Equal(t, http.StatusBadRequest, ack("wrong", response.Tokens))
+	require.Equal(t, http.StatusOK, ack("secret", response.Tokens[1:]))
+	response = poll()
+	require.Len(t, response.Data, 1)
+
+	require.Equal(t, http.StatusOK, ack("secret", response.Tokens))
+	response = poll()
+	require.Empty(t, response.Data)
+	require.Empty(t, response.Tokens)
+
+	// regular polls drain the interactions without tokens
+	require.NoError(t, store.AddInteraction("abcdefghij", []byte("third")))
+	w := httptest.NewRecorder()
+	h.pollHandler(w, httptest.NewRequest("GET", "/poll?id=abcdefghij&secret=secret", nil))
+	require.NotContains(t, w.Body.String(), "tokens")
+	require.Empty(t, poll().Data)
+}
+
+func TestCorsOrigins(t *testing.T) {
+	cors := func(originURLs []string, origin string) http.Header {
+		h := &HTTPServer{options: &Options{OriginURLs: originURLs}}
+		req := httptest.NewRequest("OPTIONS", "/poll", nil)
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		w := httptest.NewRecorder()
+		h.corsMiddleware(http.NotFoundHandler()).ServeHTTP(w, req)
+		require.Equal(t, http.StatusNoContent, w.Code)
+		return w.Header()
+	}
+
+	// a single origin is sent as is
+	require.Equal(t, "*", cors([]string{"*"}, "https://app.example.com").Get("Access-Control-Allow-Origin"))
+	header := cors([]string{"https://dashboard.example.com"}, "https://other.com")
+	require.Equal(t, "https://dashboard.example.com", header.Get("Access-Control-Allow-Origin"))
+	require.Empty(t, header.Get("Vary"))
+
+	origins := []string{"https://dashboard.example.com", "https://*.example.org", "chrome-extension://abcdef"}
+	for origin, allowed := range map[string]bool{
+		"https://dashboard.example.com": true,
+		"https://app.example.org":       true,
+		"https://a.b.example.org":       true,
+		"chrome-extension://abcdef":     true,
+		"https://example.org":           false,
+		"https://app.example.org.evil":  false,
+		"http://dashboard.example.com":  false,
+		"":                              false,
+	} {
+		header := cors(origins, origin)
+		require.Equal(t, "Origin", header.Get("Vary"))
+		if allowed {
+			require.Equal(t, origin, header.Get("Access-Control-Allow-Origin"), origin)
+		} else {
+			require.NotContains(t, header, "Access-Control-Allow-Origin", origin)
+		}
+	}
+	require.Equal(t, "https://app.example.org", cors([]string{"https://*.example.org"}, "https://app.example.org").Get("Access-Control-Allow-Origin"))
+
+	// a bare wildcard among other origins allows any origin
+	header = cors([]string{"https://dashboard.example.com", "*"}, "https://other.com")
+	require.Equal(t, "https://other.com", header.Get("Access-Control-Allow-Origin"))
+	require.Equal(t, "Origin", header.Get("Vary"))
+
+	// the deprecated OriginURL is used when OriginURLs is empty
+	h := &HTTPServer{options: &Options{OriginURL: "https://dashboard.example.com"}}
+	w := httptest.NewRecorder()
+	h.corsMiddleware(http.NotFoundHandler()).ServeHTTP(w, httptest.NewRequest("OPTIONS", "/poll", nil))
+	require.Equal(t, "https://dashboard.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+}
+
+func TestPollBatchLimit(t *testing.T) {
+	opts := &Options{MaxPollBatch: 3}
+	store := newTestStore(t, opts)
+	require.NoError(t, store.SetIDPublicKey("abcdefghij", "secret", newTestPublicKey(t)))
+	for i := 0; i < 5; i++ {
+		require.NoError(t, store.AddInteraction("abcdefghij", []byte(strconv.Itoa(i))))
+	}
+	h := &HTTPServer{options: opts}
+
+	poll := func(query string) *PollResponse {
+		w := httptest.NewRecorder()
+		h.pollHandler(w, httptest.NewRequest("GET", "/poll?id=abcdefghij&secret=secret"+query, nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		response := &PollResponse{}
+		require.NoError(t, jsoniter.NewDecoder(w.Body).Decode(response))
+		return response
+	}
+
+	// the limit is capped by MaxPollBatch
+	response := poll("&limit=10")
+	require.Len(t, response.Data, 3)
+	require.True(t, response.More)
+	response = poll("&limit=1")
+	require.Len(t, response.Data, 1)
+	require.True(t, response.More)
+	response = poll("")
+	require.Len(t, response.Data, 1)
+	require.False(t, response.More)
+	require.Empty(t, poll("").Data)
+
+	// peeked interactions are limited as well
+	require.NoError(t, store.AddInteraction("abcdefghij", []byte("first")))
+	require.NoError(t, store.AddInteraction("abcdefghij", []byte("second")))
+	response = poll("&ack=false&limit=1")
+	require.Len(t, response.Data, 1)
+	require.Len(t, response.Tokens, 1)
+	require.True(t, response.More)
+
+	w := httptest.NewRecorder()
+	h.pollHandler(w, httptest.NewRequest("GET", "/poll?id=abcdefghij&secret=secret&limit=-1", nil))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestPollCompression(t *testing.T) {
+	opts := &Options{}
+	store := newTestStore(t, opts)
+	require.NoError(t, store.SetIDPublicKey("abcdefghij", "secret", newTestPublicKey(t)))
+	h := &HTTPServer{options: opts}
+	handler := h.compressMiddleware(http.HandlerFunc(h.pollHandler))
+
+	poll := func(acceptEncoding string) *http.Response {
+		require.NoError(t, store.AddInteraction("abcdefghij", []byte(strings.Repeat("interaction", 100))))
+		req := httptest.NewRequest("GET", "/poll?id=abcdefghij&secret=secret", nil)
+		if acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Result()
+	}
+
+	resp := poll("deflate;q=0.5, gzip;q=0.8")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+	require.Equal(t, "Accept-Encoding", resp.Header.Get("Vary"))
+	gz, err := gzip.NewReader(resp.Body)
+	require.NoError(t, err)
+	response := &PollResponse{}
+	require.NoError(t, jsoniter.NewDecoder(gz).Decode(response))
+	require.Len(t, response.Data, 1)
+	require.NotEmpty(t, response.AESKey)
+
+	resp = poll("deflate, gzip;q=0.8")
+	require.Equal(t, "deflate", resp.Header.Get("Content-Encoding"))
+	zr, err := zlib.NewReader(resp.Body)
+	require.NoError(t, err)
+	response = &PollResponse{}
+	require.NoError(t, jsoniter.NewDecoder(zr).Decode(response))
+	require.Len(t, response.Data, 1)
+
+	for _, acceptEncoding := range []string{"", "gzip;q=0", "br"} {
+		resp = poll(acceptEncoding)
+		require.Empty(t, resp.Header.Get("Content-Encoding"))
+		response = &PollResponse{}
+		require.NoError(t, jsoniter.NewDecoder(resp.Body).Decode(response))
+		require.Len(t, response.Data, 1)
+	}
+
+	// compression can be disabled for proxies already compressing the responses
+	opts.HTTPDisableCompression = true
+	resp = poll("gzip")
+	require.Empty(t, resp.Header.Get("Content-Encoding"))
+	response = &PollResponse{}
+	require.NoError(t, jsoniter.NewDecoder(resp.Body).Decode(response))
+	require.Len(t, response.Data, 1)
+}
+
+func TestMetricsCompression(t *testing.T) {
+	opts := &Options{}
+	newTestStore(t, opts)
+	opts.Stats = &Metrics{}
+	cache := newSystemMetricsCache(time.Hour)
+	cache.gather = func() *SystemMetrics {
+		return &SystemMetrics{Memory: &MemoryMetrics{}, Cpu: &CpuStats{}, Network: &NetworkStats{}}
+	}
+	h := &HTTPServer{options: opts, systemMetrics: cache}
+
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	h.compressMiddleware(http.HandlerFunc(h.metricsHandler)).ServeHTTP(w, req)
+	require.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	require.Equal(t, "application/json; charset=utf-8", w.Header().Get("Content-Type"))
+	gz, err := gzip.NewReader(w.Body)
+	require.NoError(t, err)
+	metrics := &Metrics{}
+	require.NoError(t, jsoniter.NewDecoder(gz).Decode(metrics))
+}
+
+func TestPublicKeyHandler(t *testing.T) {
+	opts := &Options{}
+	store := newTestStore(t, opts)
+	firstKey, secondKey := newTestPublicKey(t), newTestPublicKey(t)
+	require.NoError(t, store.SetIDPublicKey("abcdefghij", "secret", firstKey))
+	h := &HTTPServer{options: opts}
+
+	update := func(method, secret, publicKey string) int {
+		body, _ := jsoniter.Marshal(&PublicKeyRequest{CorrelationID: "abcdefghij", SecretKey: secret, PublicKey: publicKey})
+		w := httptest.NewRecorder()
+		h.publicKeyHandler(w, httptest.NewRequest(method, "/keys", bytes.NewReader(body)))
+		return w.Code
+	}
+	poll := func() *PollResponse {
+		require.NoError(t, store.AddInteraction("abcdefghij", []byte("data")))
+		w := httptest.NewRecorder()
+		h.pollHandler(w, httptest.NewRequest("GET", "/poll?id=abcdefghij&secret=secret", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		response := &PollResponse{}
+		require.NoError(t, jsoniter.NewDecoder(w.Body).Decode(response))
+		return response
+	}
+
+	require.Empty(t, poll().AESKeys, "single key sessions only return aes_key")
+	require.Equal(t, http.StatusBadRequest, update("POST", "wrong", secondKey))
+	require.Equal(t, http.StatusMethodNotAllowed, update("GET", "secret", secondKey))
+	require.Equal(t, http.StatusOK, update("POST", "secret", secondKey))
+
+	response := poll()
+	require.Len(t, response.AESKeys, 2)
+	require.Contains(t, response.AESKeys, mustFingerprint(t, firstKey))
+	require.Contains(t, response.AESKeys, mustFingerprint(t, secondKey))
+	require.Equal(t, response.AESKey, response.AESKeys[mustFingerprint(t, firstKey)])
+
+	require.Equal(t, http.StatusOK, update("DELETE", "secret", firstKey))
+	require.Equal(t, http.StatusBadRequest, update("DELETE", "secret", secondKey))
+	require.Empty(t, poll().AESKeys)
+}
+
+// mustFingerprint returns the fingerprint of a base64 encoded public key
+func mustFingerprint(t *testing.T, publicKey string) string {
+	t.Helper()
+
+	key, err := storage.ParseB64RSAPublicKeyFromPEM(publicKey)
+	require.NoError(t, err)
+	fingerprint, err := storage.PublicKeyFingerprint(key)
+	require.NoError(t, err)
+	return fingerprint
+}
+
+func TestDeprecatedDomainInteraction(t *testing.T) {
+	opts := &Options{Domains: []string{"new.example.com", "old.example.net"}, DeprecatedDomains: []string{"old.example.net"}, WarnDeprecatedDomains: true, CorrelationIdLength: 10, CorrelationIdNonceLength: 3}
+	store := newTestStore(t, opts, "abcdefghij")
+	h := &HTTPServer{options: opts}
+
+	for _, host := range []string{"abcdefghijklm.new.example.com", "abcdefghijklm.old.example.net"} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Host = host
+		w := httptest.NewRecorder()
+		h.logger(http.HandlerFunc(h.defaultHandler)).ServeHTTP(w, req)
+		if strings.HasSuffix(host, "old.example.net") {
+			require.Equal(t, `299 - "domain old.example.net is deprecated"`, w.Result().Header.Get("Warning"))
+		} else {
+			require.Empty(t, w.Result().Header.Get("Warning"))
+		}
+	}
+
+	data, err := store.GetInteractionsWithIdForConsumer("abcdefghij", "consumer")
+	require.NoError(t, err)
+	require.Len(t, data, 2)
+	for i, deprecated := range []bool{false, true} {
+		interaction := &Interaction{}
+		require.NoError(t, jsoniter.UnmarshalFromString(data[i], interaction))
+		require.Equal(t, deprecated, interaction.Deprecated)
+	}
+}
+
+func TestRecordACMEChallenges(t *testing.T) {
+	acmeStore := acme.NewProvider()
+	acmeStore.PresentHTTPChallenge("abcdefghijklm", "abcdefghijklm.thumbprint")
+	var results []*Interaction
+	opts := &Options{Domains: []string{"example.com"}, CorrelationIdLength: 10, CorrelationIdNonceLength: 3, ScanEverywhere: true, RootTLD: true, RecordACMEChallenges: true, ACMEStore: acmeStore}
+	opts.OnResult = func(result interface{}) { results = append(results, result.(*Interaction)) }
+	store := newTestStore(t, opts, "abcdefghij", "example.com")
+	h, err := NewHTTPServer(opts)
+	require.NoError(t, err)
+
+	req := httptest.NewRequest("GET", "/.well-known/acme-challenge/abcdefghijklm", nil)
+	req.Host = "abcdefghijklm.example.com"
+	w := httptest.NewRecorder()
+	h.nontlsserver.Handler.ServeHTTP(w, req)
+	body, _ := io.ReadAll(w.Result().Body)
+	require.Equal(t, "abcdefghijklm.thumbprint", string(body))
+
+	// the challenge token is not mistaken for a correlation id
+	data, err := store.GetInteractionsWithIdForConsumer("abcdefghij", "consumer")
+	require.NoError(t, err)
+	require.Empty(t, data)
+
+	require.Len(t, results, 1)
+	require.Equal(t, "acme", results[0].Protocol)
+	require.Contains(t, results[0].RawResponse, "abcdefghijklm.thumbprint")
+	data, err = store.GetInteractionsWithIdForConsumer("example.com", "consumer")
+	require.NoError(t, err)
+	require.Len(t, data, 1)
+	interaction := &Interaction{}
+	require.NoError(t, jsoniter.UnmarshalFromString(data[0], interaction))
+	require.Equal(t, "acme", interaction.Protocol)
+	require.Equal(t, "abcdefghijklm.example.com", interaction.FullId)
+}
+
+func TestTLSALPNChallenge(t *testing.T) {
+	store := acme.NewProvider()
+	require.NoError(t, store.PresentTLSALPNChallenge("example.com", "token.thumbprint"))
+	h := &HTTPServer{options: &Options{ACMEStore: store, TLSALPNChallenge: true}}
+	certificate := newTestCertificate(t)
+	config := h.applyTLSOptions(&tls.Config{Certificates: []tls.Certificate{certificate}, NextProtos: []string{"http/1.1"}})
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	require.NoError(t, err)
+	defer func() { _ = listener.Close() }()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.(*tls.Conn).Handshake()
+			_ = conn.Close()
+		}
+	}()
+
+	handshake := func(serverName string, protos ...string) (tls.ConnectionState, error) {
+		conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{ServerName: serverName, NextProtos: protos, InsecureSkipVerify: true})
+		if err != nil {
+			return tls.ConnectionState{}, err
+		}
+		defer func() { _ = conn.Close() }()
+		return conn.ConnectionState(), nil
+	}
+
+	// validation handshakes get the challenge certificate with the key authorization digest
+	state, err := handshake("example.com", acme.TLSALPNProtocol)
+	require.NoError(t, err)
+	require.Equal(t, acme.TLSALPNProtocol, state.NegotiatedProtocol)
+	leaf := state.PeerCertificates[0]
+	require.Equal(t, []string{"example.com"}, leaf.DNSNames)
+	digest := sha256.Sum256([]byte("token.thumbprint"))
+	expected, err := asn1.Marshal(digest[:])
+	require.NoError(t, err)
+	var found bool
+	for _, extension := range leaf.Extensions {
+		if extension.Id.Equal(asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 31}) {
+			found = true
+			require.True(t, extension.Critical)
+			require.Equal(t, expected, extension.Value)
+		}
+	}
+	require.True(t, found, "acmeIdentifier extension should be present")
+
+	// regular handshakes get the configured certificate
+	state, err = handshake("example.com", "http/1.1")
+	require.NoError(t, err)
+	require.Equal(t, certificate.Certificate[0], state.PeerCertificates[0].Raw)
+
+	// validations fail once the challenge is cleaned up
+	store.CleanUpTLSALPNChallenge("example.com")
+	_, err = handshake("example.com", acme.TLSALPNProtocol)
+	require.Error(t, err)
+}
+
+func TestInvalidCertSNIs(t *testing.T) {
+	opts := &Options{InvalidCertSNIs: []string{"invalid.example.com"}, CorrelationIdLength: 10, CorrelationIdNonceLength: 3}
+	store := newTestStore(t, opts, "abcdefghij")
+	h := &HTTPServer{options: opts}
+	certificate := newTestCertificate(t)
+	config := h.applyTLSOptions(&tls.Config{Certificates: []tls.Certificate{certificate}})
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	require.NoError(t, err)
+	defer func() { _ = listener.Close() }()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.(*tls.Conn).Handshake()
+			_ = conn.Close()
+		}
+	}()
+
+	handshake := func(serverName string) *x509.Certificate {
+		conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{ServerName: serverName, InsecureSkipVerify: true})
+		require.NoError(t, err)
+		defer func() { _ = conn.Close() }()
+		return conn.ConnectionState().PeerCertificates[0]
+	}
+
+	// configured server names and their subdomains get the invalid certificate
+	for _, serverName := range []string{"invalid.example.com", "abcdefghijklm.INVALID.example.com"} {
+		leaf := handshake(serverName)
+		require.Equal(t, []string{invalidCertificateHostname}, leaf.DNSNames)
+		require.Equal(t, leaf.RawSubject, leaf.RawIssuer, "invalid certificate should be self-signed")
+		require.NoError(t, leaf.CheckSignature(leaf.SignatureAlgorithm, leaf.RawTBSCertificate, leaf.Signature))
+		require.Error(t, leaf.VerifyHostname(serverName))
+	}
+	// other server names get the configured certificate
+	require.Equal(t, certificate.Certificate[0], handshake("valid.example.com").Raw)
+
+	data, err := store.GetInteractionsWithIdForConsumer("abcdefghij", "consumer")
+	require.NoError(t, err)
+	require.Len(t, data, 1, "only handshakes with a correlation id should be recorded")
+	interaction := &Interaction{}
+	require.NoError(t, jsoniter.UnmarshalFromString(data[0], interaction))
+	require.Equal(t, "tls", interaction.Protocol)
+	require.Equal(t, "abcdefghijklm", interaction.UniqueID)
+	require.Equal(t, "abcdefghijklm.INVALID.example.com", interaction.SNI)
+	require.Equal(t, "127.0.0.1", interaction.RemoteAddress)
+}
+
+func TestCorrelationSalt(t *testing.T) {
+	opts := &Options{CorrelationSalt: "server-salt"}
+	store := newTestStore(t, opts)
+	h := &HTTPServer{options: opts}
+	publicKey := newTestPublicKey(t)
+
+	register := func(correlationID, correlationHMAC string) int {
+		body, err := jsoniter.Marshal(&RegisterRequest{PublicKey: publicKey, SecretKey: "secret", CorrelationID: correlationID, CorrelationHMAC: correlationHMAC})
+		require.NoError(t, err)
+		w := httptest.NewRecorder()
+		h.registerHandler(w, httptest.NewRequest("POST", "/register", strings.NewReader(string(body))))
+		return w.Result().StatusCode
+	}
+
+	require.Equal(t, http.StatusOK, register("validvalidv", CorrelationHMAC("server-salt", "validvalidv")))
+	require.Equal(t, http.StatusForbidden, register("forgedforge", CorrelationHMAC("other-salt", "forgedforge")), "hmac under another salt should be rejected")
+	require.Equal(t, http.StatusForbidden, register("missingmiss", ""), "missing hmac should be rejected")
+
+	// interactions of forged ids are never stored
+	require.NoError(t, store.AddInteraction("validvalidv", []byte("interaction")))
+	require.Error(t, store.AddInteraction("forgedforge", []byte("interaction")))
+}
+
+func TestCampaignHandler(t *testing.T) {
+	opts := &Options{AdminToken: "admin-secret", CorrelationIdLength: 11, Stats: &Metrics{}}
+	newTestStore(t, opts)
+	h := &HTTPServer{options: opts}
+	publicKey := newTestPublicKey(t)
+
+	register := func(correlationID, campaign string) {
+		body, err := jsoniter.Marshal(&RegisterRequest{PublicKey: publicKey, SecretKey: "secret", CorrelationID: correlationID, Campaign: campaign})
+		require.NoError(t, err)
+		w := httptest.NewRecorder()
+		h.registerHandler(w, httptest.NewRequest("POST", "/register", strings.NewReader(string(body))))
+		require.Equal(t, http.StatusOK, w.Result().StatusCode)
+	}
+	register("campaignone", "engagement")
+	register("campaigntwo", "engagement")
+	register("standalone", "")
+
+	first := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, interaction := range []*Interaction{
+		{Protocol: "dns", CorrelationID: "campaignone", RemoteAddress: "192.0.2.1", Timestamp: first},
+		{Protocol: "http", CorrelationID: "campaignone", RemoteAddress: "192.0.2.1", Timestamp: first.Add(time.Minute)},
+		// dns interactions only carry the unique id
+		{Protocol: "dns", UniqueID: "campaigntwoabc", RemoteAddress: "192.0.2.2", Timestamp: first.Add(time.Hour)},
+		{Protocol: "smtp", CorrelationID: "standalone", RemoteAddress: "192.0.2.3", Timestamp: first},
+	} {
+		opts.publishInteraction(interaction, nil)
+	}
+
+	handler := h.adminMiddleware(http.HandlerFunc(h.campaignHandler))
+	get := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Authorization", "admin-secret")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get("/admin/campaign/engagement")
+	require.Equal(t, http.StatusOK, w.Result().StatusCode)
+	response := &CampaignResponse{}
+	require.NoError(t, jsoniter.NewDecoder(w.Body).Decode(response))
+	require.Equal(t, "engagement", response.Campaign)
+	require.Equal(t, []string{"campaignone", "campaigntwo"}, response.CorrelationIDs)
+	require.Equal(t, 3, response.Interactions)
+	require.Equal(t, map[string]int{"dns": 2, "http": 1}, response.Protocols)
+	require.Equal(t, []string{"192.0.2.1", "192.0.2.2"}, response.RemoteAddresses)
+	require.True(t, first.Equal(*response.FirstInteraction))
+	require.True(t, first.Add(time.Hour).Equal(*response.LastInteraction))
+	require.Len(t, response.Sessions, 2)
+	require.Equal(t, "campaignone", response.Sessions[0].CorrelationID)
+	require.Equal(t, 2, response.Sessions[0].Interactions)
+	require.Equal(t, []string{"192.0.2.1"}, response.Sessions[0].RemoteAddresses)
+
+	require.Equal(t, http.StatusNotFound, get("/admin/campaign/unknown").Result().StatusCode)
+}
+
+func TestReplayHandler(t *testing.T) {
+	opts := &Options{Domains: []string{"example.com"}, CorrelationIdLength: 10, CorrelationIdNonceLength: 3, AdminToken: "admin-secret", ReplayCacheSize: 10}
+	store := newTestStore(t, opts, "abcdefghij")
+	h, err := NewHTTPServer(opts)
+	require.NoError(t, err)
+
+	// capture a request
+	req := httptest.NewRequest("POST", "/callback?token=value", strings.NewReader("captured body"))
+	req.Host = "abcdefghijklm.example.com"
+	req.Header.Set("X-Custom", "header-value")
+	h.nontlsserver.Handler.ServeHTTP(httptest.NewRecorder(), req)
+	data, err := store.GetInteractionsWithIdForConsumer("abcdefghij", "consumer")
+	require.NoError(t, err)
+	require.Len(t, data, 1)
+	interaction := &Interaction{}
+	require.NoError(t, jsoniter.UnmarshalFromString(data[0], interaction))
+	require.NotEmpty(t, interaction.ID)
+
+	var replayed struct {
+		method, uri, host, header, body string
+	}
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		replayed.method, replayed.uri, replayed.host = r.Method, r.RequestURI, r.Host
+		replayed.header, replayed.body = r.Header.Get("X-Custom"), string(body)
+		w.Header().Set("X-Target", "reached")
+		w.WriteHeader(http.StatusAccepted)
+		_, _ = w.Write([]byte("target response"))
+	}))
+	defer target.Close()
+
+	replay := func(request *ReplayRequest) *httptest.ResponseRecorder {
+		body, err := jsoniter.Marshal(request)
+		require.NoError(t, err)
+		req := httptest.NewRequest("POST", "/admin/replay", strings.NewReader(string(body)))
+		req.Header.Set("Authorization", "admin-secret")
+		w := httptest.NewRecorder()
+		h.nontlsserver.Handler.ServeHTTP(w, req)
+		return w
+	}
+
+	w := replay(&ReplayRequest{ID: interaction.ID, Target: target.URL + "/prefix/"})
+	require.Equal(t, http.StatusOK, w.Result().StatusCode)
+	response := &ReplayResponse{}
+	require.NoError(t, jsoniter.NewDecoder(w.Body).Decode(response))
+	require.Equal(t, http.StatusAccepted, response.StatusCode)
+	require.Contains(t, response.RawResponse, "X-Target: reached")
+	require.Contains(t, response.RawResponse, "target response")
+	require.Equal(t, "POST", replayed.method)
+	require.Equal(t, "/prefix/callback?token=value", replayed.uri)
+	require.Equal(t, strings.TrimPrefix(target.URL, "http://"), replayed.host)
+	require.Equal(t, "header-value", replayed.header)
+	require.Equal(t, "captured body", replayed.body)
+
+	w = replay(&ReplayRequest{ID: interaction.ID, Target: target.URL, PreserveHost: true})
+	require.Equal(t, http.StatusOK, w.Result().StatusCode)
+	require.Equal(t, "abcdefghijklm.example.com", replayed.host)
+
+	require.Equal(t, http.StatusNotFound, replay(&ReplayRequest{ID: "unknown", Target: target.URL}).Result().StatusCode)
+	require.Equal(t, http.StatusBadRequest, replay(&ReplayRequest{ID: interaction.ID, Target: "file:///etc/passwd"}).Result().StatusCode)
+}
+
+func TestClassifyInteractions(t *testing.T) {
+	opts := &Options{Domains: []string{"example.com"}, CorrelationIdLength: 10, CorrelationIdNonceLength: 3, ClassifyInteractions: true}
+	store := newTestStore(t, opts, "abcdefghij")
+	h, err := NewHTTPServer(opts)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name, method, host, target, body, likely string
+	}{
+		{"bare canary", "GET", "abcdefghijklm.example.com", "/", "", InteractionCanary},
+		{"query data", "GET", "abcdefghijklm.example.com", "/?user=admin", "", InteractionData},
+		{"path data", "GET", "abcdefghijklm.example.com", "/exfil/c2VjcmV0", "", InteractionData},
+		{"subdomain data", "GET", "c2VjcmV0.abcdefghijklm.example.com", "/", "", InteractionData},
+		{"body data", "POST", "abcdefghijklm.example.com", "/", "secret=value", InteractionData},
+		{"scan path", "GET", "abcdefghijklm.example.com", "/robots.txt", "", InteractionScan},
+		{"scan method", "HEAD", "abcdefghijklm.example.com", "/", "", InteractionScan},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(test.method, test.target, strings.NewReader(test.body))
+			req.Host = test.host
+			h.nontlsserver.Handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			data, err := store.GetInteractionsWithIdForConsumer("abcdefghij", "consumer")
+			require.NoError(t, err)
+			require.Len(t, data, 1)
+			interaction := &Interaction{}
+			require.NoError(t, jsoniter.UnmarshalFromString(data[0], interaction))
+			require.Equal(t, test.likely, interaction.Likely)
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/projectdiscovery/gologger"
+	stringsutil "github.com/projectdiscovery/utils/strings"
+)
+
+// invalidCertificateHostname is the only name of the invalid certificate, so that
+// it fails both the chain and the hostname verification of the clients
+const invalidCertificateHostname = "invalid.interactsh.local"
+
+// newInvalidCertificate returns a self-signed certificate for invalidCertificateHostname
+func newInvalidCertificate() (*tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: invalidCertificateHostname},
+		DNSNames:     []string{invalidCertificateHostname},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(365 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+// isInvalidCertSNI returns true if the server name is, or is a subdomain of, one of the InvalidCertSNIs
+func (options *Options) isInvalidCertSNI(serverName string) bool {
+	serverName = strings.ToLower(strings.TrimSuffix(serverName, "."))
+	if serverName == "" {
+		return false
+	}
+	for _, sni := range options.InvalidCertSNIs {
+		sni = strings.ToLower(strings.TrimSuffix(sni, "."))
+		if serverName == sni || strings.HasSuffix(serverName, "."+sni) {
+			return true
+		}
+	}
+	return false
+}
+
+// recordInvalidCertHandshake records the handshakes served the invalid certificate
+// as tls interactions, as clients rejecting it never send their http request
+func (h *HTTPServer) recordInvalidCertHandshake(hello *tls.ClientHelloInfo) {
+	receivedAt := time.Now()
+
+	var uniqueID, fullID string
+	if h.options.CorrelationPosition == CorrelationPositionLeftmost {
+		uniqueID, fullID, _ = h.options.leftmostCorrelationID(hello.ServerName, ".")
+	} else {
+		parts := strings.Split(hello.ServerName, ".")
+		for i, part := range parts {
+			for partChunk := range stringsutil.SlideWithLength(part, h.options.GetIdLength()) {
+				normalizedPartChunk := strings.ToLower(partChunk)
+				if h.options.isCorrelationID(normalizedPartChunk) {
+					uniqueID = normalizedPartChunk
+					fullID = strings.Join(parts[:i+1], ".")
+				}
+			}
+		}
+	}
+	if uniqueID == "" {
+		gologger.Debug().Msgf("Served invalid certificate for %s without correlation id\n", hello.ServerName)
+		return
+	}
+
+	correlationID := uniqueID[:h.options.CorrelationIdLength]
+	var host string
+	if hello.Conn != nil {
+		host, _, _ = net.SplitHostPort(hello.Conn.RemoteAddr().String())
+	}
+	interaction := &Interaction{
+		Protocol:      "tls",
+		UniqueID:      uniqueID,
+		FullId:        fullID,
+		CorrelationID: correlationID,
+		RawRequest:    fmt.Sprintf("ServerName=%s\nSupportedProtos=%s\n", hello.ServerName, strings.Join(hello.SupportedProtos, ",")),
+		SNI:           hello.ServerName,
+		RemoteAddress: host,
+		ReceivedAt:    receivedAt,
+		Timestamp:     time.Now(),
+	}
+	h.options.tagInteraction(interaction)
+	data, err := h.options.encodeInteraction(interaction)
+	if err != nil {
+		gologger.Warning().Msgf("Could not encode tls interaction: %s\n", err)
+		return
+	}
+	gologger.Debug().Msgf("TLS Interaction: \n%s\n", string(data))
+	h.options.publishInteraction(interaction, data)
+	if err := h.options.storeInteraction(correlationID, data); err != nil {
+		gologger.Warning().Msgf("Could not store tls interaction: %s\n", err)
+	}
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// JWTClaims is the decoded header and claims of a JWT bearer token. The
+// signature is neither verified nor recorded.
+type JWTClaims struct {
+	Header map[string]interface{} `json:"header,omitempty"`
+	Claims map[string]interface{} `json:"claims,omitempty"`
+}
+
+// bearerToken returns the token of the Bearer Authorization header of a request
+func bearerToken(r *http.Request) string {
+	authorization := r.Header.Get("Authorization")
+	if len(authorization) < 7 || !strings.EqualFold(authorization[:7], "Bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(authorization[7:])
+}
+
+// decodeJWT decodes the header and claims of a JWT, returning nil if the token isn't one
+func decodeJWT(token string) *JWTClaims {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil
+	}
+	claims := &JWTClaims{}
+	if err := decodeJWTSegment(parts[0], &claims.Header); err != nil {
+		return nil
+	}
+	if err := decodeJWTSegment(parts[1], &claims.Claims); err != nil {
+		return nil
+	}
+	return claims
+}
+
+// decodeJWTSegment decodes a base64url encoded json segment of a JWT
+func decodeJWTSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(segment, "="))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// redactJWTSignature replaces the signature of a JWT in the raw request
+func redactJWTSignature(rawRequest, token string) string {
+	idx := strings.LastIndexByte(token, '.')
+	if idx == -1 || idx == len(token)-1 {
+		return rawRequest
+	}
+	return strings.ReplaceAll(rawRequest, token, token[:idx+1]+"[redacted]")
+}
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/projectdiscovery/gologger"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl/plain"
+)
+
+const (
+	kafkaQueueSize    = 4096
+	kafkaBatchSize    = 100
+	kafkaWriteTimeout = 10 * time.Second
+)
+
+// kafkaWriter is the subset of the kafka writer used to publish interactions
+type kafkaWriter interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// KafkaPublisher publishes interactions to a kafka topic asynchronously.
+// Interactions are queued in a bounded buffer and dropped when it's full,
+// so protocol handlers are never blocked by the brokers.
+type KafkaPublisher struct {
+	writer kafkaWriter
+	stats  *Metrics
+	queue  chan []byte
+	stop   chan struct{}
+	done   chan struct{}
+
+	// mu guards closed, so that nothing is queued once the publisher is closed
+	mu     sync.RWMutex
+	closed bool
+}
+
+// NewKafkaPublisher returns a publisher writing to the kafka brokers and topic of the options.
+func NewKafkaPublisher(options *Options) (*KafkaPublisher, error) {
+	if len(options.KafkaBrokers) == 0 || options.KafkaTopic == "" {
+		return nil, errors.New("kafka brokers and topic are required")
+	}
+	transport := &kafka.Transport{}
+	if options.KafkaUsername != "" {
+		transport.SASL = plain.Mechanism{Username: options.KafkaUsername, Password: options.KafkaPassword}
+	}
+	if options.KafkaTLS {
+		transport.TLS = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	writer := &kafka.Writer{
+		Addr:         kafka.TCP(options.KafkaBrokers...),
+		Topic:        options.KafkaTopic,
+		Balancer:     &kafka.LeastBytes{},
+		BatchSize:    kafkaBatchSize,
+		BatchTimeout: 50 * time.Millisecond,
+		Transport:    transport,
+	}
+	return newKafkaPublisher(writer, options.Stats), nil
+}
+
+func newKafkaPublisher(writer kafkaWriter, stats *Metrics) *KafkaPublisher {
+	if stats == nil {
+		stats = &Metrics{}
+	}
+	publisher := &KafkaPublisher{
+		writer: writer,
+		stats:  stats,
+		queue:  make(chan []byte, kafkaQueueSize),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go publisher.run()
+	return publisher
+}
+
+// Publish enqueues an interaction for publishing without blocking. Interactions
+// published once the publisher is closed are dropped.
+func (k *KafkaPublisher) Publish(data []byte) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	if k.closed {
+		atomic.AddUint64(&k.stats.KafkaDropped, 1)
+		return
+	}
+	select {
+	case k.queue <- data:
+	default:
+		atomic.AddUint64(&k.stats.KafkaDropped, 1)
+		gologger.Warning().Msgf("Kafka queue is full, dropping interaction\n")
+	}
+}
+
+// Close flushes the queued interactions and closes the kafka writer.
+func (k *KafkaPublisher) Close() error {
+	k.mu.Lock()
+	if k.closed {
+		k.mu.Unlock()
+		return nil
+	}
+	k.closed = true
+	k.mu.Unlock()
+
+	close(k.stop)
+	<-k.done
+	return k.writer.Close()
+}
+
+func (k *KafkaPublisher) run() {
+	defer close(k.done)
+
+	for {
+		select {
+		case data := <-k.queue:
+			k.write(data)
+		case <-k.stop:
+			// the interactions queued before closing are still published
+			for {
+				select {
+				case data := <-k.queue:
+					k.write(data)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// write publishes an interaction along with the ones already queued as a batch
+func (k *KafkaPublisher) write(data []byte) {
+	messages := []kafka.Message{{Value: data}}
+batch:
+	for len(messages) < kafkaBatchSize {
+		select {
+		case data := <-k.queue:
+			messages = append(messages, kafka.Message{Value: data})
+		default:
+			break batch
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), kafkaWriteTimeout)
+	err := k.writer.WriteMessages(ctx, messages...)
+	cancel()
+	if err != nil {
+		atomic.AddUint64(&k.stats.KafkaFailed, uint64(len(messages)))
+		gologger.Warning().Msgf("Could not publish %d interactions to kafka: %s\n", len(messages), err)
+	}
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/require"
+)
+
+// mockKafkaWriter records the written messages, optionally failing or blocking the writes
+type mockKafkaWriter struct {
+	sync.Mutex
+	messages []kafka.Message
+	err      error
+	block    chan struct{}
+}
+
+func (m *mockKafkaWriter) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	if m.block != nil {
+		<-m.block
+	}
+	m.Lock()
+	defer m.Unlock()
+	if m.err != nil {
+		return m.err
+	}
+	m.messages = append(m.messages, msgs...)
+	return nil
+}
+
+func (m *mockKafkaWriter) Close() error { return nil }
+
+func (m *mockKafkaWriter) written() []kafka.Message {
+	m.Lock()
+	defer m.Unlock()
+	return append([]kafka.Message(nil), m.messages...)
+}
+
+func TestKafkaPublisherInteractions(t *testing.T) {
+	opts := &Options{Domains: []string{"example.com"}, CorrelationIdLength: 10, CorrelationIdNonceLength: 3}
+	newTestStore(t, opts, "abcdefghij")
+	writer := &mockKafkaWriter{}
+	opts.Kafka = newKafkaPublisher(writer, opts.Stats)
+	h := &HTTPServer{options: opts}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Host = "abcdefghijklm.example.com"
+	h.logger(http.HandlerFunc(h.defaultHandler)).ServeHTTP(httptest.NewRecorder(), req)
+	require.NoError(t, opts.Kafka.Close())
+
+	messages := writer.written()
+	require.Len(t, messages, 1)
+	interaction := &Interaction{}
+	require.NoError(t, jsoniter.Unmarshal(messages[0].Value, interaction))
+	require.Equal(t, "abcdefghijklm", interaction.UniqueID)
+	require.Equal(t, "http", interaction.Protocol)
+}
+
+func TestKafkaPublisherTokenInteractions(t *testing.T) {
+	opts := &Options{Token: "client-secret"}
+	store := newTestStore(t, opts, "client-secret")
+	writer := &mockKafkaWriter{}
+	opts.Kafka = newKafkaPublisher(writer, opts.Stats)
+
+	// interactions without correlation id are published as well
+	(&FTPServer{options: opts}).recordInteraction("192.0.2.1:2121", "USER test")
+	require.NoError(t, opts.Kafka.Close())
+
+	messages := writer.written()
+	require.Len(t, messages, 1)
+	interaction := &Interaction{}
+	require.NoError(t, jsoniter.Unmarshal(messages[0].Value, interaction))
+	require.Equal(t, "ftp", interaction.Protocol)
+	stored, err := store.GetInteractionsWithIdForConsumer("client-secret", "consumer")
+	require.NoError(t, err)
+	require.Len(t, stored, 1)
+}
+
+func TestKafkaPublisherFailures(t *testing.T) {
+	stats := &Metrics{}
+	writer := &mockKafkaWriter{err: errors.New("broker unavailable")}
+	publisher := newKafkaPublisher(writer, stats)
+	publisher.Publish([]byte("interaction"))
+	require.NoError(t, publisher.Close())
+	require.Equal(t, uint64(1), stats.KafkaFailed)
+
+	// interactions published by the handlers still running once closed are dropped
+	publisher.Publish([]byte("interaction"))
+	require.Equal(t, uint64(1), stats.KafkaDropped)
+	require.NoError(t, publisher.Close())
+
+	// a stuck broker never blocks publishing, overflowing interactions are dropped
+	stats = &Metrics{}
+	writer = &mockKafkaWriter{block: make(chan struct{})}
+	publisher = newKafkaPublisher(writer, stats)
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < kafkaQueueSize+kafkaBatchSize+10; i++ {
+			publisher.Publish([]byte("interaction"))
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("publish blocked on a stuck broker")
+	}
+	close(writer.block)
+	require.NoError(t, publisher.Close())
+	require.NotZero(t, stats.KafkaDropped)
+	require.Equal(t, kafkaQueueSize+kafkaBatchSize+10, len(writer.written())+int(stats.KafkaDropped))
+}
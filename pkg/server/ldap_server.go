@@ -7,7 +7,7 @@ import (
 	"sync/atomic"
 	"time"
 
-	jsoniter "github.com/json-iterator/go"
+	goldap "github.com/lor00x/goldap/message"
 	"github.com/projectdiscovery/gologger"
 	ldap "github.com/projectdiscovery/ldapserver"
 	stringsutil "github.com/projectdiscovery/utils/strings"
@@ -21,12 +21,20 @@ type LDAPServer struct {
 	options    *Options
 	server     *ldap.Server
 	tlsConfig  *tls.Config
+	// script holds the scripted results of the ldap operations
+	script *ProtocolScript
 }
 
 // NewLDAPServer returns a new LDAP server.
 func NewLDAPServer(options *Options, withLogger bool) (*LDAPServer, error) {
 	ldapserver := &LDAPServer{options: options, WithLogger: withLogger}
 
+	script, err := options.loadProtocolScript("ldap")
+	if err != nil {
+		return nil, err
+	}
+	ldapserver.script = script
+
 	if withLogger {
 		ldap.HandleLogCallback = ldapserver.handleLog
 	}
@@ -45,7 +53,7 @@ func NewLDAPServer(options *Options, withLogger bool) (*LDAPServer, error) {
 	routes.Search(ldapserver.handleSearch)
 
 	server := ldap.NewServer()
-	err := server.Handle(routes)
+	err = server.Handle(routes)
 	if err != nil {
 		return nil, err
 	}
@@ -70,6 +78,7 @@ func (ldapServer *LDAPServer) handleBind(w ldap.ResponseWriter, m *ldap.Message)
 
 	r := m.GetBindRequest()
 	res := ldap.NewBindResponse(ldap.LDAPResultSuccess)
+	ldapServer.applyScript("bind", &res.LDAPResult)
 	var message strings.Builder
 	message.WriteString("Type=Bind\n")
 	message.WriteString(fmt.Sprintf("AuthenticationChoice=%s\n", r.AuthenticationChoice()))
@@ -87,6 +96,7 @@ func (ldapServer *LDAPServer) handleBind(w ldap.ResponseWriter, m *ldap.Message)
 
 // handleSearch is a handler for search requests
 func (ldapServer *LDAPServer) handleSearch(w ldap.ResponseWriter, m *ldap.Message) {
+	receivedAt := time.Now()
 	atomic.AddUint64(&ldapServer.options.Stats.Ldap, 1)
 
 	var uniqueID, fullID string
@@ -115,6 +125,7 @@ func (ldapServer *LDAPServer) handleSearch(w ldap.ResponseWriter, m *ldap.Messag
 	e.AddAttribute("cn", "interact")
 	w.Write(e)
 	res := ldap.NewSearchResultDoneResponse(ldap.LDAPResultSuccess)
+	ldapServer.applyScript("search", (*goldap.LDAPResult)(&res))
 	w.Write(res)
 	searchString := string(baseObject) + "," + string(filter)
 	gologger.Debug().Msgf("Searching ladp interaction in msg: %s, base: %s, for %s, searchString: %s",
@@ -129,14 +140,14 @@ func (ldapServer *LDAPServer) handleSearch(w ldap.ResponseWriter, m *ldap.Messag
 					if i+1 <= len(partChunks) {
 						fullID = strings.Join(partChunks[:i+1], ".")
 					}
-					ldapServer.handleInteraction(uniqueID, fullID, message.String(), host)
+					ldapServer.handleInteraction(uniqueID, fullID, message.String(), host, receivedAt)
 				}
 			}
 		}
 	}
 }
 
-func (ldapServer *LDAPServer) handleInteraction(uniqueID, fullID, reqString, host string) {
+func (ldapServer *LDAPServer) handleInteraction(uniqueID, fullID, reqString, host string, receivedAt time.Time) {
 	if uniqueID != "" {
 		correlationID := uniqueID[:ldapServer.options.CorrelationIdLength]
 		interaction := &Interaction{
@@ -145,14 +156,17 @@ func (ldapServer *LDAPServer) handleInteraction(uniqueID, fullID, reqString, hos
 			FullId:        fullID,
 			RawRequest:    reqString,
 			RemoteAddress: host,
+			ReceivedAt:    receivedAt,
 			Timestamp:     time.Now(),
 		}
-		data, err := jsoniter.Marshal(interaction)
+		ldapServer.options.tagInteraction(interaction)
+		data, err := ldapServer.options.encodeInteraction(interaction)
 		if err != nil {
 			gologger.Warning().Msgf("Could not encode ldap interaction: %s\n", err)
 		} else {
 			gologger.Debug().Msgf("LDAP Interaction: \n%s\n", string(data))
-			if err := ldapServer.options.Storage.AddInteraction(correlationID, data); err != nil {
+			ldapServer.options.publishInteraction(interaction, data)
+			if err := ldapServer.options.storeInteraction(correlationID, data); err != nil {
 				gologger.Warning().Msgf("Could not store ldap interaction: %s\n", err)
 			}
 		}
@@ -164,6 +178,7 @@ func (ldapServer *LDAPServer) handleInteraction(uniqueID, fullID, reqString, hos
 		ldapServer.logInteraction(Interaction{
 			RemoteAddress: host,
 			RawRequest:    reqString,
+			ReceivedAt:    receivedAt,
 		})
 	}
 }
@@ -225,6 +240,7 @@ func (ldapServer *LDAPServer) handleCompare(w ldap.ResponseWriter, m *ldap.Messa
 	message.WriteString(fmt.Sprintf("Attribute value expected=%s\n", r.Ava().AssertionValue()))
 
 	res := ldap.NewCompareResponse(ldap.LDAPResultCompareTrue)
+	ldapServer.applyScript("compare", (*goldap.LDAPResult)(&res))
 	w.Write(res)
 
 	if ldapServer.WithLogger {
@@ -250,6 +266,7 @@ func (ldapServer *LDAPServer) handleAdd(w ldap.ResponseWriter, m *ldap.Message)
 	}
 
 	res := ldap.NewAddResponse(ldap.LDAPResultSuccess)
+	ldapServer.applyScript("add", (*goldap.LDAPResult)(&res))
 	w.Write(res)
 
 	if ldapServer.WithLogger {
@@ -270,6 +287,7 @@ func (ldapServer *LDAPServer) handleDelete(w ldap.ResponseWriter, m *ldap.Messag
 	message.WriteString(fmt.Sprintf("Entity=%s\n", r))
 
 	res := ldap.NewDeleteResponse(ldap.LDAPResultSuccess)
+	ldapServer.applyScript("delete", (*goldap.LDAPResult)(&res))
 	w.Write(res)
 
 	if ldapServer.WithLogger {
@@ -309,6 +327,7 @@ func (ldapServer *LDAPServer) handleModify(w ldap.ResponseWriter, m *ldap.Messag
 	}
 
 	res := ldap.NewModifyResponse(ldap.LDAPResultSuccess)
+	ldapServer.applyScript("modify", (*goldap.LDAPResult)(&res))
 	w.Write(res)
 
 	if ldapServer.WithLogger {
@@ -390,6 +409,18 @@ func (ldapServer *LDAPServer) handleExtended(w ldap.ResponseWriter, m *ldap.Mess
 	}
 }
 
+// applyScript sets the result code and diagnostic message scripted for the operation
+func (ldapServer *LDAPServer) applyScript(operation string, result *goldap.LDAPResult) {
+	response := ldapServer.script.response(operation)
+	if response == nil {
+		return
+	}
+	result.SetResultCode(response.Code)
+	if response.Message != "" {
+		result.SetDiagnosticMessage(response.Message)
+	}
+}
+
 func (ldapServer *LDAPServer) handleLog(host string, f string, v ...interface{}) {
 	// just discard logs if logger is disabled
 	if !ldapServer.WithLogger {
@@ -413,12 +444,15 @@ func (ldapServer *LDAPServer) logInteraction(interaction Interaction) {
 	// Correlation id doesn't apply here, we skip encryption
 	interaction.Protocol = "ldap"
 	interaction.Timestamp = time.Now()
-	data, err := jsoniter.Marshal(interaction)
+	if interaction.ReceivedAt.IsZero() {
+		interaction.ReceivedAt = interaction.Timestamp
+	}
+	data, err := ldapServer.options.encodeInteraction(&interaction)
 	if err != nil {
 		gologger.Warning().Msgf("Could not encode ldap interaction: %s\n", err)
 	} else {
 		gologger.Debug().Msgf("LDAP Interaction: \n%s\n", string(data))
-		if err := ldapServer.options.Storage.AddInteractionWithId(ldapServer.options.Token, data); err != nil {
+		if err := ldapServer.options.storeInteractionWithId(ldapServer.options.Token, &interaction, data); err != nil {
 			gologger.Warning().Msgf("Could not store ldap interaction: %s\n", err)
 		}
 	}
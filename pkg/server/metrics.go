@@ -2,6 +2,9 @@ package server
 
 import (
 	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	units "github.com/docker/go-units"
 	"github.com/mackerelio/go-osstat/network"
@@ -9,17 +12,49 @@ import (
 )
 
 type Metrics struct {
-	Dns      uint64                `json:"dns"`
-	Ftp      uint64                `json:"ftp"`
-	Http     uint64                `json:"http"`
-	Ldap     uint64                `json:"ldap"`
-	Smb      uint64                `json:"smb"`
-	Smtp     uint64                `json:"smtp"`
-	Sessions int64                 `json:"sessions"`
-	Cache    *storage.CacheMetrics `json:"cache"`
-	Memory   *MemoryMetrics        `json:"memory"`
-	Cpu      *CpuStats             `json:"cpu"`
-	Network  *NetworkStats         `json:"network"`
+	Dns          uint64                `json:"dns"`
+	Ftp          uint64                `json:"ftp"`
+	Http         uint64                `json:"http"`
+	HttpSkipped  uint64                `json:"http-skipped,omitempty"`
+	Ldap         uint64                `json:"ldap"`
+	Smb          uint64                `json:"smb"`
+	Smtp         uint64                `json:"smtp"`
+	Tcp          uint64                `json:"tcp"`
+	Sessions     int64                 `json:"sessions"`
+	KafkaFailed  uint64                `json:"kafka-failed,omitempty"`
+	KafkaDropped uint64                `json:"kafka-dropped,omitempty"`
+	CEFFailed    uint64                `json:"cef-failed,omitempty"`
+	CEFDropped   uint64                `json:"cef-dropped,omitempty"`
+	TokenUsage   map[string]uint64     `json:"token-usage,omitempty"`
+	Cache        *storage.CacheMetrics `json:"cache"`
+	Memory       *MemoryMetrics        `json:"memory"`
+	Cpu          *CpuStats             `json:"cpu"`
+	Network      *NetworkStats         `json:"network"`
+
+	// tokens counts the authenticated requests by token label
+	tokens sync.Map
+}
+
+// recordToken counts a request authenticated with the token of the label
+func (m *Metrics) recordToken(label string) {
+	if m == nil {
+		return
+	}
+	count, _ := m.tokens.LoadOrStore(label, new(uint64))
+	atomic.AddUint64(count.(*uint64), 1)
+}
+
+// tokenUsage returns the number of authenticated requests by token label
+func (m *Metrics) tokenUsage() map[string]uint64 {
+	var usage map[string]uint64
+	m.tokens.Range(func(key, value any) bool {
+		if usage == nil {
+			usage = make(map[string]uint64)
+		}
+		usage[key.(string)] = atomic.LoadUint64(value.(*uint64))
+		return true
+	})
+	return usage
 }
 
 func GetCacheMetrics(options *Options) *storage.CacheMetrics {
@@ -46,6 +81,7 @@ type MemoryMetrics struct {
 	MSpanSys     string `json:"mspan_sys"`
 	MCacheInuse  string `json:"mcache_in_use"`
 	MCacheSys    string `json:"mcache_sys"`
+	stats        *runtime.MemStats
 }
 
 func GetMemoryMetrics() *MemoryMetrics {
@@ -70,6 +106,7 @@ func GetMemoryMetrics() *MemoryMetrics {
 		MSpanSys:     units.HumanSize(float64(mStats.MSpanSys)),
 		MCacheInuse:  units.HumanSize(float64(mStats.MCacheInuse)),
 		MCacheSys:    units.HumanSize(float64(mStats.MCacheSys)),
+		stats:        &mStats,
 	}
 }
 
@@ -105,3 +142,45 @@ func GetNetworkMetrics() *NetworkStats {
 	networkStats.Tx = units.HumanSize(float64(networkStats.txBytes))
 	return networkStats
 }
+
+// SystemMetrics is a snapshot of the host metrics reported by the metrics endpoint
+type SystemMetrics struct {
+	Memory  *MemoryMetrics
+	Cpu     *CpuStats
+	Network *NetworkStats
+}
+
+// GetSystemMetrics gathers the memory, cpu and network metrics of the host
+func GetSystemMetrics() *SystemMetrics {
+	return &SystemMetrics{
+		Memory:  GetMemoryMetrics(),
+		Cpu:     GetCpuMetrics(),
+		Network: GetNetworkMetrics(),
+	}
+}
+
+// systemMetricsCache reuses a system metrics snapshot until it's older than ttl
+type systemMetricsCache struct {
+	ttl    time.Duration
+	gather func() *SystemMetrics
+
+	mu       sync.Mutex
+	snapshot *SystemMetrics
+	updated  time.Time
+}
+
+func newSystemMetricsCache(ttl time.Duration) *systemMetricsCache {
+	return &systemMetricsCache{ttl: ttl, gather: GetSystemMetrics}
+}
+
+// get returns the cached snapshot, gathering a new one when it's expired
+func (c *systemMetricsCache) get() *SystemMetrics {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.snapshot == nil || time.Since(c.updated) >= c.ttl {
+		c.snapshot = c.gather()
+		c.updated = time.Now()
+	}
+	return c.snapshot
+}
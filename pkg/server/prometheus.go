@@ -0,0 +1,151 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// prometheusContentType is the content type of the Prometheus text exposition format
+const prometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// acceptsPrometheus returns true if the client prefers the Prometheus text format
+// (text/plain or application/openmetrics-text) over json in its Accept header.
+// Wildcards are not taken into account so that json stays the default.
+func acceptsPrometheus(req *http.Request) bool {
+	var textQ, jsonQ float64
+	for _, part := range strings.Split(req.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+		switch mediaType {
+		case "text/plain", "application/openmetrics-text":
+			textQ = max(textQ, q)
+		case "application/json":
+			jsonQ = max(jsonQ, q)
+		}
+	}
+	return textQ > 0 && textQ > jsonQ
+}
+
+// prometheusLabelEscaper escapes the label values of the Prometheus text format
+var prometheusLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// prometheusWriter writes metrics in the Prometheus text exposition format
+type prometheusWriter struct {
+	w io.Writer
+}
+
+// write writes a metric along with its HELP and TYPE lines
+func (p prometheusWriter) write(name, kind, help string, value interface{}) {
+	_, _ = fmt.Fprintf(p.w, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", name, help, name, kind, name, value)
+}
+
+func (p prometheusWriter) counter(name, help string, value interface{}) {
+	p.write(name, "counter", help, value)
+}
+
+func (p prometheusWriter) gauge(name, help string, value interface{}) {
+	p.write(name, "gauge", help, value)
+}
+
+// writePrometheusMetrics writes the metrics in the Prometheus text exposition format.
+// The metric names are stable:
+//
+//	interactsh_<protocol>_interactions_total      interactions per protocol (dns, ftp, http, ldap, smb, smtp, tcp)
+//	interactsh_http_skipped_total                 http requests not recorded due to their method
+//	interactsh_sessions                           registered sessions
+//	interactsh_{kafka,cef}_{failed,dropped}_total interactions the exporters failed to send or dropped
+//	interactsh_token_requests_total{token}        requests authenticated with a client token, by token label
+//	interactsh_cache_*                            correlation cache and buffered interactions memory
+//	interactsh_memory_*                           go runtime memory statistics
+//	interactsh_cpu_*_ticks_total                  host cpu time per mode
+//	interactsh_network_*_bytes_total              host network traffic
+func writePrometheusMetrics(w io.Writer, m *Metrics) {
+	p := prometheusWriter{w: w}
+
+	for _, protocol := range []struct {
+		name  string
+		count uint64
+	}{
+		{"dns", m.Dns}, {"ftp", m.Ftp}, {"http", m.Http}, {"ldap", m.Ldap},
+		{"smb", m.Smb}, {"smtp", m.Smtp}, {"tcp", m.Tcp},
+	} {
+		p.counter("interactsh_"+protocol.name+"_interactions_total", "Total number of "+protocol.name+" interactions.", protocol.count)
+	}
+	p.counter("interactsh_http_skipped_total", "Total number of http requests not recorded due to their method.", m.HttpSkipped)
+	p.gauge("interactsh_sessions", "Number of registered sessions.", m.Sessions)
+	p.counter("interactsh_kafka_failed_total", "Total number of interactions that failed to be sent to kafka.", m.KafkaFailed)
+	p.counter("interactsh_kafka_dropped_total", "Total number of interactions dropped by the kafka exporter.", m.KafkaDropped)
+	p.counter("interactsh_cef_failed_total", "Total number of interactions that failed to be sent as cef events.", m.CEFFailed)
+	p.counter("interactsh_cef_dropped_total", "Total number of interactions dropped by the cef exporter.", m.CEFDropped)
+	if len(m.TokenUsage) > 0 {
+		labels := make([]string, 0, len(m.TokenUsage))
+		for label := range m.TokenUsage {
+			labels = append(labels, label)
+		}
+		sort.Strings(labels)
+		_, _ = fmt.Fprintf(w, "# HELP interactsh_token_requests_total Total number of requests authenticated with a client token.\n# TYPE interactsh_token_requests_total counter\n")
+		for _, label := range labels {
+			_, _ = fmt.Fprintf(w, "interactsh_token_requests_total{token=\"%s\"} %d\n", prometheusLabelEscaper.Replace(label), m.TokenUsage[label])
+		}
+	}
+
+	if cache := m.Cache; cache != nil {
+		p.counter("interactsh_cache_hits_total", "Total number of correlation cache hits.", cache.HitCount)
+		p.counter("interactsh_cache_misses_total", "Total number of correlation cache misses.", cache.MissCount)
+		p.counter("interactsh_cache_load_success_total", "Total number of successful correlation cache loads.", cache.LoadSuccessCount)
+		p.counter("interactsh_cache_load_errors_total", "Total number of failed correlation cache loads.", cache.LoadErrorCount)
+		p.counter("interactsh_cache_load_seconds_total", "Total time spent loading correlation cache entries.", cache.TotalLoadTime.Seconds())
+		p.counter("interactsh_cache_evictions_total", "Total number of correlation cache evictions.", cache.EvictionCount)
+		p.gauge("interactsh_cache_memory_bytes", "Bytes of interactions buffered in memory.", cache.MemoryUsage)
+		p.counter("interactsh_cache_memory_rejected_total", "Total number of interactions rejected by the storage memory limit.", cache.MemoryRejected)
+		p.counter("interactsh_cache_memory_evicted_total", "Total number of interactions evicted by the storage memory limit.", cache.MemoryEvicted)
+	}
+
+	if m.Memory != nil && m.Memory.stats != nil {
+		stats := m.Memory.stats
+		p.gauge("interactsh_memory_alloc_bytes", "Bytes of allocated heap objects.", stats.Alloc)
+		p.counter("interactsh_memory_alloc_bytes_total", "Total bytes allocated for heap objects.", stats.TotalAlloc)
+		p.gauge("interactsh_memory_sys_bytes", "Bytes of memory obtained from the OS.", stats.Sys)
+		p.counter("interactsh_memory_lookups_total", "Total number of pointer lookups.", stats.Lookups)
+		p.counter("interactsh_memory_mallocs_total", "Total number of heap objects allocated.", stats.Mallocs)
+		p.counter("interactsh_memory_frees_total", "Total number of heap objects freed.", stats.Frees)
+		p.gauge("interactsh_memory_heap_alloc_bytes", "Bytes of allocated heap objects.", stats.HeapAlloc)
+		p.gauge("interactsh_memory_heap_sys_bytes", "Bytes of heap memory obtained from the OS.", stats.HeapSys)
+		p.gauge("interactsh_memory_heap_idle_bytes", "Bytes in idle heap spans.", stats.HeapIdle)
+		p.gauge("interactsh_memory_heap_inuse_bytes", "Bytes in in-use heap spans.", stats.HeapInuse)
+		p.gauge("interactsh_memory_heap_released_bytes", "Bytes of physical memory returned to the OS.", stats.HeapReleased)
+		p.gauge("interactsh_memory_heap_objects", "Number of allocated heap objects.", stats.HeapObjects)
+		p.gauge("interactsh_memory_stack_inuse_bytes", "Bytes in stack spans.", stats.StackInuse)
+		p.gauge("interactsh_memory_stack_sys_bytes", "Bytes of stack memory obtained from the OS.", stats.StackSys)
+		p.gauge("interactsh_memory_mspan_inuse_bytes", "Bytes of allocated mspan structures.", stats.MSpanInuse)
+		p.gauge("interactsh_memory_mspan_sys_bytes", "Bytes of memory obtained from the OS for mspan structures.", stats.MSpanSys)
+		p.gauge("interactsh_memory_mcache_inuse_bytes", "Bytes of allocated mcache structures.", stats.MCacheInuse)
+		p.gauge("interactsh_memory_mcache_sys_bytes", "Bytes of memory obtained from the OS for mcache structures.", stats.MCacheSys)
+	}
+
+	if cpu := m.Cpu; cpu != nil {
+		p.counter("interactsh_cpu_user_ticks_total", "Host cpu time spent in user mode.", cpu.User)
+		p.counter("interactsh_cpu_system_ticks_total", "Host cpu time spent in system mode.", cpu.System)
+		p.counter("interactsh_cpu_idle_ticks_total", "Host cpu time spent idle.", cpu.Idle)
+		p.counter("interactsh_cpu_nice_ticks_total", "Host cpu time spent in user mode with low priority.", cpu.Nice)
+		p.counter("interactsh_cpu_ticks_total", "Host cpu time.", cpu.Total)
+	}
+
+	if network := m.Network; network != nil {
+		p.counter("interactsh_network_received_bytes_total", "Bytes received by the host network interfaces.", network.rxBytes)
+		p.counter("interactsh_network_transmitted_bytes_total", "Bytes transmitted by the host network interfaces.", network.txBytes)
+	}
+}
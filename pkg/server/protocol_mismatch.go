@@ -0,0 +1,174 @@
+package server
+
+import (
+	"bytes"
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/projectdiscovery/gologger"
+)
+
+// maxMismatchHeadSize is the maximum number of bytes read from a connection to detect its protocol
+const maxMismatchHeadSize = 4096
+
+var httpRequestLine = regexp.MustCompile(`^[A-Z]+ \S+ HTTP/[0-9.]+$`)
+
+// detectProtocol returns the protocol spoken by a client from the first bytes it sent,
+// and whether enough bytes were received to tell.
+func detectProtocol(head []byte) (string, bool) {
+	// tls handshake record
+	if len(head) >= 2 && head[0] == 0x16 && head[1] == 0x03 {
+		return "tls", true
+	}
+	idx := bytes.IndexByte(head, '\n')
+	if idx == -1 {
+		return "", len(head) >= maxMismatchHeadSize
+	}
+	line := strings.TrimSpace(string(head[:idx]))
+	if httpRequestLine.MatchString(line) {
+		return "http", true
+	}
+	verb := strings.ToUpper(line)
+	if i := strings.IndexByte(verb, ' '); i != -1 {
+		verb = verb[:i]
+	}
+	switch verb {
+	case "HELO", "EHLO", "MAIL", "RCPT":
+		return "smtp", true
+	case "USER", "PASS", "SYST", "PASV", "EPSV":
+		return "ftp", true
+	}
+	return "", true
+}
+
+// mismatchListener detects the clients of its connections speaking
+// another protocol than expected and records them as interactions
+type mismatchListener struct {
+	net.Listener
+	options  *Options
+	expected string
+}
+
+func (l *mismatchListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &mismatchConn{Conn: conn, options: l.options, expected: l.expected, receivedAt: time.Now()}, nil
+}
+
+// mismatchConn holds the first bytes read from a connection until its protocol is detected
+type mismatchConn struct {
+	net.Conn
+	options    *Options
+	expected   string
+	receivedAt time.Time
+
+	mu       sync.Mutex
+	head     []byte
+	detected string
+	done     bool
+}
+
+func (c *mismatchConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.sniff(b[:n])
+	}
+	return n, err
+}
+
+func (c *mismatchConn) Close() error {
+	c.mu.Lock()
+	if !c.done && c.detected != "" {
+		c.finish()
+	}
+	c.done = true
+	c.mu.Unlock()
+	return c.Conn.Close()
+}
+
+// sniff accumulates the bytes read until the protocol is detected. Mismatched
+// http requests are held until the end of their headers, which carry the host.
+func (c *mismatchConn) sniff(data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.done {
+		return
+	}
+	if remaining := maxMismatchHeadSize - len(c.head); len(data) > remaining {
+		data = data[:remaining]
+	}
+	c.head = append(c.head, data...)
+
+	if c.detected == "" {
+		detected, ok := detectProtocol(c.head)
+		if !ok {
+			return
+		}
+		if detected == "" || detected == c.expected {
+			c.done = true
+			c.head = nil
+			return
+		}
+		c.detected = detected
+	}
+	if c.detected == "http" && len(c.head) < maxMismatchHeadSize &&
+		!bytes.Contains(c.head, []byte("\r\n\r\n")) && !bytes.Contains(c.head, []byte("\n\n")) {
+		return
+	}
+	c.finish()
+}
+
+// finish records the mismatched protocol interaction. The caller must hold the lock.
+func (c *mismatchConn) finish() {
+	c.done = true
+	head := string(c.head)
+	c.head = nil
+	c.options.recordProtocolMismatch(c.expected, c.detected, head, c.RemoteAddr(), c.receivedAt)
+}
+
+// recordProtocolMismatch records a client speaking the detected protocol to
+// the server of the expected protocol when its request carries a correlation id
+func (options *Options) recordProtocolMismatch(expected, detected, rawRequest string, remoteAddr net.Addr, receivedAt time.Time) {
+	// binary handshakes can't carry a readable correlation id
+	var uniqueID, fullID string
+	if detected != "tls" {
+		uniqueID, fullID = options.scanCorrelationID(rawRequest)
+	}
+	if uniqueID == "" {
+		gologger.Debug().Msgf("Received %s request on the %s server from %s without correlation id\n", detected, expected, remoteAddr)
+		return
+	}
+
+	host, _, _ := net.SplitHostPort(remoteAddr.String())
+	correlationID := uniqueID[:options.CorrelationIdLength]
+	interaction := &Interaction{
+		Protocol:         expected,
+		UniqueID:         uniqueID,
+		FullId:           fullID,
+		CorrelationID:    correlationID,
+		RawRequest:       rawRequest,
+		ProtocolMismatch: true,
+		ExpectedProtocol: expected,
+		DetectedProtocol: detected,
+		RemoteAddress:    host,
+		ReceivedAt:       receivedAt,
+		Timestamp:        time.Now(),
+	}
+	options.tagInteraction(interaction)
+	data, err := options.encodeInteraction(interaction)
+	if err != nil {
+		gologger.Warning().Msgf("Could not encode protocol mismatch interaction: %s\n", err)
+		return
+	}
+	gologger.Debug().Msgf("Protocol Mismatch Interaction: \n%s\n", string(data))
+	options.publishInteraction(interaction, data)
+	if err := options.storeInteraction(correlationID, data); err != nil {
+		gologger.Warning().Msgf("Could not store protocol mismatch interaction: %s\n", err)
+	}
+}
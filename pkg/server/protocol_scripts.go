@@ -0,0 +1,202 @@
+package server
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// ProtocolScript is the declarative definition of the responses of a
+// protocol server, loaded from <protocol>.yaml in the ProtocolScriptDir
+type ProtocolScript struct {
+	// Banner are the lines sent instead of the greeting of the smtp and ftp servers
+	Banner []string `yaml:"banner"`
+	// Responses replace the responses to the matching commands or ldap operations
+	Responses []ProtocolScriptResponse `yaml:"responses"`
+}
+
+// ProtocolScriptResponse is the response sent to a command
+type ProtocolScriptResponse struct {
+	// Command is the smtp or ftp command (eg. VRFY), or the ldap operation
+	// (bind, search, compare, add, delete, modify) answered
+	Command string `yaml:"command"`
+	// Lines are the lines sent instead of the smtp or ftp server response
+	Lines []string `yaml:"lines"`
+	// Code is the result code of the ldap response
+	Code int `yaml:"code"`
+	// Message is the diagnostic message of the ldap response
+	Message string `yaml:"message"`
+}
+
+// LoadProtocolScript reads the script of a protocol from a directory,
+// returning nil when the directory has none for the protocol
+func LoadProtocolScript(dir, protocol string) (*ProtocolScript, error) {
+	data, err := os.ReadFile(filepath.Join(dir, protocol+".yaml"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read file")
+	}
+	script := &ProtocolScript{}
+	if err := yaml.Unmarshal(data, script); err != nil {
+		return nil, errors.Wrapf(err, "could not decode %s script", protocol)
+	}
+	for _, response := range script.Responses {
+		if response.Command == "" {
+			return nil, errors.Errorf("%s script response command is required", protocol)
+		}
+	}
+	return script, nil
+}
+
+// loadProtocolScript loads the script of a protocol from the ProtocolScriptDir when configured
+func (options *Options) loadProtocolScript(protocol string) (*ProtocolScript, error) {
+	if options.ProtocolScriptDir == "" {
+		return nil, nil
+	}
+	return LoadProtocolScript(options.ProtocolScriptDir, protocol)
+}
+
+// response returns the scripted response to a command
+func (s *ProtocolScript) response(command string) *ProtocolScriptResponse {
+	if s == nil {
+		return nil
+	}
+	for i := range s.Responses {
+		if strings.EqualFold(s.Responses[i].Command, command) {
+			return &s.Responses[i]
+		}
+	}
+	return nil
+}
+
+// scriptListener answers the line based protocol connections it accepts with the script responses
+type scriptListener struct {
+	net.Listener
+	script *ProtocolScript
+}
+
+func (l *scriptListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &scriptConn{Conn: conn, script: l.script}, nil
+}
+
+// scriptConn replaces the greeting and the responses written by the server
+// to the commands read from the connection. Each command is answered by
+// the next write, further writes for the same command are left untouched.
+type scriptConn struct {
+	net.Conn
+	script *ProtocolScript
+
+	mu       sync.Mutex
+	greeted  bool
+	pending  []byte
+	commands []string
+	// data is set while the client sends the message of a smtp DATA command
+	data bool
+	// encrypted is set once the connection is upgraded to tls
+	encrypted bool
+}
+
+func (c *scriptConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.read(b[:n])
+	}
+	return n, err
+}
+
+// read queues the commands of the complete lines read from the connection
+func (c *scriptConn) read(data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.encrypted {
+		return
+	}
+	c.pending = append(c.pending, data...)
+	for {
+		idx := bytes.IndexByte(c.pending, '\n')
+		if idx == -1 {
+			return
+		}
+		line := strings.TrimSpace(string(c.pending[:idx]))
+		c.pending = c.pending[idx+1:]
+		if c.data {
+			// the end of the message is answered like a command
+			if line == "." {
+				c.data = false
+				c.commands = append(c.commands, "")
+			}
+			continue
+		}
+		command := line
+		if i := strings.IndexByte(command, ' '); i != -1 {
+			command = command[:i]
+		}
+		c.commands = append(c.commands, strings.ToUpper(command))
+	}
+}
+
+func (c *scriptConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	out := c.rewrite(b)
+	c.mu.Unlock()
+
+	if _, err := c.Conn.Write(out); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// rewrite returns the data to write instead of the server response. The caller must hold the lock.
+func (c *scriptConn) rewrite(b []byte) []byte {
+	if c.encrypted {
+		return b
+	}
+	if !c.greeted {
+		c.greeted = true
+		if len(c.script.Banner) > 0 {
+			return scriptLines(c.script.Banner)
+		}
+		return b
+	}
+	if len(c.commands) == 0 {
+		return b
+	}
+	command := c.commands[0]
+	c.commands = c.commands[1:]
+
+	// the server state follows its own response rather than the scripted one
+	switch {
+	case command == "DATA" && strings.HasPrefix(string(b), "354"):
+		c.data = true
+	case command == "STARTTLS" && strings.HasPrefix(string(b), "220"),
+		command == "AUTH" && strings.HasPrefix(string(b), "234"):
+		c.encrypted = true
+	}
+	if response := c.script.response(command); response != nil && len(response.Lines) > 0 {
+		return scriptLines(response.Lines)
+	}
+	return b
+}
+
+// scriptLines returns the lines terminated by CRLF
+func scriptLines(lines []string) []byte {
+	var b strings.Builder
+	for _, line := range lines {
+		b.WriteString(line)
+		b.WriteString("\r\n")
+	}
+	return []byte(b.String())
+}
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/goburrow/cache"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/pkg/errors"
+	"github.com/projectdiscovery/gologger"
+	"github.com/rs/xid"
+)
+
+const (
+	// replayTimeout is the maximum time waited for the response of a replayed request
+	replayTimeout = 10 * time.Second
+	// maxReplayResponseSize is the maximum size of the replayed response body returned
+	maxReplayResponseSize = 1024 * 1024
+)
+
+// newReplayCache returns the cache of the raw http requests kept for /admin/replay, nil when disabled
+func newReplayCache(options *Options) cache.Cache {
+	if options.ReplayCacheSize <= 0 {
+		return nil
+	}
+	return cache.New(cache.WithMaximumSize(options.ReplayCacheSize), cache.WithExpireAfterWrite(time.Hour))
+}
+
+// keepForReplay assigns an id to the http interaction and keeps its raw request for /admin/replay
+func (h *HTTPServer) keepForReplay(interaction *Interaction) {
+	if h.replayRequests == nil {
+		return
+	}
+	interaction.ID = xid.New().String()
+	h.replayRequests.Put(interaction.ID, interaction.RawRequest)
+}
+
+// ReplayRequest is a request to replay a captured http interaction to a target
+type ReplayRequest struct {
+	// ID is the id of the captured http interaction
+	ID string `json:"id"`
+	// Target is the base url the request is sent to, its path prefixes the captured one
+	Target string `json:"target"`
+	// PreserveHost keeps the captured Host header instead of the target host
+	PreserveHost bool `json:"preserve-host,omitempty"`
+}
+
+// ReplayResponse is the response of the target to a replayed request
+type ReplayResponse struct {
+	StatusCode int `json:"status-code"`
+	// RawResponse is the response of the target, its body truncated to 1MB
+	RawResponse string `json:"raw-response"`
+}
+
+// replayHandler sends a captured http request to a target and returns its response
+func (h *HTTPServer) replayHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	r := &ReplayRequest{}
+	if err := jsoniter.NewDecoder(req.Body).Decode(r); err != nil {
+		jsonError(w, fmt.Sprintf("could not decode json body: %s", err), http.StatusBadRequest)
+		return
+	}
+	value, ok := h.replayRequests.GetIfPresent(r.ID)
+	if !ok {
+		jsonError(w, "interaction not found", http.StatusNotFound)
+		return
+	}
+	rawRequest, _ := value.(string)
+	replayed, err := newReplayRequest(rawRequest, r.Target, r.PreserveHost)
+	if err != nil {
+		jsonError(w, fmt.Sprintf("could not build replayed request: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	client := &http.Client{
+		Timeout: replayTimeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Do(replayed.WithContext(req.Context()))
+	if err != nil {
+		gologger.Warning().Msgf("Could not replay interaction %s to %s: %s\n", r.ID, r.Target, err)
+		jsonError(w, fmt.Sprintf("could not replay request: %s", err), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	resp.Body = io.NopCloser(io.LimitReader(resp.Body, maxReplayResponseSize))
+	dump, err := httputil.DumpResponse(resp, true)
+	if err != nil {
+		jsonError(w, fmt.Sprintf("could not read replayed response: %s", err), http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := jsoniter.NewEncoder(w).Encode(&ReplayResponse{StatusCode: resp.StatusCode, RawResponse: string(dump)}); err != nil {
+		gologger.Warning().Msgf("Could not encode replayed response: %s\n", err)
+	}
+	gologger.Debug().Msgf("Replayed interaction %s to %s\n", r.ID, r.Target)
+}
+
+// newReplayRequest parses a captured raw request into a request sent to the target
+func newReplayRequest(rawRequest, target string, preserveHost bool) (*http.Request, error) {
+	targetURL, err := url.Parse(target)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid target")
+	}
+	if targetURL.Scheme != "http" && targetURL.Scheme != "https" || targetURL.Host == "" {
+		return nil, errors.New("target must be an absolute http or https url")
+	}
+	reader := bufio.NewReader(strings.NewReader(rawRequest))
+	captured, err := http.ReadRequest(reader)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not parse captured request")
+	}
+	body, err := io.ReadAll(captured.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read captured request body")
+	}
+	// the dump of a request without Content-Length header ends with its body
+	if len(body) == 0 {
+		body, _ = io.ReadAll(reader)
+	}
+
+	replayURL := *targetURL
+	replayURL.Path = strings.TrimSuffix(targetURL.Path, "/") + captured.URL.Path
+	replayURL.RawPath = ""
+	replayURL.RawQuery = captured.URL.RawQuery
+	replayed, err := http.NewRequest(captured.Method, replayURL.String(), strings.NewReader(string(body)))
+	if err != nil {
+		return nil, err
+	}
+	replayed.Header = captured.Header.Clone()
+	if preserveHost {
+		replayed.Host = captured.Host
+	}
+	return replayed, nil
+}
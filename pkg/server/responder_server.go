@@ -7,7 +7,6 @@ import (
 	"strings"
 	"time"
 
-	jsoniter "github.com/json-iterator/go"
 	"github.com/projectdiscovery/gologger"
 	"github.com/projectdiscovery/interactsh/pkg/filewatcher"
 	fileutil "github.com/projectdiscovery/utils/file"
@@ -82,21 +81,7 @@ func (h *ResponderServer) ListenAndServe(responderAlive chan bool) error {
 						continue
 					}
 
-					// Correlation id doesn't apply here, we skip encryption
-					interaction := &Interaction{
-						Protocol:   "responder",
-						RawRequest: responderData,
-						Timestamp:  time.Now(),
-					}
-					data, err := jsoniter.Marshal(interaction)
-					if err != nil {
-						gologger.Warning().Msgf("Could not encode responder interaction: %s\n", err)
-					} else {
-						gologger.Debug().Msgf("Responder Interaction: \n%s\n", string(data))
-						if err := h.options.Storage.AddInteractionWithId(h.options.Token, data); err != nil {
-							gologger.Warning().Msgf("Could not store dns interaction: %s\n", err)
-						}
-					}
+					h.recordInteraction(responderData)
 				}
 			}
 		}
@@ -105,6 +90,27 @@ func (h *ResponderServer) ListenAndServe(responderAlive chan bool) error {
 	return h.cmd.Wait()
 }
 
+// recordInteraction stores and publishes a responder interaction in the auth token stream
+func (h *ResponderServer) recordInteraction(rawRequest string) {
+	// Correlation id doesn't apply here, we skip encryption
+	now := time.Now()
+	interaction := &Interaction{
+		Protocol:   "responder",
+		RawRequest: rawRequest,
+		ReceivedAt: now,
+		Timestamp:  now,
+	}
+	data, err := h.options.encodeInteraction(interaction)
+	if err != nil {
+		gologger.Warning().Msgf("Could not encode responder interaction: %s\n", err)
+		return
+	}
+	gologger.Debug().Msgf("Responder Interaction: \n%s\n", string(data))
+	if err := h.options.storeInteractionWithId(h.options.Token, interaction, data); err != nil {
+		gologger.Warning().Msgf("Could not store responder interaction: %s\n", err)
+	}
+}
+
 func (h *ResponderServer) Close() {
 	_ = h.cmd.Process.Kill()
 	if fileutil.FolderExists(h.tmpFolder) {
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	defaultResultBatchSize     = 100
+	defaultResultBatchInterval = time.Second
+)
+
+// resultBatcher groups interactions passed to the OnResultBatch callback,
+// flushing them once the batch is full or the interval elapses.
+type resultBatcher struct {
+	size     int
+	callback func([]*Interaction)
+
+	mu      sync.Mutex
+	pending []*Interaction
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+func newResultBatcher(size int, interval time.Duration, callback func([]*Interaction)) *resultBatcher {
+	if size <= 0 {
+		size = defaultResultBatchSize
+	}
+	if interval <= 0 {
+		interval = defaultResultBatchInterval
+	}
+	batcher := &resultBatcher{
+		size:     size,
+		callback: callback,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go batcher.run(interval)
+	return batcher
+}
+
+// add queues an interaction, flushing the batch when it's full
+func (b *resultBatcher) add(interaction *Interaction) {
+	b.mu.Lock()
+	b.pending = append(b.pending, interaction)
+	var batch []*Interaction
+	if len(b.pending) >= b.size {
+		batch = b.pending
+		b.pending = nil
+	}
+	b.mu.Unlock()
+
+	if len(batch) > 0 {
+		b.callback(batch)
+	}
+}
+
+// flush passes the queued interactions to the callback
+func (b *resultBatcher) flush() {
+	b.mu.Lock()
+	batch := b.pending
+	b.pending = nil
+	b.mu.Unlock()
+
+	if len(batch) > 0 {
+		b.callback(batch)
+	}
+}
+
+func (b *resultBatcher) run(interval time.Duration) {
+	defer close(b.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			b.flush()
+		case <-b.stop:
+			return
+		}
+	}
+}
+
+// close stops the periodic flushes and flushes the queued interactions
+func (b *resultBatcher) close() {
+	close(b.stop)
+	<-b.done
+	b.flush()
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// batchRecorder records the batches passed to OnResultBatch
+type batchRecorder struct {
+	mu      sync.Mutex
+	batches [][]*Interaction
+}
+
+func (r *batchRecorder) record(batch []*Interaction) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.batches = append(r.batches, batch)
+}
+
+func (r *batchRecorder) sizes() []int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	sizes := make([]int, len(r.batches))
+	for i, batch := range r.batches {
+		sizes[i] = len(batch)
+	}
+	return sizes
+}
+
+func TestOnResultBatchSize(t *testing.T) {
+	recorder := &batchRecorder{}
+	var single int
+	opts := &Options{
+		OnResult:              func(interface{}) { single++ },
+		OnResultBatch:         recorder.record,
+		OnResultBatchSize:     3,
+		OnResultBatchInterval: time.Hour,
+	}
+	for i := 0; i < 7; i++ {
+		opts.emitResult(&Interaction{Protocol: "dns"})
+	}
+	require.Equal(t, 7, single, "single callback should be called for every interaction")
+	require.Equal(t, []int{3, 3}, recorder.sizes())
+
+	opts.FlushResults()
+	require.Equal(t, []int{3, 3, 1}, recorder.sizes(), "pending interactions should be flushed")
+}
+
+func TestOnResultBatchInterval(t *testing.T) {
+	recorder := &batchRecorder{}
+	opts := &Options{
+		OnResultBatch:         recorder.record,
+		OnResultBatchSize:     100,
+		OnResultBatchInterval: 200 * time.Millisecond,
+	}
+	defer opts.FlushResults()
+
+	opts.emitResult(&Interaction{Protocol: "http"})
+	opts.emitResult(&Interaction{Protocol: "dns"})
+	require.Empty(t, recorder.sizes(), "batch should not be flushed before the interval")
+	require.Eventually(t, func() bool {
+		sizes := recorder.sizes()
+		return len(sizes) == 1 && sizes[0] == 2
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestOnResultTokenProtocols(t *testing.T) {
+	recorder := &batchRecorder{}
+	var protocols []string
+	opts := &Options{Token: "client-secret"}
+	newTestStore(t, opts, "client-secret")
+	opts.OnResult = func(out interface{}) { protocols = append(protocols, out.(*Interaction).Protocol) }
+	opts.OnResultBatch = recorder.record
+	opts.OnResultBatchSize = 3
+	opts.OnResultBatchInterval = time.Hour
+
+	(&FTPServer{options: opts}).recordInteraction("192.0.2.1:2121", "USER test")
+	(&SMBServer{options: opts}).recordInteraction("smb data")
+	(&ResponderServer{options: opts}).recordInteraction("responder data")
+	require.Equal(t, []string{"ftp", "smb", "responder"}, protocols)
+	require.Equal(t, []int{3}, recorder.sizes())
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+)
+
+// rttConnContextKey is the context key of the connection of an http request
+type rttConnContextKey struct{}
+
+// rttConnContext keeps the connection of the http requests in their
+// context so the round trip time can be measured when they are recorded
+func (h *HTTPServer) rttConnContext(ctx context.Context, conn net.Conn) context.Context {
+	if !h.options.MeasureRTT {
+		return ctx
+	}
+	return context.WithValue(ctx, rttConnContextKey{}, conn)
+}
+
+// requestRTT returns the round trip time of the connection of an http request in milliseconds
+func (h *HTTPServer) requestRTT(ctx context.Context) float64 {
+	conn, ok := ctx.Value(rttConnContextKey{}).(net.Conn)
+	if !ok {
+		return 0
+	}
+	return h.options.measureRTT(conn)
+}
+
+// measureRTT returns the round trip time estimated by the kernel for a tcp
+// connection in milliseconds. It is best-effort and returns zero when
+// the measure is disabled or not available for the connection.
+func (options *Options) measureRTT(conn net.Conn) float64 {
+	if !options.MeasureRTT {
+		return 0
+	}
+	for {
+		switch c := conn.(type) {
+		case *tls.Conn:
+			conn = c.NetConn()
+		case *mismatchConn:
+			conn = c.Conn
+		case *net.TCPConn:
+			rtt, ok := tcpRTT(c)
+			if !ok {
+				return 0
+			}
+			return float64(rtt.Microseconds()) / 1000
+		default:
+			return 0
+		}
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package server
+
+import (
+	"net"
+	"time"
+)
+
+func tcpRTT(conn *net.TCPConn) (time.Duration, bool) {
+	return 0, false
+}
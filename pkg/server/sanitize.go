@@ -0,0 +1,88 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/pkg/errors"
+)
+
+// encodeInteraction encodes the interaction in json, escaping the non-printable content of
+// its raw request and response and adding the server info and session metadata when enabled
+func (options *Options) encodeInteraction(interaction *Interaction) ([]byte, error) {
+	if options.IncludeServerInfo && interaction.ServerInfo == nil {
+		interaction.ServerInfo = currentServerInfo()
+	}
+	if options.SessionMetadata && interaction.SessionMetadata == nil {
+		if correlationID := options.interactionCorrelationID(interaction); correlationID != "" {
+			interaction.SessionMetadata = options.sessionMetadata(correlationID)
+		}
+	}
+	if options.SanitizeNonPrintable {
+		sanitizeInteraction(interaction)
+	}
+	return jsoniter.Marshal(interaction)
+}
+
+// sanitizeInteraction escapes the non-printable content of the raw request and response
+func sanitizeInteraction(interaction *Interaction) {
+	if interaction.Sanitized {
+		return
+	}
+	interaction.RawRequest = EscapeNonPrintable(interaction.RawRequest)
+	interaction.RawResponse = EscapeNonPrintable(interaction.RawResponse)
+	interaction.Sanitized = true
+}
+
+// EscapeNonPrintable escapes the bytes of s which are not printable utf-8 as \xHH.
+// Line breaks and tabs are kept, backslashes are doubled so the escaping is reversible.
+func EscapeNonPrintable(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case r == '\\':
+			b.WriteString(`\\`)
+		case r == '\r' || r == '\n' || r == '\t':
+			b.WriteRune(r)
+		case r == utf8.RuneError && size == 1, !unicode.IsPrint(r):
+			for j := i; j < i+size; j++ {
+				fmt.Fprintf(&b, `\x%02x`, s[j])
+			}
+		default:
+			b.WriteString(s[i : i+size])
+		}
+		i += size
+	}
+	return b.String()
+}
+
+// UnescapeNonPrintable returns the original content of a string escaped with EscapeNonPrintable
+func UnescapeNonPrintable(s string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+1 < len(s) && s[i+1] == '\\' {
+			b.WriteByte('\\')
+			i++
+			continue
+		}
+		if i+3 >= len(s) || s[i+1] != 'x' {
+			return "", errors.Errorf("invalid escape sequence at offset %d", i)
+		}
+		value, err := strconv.ParseUint(s[i+2:i+4], 16, 8)
+		if err != nil {
+			return "", errors.Wrapf(err, "invalid escape sequence at offset %d", i)
+		}
+		b.WriteByte(byte(value))
+		i += 3
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"testing"
+	"unicode"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEscapeNonPrintable(t *testing.T) {
+	tests := []struct {
+		input   string
+		escaped string
+	}{
+		{"GET / HTTP/1.1\r\nHost: example.com\r\n\r\n", "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"},
+		{"\x16\x03\x01\x00\xff", `\x16\x03\x01\x00\xff`},
+		{"\x1b[31mred\x1b[0m", `\x1b[31mred\x1b[0m`},
+		{`C:\path\x00`, `C:\\path\\x00`},
+		{"héllo wörld", "héllo wörld"},
+		{"bell\a\ttab", `bell\x07` + "\ttab"},
+	}
+	for _, test := range tests {
+		escaped := EscapeNonPrintable(test.input)
+		require.Equal(t, test.escaped, escaped)
+		unescaped, err := UnescapeNonPrintable(escaped)
+		require.NoError(t, err)
+		require.Equal(t, test.input, unescaped)
+	}
+
+	_, err := UnescapeNonPrintable(`\x1`)
+	require.Error(t, err)
+	_, err = UnescapeNonPrintable(`\q`)
+	require.Error(t, err)
+}
+
+func TestEncodeInteractionSanitized(t *testing.T) {
+	binary := "\x00\x01\x02\xfe\xff\x1b]0;title\x07"
+	opts := &Options{SanitizeNonPrintable: true}
+	data, err := opts.encodeInteraction(&Interaction{Protocol: "tcp", RawRequest: binary, RawResponse: "ok\x00"})
+	require.NoError(t, err)
+
+	interaction := &Interaction{}
+	require.NoError(t, jsoniter.Unmarshal(data, interaction))
+	require.True(t, interaction.Sanitized)
+	for _, r := range interaction.RawRequest + interaction.RawResponse {
+		require.True(t, unicode.IsPrint(r), "unexpected non-printable rune %q", r)
+	}
+	rawRequest, err := UnescapeNonPrintable(interaction.RawRequest)
+	require.NoError(t, err)
+	require.Equal(t, binary, rawRequest)
+	rawResponse, err := UnescapeNonPrintable(interaction.RawResponse)
+	require.NoError(t, err)
+	require.Equal(t, "ok\x00", rawResponse)
+
+	// interactions are left untouched when disabled
+	opts.SanitizeNonPrintable = false
+	data, err = opts.encodeInteraction(&Interaction{Protocol: "tcp", RawRequest: "\x00"})
+	require.NoError(t, err)
+	interaction = &Interaction{}
+	require.NoError(t, jsoniter.Unmarshal(data, interaction))
+	require.False(t, interaction.Sanitized)
+	require.Equal(t, "\x00", interaction.RawRequest)
+}
@@ -1,9 +1,14 @@
 package server
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
 	"crypto/tls"
+	"encoding/hex"
 	"net"
+	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/projectdiscovery/interactsh/pkg/server/acme"
@@ -19,16 +24,96 @@ type Interaction struct {
 	UniqueID string `json:"unique-id"`
 	// FullId is the full path for the subdomain receiving the interaction.
 	FullId string `json:"full-id"`
+	// CorrelationID is the correlation id prefix of the unique id.
+	CorrelationID string `json:"correlation-id,omitempty"`
+	// FuzzyMatched is set when the correlation id was matched to the closest registered one
+	FuzzyMatched bool `json:"fuzzy-matched,omitempty"`
+	// ID identifies the http interactions kept to be replayed with /admin/replay
+	ID string `json:"id,omitempty"`
+	// Fingerprint is a stable hash of the interaction key contents (see interactionFingerprint)
+	Fingerprint string `json:"fingerprint,omitempty"`
 	// QType is the question type for the interaction
 	QType string `json:"q-type,omitempty"`
+	// DNSClass is the question class of the DNS query (IN, CH, HS, etc.)
+	DNSClass string `json:"dns-class,omitempty"`
+	// DoH is set for DNS queries received over HTTPS
+	DoH bool `json:"doh,omitempty"`
+	// DNSHop is the hop of the wildcard CNAME resolution (1 for the CNAME answer, 2 for its target)
+	DNSHop int `json:"dns-hop,omitempty"`
+	// DNSOpcode is the opcode of the DNS query
+	DNSOpcode string `json:"dns-opcode,omitempty"`
+	// DNSFlags are the header flags set on the DNS query
+	DNSFlags []string `json:"dns-flags,omitempty"`
+	// DNSPadded is set when the DNS response carries an EDNS0 padding option
+	DNSPadded bool `json:"dns-padded,omitempty"`
+	// DNSQuestionCount is the number of questions of the DNS query
+	DNSQuestionCount int `json:"dns-question-count,omitempty"`
+	// DNSQuestions are the names of all the questions of a multi-question DNS query
+	DNSQuestions []string `json:"dns-questions,omitempty"`
+	// DNSForwarded is set for DNS queries relayed to the upstream resolver
+	DNSForwarded bool `json:"dns-forwarded,omitempty"`
 	// RawRequest is the raw request received by the interactsh server.
 	RawRequest string `json:"raw-request,omitempty"`
 	// RawResponse is the raw response sent by the interactsh server.
 	RawResponse string `json:"raw-response,omitempty"`
+	// Sanitized is set when the non-printable bytes of RawRequest and RawResponse
+	// are escaped as \xHH, UnescapeNonPrintable restores them
+	Sanitized bool `json:"sanitized,omitempty"`
+	// DecodedData is the base64 decoded value of the labels preceding the correlation id
+	DecodedData string `json:"decoded-data,omitempty"`
+	// QueryParams are the query parameters of HTTP requests
+	QueryParams map[string][]string `json:"query-params,omitempty"`
+	// Likely is the classification of http interactions (canary, data or scan)
+	Likely string `json:"likely,omitempty"`
+	// JWTClaims is the decoded header and claims of the JWT bearer token of HTTP requests
+	JWTClaims *JWTClaims `json:"jwt-claims,omitempty"`
+	// ServerInfo identifies the server instance and process which recorded the interaction
+	ServerInfo *ServerInfo `json:"server-info,omitempty"`
+	// SessionMetadata is the metadata sent by the client at registration
+	SessionMetadata map[string]string `json:"session-metadata,omitempty"`
+	// Deprecated is set for interactions received on a deprecated domain
+	Deprecated bool `json:"deprecated,omitempty"`
+	// Tags are added by the matching tagging rules
+	Tags []string `json:"tags,omitempty"`
+	// MatchedRules are the ids of the tagging rules which matched, in the order of the rules
+	MatchedRules []string `json:"matched-rules,omitempty"`
+	// NoHost is set for HTTP requests received without a Host header
+	NoHost bool `json:"no-host,omitempty"`
+	// SNI is the TLS server name sent by the client, independently of the host header
+	SNI string `json:"sni,omitempty"`
+	// ClientCertSubject is the subject of the TLS client certificate presented by the client
+	ClientCertSubject string `json:"client-cert-subject,omitempty"`
+	// ClientCertFingerprint is the hex encoded sha256 fingerprint of the TLS client certificate
+	ClientCertFingerprint string `json:"client-cert-fingerprint,omitempty"`
+	// WebSocket is set for HTTP requests attempting a websocket upgrade
+	WebSocket bool `json:"websocket,omitempty"`
+	// WebSocketData are the data frames sent by the client after the websocket handshake,
+	// set along with the websocket protocol for the upgrades completed with CaptureWebSocket
+	WebSocketData []string `json:"websocket-data,omitempty"`
 	// SMTPFrom is the mail form field
 	SMTPFrom string `json:"smtp-from,omitempty"`
+	// SMTPStartTLS is set when the smtp client upgraded the connection with STARTTLS
+	SMTPStartTLS bool `json:"smtp-starttls,omitempty"`
+	// SMTPAuthMechanism is the AUTH mechanism used by the smtp client
+	SMTPAuthMechanism string `json:"smtp-auth-mechanism,omitempty"`
+	// SMTPAuthUsername is the decoded AUTH username offered by the smtp client
+	SMTPAuthUsername string `json:"smtp-auth-username,omitempty"`
+	// SMTPAuthPassword is the decoded AUTH password offered by the smtp client
+	SMTPAuthPassword string `json:"smtp-auth-password,omitempty"`
+	// ProtocolMismatch is set for requests of another protocol than the one of the server receiving them
+	ProtocolMismatch bool `json:"protocol-mismatch,omitempty"`
+	// ExpectedProtocol is the protocol of the server receiving a mismatched request
+	ExpectedProtocol string `json:"expected-protocol,omitempty"`
+	// DetectedProtocol is the protocol detected from the first bytes of a mismatched request
+	DetectedProtocol string `json:"detected-protocol,omitempty"`
 	// RemoteAddress is the remote address for interaction
 	RemoteAddress string `json:"remote-address"`
+	// ReceivedAt is the time the request was received, before its processing
+	ReceivedAt time.Time `json:"received-at"`
+	// RequestReadMs is the time spent reading the HTTP request body in milliseconds
+	RequestReadMs int64 `json:"request-read-ms,omitempty"`
+	// RTTMs is the round trip time estimated for the connection of the remote host in milliseconds
+	RTTMs float64 `json:"rtt-ms,omitempty"`
 	// Timestamp is the timestamp for the interaction
 	Timestamp time.Time           `json:"timestamp"`
 	AsnInfo   []map[string]string `json:"asninfo,omitempty"`
@@ -38,12 +123,34 @@ type Interaction struct {
 type Options struct {
 	// Domains is the list domains for the instance.
 	Domains []string
+	// DeprecatedDomains are domains of Domains still answered during a migration,
+	// whose interactions are flagged as deprecated
+	DeprecatedDomains []string
+	// WarnDeprecatedDomains logs interactions on deprecated domains and adds a Warning header to http responses
+	WarnDeprecatedDomains bool
 	// IPAddresses contains the IP addresses (IPv4 and/or IPv6) for the server
 	IPAddresses []net.IP
+	// DNSAnswerPool contains the IP addresses wildcard subdomains are answered with instead of IPAddresses
+	DNSAnswerPool []net.IP
+	// DNSAnswerStrategy selects the DNSAnswerPool addresses of each answer (roundrobin, random, all)
+	DNSAnswerStrategy string
 	// ListenIP is the IP address to listen servers on
 	ListenIP string
 	// DomainPort is the port to listen DNS servers on
 	DnsPort int
+	// DNSTCPOnly serves DNS over TCP only, without the UDP listener
+	DNSTCPOnly bool
+	// DNSTruncateUDP answers UDP queries with the truncated flag instead of dropping them (requires DNSTCPOnly)
+	DNSTruncateUDP bool
+	// DNSEDNSPadding pads the responses to EDNS0 queries to a multiple of this block size
+	DNSEDNSPadding int
+	// DNSRefuseMultiQuestion records the DNS queries with more than one question and refuses them
+	DNSRefuseMultiQuestion bool
+	// DNSUpstream is the resolver the DNS queries for names outside the configured domains are forwarded to,
+	// the forwarded queries are recorded under Token which must be set
+	DNSUpstream string
+	// DNSDisableCompression sends DNS responses without name compression pointers
+	DNSDisableCompression bool
 	// HttpPort is the port to listen HTTP server on
 	HttpPort int
 	// HttpsPort is the port to listen HTTPS server on
@@ -56,6 +163,24 @@ type Options struct {
 	SmtpsPort int
 	// SmtpAutoTLSPort is the port to listen Smtp autoTLS server on
 	SmtpAutoTLSPort int
+	// SMTPAdvertiseSTARTTLS offers STARTTLS on the plain smtp ports
+	SMTPAdvertiseSTARTTLS bool
+	// SMTPRequireTLS requires STARTTLS on the plain smtp ports before accepting mail
+	SMTPRequireTLS bool
+	// SMTPCaptureAuth records the smtp AUTH mechanism and username in interactions
+	SMTPCaptureAuth bool
+	// SMTPCapturePassword also records the smtp AUTH password (requires SMTPCaptureAuth)
+	SMTPCapturePassword bool
+	// SMTPTranscriptSize is the maximum size in bytes of the smtp transcript recorded as raw request (0 records only the message data)
+	SMTPTranscriptSize int
+	// DetectProtocolMismatch records the requests of other protocols sent to the plain http, smtp and ftp servers
+	DetectProtocolMismatch bool
+	// ProtocolScriptDir holds the smtp.yaml, ftp.yaml and ldap.yaml scripts of the responses of these servers
+	ProtocolScriptDir string
+	// SanitizeNonPrintable escapes the non-printable bytes of the raw requests and responses of the interactions
+	SanitizeNonPrintable bool
+	// MeasureRTT records the round trip time estimated for the tcp connections of http and tcp interactions
+	MeasureRTT bool
 	// FtpPort is the port to listen Ftp server on
 	FtpPort int
 	// FtpsPort is the port to listen Ftps server on
@@ -74,26 +199,159 @@ type Options struct {
 	HTTPDirectory string
 	// Token required to retrieve interactions
 	Token string
+	// Tokens are additional tokens accepted by the client endpoints, mapped to the label of their user
+	Tokens map[string]string
+	// AuthVerifier when set replaces the static token check of the client endpoints
+	AuthVerifier func(req *http.Request) bool
+	// AdminToken required to access the admin endpoints (disabled if empty)
+	AdminToken string
+	// AdminListenAddr is the address of a dedicated listener serving the admin and metrics endpoints
+	AdminListenAddr string
+	// ReplayCacheSize is the number of captured http requests kept to be replayed with /admin/replay (0 to disable)
+	ReplayCacheSize int
+	// PollErrorRate is the fraction (0.0-1.0) of poll requests failing with a simulated error
+	PollErrorRate float64
+	// MaxDynamicHeaders is the maximum number of dynamic response headers applied per request (0 for no limit)
+	MaxDynamicHeaders int
+	// MaxDynamicResponseSize is the maximum size in bytes of streamed dynamic responses (10MB when not set)
+	MaxDynamicResponseSize int64
+	// MaxResponseDelay is the upper bound of the delay requested with dynamic responses (0 for no limit)
+	MaxResponseDelay time.Duration
+	// SitemapXML is the response for /sitemap.xml ({DOMAIN} and {REFLECTION} placeholders are replaced)
+	SitemapXML string
+	// KafkaBrokers are the kafka brokers interactions are published to
+	KafkaBrokers []string
+	// KafkaTopic is the kafka topic interactions are published to
+	KafkaTopic string
+	// KafkaUsername is the SASL/PLAIN username for the kafka brokers
+	KafkaUsername string
+	// KafkaPassword is the SASL/PLAIN password for the kafka brokers
+	KafkaPassword string
+	// KafkaTLS enables TLS for the kafka brokers connection
+	KafkaTLS bool
+	// Kafka publishes interactions when set
+	Kafka *KafkaPublisher
+	// CEFCollector is the http(s) url or udp/tcp syslog address interactions are forwarded to in CEF format
+	CEFCollector string
+	// CEF forwards interactions in CEF format when set
+	CEF *CEFExporter
+	// DedupWindow drops the interactions repeating the fingerprint of one recorded for the same correlation ID within the window
+	DedupWindow time.Duration
+	// DedupPath is the leveldb directory persisting the dedup fingerprints across restarts
+	DedupPath string
+	// Dedup drops the duplicate interactions when set
+	Dedup *Deduplicator
+	// DeregisterGracePeriod keeps deregistered sessions pollable for the duration before removing them
+	DeregisterGracePeriod time.Duration
+	// MaxConcurrentPolls is the maximum number of simultaneous polls per correlation ID (0 for no limit)
+	MaxConcurrentPolls int
+	// PollRateLimit is the maximum number of polls per second per correlation ID (0 for no limit)
+	PollRateLimit int
+	// PollRateBurst is the number of polls per correlation ID allowed above the rate limit (PollRateLimit if not set)
+	PollRateBurst int
+	// MaxPollBatch is the maximum number of interactions returned per poll (0 for no limit)
+	MaxPollBatch int
+	// HTTPDisableCompression sends the poll and metrics responses uncompressed, for proxies already compressing them
+	HTTPDisableCompression bool
+	// RegisterRateLimit is the maximum number of registrations per minute from a source IP (0 for no limit)
+	RegisterRateLimit int
+	// RequireTLSForAPI rejects the requests to the client api endpoints received on the plain http server
+	RequireTLSForAPI bool
+	// ApidocsIndex lists the registered dynamic endpoint suburls at /apidocs/
+	ApidocsIndex bool
+	// DynamicEndpointsFile is a YAML file of dynamic endpoints served at /apidocs/
+	DynamicEndpointsFile string
+	// DynamicEndpointsReload is how often the DynamicEndpointsFile is checked for changes (0 to never reload it)
+	DynamicEndpointsReload time.Duration
+	// DynamicEndpointTTL is how long endpoints registered through /storerequest are served (0 to never expire them)
+	DynamicEndpointTTL time.Duration
+	// DynamicEndpointCooldown is the minimum interval between updates of a suburl (24 hours if not set)
+	DynamicEndpointCooldown time.Duration
+	// MaxRetention is the upper bound of the interaction retention requested by clients (0 for no bound)
+	MaxRetention time.Duration
+	// EnableTrace echoes TRACE requests back to the client (otherwise they are refused)
+	EnableTrace bool
+	// CaptureWebSocket completes websocket upgrades and records the frames sent by clients
+	CaptureWebSocket bool
+	// MaxURLLength is the maximum length of a request url before it's rejected (0 disables the limit)
+	MaxURLLength int
+	// TaggingRules tag the interactions matching them
+	TaggingRules []*TaggingRule
+	// UserAgentResponses are served to the http clients whose User-Agent matches them, the first match wins
+	UserAgentResponses []*UserAgentResponse
 	// Enable root tld interactions
 	RootTLD bool
-	// OriginURL for the HTTP Server
+	// RecordACMEChallenges records ACME HTTP-01 challenge requests as interactions with the acme protocol
+	RecordACMEChallenges bool
+	// OriginURLs are the origins allowed by the CORS headers of the HTTP Server. A single
+	// entry is always sent as is, otherwise the request origin is sent back when it
+	// matches one of them, supporting * wildcards (eg. https://*.example.com)
+	OriginURLs []string
+	// OriginURL is the origin allowed by the CORS headers of the HTTP Server when OriginURLs is empty.
+	//
+	// Deprecated: use OriginURLs instead.
 	OriginURL string
 	// FTPDirectory or temporary one
 	FTPDirectory string
+	// TCPPort is the port to listen the generic tcp server on
+	TCPPort int
+	// TCPBanner is sent to the clients of the generic tcp server when they connect
+	TCPBanner string
+	// TCPMaxSize is the maximum size in bytes of the data recorded from a tcp connection
+	TCPMaxSize int
 	// ScanEverywhere for potential correlation id
 	ScanEverywhere bool
+	// ScanCookies are the names of the cookies scanned for potential correlation id
+	ScanCookies []string
+	// ScanFormFields scans the field values of POST form bodies for potential correlation id
+	ScanFormFields bool
+	// RequireCorrelationID rejects http requests without correlation id (client api endpoints are exempt)
+	RequireCorrelationID bool
+	// UnmatchedResponse is the body of the 404 response to rejected requests (the connection is closed if empty)
+	UnmatchedResponse string
+	// LogUnmatched logs the http requests rejected for missing a correlation id
+	LogUnmatched bool
+	// DecodeExfil decodes base64 data in the labels preceding the correlation id of http and dns interactions
+	DecodeExfil bool
+	// ClassifyInteractions classifies http interactions as likely canary, data or scan requests
+	ClassifyInteractions bool
+	// DecodeJWT decodes the header and claims of the JWT bearer token of http interactions, redacting its signature
+	DecodeJWT bool
+	// RecordMethods are the http methods recorded as interactions, requests of other methods are only answered (all if empty)
+	RecordMethods []string
+	// IncludeServerInfo adds the hostname, pid and start time of the server to the interactions
+	IncludeServerInfo bool
+	// SessionMetadata attaches the metadata sent by the clients at registration to their interactions
+	SessionMetadata bool
+	// CorrelationPosition is where correlation ids are searched in requests (anywhere or leftmost label)
+	CorrelationPosition string
+	// FuzzyCorrelationDistance is the maximum edit distance of the correlation ids matched to the
+	// closest registered one when none is registered exactly (0 to disable)
+	FuzzyCorrelationDistance int
 	// CorrelationIdLength of preamble
 	CorrelationIdLength int
 	// CorrelationIdNonceLength of the unique identifier
 	CorrelationIdNonceLength int
+	// CorrelationSalt requires clients to register with the HMAC of their correlation ID
+	// under the salt, so that guessed IDs can't be registered and their interactions stored
+	CorrelationSalt string
 	// Certificate Path
 	CertificatePath string
 	// Private Key Path
 	PrivateKeyPath string
 	// CustomRecords is a file containing custom DNS records
 	CustomRecords string
+	// DoH answers DNS over HTTPS queries on the /dns-query http endpoint
+	DoH bool
+	// DNSVersionString answers CHAOS class version.bind and hostname.bind queries when set
+	DNSVersionString string
+	// DNSWildcardCNAMESuffix answers correlation subdomains with a CNAME to the subdomain
+	// suffixed with the given label, recording both hops of the resolution
+	DNSWildcardCNAMESuffix string
 	// HTTP header containing origin IP
 	OriginIPHeader string
+	// AccessLogPath is a file a json line is appended to for every http request, matched or not
+	AccessLogPath string
 	// Version is the version of interactsh server
 	Version string
 	// DiskStorage enables storing interactions on disk
@@ -104,26 +362,143 @@ type Options struct {
 	DynamicResp bool
 	// EnableMetrics enables metrics endpoint
 	EnableMetrics bool
+	// MetricsCacheTTL is how long the system metrics of the metrics endpoint are reused (0 to gather them on every request)
+	MetricsCacheTTL time.Duration
 	// ServerToken hide server version in HTTP response X-Interactsh-Version header
 	NoVersionHeader bool
 	// HeaderServer use custom string in HTTP response Server header instead of domain
 	HeaderServer string
+	// ServerHeaderPool are the HTTP response Server header values selected per request instead of HeaderServer or the domain
+	ServerHeaderPool []string
+	// ServerHeaderStrategy selects the ServerHeaderPool value of each response (roundrobin, random)
+	ServerHeaderStrategy string
 	// DefaultHTTPResponseFile is a file to serve for all HTTP requests (takes priority over other options)
 	DefaultHTTPResponseFile string
+	// TLSSessionTicketsDisabled disables TLS session ticket resumption on the HTTPS server
+	TLSSessionTicketsDisabled bool
+	// CaptureClientCert requests a client certificate on the HTTPS server, without requiring it
+	CaptureClientCert bool
+	// OCSPStapleFile is a DER encoded OCSP response stapled on HTTPS handshakes
+	OCSPStapleFile string
+	// InvalidCertSNIs are the server names (and their subdomains) served a self-signed certificate for another hostname
+	InvalidCertSNIs []string
+	// TLSALPNChallenge answers ACME TLS-ALPN-01 challenges of the ACMEStore on the https listener
+	TLSALPNChallenge bool
+	// DefaultCacheHeaders are caching headers (Cache-Control, ETag, Expires) set on every HTTP response
+	DefaultCacheHeaders map[string]string
 
 	ACMEStore *acme.Provider
 	Stats     *Metrics
-	OnResult  OnResultCallback
+	// OnResult is called with every interaction
+	OnResult OnResultCallback
+	// OnResultBatch is called with batches of interactions, flushed when
+	// OnResultBatchSize is reached or every OnResultBatchInterval
+	OnResultBatch         func([]*Interaction)
+	OnResultBatchSize     int
+	OnResultBatchInterval time.Duration
+
+	batcherOnce sync.Once
+	batcher     *resultBatcher
+
+	// campaigns aggregates the interactions of the correlation ids registered under a campaign,
+	// deregistered ones are kept until purged for the analysis of the whole campaign
+	campaignsOnce sync.Once
+	campaigns     *campaignTracker
+
+	// notifier wakes up the event streams when their interactions are stored
+	notifierOnce sync.Once
+	notifier     *interactionNotifier
 
 	Certificates []tls.Certificate
 	CertFiles    []acme.CertificateFiles
+	// CertificateStore serves the tls certificates and reloads them without restart
+	CertificateStore *acme.CertificateStore
 }
 type OnResultCallback func(out interface{})
 
+// interactionFingerprint returns the hex encoded sha256 of the protocol, remote
+// address, path (url path for http, lowercase queried name for dns) and question
+// type of an interaction joined by newlines, so it can be reproduced externally.
+func interactionFingerprint(protocol, remoteAddress, path, qType string) string {
+	hash := sha256.Sum256([]byte(strings.Join([]string{protocol, remoteAddress, path, qType}, "\n")))
+	return hex.EncodeToString(hash[:])
+}
+
+// CorrelationHMAC returns the hex encoded HMAC-SHA256 of the correlation ID under the salt
+func CorrelationHMAC(salt, correlationID string) string {
+	mac := hmac.New(sha256.New, []byte(salt))
+	mac.Write([]byte(strings.ToLower(correlationID)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// validCorrelationHMAC returns true if no salt is configured or the HMAC matches the correlation ID
+func (options *Options) validCorrelationHMAC(correlationID, correlationHMAC string) bool {
+	if options.CorrelationSalt == "" {
+		return true
+	}
+	return hmac.Equal([]byte(CorrelationHMAC(options.CorrelationSalt, correlationID)), []byte(strings.ToLower(correlationHMAC)))
+}
+
 func (options *Options) GetIdLength() int {
 	return options.CorrelationIdLength + options.CorrelationIdNonceLength
 }
 
+// interactionCorrelationID returns the correlation ID of the interaction, derived
+// from its unique ID for the protocols not setting CorrelationID
+func (options *Options) interactionCorrelationID(interaction *Interaction) string {
+	if interaction.CorrelationID != "" {
+		return interaction.CorrelationID
+	}
+	if options.CorrelationIdLength > 0 && len(interaction.UniqueID) >= options.CorrelationIdLength {
+		return interaction.UniqueID[:options.CorrelationIdLength]
+	}
+	return ""
+}
+
+// sessionMetadata returns the metadata sent by the client of the correlation ID at registration
+func (options *Options) sessionMetadata(correlationID string) map[string]string {
+	item, err := options.Storage.GetCacheItem(correlationID)
+	if err != nil {
+		return nil
+	}
+	item.Lock()
+	defer item.Unlock()
+	return item.Metadata
+}
+
+// publishInteraction publishes the interaction to the configured callbacks and outputs
+func (options *Options) publishInteraction(interaction *Interaction, data []byte) {
+	options.campaignTracker().track(options.interactionCorrelationID(interaction), interaction)
+	options.emitResult(interaction)
+	if options.Kafka != nil {
+		options.Kafka.Publish(data)
+	}
+	if options.CEF != nil {
+		options.CEF.Export(interaction)
+	}
+}
+
+// emitResult passes the interaction to the OnResult and OnResultBatch callbacks
+func (options *Options) emitResult(interaction *Interaction) {
+	if options.OnResult != nil {
+		options.OnResult(interaction)
+	}
+	if options.OnResultBatch != nil {
+		options.batcherOnce.Do(func() {
+			options.batcher = newResultBatcher(options.OnResultBatchSize, options.OnResultBatchInterval, options.OnResultBatch)
+		})
+		options.batcher.add(interaction)
+	}
+}
+
+// FlushResults stops the OnResultBatch periodic flushes and passes
+// the pending interactions to the callback.
+func (options *Options) FlushResults() {
+	if options.batcher != nil {
+		options.batcher.close()
+	}
+}
+
 // URLReflection returns a reversed part of the URL payload
 // which is checked in the response.
 func (options *Options) URLReflection(URL string) string {
@@ -139,6 +514,10 @@ func (options *Options) URLReflection(URL string) string {
 // getURLIDComponent returns the interactsh ID
 func (options *Options) getURLIDComponent(URL string) string {
 	parts := strings.Split(URL, ".")
+	// hosts without a domain part (eg. missing host header) carry no id
+	if len(parts) < 2 {
+		return ""
+	}
 	// ignore the domain parts
 	var randomID string
 	for _, part := range parts[:len(parts)-2] {
@@ -0,0 +1,26 @@
+package server
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+// processStartedAt is the time the server process started at
+var processStartedAt = time.Now()
+
+// ServerInfo identifies the server instance and process which recorded an interaction
+type ServerInfo struct {
+	// Hostname is the hostname of the server instance
+	Hostname string `json:"hostname,omitempty"`
+	// PID is the process id of the server
+	PID int `json:"pid"`
+	// StartedAt is the time the server process started at
+	StartedAt time.Time `json:"started-at"`
+}
+
+// currentServerInfo returns the ServerInfo of the running process
+var currentServerInfo = sync.OnceValue(func() *ServerInfo {
+	hostname, _ := os.Hostname()
+	return &ServerInfo{Hostname: hostname, PID: os.Getpid(), StartedAt: processStartedAt}
+})
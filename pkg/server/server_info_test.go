@@ -0,0 +1,39 @@
+package server
+
+import (
+	"os"
+	"testing"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIncludeServerInfo(t *testing.T) {
+	opts := newTestOptions([]string{"192.0.2.50"}, "127.0.0.1")
+	opts.CorrelationIdLength = 10
+	store := newTestStore(t, opts, "abcdefghij")
+	dnsServer := NewDNSServer("udp", opts)
+
+	query := func() *Interaction {
+		req := new(dns.Msg)
+		req.SetQuestion("abcdefghij.example.com.", dns.TypeA)
+		dnsServer.ServeDNS(&testResponseWriter{}, req)
+
+		data, err := store.GetInteractionsWithIdForConsumer("abcdefghij", "consumer")
+		require.NoError(t, err)
+		require.Len(t, data, 1)
+		interaction := &Interaction{}
+		require.NoError(t, jsoniter.UnmarshalFromString(data[0], interaction))
+		return interaction
+	}
+	require.Nil(t, query().ServerInfo)
+
+	opts.IncludeServerInfo = true
+	interaction := query()
+	require.NotNil(t, interaction.ServerInfo)
+	hostname, _ := os.Hostname()
+	require.Equal(t, hostname, interaction.ServerInfo.Hostname)
+	require.Equal(t, os.Getpid(), interaction.ServerInfo.PID)
+	require.True(t, interaction.ServerInfo.StartedAt.Equal(processStartedAt))
+}
@@ -12,3 +12,23 @@ func TestGetURLIDComponent(t *testing.T) {
 	random := options.getURLIDComponent("c6rj61aciaeutn2ae680cg5.ugboyyyyyn.interactsh.com")
 	require.Equal(t, "ugboyyyyyn", random, "could not get correct component")
 }
+
+func TestGetURLIDComponentWithoutDomain(t *testing.T) {
+	options := Options{CorrelationIdLength: settings.CorrelationIdLengthDefault, CorrelationIdNonceLength: settings.CorrelationIdNonceLengthDefault}
+	require.Equal(t, "", options.getURLIDComponent(""), "empty host should not have a component")
+	require.Equal(t, "", options.URLReflection("localhost"), "single label host should not have a reflection")
+}
+
+func TestDecodeExfil(t *testing.T) {
+	t.Run("base64", func(t *testing.T) {
+		require.Equal(t, "test", decodeExfil("dGVzdA.abcdefghijklm.example.com", "abcdefghijklm"))
+		require.Equal(t, "secret data", decodeExfil("c2VjcmV0.IGRhdGE.abcdefghijklm.example.com", "abcdefghijklm"), "labels should be joined")
+		require.Equal(t, "root:x:0:0", decodeExfil("cm9vdDp4OjA6MA.ABCDEFGHIJKLM.example.com", "abcdefghijklm"))
+	})
+	t.Run("not base64", func(t *testing.T) {
+		require.Empty(t, decodeExfil("www.abcdefghijklm.example.com", "abcdefghijklm"), "short labels should be ignored")
+		require.Empty(t, decodeExfil("hello.abcdefghijklm.example.com", "abcdefghijklm"))
+		require.Empty(t, decodeExfil("AAECAwQF.abcdefghijklm.example.com", "abcdefghijklm"), "binary data should be ignored")
+		require.Empty(t, decodeExfil("abcdefghijklm.example.com", "abcdefghijklm"))
+	})
+}
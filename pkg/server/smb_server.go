@@ -8,7 +8,6 @@ import (
 	"sync/atomic"
 	"time"
 
-	jsoniter "github.com/json-iterator/go"
 	"github.com/projectdiscovery/gologger"
 	"github.com/projectdiscovery/interactsh/pkg/filewatcher"
 	fileutil "github.com/projectdiscovery/utils/file"
@@ -94,21 +93,7 @@ func (h *SMBServer) ListenAndServe(smbAlive chan bool) error {
 						continue
 					}
 
-					// Correlation id doesn't apply here, we skip encryption
-					interaction := &Interaction{
-						Protocol:   "smb",
-						RawRequest: smbData,
-						Timestamp:  time.Now(),
-					}
-					data, err := jsoniter.Marshal(interaction)
-					if err != nil {
-						gologger.Warning().Msgf("Could not encode smb interaction: %s\n", err)
-					} else {
-						gologger.Debug().Msgf("SMB Interaction: \n%s\n", string(data))
-						if err := h.options.Storage.AddInteractionWithId(h.options.Token, data); err != nil {
-							gologger.Warning().Msgf("Could not store dns interaction: %s\n", err)
-						}
-					}
+					h.recordInteraction(smbData)
 				}
 			}
 		}
@@ -117,6 +102,27 @@ func (h *SMBServer) ListenAndServe(smbAlive chan bool) error {
 	return h.cmd.Wait()
 }
 
+// recordInteraction stores and publishes a smb interaction in the auth token stream
+func (h *SMBServer) recordInteraction(rawRequest string) {
+	// Correlation id doesn't apply here, we skip encryption
+	now := time.Now()
+	interaction := &Interaction{
+		Protocol:   "smb",
+		RawRequest: rawRequest,
+		ReceivedAt: now,
+		Timestamp:  now,
+	}
+	data, err := h.options.encodeInteraction(interaction)
+	if err != nil {
+		gologger.Warning().Msgf("Could not encode smb interaction: %s\n", err)
+		return
+	}
+	gologger.Debug().Msgf("SMB Interaction: \n%s\n", string(data))
+	if err := h.options.storeInteractionWithId(h.options.Token, interaction, data); err != nil {
+		gologger.Warning().Msgf("Could not store smb interaction: %s\n", err)
+	}
+}
+
 func (h *SMBServer) Close() {
 	_ = h.cmd.Process.Kill()
 	if fileutil.FileExists(h.tmpFile) {
@@ -8,7 +8,7 @@ import (
 	"time"
 
 	"git.mills.io/prologic/smtpd"
-	jsoniter "github.com/json-iterator/go"
+	"github.com/goburrow/cache"
 	"github.com/projectdiscovery/gologger"
 	stringsutil "github.com/projectdiscovery/utils/strings"
 )
@@ -19,14 +19,42 @@ type SMTPServer struct {
 	options     *Options
 	smtpServer  smtpd.Server
 	smtpsServer smtpd.Server
+	// upgraded holds the remote addresses of connections upgraded with STARTTLS
+	upgraded cache.Cache
+	// credentials holds the AUTH credentials offered by the remote addresses
+	credentials cache.Cache
+	// transcripts holds the transcripts of the connections by remote address
+	transcripts cache.Cache
+	// script holds the scripted responses of the plain smtp listeners
+	script *ProtocolScript
+}
+
+// smtpAuth is the AUTH exchange offered by a smtp client
+type smtpAuth struct {
+	Mechanism string
+	Username  string
+	Password  string
 }
 
 // NewSMTPServer returns a new TLS & Non-TLS SMTP server.
 func NewSMTPServer(options *Options) (*SMTPServer, error) {
-	server := &SMTPServer{options: options}
+	server := &SMTPServer{
+		options:     options,
+		upgraded:    cache.New(cache.WithExpireAfterWrite(time.Hour)),
+		credentials: cache.New(cache.WithExpireAfterWrite(time.Hour)),
+		transcripts: cache.New(cache.WithExpireAfterWrite(time.Hour)),
+	}
+	script, err := options.loadProtocolScript("smtp")
+	if err != nil {
+		return nil, err
+	}
+	server.script = script
 
-	authHandler := func(remoteAddr net.Addr, mechanism string, username []byte, password []byte, shared []byte) (bool, error) {
-		return true, nil
+	authHandler := smtpd.AuthHandler(server.authHandler)
+	var authMechs map[string]bool
+	if options.SMTPCaptureAuth {
+		// misconfigured clients often authenticate in plaintext, accept it to capture the credentials
+		authMechs = map[string]bool{"LOGIN": true, "PLAIN": true}
 	}
 	rcptHandler := func(remoteAddr net.Addr, from string, to string) bool {
 		return true
@@ -34,6 +62,7 @@ func NewSMTPServer(options *Options) (*SMTPServer, error) {
 	server.smtpServer = smtpd.Server{
 		Addr:        formatAddress(options.ListenIP, options.SmtpPort),
 		AuthHandler: authHandler,
+		AuthMechs:   authMechs,
 		HandlerRcpt: rcptHandler,
 		Hostname:    options.Domains[0],
 		Appname:     "interactsh",
@@ -42,6 +71,7 @@ func NewSMTPServer(options *Options) (*SMTPServer, error) {
 	server.smtpsServer = smtpd.Server{
 		Addr:        formatAddress(options.ListenIP, options.SmtpsPort),
 		AuthHandler: authHandler,
+		AuthMechs:   authMechs,
 		HandlerRcpt: rcptHandler,
 		Hostname:    options.Domains[0],
 		Appname:     "interactsh",
@@ -60,35 +90,113 @@ func (h *SMTPServer) ListenAndServe(tlsConfig *tls.Config, smtpAlive, smtpsAlive
 		srv.TLSConfig = tlsConfig
 
 		smtpsAlive <- true
-		err := srv.ListenAndServe()
+		err := h.listenAndServe(srv)
 		if err != nil {
 			gologger.Error().Msgf("Could not serve smtp with tls on port %d: %s\n", h.options.SmtpAutoTLSPort, err)
 			smtpsAlive <- false
 		}
 	}()
 
+	h.configureSTARTTLS(tlsConfig)
+
 	smtpAlive <- true
 	go func() {
-		if err := h.smtpServer.ListenAndServe(); err != nil {
+		if err := h.listenAndServe(&h.smtpServer); err != nil {
 			smtpAlive <- false
 			gologger.Error().Msgf("Could not serve smtp on port %d: %s\n", h.options.SmtpPort, err)
 		}
 	}()
-	if err := h.smtpsServer.ListenAndServe(); err != nil {
+	if err := h.listenAndServe(&h.smtpsServer); err != nil {
 		gologger.Error().Msgf("Could not serve smtp on port %d: %s\n", h.options.SmtpsPort, err)
 		smtpAlive <- false
 	}
 }
 
+// listenAndServe listens on the address of the smtp server and serves it
+func (h *SMTPServer) listenAndServe(srv *smtpd.Server) error {
+	if h.options.SMTPTranscriptSize <= 0 && !h.options.DetectProtocolMismatch && h.script == nil {
+		return srv.ListenAndServe()
+	}
+	// defaults applied by smtpd.Server.ListenAndServe
+	if srv.Timeout == 0 {
+		srv.Timeout = 5 * time.Minute
+	}
+	ln, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		return err
+	}
+	return h.serve(srv, ln)
+}
+
+// serve serves the smtp server on the listener, recording the transcripts,
+// answering with the scripted responses and detecting the other protocols
+// sent to it when enabled
+func (h *SMTPServer) serve(srv *smtpd.Server, ln net.Listener) error {
+	if h.options.SMTPTranscriptSize > 0 {
+		ln = &transcriptListener{Listener: ln, server: h}
+	}
+	// the transcript records the scripted responses sent to the client
+	if h.script != nil {
+		ln = &scriptListener{Listener: ln, script: h.script}
+	}
+	if h.options.DetectProtocolMismatch {
+		ln = &mismatchListener{Listener: ln, options: h.options, expected: "smtp"}
+	}
+	return srv.Serve(ln)
+}
+
+// configureSTARTTLS offers and optionally enforces STARTTLS on the plain smtp listeners
+func (h *SMTPServer) configureSTARTTLS(tlsConfig *tls.Config) {
+	if tlsConfig == nil || !(h.options.SMTPAdvertiseSTARTTLS || h.options.SMTPRequireTLS) {
+		return
+	}
+	config := tlsConfig.Clone()
+	config.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		h.upgraded.Put(hello.Conn.RemoteAddr().String(), true)
+		return nil, nil
+	}
+	for _, srv := range []*smtpd.Server{&h.smtpServer, &h.smtpsServer} {
+		srv.TLSConfig = config
+		srv.TLSRequired = h.options.SMTPRequireTLS
+	}
+}
+
+// authHandler accepts any credentials, recording them when capture is enabled
+func (h *SMTPServer) authHandler(remoteAddr net.Addr, mechanism string, username []byte, password []byte, shared []byte) (bool, error) {
+	if h.options.SMTPCaptureAuth {
+		auth := smtpAuth{Mechanism: mechanism, Username: string(username)}
+		// CRAM-MD5 only offers a digest of the password
+		if h.options.SMTPCapturePassword && mechanism != "CRAM-MD5" {
+			auth.Password = string(password)
+		}
+		h.credentials.Put(remoteAddr.String(), auth)
+	}
+	return true, nil
+}
+
 // defaultHandler is a handler for default collaborator requests
 func (h *SMTPServer) defaultHandler(remoteAddr net.Addr, from string, to []string, data []byte) error {
+	receivedAt := time.Now()
 	atomic.AddUint64(&h.options.Stats.Smtp, 1)
 
+	_, startTLS := h.upgraded.GetIfPresent(remoteAddr.String())
+	var auth smtpAuth
+	if value, ok := h.credentials.GetIfPresent(remoteAddr.String()); ok {
+		auth, _ = value.(smtpAuth)
+	}
+
 	var uniqueID, fullID string
 
 	dataString := string(data)
 	gologger.Debug().Msgf("New SMTP request: %s %s %s %s\n", remoteAddr, from, to, dataString)
 
+	// the transcript already carries the message data
+	rawRequest := dataString
+	transcript := h.transcript(remoteAddr)
+	if transcript != "" {
+		rawRequest = transcript
+	}
+
 	// if root-tld is enabled stores any interaction towards the main domain
 	for _, addr := range to {
 		if h.options.RootTLD {
@@ -98,20 +206,25 @@ func (h *SMTPServer) defaultHandler(remoteAddr net.Addr, from string, to []strin
 					host, _, _ := net.SplitHostPort(remoteAddr.String())
 					address := addr[strings.LastIndex(addr, "@"):]
 					interaction := &Interaction{
-						Protocol:      "smtp",
-						UniqueID:      address,
-						FullId:        address,
-						RawRequest:    dataString,
-						SMTPFrom:      from,
-						RemoteAddress: host,
-						Timestamp:     time.Now(),
+						Protocol:          "smtp",
+						UniqueID:          address,
+						FullId:            address,
+						RawRequest:        rawRequest,
+						SMTPFrom:          from,
+						SMTPStartTLS:      startTLS,
+						SMTPAuthMechanism: auth.Mechanism,
+						SMTPAuthUsername:  auth.Username,
+						SMTPAuthPassword:  auth.Password,
+						RemoteAddress:     host,
+						ReceivedAt:        receivedAt,
+						Timestamp:         time.Now(),
 					}
-					data, err := jsoniter.Marshal(interaction)
+					data, err := h.options.encodeInteraction(interaction)
 					if err != nil {
 						gologger.Warning().Msgf("Could not encode root tld SMTP interaction: %s\n", err)
 					} else {
 						gologger.Debug().Msgf("Root TLD SMTP Interaction: \n%s\n", string(data))
-						if err := h.options.Storage.AddInteractionWithId(ID, data); err != nil {
+						if err := h.options.storeInteractionWithId(ID, interaction, data); err != nil {
 							gologger.Warning().Msgf("Could not store root tld smtp interaction: %s\n", err)
 						}
 					}
@@ -134,25 +247,36 @@ func (h *SMTPServer) defaultHandler(remoteAddr net.Addr, from string, to []strin
 			}
 		}
 	}
+	// mails not addressed to a correlation id may still carry one in the transcript
+	if uniqueID == "" && transcript != "" {
+		uniqueID, fullID = h.options.scanCorrelationID(transcript)
+	}
 	if uniqueID != "" {
 		host, _, _ := net.SplitHostPort(remoteAddr.String())
 
 		correlationID := uniqueID[:h.options.CorrelationIdLength]
 		interaction := &Interaction{
-			Protocol:      "smtp",
-			UniqueID:      uniqueID,
-			FullId:        fullID,
-			RawRequest:    dataString,
-			SMTPFrom:      from,
-			RemoteAddress: host,
-			Timestamp:     time.Now(),
+			Protocol:          "smtp",
+			UniqueID:          uniqueID,
+			FullId:            fullID,
+			RawRequest:        rawRequest,
+			SMTPFrom:          from,
+			SMTPStartTLS:      startTLS,
+			SMTPAuthMechanism: auth.Mechanism,
+			SMTPAuthUsername:  auth.Username,
+			SMTPAuthPassword:  auth.Password,
+			RemoteAddress:     host,
+			ReceivedAt:        receivedAt,
+			Timestamp:         time.Now(),
 		}
-		data, err := jsoniter.Marshal(interaction)
+		h.options.tagInteraction(interaction)
+		data, err := h.options.encodeInteraction(interaction)
 		if err != nil {
 			gologger.Warning().Msgf("Could not encode smtp interaction: %s\n", err)
 		} else {
 			gologger.Debug().Msgf("%s\n", string(data))
-			if err := h.options.Storage.AddInteraction(correlationID, data); err != nil {
+			h.options.publishInteraction(interaction, data)
+			if err := h.options.storeInteraction(correlationID, data); err != nil {
 				gologger.Warning().Msgf("Could not store smtp interaction: %s\n", err)
 			}
 		}
@@ -0,0 +1,280 @@
+package server
+
+import (
+	"crypto/tls"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSMTPServerSTARTTLS(t *testing.T) {
+	t.Run("upgraded", func(t *testing.T) {
+		interaction := sendTestMail(t, newTestSMTPOptions(), func(client *smtp.Client) {
+			require.NoError(t, client.StartTLS(&tls.Config{InsecureSkipVerify: true}))
+		})
+		require.True(t, interaction.SMTPStartTLS)
+	})
+	t.Run("plaintext", func(t *testing.T) {
+		interaction := sendTestMail(t, newTestSMTPOptions(), nil)
+		require.False(t, interaction.SMTPStartTLS)
+	})
+}
+
+func TestSMTPServerCaptureAuth(t *testing.T) {
+	plainAuth := func(client *smtp.Client) {
+		require.NoError(t, client.Auth(smtp.PlainAuth("", "leaked-user", "leaked-password", "127.0.0.1")))
+	}
+	t.Run("disabled", func(t *testing.T) {
+		opts := newTestSMTPOptions()
+		opts.SMTPCapturePassword = true
+		interaction := sendTestMail(t, opts, func(client *smtp.Client) {
+			require.NoError(t, client.StartTLS(&tls.Config{InsecureSkipVerify: true}))
+			plainAuth(client)
+		})
+		require.Empty(t, interaction.SMTPAuthMechanism)
+		require.Empty(t, interaction.SMTPAuthUsername)
+		require.Empty(t, interaction.SMTPAuthPassword)
+	})
+	t.Run("username", func(t *testing.T) {
+		opts := newTestSMTPOptions()
+		opts.SMTPCaptureAuth = true
+		interaction := sendTestMail(t, opts, plainAuth)
+		require.Equal(t, "PLAIN", interaction.SMTPAuthMechanism)
+		require.Equal(t, "leaked-user", interaction.SMTPAuthUsername)
+		require.Empty(t, interaction.SMTPAuthPassword, "password should only be captured when enabled")
+	})
+	t.Run("password", func(t *testing.T) {
+		opts := newTestSMTPOptions()
+		opts.SMTPCaptureAuth = true
+		opts.SMTPCapturePassword = true
+		interaction := sendTestMail(t, opts, plainAuth)
+		require.Equal(t, "leaked-user", interaction.SMTPAuthUsername)
+		require.Equal(t, "leaked-password", interaction.SMTPAuthPassword)
+	})
+}
+
+func TestSMTPServerTranscript(t *testing.T) {
+	t.Run("conversation", func(t *testing.T) {
+		opts := newTestSMTPOptions()
+		opts.SMTPTranscriptSize = 65536
+		interaction := sendTestMail(t, opts, nil)
+		require.True(t, strings.HasPrefix(interaction.RawRequest, "S: 220 "))
+		for _, line := range []string{
+			"C: EHLO localhost\r\n",
+			"C: MAIL FROM:<sender@example.org>",
+			"S: 250 2.1.0 Ok\r\n",
+			"C: RCPT TO:<user@abcdefghijklm.example.com>\r\n",
+			"C: DATA\r\n",
+			"S: 354 Start mail input; end with <CR><LF>.<CR><LF>\r\n",
+			"C: Subject: test\r\n",
+			"C: hello\r\n",
+			"C: .\r\n",
+		} {
+			require.Contains(t, interaction.RawRequest, line)
+		}
+	})
+	t.Run("redacted auth", func(t *testing.T) {
+		opts := newTestSMTPOptions()
+		opts.SMTPTranscriptSize = 65536
+		opts.SMTPCaptureAuth = true
+		interaction := sendTestMail(t, opts, func(client *smtp.Client) {
+			require.NoError(t, client.Auth(smtp.PlainAuth("", "leaked-user", "leaked-password", "127.0.0.1")))
+		})
+		require.Contains(t, interaction.RawRequest, "C: AUTH PLAIN [redacted]\r\n")
+	})
+	t.Run("size cap", func(t *testing.T) {
+		opts := newTestSMTPOptions()
+		opts.SMTPTranscriptSize = 128
+		interaction := sendTestMail(t, opts, nil)
+		require.LessOrEqual(t, len(interaction.RawRequest), 128+len("[truncated]\r\n"))
+		require.True(t, strings.HasSuffix(interaction.RawRequest, "[truncated]\r\n"))
+	})
+	t.Run("correlation id", func(t *testing.T) {
+		opts := newTestSMTPOptions()
+		opts.SMTPTranscriptSize = 65536
+		store := newTestStore(t, opts, "abcdefghij")
+		addr := startTestSMTPServer(t, false, opts)
+
+		client, err := smtp.Dial(addr)
+		require.NoError(t, err)
+		defer client.Close()
+		require.NoError(t, client.Mail("sender@abcdefghijklm.example.com"))
+		require.NoError(t, client.Rcpt("user@example.org"))
+		w, err := client.Data()
+		require.NoError(t, err)
+		_, err = w.Write([]byte("Subject: test\r\n\r\nhello\r\n"))
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+
+		data, err := store.GetInteractionsWithIdForConsumer("abcdefghij", "consumer")
+		require.NoError(t, err)
+		require.Len(t, data, 1)
+		interaction := &Interaction{}
+		require.NoError(t, jsoniter.UnmarshalFromString(data[0], interaction))
+		require.Equal(t, "abcdefghijklm", interaction.UniqueID)
+		require.Equal(t, "abcdefghijklm.example.com", interaction.FullId)
+	})
+}
+
+func TestSMTPServerProtocolScript(t *testing.T) {
+	dir := t.TempDir()
+	script := `banner:
+  - "220 mx.example.org ESMTP Postfix"
+responses:
+  - command: VRFY
+    lines:
+      - "252 2.0.0 Cannot VRFY user"
+`
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "smtp.yaml"), []byte(script), 0o600))
+
+	opts := newTestSMTPOptions()
+	opts.ProtocolScriptDir = dir
+	opts.SMTPTranscriptSize = 65536
+	interaction := sendTestMail(t, opts, func(client *smtp.Client) {
+		id, err := client.Text.Cmd("VRFY root")
+		require.NoError(t, err)
+		client.Text.StartResponse(id)
+		defer client.Text.EndResponse(id)
+		_, msg, err := client.Text.ReadResponse(252)
+		require.NoError(t, err)
+		require.Equal(t, "2.0.0 Cannot VRFY user", msg)
+	})
+	require.True(t, strings.HasPrefix(interaction.RawRequest, "S: 220 mx.example.org ESMTP Postfix\r\n"))
+	require.Contains(t, interaction.RawRequest, "C: VRFY root\r\nS: 252 2.0.0 Cannot VRFY user\r\n")
+	// the commands without a scripted response are answered by the server
+	require.Contains(t, interaction.RawRequest, "S: 354 Start mail input")
+}
+
+func TestSMTPServerProtocolMismatch(t *testing.T) {
+	opts := newTestSMTPOptions()
+	opts.DetectProtocolMismatch = true
+	store := newTestStore(t, opts, "abcdefghij")
+	addr := startTestSMTPServer(t, false, opts)
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET /index.html HTTP/1.1\r\nHost: abcdefghijklm.example.com\r\nUser-Agent: test\r\n\r\n"))
+	require.NoError(t, err)
+
+	var data []string
+	require.Eventually(t, func() bool {
+		data, err = store.GetInteractionsWithIdForConsumer("abcdefghij", "consumer")
+		return err == nil && len(data) > 0
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Len(t, data, 1)
+	interaction := &Interaction{}
+	require.NoError(t, jsoniter.UnmarshalFromString(data[0], interaction))
+	require.True(t, interaction.ProtocolMismatch)
+	require.Equal(t, "smtp", interaction.Protocol)
+	require.Equal(t, "smtp", interaction.ExpectedProtocol)
+	require.Equal(t, "http", interaction.DetectedProtocol)
+	require.Equal(t, "abcdefghijklm", interaction.UniqueID)
+	require.Equal(t, "abcdefghijklm.example.com", interaction.FullId)
+	require.Contains(t, interaction.RawRequest, "GET /index.html HTTP/1.1\r\n")
+	require.Contains(t, interaction.RawRequest, "User-Agent: test\r\n")
+
+	// smtp clients are not flagged
+	sendTestMail(t, opts, nil)
+}
+
+func TestDetectProtocol(t *testing.T) {
+	tests := []struct {
+		head     string
+		protocol string
+		ok       bool
+	}{
+		{"GET / HTTP/1.1\r\n", "http", true},
+		{"EHLO client.example.org\r\n", "smtp", true},
+		{"USER anonymous\r\n", "ftp", true},
+		{"\x16\x03\x01\x02\x00", "tls", true},
+		{"NOOP\r\n", "", true},
+		{"GET / HTTP", "", false},
+	}
+	for _, test := range tests {
+		protocol, ok := detectProtocol([]byte(test.head))
+		require.Equal(t, test.protocol, protocol, test.head)
+		require.Equal(t, test.ok, ok, test.head)
+	}
+}
+
+func TestSMTPServerRequireTLS(t *testing.T) {
+	addr := startTestSMTPServer(t, true, newTestOptions(nil, "127.0.0.1"))
+
+	client, err := smtp.Dial(addr)
+	require.NoError(t, err)
+	defer client.Close()
+
+	ok, _ := client.Extension("STARTTLS")
+	require.True(t, ok)
+	require.Error(t, client.Mail("sender@example.org"))
+}
+
+// newTestSMTPOptions returns options accepting correlation ids such as abcdefghijklm
+func newTestSMTPOptions() *Options {
+	opts := newTestOptions(nil, "127.0.0.1")
+	opts.CorrelationIdLength = 10
+	opts.CorrelationIdNonceLength = 3
+	return opts
+}
+
+// sendTestMail delivers a mail to a registered correlation id and returns the stored interaction,
+// prepare is invoked on the session before the mail transaction.
+func sendTestMail(t *testing.T, opts *Options, prepare func(client *smtp.Client)) *Interaction {
+	t.Helper()
+
+	store := newTestStore(t, opts, "abcdefghij")
+	addr := startTestSMTPServer(t, false, opts)
+
+	client, err := smtp.Dial(addr)
+	require.NoError(t, err)
+	defer client.Close()
+
+	ok, _ := client.Extension("STARTTLS")
+	require.True(t, ok)
+	if prepare != nil {
+		prepare(client)
+	}
+	require.NoError(t, client.Mail("sender@example.org"))
+	require.NoError(t, client.Rcpt("user@abcdefghijklm.example.com"))
+	w, err := client.Data()
+	require.NoError(t, err)
+	_, err = w.Write([]byte("Subject: test\r\n\r\nhello\r\n"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	require.NoError(t, client.Quit())
+
+	data, err := store.GetInteractionsWithIdForConsumer("abcdefghij", "consumer")
+	require.NoError(t, err)
+	require.Len(t, data, 1)
+	interaction := &Interaction{}
+	require.NoError(t, jsoniter.UnmarshalFromString(data[0], interaction))
+	return interaction
+}
+
+// startTestSMTPServer serves the plain smtp server with STARTTLS advertised on a random local port
+func startTestSMTPServer(t *testing.T, requireTLS bool, opts *Options) string {
+	t.Helper()
+
+	if opts.Stats == nil {
+		opts.Stats = &Metrics{}
+	}
+	opts.SMTPAdvertiseSTARTTLS = true
+	opts.SMTPRequireTLS = requireTLS
+	smtpServer, err := NewSMTPServer(opts)
+	require.NoError(t, err)
+	smtpServer.configureSTARTTLS(&tls.Config{Certificates: []tls.Certificate{newTestCertificate(t)}})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+	go func() { _ = smtpServer.serve(&smtpServer.smtpServer, listener) }()
+	return listener.Addr().String()
+}
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"sync"
+)
+
+// smtpTranscript is the command/response exchange of a smtp connection,
+// recorded line by line up to a maximum size
+type smtpTranscript struct {
+	mu      sync.Mutex
+	buf     bytes.Buffer
+	maxSize int
+	// pending holds the client bytes read since the last complete line
+	pending []byte
+	// auth is set while the server is waiting for an AUTH continuation
+	auth bool
+	// redactAuth hides the AUTH arguments and continuations carrying the credentials
+	redactAuth bool
+	// starttls is set while the server is answering a STARTTLS command
+	starttls bool
+	// encrypted is set once the connection is upgraded with STARTTLS
+	encrypted bool
+	truncated bool
+}
+
+// read records the client lines of data read from the connection
+func (t *smtpTranscript) read(data []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.encrypted {
+		return
+	}
+	t.pending = append(t.pending, data...)
+	for {
+		idx := bytes.IndexByte(t.pending, '\n')
+		if idx == -1 {
+			return
+		}
+		line := string(t.pending[:idx+1])
+		t.pending = t.pending[idx+1:]
+
+		if t.redactAuth {
+			if t.auth {
+				line = "[redacted]\r\n"
+			} else if fields := strings.Fields(line); len(fields) > 2 && strings.EqualFold(fields[0], "AUTH") {
+				line = fields[0] + " " + fields[1] + " [redacted]\r\n"
+			}
+		}
+		t.append("C: " + line)
+		if strings.EqualFold(strings.TrimSpace(line), "STARTTLS") {
+			t.starttls = true
+			t.pending = nil
+			return
+		}
+	}
+}
+
+// write records the server responses written to the connection
+func (t *smtpTranscript) write(data []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.encrypted {
+		return
+	}
+	for _, line := range strings.SplitAfter(string(data), "\n") {
+		if line == "" {
+			continue
+		}
+		t.auth = strings.HasPrefix(line, "334")
+		t.append("S: " + line)
+	}
+	// the exchange following an accepted STARTTLS is encrypted
+	if t.starttls {
+		t.starttls = false
+		if strings.HasPrefix(string(data), "220") {
+			t.encrypted = true
+			t.append("[encrypted]\r\n")
+		}
+	}
+}
+
+// append adds a line to the transcript unless the maximum size is reached
+func (t *smtpTranscript) append(line string) {
+	if t.truncated {
+		return
+	}
+	if t.buf.Len()+len(line) > t.maxSize {
+		t.truncated = true
+		t.buf.WriteString("[truncated]\r\n")
+		return
+	}
+	t.buf.WriteString(line)
+}
+
+// reset returns the transcript recorded so far and starts a new one
+// for the next mail transaction of the connection
+func (t *smtpTranscript) reset() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	transcript := t.buf.String()
+	t.buf.Reset()
+	t.truncated = false
+	return transcript
+}
+
+// transcriptConn records the smtp transcript of a connection
+type transcriptConn struct {
+	net.Conn
+	server     *SMTPServer
+	transcript *smtpTranscript
+}
+
+func (c *transcriptConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.transcript.read(b[:n])
+	}
+	return n, err
+}
+
+func (c *transcriptConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if n > 0 {
+		c.transcript.write(b[:n])
+	}
+	return n, err
+}
+
+func (c *transcriptConn) Close() error {
+	c.server.transcripts.Invalidate(c.RemoteAddr().String())
+	return c.Conn.Close()
+}
+
+// transcriptListener records the smtp transcript of the accepted connections
+type transcriptListener struct {
+	net.Listener
+	server *SMTPServer
+}
+
+func (l *transcriptListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	transcript := &smtpTranscript{
+		maxSize:    l.server.options.SMTPTranscriptSize,
+		redactAuth: !l.server.options.SMTPCapturePassword,
+	}
+	l.server.transcripts.Put(conn.RemoteAddr().String(), transcript)
+	return &transcriptConn{Conn: conn, server: l.server, transcript: transcript}, nil
+}
+
+// transcript returns the transcript of the current mail transaction of the remote address
+func (h *SMTPServer) transcript(remoteAddr net.Addr) string {
+	value, ok := h.transcripts.GetIfPresent(remoteAddr.String())
+	if !ok {
+		return ""
+	}
+	transcript, ok := value.(*smtpTranscript)
+	if !ok {
+		return ""
+	}
+	return transcript.reset()
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+const (
+	// defaultMaxDynamicResponseSize caps streamed responses when no maximum is configured
+	defaultMaxDynamicResponseSize = 10 * 1024 * 1024
+	streamChunkSize               = 32 * 1024
+)
+
+// streamChunk is the generated content of streamed responses
+var streamChunk = bytes.Repeat([]byte("interactsh"), streamChunkSize/len("interactsh")+1)[:streamChunkSize]
+
+// isStreamRequest returns true if the request asks for a streamed dynamic response
+func (h *HTTPServer) isStreamRequest(r *http.Request) bool {
+	if !h.options.DynamicResp {
+		return false
+	}
+	values := r.URL.Query()
+	return values.Get("stream_bytes") != "" || values.Get("stream") == "infinite"
+}
+
+// serveStream streams generated bytes to the client, flushing every chunk,
+// until the requested size or MaxDynamicResponseSize is reached or the client
+// disconnects. The returned response dump only records the streamed length.
+func (h *HTTPServer) serveStream(w http.ResponseWriter, r *http.Request) string {
+	maxSize := h.options.MaxDynamicResponseSize
+	if maxSize <= 0 {
+		maxSize = defaultMaxDynamicResponseSize
+	}
+	size := maxSize
+	if value := r.URL.Query().Get("stream_bytes"); value != "" {
+		if parsed, err := strconv.ParseInt(value, 10, 64); err == nil && parsed >= 0 && parsed < maxSize {
+			size = parsed
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+
+	var written int64
+	for written < size && r.Context().Err() == nil {
+		chunk := streamChunk
+		if remaining := size - written; remaining < int64(len(chunk)) {
+			chunk = chunk[:remaining]
+		}
+		n, err := w.Write(chunk)
+		written += int64(n)
+		if err != nil {
+			break
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	return fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Type: application/octet-stream\r\nX-Content-Type-Options: nosniff\r\n\r\n[streamed %d bytes]", written)
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamedDynamicResponse(t *testing.T) {
+	opts := &Options{Domains: []string{"example.com"}, CorrelationIdLength: 10, CorrelationIdNonceLength: 3, DynamicResp: true, MaxDynamicResponseSize: 256 * 1024}
+	store := newTestStore(t, opts, "abcdefghij")
+	h := &HTTPServer{options: opts}
+	ts := httptest.NewServer(h.logger(http.HandlerFunc(h.defaultHandler)))
+	defer ts.Close()
+
+	read := func(query string) int {
+		req, err := http.NewRequest("GET", ts.URL+"/"+query, nil)
+		require.NoError(t, err)
+		req.Host = "abcdefghijklm.example.com"
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, "application/octet-stream", resp.Header.Get("Content-Type"))
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return len(body)
+	}
+
+	require.Equal(t, 100000, read("?stream_bytes=100000"))
+	require.Equal(t, 256*1024, read("?stream_bytes=999999999"), "streams should be capped")
+	require.Equal(t, 256*1024, read("?stream=infinite"), "infinite streams should be capped")
+
+	data, err := store.GetInteractionsWithIdForConsumer("abcdefghij", "consumer")
+	require.NoError(t, err)
+	require.Len(t, data, 3)
+	interaction := &Interaction{}
+	require.NoError(t, jsoniter.UnmarshalFromString(data[0], interaction))
+	require.Contains(t, interaction.RawResponse, "[streamed 100000 bytes]")
+}
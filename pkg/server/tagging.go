@@ -0,0 +1,97 @@
+package server
+
+import (
+	"os"
+	"regexp"
+	"slices"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// TaggingRule tags interactions whose field matches the regex
+type TaggingRule struct {
+	// Field is the interaction field matched (protocol, unique-id, full-id, q-type, raw-request, remote-address)
+	Field string `yaml:"match-field"`
+	// Regex is the regular expression matched against the field
+	Regex string `yaml:"regex"`
+	// Tag is added to the interaction on match
+	Tag string `yaml:"tag"`
+	// Name identifies the rule in the matched rules of the interactions (defaults to match-field:regex)
+	Name string `yaml:"name,omitempty"`
+
+	compiled *regexp.Regexp
+}
+
+// taggingRuleFields are the interaction fields usable in tagging rules
+var taggingRuleFields = map[string]func(*Interaction) string{
+	"protocol":       func(i *Interaction) string { return i.Protocol },
+	"unique-id":      func(i *Interaction) string { return i.UniqueID },
+	"full-id":        func(i *Interaction) string { return i.FullId },
+	"q-type":         func(i *Interaction) string { return i.QType },
+	"raw-request":    func(i *Interaction) string { return i.RawRequest },
+	"remote-address": func(i *Interaction) string { return i.RemoteAddress },
+}
+
+// NewTaggingRule returns a compiled tagging rule
+func NewTaggingRule(field, regex, tag string) (*TaggingRule, error) {
+	rule := &TaggingRule{Field: field, Regex: regex, Tag: tag}
+	if err := rule.compile(); err != nil {
+		return nil, err
+	}
+	return rule, nil
+}
+
+func (r *TaggingRule) compile() error {
+	if _, ok := taggingRuleFields[r.Field]; !ok {
+		return errors.Errorf("unknown tagging rule field %q", r.Field)
+	}
+	if r.Tag == "" {
+		return errors.New("tagging rule tag is required")
+	}
+	compiled, err := regexp.Compile(r.Regex)
+	if err != nil {
+		return errors.Wrapf(err, "invalid tagging rule regex %q", r.Regex)
+	}
+	r.compiled = compiled
+	return nil
+}
+
+// ID returns the identifier of the rule recorded in the matched rules of the interactions
+func (r *TaggingRule) ID() string {
+	if r.Name != "" {
+		return r.Name
+	}
+	return r.Field + ":" + r.Regex
+}
+
+// LoadTaggingRules reads a YAML list of tagging rules from a file
+func LoadTaggingRules(input string) ([]*TaggingRule, error) {
+	data, err := os.ReadFile(input)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read file")
+	}
+	var rules []*TaggingRule
+	if err := yaml.Unmarshal(data, &rules); err != nil {
+		return nil, errors.Wrap(err, "could not decode tagging rules")
+	}
+	for _, rule := range rules {
+		if err := rule.compile(); err != nil {
+			return nil, err
+		}
+	}
+	return rules, nil
+}
+
+// tagInteraction adds the tags and the ids of the matching tagging rules to the interaction
+func (options *Options) tagInteraction(interaction *Interaction) {
+	for _, rule := range options.TaggingRules {
+		if rule.compiled == nil || !rule.compiled.MatchString(taggingRuleFields[rule.Field](interaction)) {
+			continue
+		}
+		interaction.MatchedRules = append(interaction.MatchedRules, rule.ID())
+		if !slices.Contains(interaction.Tags, rule.Tag) {
+			interaction.Tags = append(interaction.Tags, rule.Tag)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTaggingRules(t *testing.T) {
+	rulesFile := filepath.Join(t.TempDir(), "rules.yaml")
+	require.NoError(t, os.WriteFile(rulesFile, []byte(`- match-field: remote-address
+  regex: '^(10\.|172\.(1[6-9]|2[0-9]|3[01])\.|192\.168\.)'
+  tag: internal
+- match-field: raw-request
+  regex: '(?i)jndi'
+  tag: log4shell
+- match-field: protocol
+  regex: '^http'
+  tag: web
+- name: web-scanner
+  match-field: raw-request
+  regex: 'Nuclei'
+  tag: web
+`), 0o600))
+	rules, err := LoadTaggingRules(rulesFile)
+	require.NoError(t, err)
+	options := &Options{TaggingRules: rules}
+
+	interaction := &Interaction{Protocol: "http", RemoteAddress: "192.168.1.10", RawRequest: "GET /${jndi:ldap://x} HTTP/1.1"}
+	options.tagInteraction(interaction)
+	require.Equal(t, []string{"internal", "log4shell", "web"}, interaction.Tags)
+	require.Equal(t, []string{`remote-address:^(10\.|172\.(1[6-9]|2[0-9]|3[01])\.|192\.168\.)`, "raw-request:(?i)jndi", "protocol:^http"}, interaction.MatchedRules)
+
+	// rules adding the same tag are all recorded
+	interaction = &Interaction{Protocol: "http", RawRequest: "GET / HTTP/1.1\r\nUser-Agent: Nuclei\r\n"}
+	options.tagInteraction(interaction)
+	require.Equal(t, []string{"web"}, interaction.Tags)
+	require.Equal(t, []string{"protocol:^http", "web-scanner"}, interaction.MatchedRules)
+
+	interaction = &Interaction{Protocol: "dns", RemoteAddress: "203.0.113.5", RawRequest: "example.com"}
+	options.tagInteraction(interaction)
+	require.Empty(t, interaction.Tags)
+	require.Empty(t, interaction.MatchedRules)
+
+	_, err = NewTaggingRule("path", ".*", "tag")
+	require.Error(t, err, "unknown fields should be rejected")
+	_, err = NewTaggingRule("protocol", "(", "tag")
+	require.Error(t, err, "invalid regexes should be rejected")
+}
+
+func TestTaggingRulesHTTPInteraction(t *testing.T) {
+	opts := &Options{Domains: []string{"example.com"}, CorrelationIdLength: 10, CorrelationIdNonceLength: 3}
+	rule, err := NewTaggingRule("raw-request", "(?i)jndi", "log4shell")
+	require.NoError(t, err)
+	opts.TaggingRules = []*TaggingRule{rule}
+	var results []*Interaction
+	opts.OnResult = func(result interface{}) { results = append(results, result.(*Interaction)) }
+	newTestStore(t, opts, "abcdefghij")
+	h := &HTTPServer{options: opts}
+
+	req := httptest.NewRequest("GET", "/?q=%24%7Bjndi:ldap://x%7D", nil)
+	req.Host = "abcdefghijklm.example.com"
+	h.logger(http.HandlerFunc(h.defaultHandler)).ServeHTTP(httptest.NewRecorder(), req)
+	require.Len(t, results, 1)
+	require.Equal(t, []string{"log4shell"}, results[0].Tags)
+	require.Equal(t, []string{"raw-request:(?i)jndi"}, results[0].MatchedRules)
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/projectdiscovery/gologger"
+)
+
+// tcpReadTimeout is the maximum time waited for the data sent on a tcp connection
+const tcpReadTimeout = 5 * time.Second
+
+// TCPServer is a generic tcp server recording every connection
+type TCPServer struct {
+	options  *Options
+	listener net.Listener
+}
+
+// NewTCPServer returns a new generic tcp server.
+func NewTCPServer(options *Options) (*TCPServer, error) {
+	return &TCPServer{options: options}, nil
+}
+
+// ListenAndServe listens on the tcp port for the server.
+func (h *TCPServer) ListenAndServe(tcpAlive chan bool) {
+	listener, err := net.Listen("tcp", formatAddress(h.options.ListenIP, h.options.TCPPort))
+	if err != nil {
+		gologger.Error().Msgf("Could not listen tcp on port %d: %s\n", h.options.TCPPort, err)
+		tcpAlive <- false
+		return
+	}
+	tcpAlive <- true
+	if err := h.serve(listener); err != nil {
+		gologger.Error().Msgf("Could not serve tcp on port %d: %s\n", h.options.TCPPort, err)
+		tcpAlive <- false
+	}
+}
+
+// serve accepts the connections of the listener until it is closed
+func (h *TCPServer) serve(listener net.Listener) error {
+	h.listener = listener
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Temporary() {
+				continue
+			}
+			return err
+		}
+		go h.handleConnection(conn)
+	}
+}
+
+// handleConnection sends the banner and records the data sent on the connection
+func (h *TCPServer) handleConnection(conn net.Conn) {
+	receivedAt := time.Now()
+	defer conn.Close()
+
+	if h.options.TCPBanner != "" {
+		if _, err := conn.Write([]byte(h.options.TCPBanner + "\r\n")); err != nil {
+			gologger.Debug().Msgf("Could not write tcp banner to %s: %s\n", conn.RemoteAddr(), err)
+		}
+	}
+
+	// the data is read until the client stops sending, up to the configured size
+	_ = conn.SetReadDeadline(time.Now().Add(tcpReadTimeout))
+	data, _ := io.ReadAll(io.LimitReader(conn, int64(h.options.TCPMaxSize)))
+	h.recordInteraction(conn, string(data), receivedAt)
+}
+
+// recordInteraction records a tcp connection for the correlation id found in its
+// data, connections without one are only visible to authenticated clients
+func (h *TCPServer) recordInteraction(conn net.Conn, data string, receivedAt time.Time) {
+	atomic.AddUint64(&h.options.Stats.Tcp, 1)
+
+	host, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	uniqueID, fullID := h.options.scanCorrelationID(data)
+	interaction := &Interaction{
+		Protocol:      "tcp",
+		UniqueID:      uniqueID,
+		FullId:        fullID,
+		RawRequest:    data,
+		RemoteAddress: host,
+		ReceivedAt:    receivedAt,
+		RTTMs:         h.options.measureRTT(conn),
+		Timestamp:     time.Now(),
+	}
+	if uniqueID == "" {
+		if h.options.Token == "" {
+			gologger.Debug().Msgf("Received tcp connection from %s without correlation id\n", host)
+			return
+		}
+		data, err := h.options.encodeInteraction(interaction)
+		if err != nil {
+			gologger.Warning().Msgf("Could not encode tcp interaction: %s\n", err)
+			return
+		}
+		gologger.Debug().Msgf("TCP Interaction: \n%s\n", string(data))
+		if err := h.options.storeInteractionWithId(h.options.Token, interaction, data); err != nil {
+			gologger.Warning().Msgf("Could not store tcp interaction: %s\n", err)
+		}
+		return
+	}
+
+	correlationID := uniqueID[:h.options.CorrelationIdLength]
+	interaction.CorrelationID = correlationID
+	h.options.tagInteraction(interaction)
+	encoded, err := h.options.encodeInteraction(interaction)
+	if err != nil {
+		gologger.Warning().Msgf("Could not encode tcp interaction: %s\n", err)
+		return
+	}
+	gologger.Debug().Msgf("TCP Interaction: \n%s\n", string(encoded))
+	h.options.publishInteraction(interaction, encoded)
+	if err := h.options.storeInteraction(correlationID, encoded); err != nil {
+		gologger.Warning().Msgf("Could not store tcp interaction: %s\n", err)
+	}
+}
+
+// Close stops the server from accepting new connections
+func (h *TCPServer) Close() {
+	if h.listener != nil {
+		_ = h.listener.Close()
+	}
+}
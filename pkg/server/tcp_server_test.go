@@ -0,0 +1,84 @@
+package server
+
+import (
+	"bufio"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/stretchr/testify/require"
+)
+
+func startTestTCPServer(t *testing.T, opts *Options) string {
+	t.Helper()
+	tcpServer, err := NewTCPServer(opts)
+	require.NoError(t, err)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() { _ = tcpServer.serve(listener) }()
+	t.Cleanup(func() { _ = listener.Close() })
+	return listener.Addr().String()
+}
+
+func TestTCPServer(t *testing.T) {
+	opts := newTestSMTPOptions()
+	opts.TCPBanner = "interactsh tcp"
+	opts.TCPMaxSize = 4096
+	store := newTestStore(t, opts, "abcdefghij")
+	addr := startTestTCPServer(t, opts)
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	banner, err := bufio.NewReader(conn).ReadString('\n')
+	require.NoError(t, err)
+	require.Equal(t, "interactsh tcp\r\n", banner)
+	_, err = conn.Write([]byte("hello abcdefghijklm\n"))
+	require.NoError(t, err)
+	require.NoError(t, conn.(*net.TCPConn).CloseWrite())
+
+	var data []string
+	require.Eventually(t, func() bool {
+		data, err = store.GetInteractionsWithIdForConsumer("abcdefghij", "consumer")
+		return err == nil && len(data) > 0
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Len(t, data, 1)
+	interaction := &Interaction{}
+	require.NoError(t, jsoniter.UnmarshalFromString(data[0], interaction))
+	require.Equal(t, "tcp", interaction.Protocol)
+	require.Equal(t, "abcdefghijklm", interaction.UniqueID)
+	require.Equal(t, "hello abcdefghijklm\n", interaction.RawRequest)
+	require.Equal(t, "127.0.0.1", interaction.RemoteAddress)
+	require.Equal(t, uint64(1), opts.Stats.Tcp)
+}
+
+func TestTCPServerMeasureRTT(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("tcp round trip time is only measured on linux")
+	}
+	opts := newTestSMTPOptions()
+	opts.TCPMaxSize = 4096
+	opts.MeasureRTT = true
+	store := newTestStore(t, opts, "abcdefghij")
+	addr := startTestTCPServer(t, opts)
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("abcdefghijklm"))
+	require.NoError(t, err)
+	require.NoError(t, conn.(*net.TCPConn).CloseWrite())
+
+	var data []string
+	require.Eventually(t, func() bool {
+		data, err = store.GetInteractionsWithIdForConsumer("abcdefghij", "consumer")
+		return err == nil && len(data) > 0
+	}, 5*time.Second, 10*time.Millisecond)
+	interaction := &Interaction{}
+	require.NoError(t, jsoniter.UnmarshalFromString(data[0], interaction))
+	// a local connection has a positive round trip time well below a second
+	require.Greater(t, interaction.RTTMs, 0.0)
+	require.Less(t, interaction.RTTMs, 1000.0)
+}
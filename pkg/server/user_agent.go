@@ -0,0 +1,85 @@
+package server
+
+import (
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// UserAgentResponse is the http response served to the clients whose User-Agent matches the regex
+type UserAgentResponse struct {
+	// Regex is the regular expression matched against the User-Agent header
+	Regex string `yaml:"ua-regex"`
+	// Status is the status code of the response (200 if unset)
+	Status int `yaml:"status,omitempty"`
+	// Body is the body of the response, {DOMAIN} is replaced by the server domain
+	Body string `yaml:"body,omitempty"`
+	// Headers are set on the response
+	Headers map[string]string `yaml:"headers,omitempty"`
+
+	compiled *regexp.Regexp
+}
+
+// NewUserAgentResponse returns a compiled user agent response
+func NewUserAgentResponse(regex string, status int, body string, headers map[string]string) (*UserAgentResponse, error) {
+	response := &UserAgentResponse{Regex: regex, Status: status, Body: body, Headers: headers}
+	if err := response.compile(); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+func (r *UserAgentResponse) compile() error {
+	if r.Status != 0 && (r.Status < 100 || r.Status > 999) {
+		return errors.Errorf("invalid user agent response status %d", r.Status)
+	}
+	compiled, err := regexp.Compile(r.Regex)
+	if err != nil {
+		return errors.Wrapf(err, "invalid user agent response regex %q", r.Regex)
+	}
+	r.compiled = compiled
+	return nil
+}
+
+// LoadUserAgentResponses reads a YAML list of user agent responses from a file
+func LoadUserAgentResponses(input string) ([]*UserAgentResponse, error) {
+	data, err := os.ReadFile(input)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read file")
+	}
+	var responses []*UserAgentResponse
+	if err := yaml.Unmarshal(data, &responses); err != nil {
+		return nil, errors.Wrap(err, "could not decode user agent responses")
+	}
+	for _, response := range responses {
+		if err := response.compile(); err != nil {
+			return nil, err
+		}
+	}
+	return responses, nil
+}
+
+// userAgentResponse returns the first user agent response matching the user agent, if any
+func (options *Options) userAgentResponse(userAgent string) *UserAgentResponse {
+	for _, response := range options.UserAgentResponses {
+		if response.compiled != nil && response.compiled.MatchString(userAgent) {
+			return response
+		}
+	}
+	return nil
+}
+
+// write writes the response for the server domain
+func (r *UserAgentResponse) write(w http.ResponseWriter, domain string) {
+	for header, value := range r.Headers {
+		w.Header().Set(header, value)
+	}
+	if r.Status != 0 {
+		w.WriteHeader(r.Status)
+	}
+	_, _ = w.Write([]byte(strings.ReplaceAll(r.Body, "{DOMAIN}", domain)))
+}
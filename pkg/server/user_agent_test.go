@@ -0,0 +1,69 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUserAgentResponses(t *testing.T) {
+	responsesFile := filepath.Join(t.TempDir(), "responses.yaml")
+	require.NoError(t, os.WriteFile(responsesFile, []byte(`- ua-regex: '(?i)(nuclei|sqlmap)'
+  status: 503
+  body: service unavailable
+  headers:
+    Retry-After: "3600"
+- ua-regex: '^Mozilla/'
+  body: <html><body>{DOMAIN}</body></html>
+  headers:
+    Content-Type: text/html
+- ua-regex: '(?i)nuclei'
+  body: never served
+`), 0o600))
+	responses, err := LoadUserAgentResponses(responsesFile)
+	require.NoError(t, err)
+
+	opts := &Options{Domains: []string{"example.com"}, CorrelationIdLength: 10, CorrelationIdNonceLength: 3, UserAgentResponses: responses}
+	newTestStore(t, opts, "abcdefghij")
+	h := &HTTPServer{options: opts}
+	send := func(userAgent string) (*http.Response, string) {
+		req := httptest.NewRequest("GET", "/path.json", nil)
+		req.Host = "abcdefghijklm.example.com"
+		req.Header.Set("User-Agent", userAgent)
+		w := httptest.NewRecorder()
+		h.defaultHandler(w, req)
+		resp := w.Result()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp, string(body)
+	}
+
+	// the first matching response wins
+	resp, body := send("Nuclei - Open-source project (github.com/projectdiscovery/nuclei)")
+	require.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	require.Equal(t, "3600", resp.Header.Get("Retry-After"))
+	require.Equal(t, "service unavailable", body)
+
+	resp, body = send("Mozilla/5.0 (X11; Linux x86_64)")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "text/html", resp.Header.Get("Content-Type"))
+	require.Equal(t, "<html><body>example.com</body></html>", body)
+
+	// anchored regexes only match at the start of the user agent
+	_, body = send("curl/8.0 Mozilla/5.0")
+	require.Equal(t, `{"data":"mlkjihgfedcba"}`, body)
+
+	// clients not matching any response fall through to the default handler
+	_, body = send("Go-http-client/1.1")
+	require.Equal(t, `{"data":"mlkjihgfedcba"}`, body)
+
+	_, err = NewUserAgentResponse("(", 0, "", nil)
+	require.Error(t, err)
+	_, err = NewUserAgentResponse(".*", 42, "", nil)
+	require.Error(t, err)
+}
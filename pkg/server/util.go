@@ -1,11 +1,15 @@
 package server
 
 import (
+	"encoding/base64"
 	"net"
 	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/asaskevich/govalidator"
+	stringsutil "github.com/projectdiscovery/utils/strings"
 	"github.com/rs/xid"
 )
 
@@ -21,6 +25,96 @@ func (options *Options) isCorrelationID(s string) bool {
 	return false
 }
 
+// scanCorrelationID returns the last correlation id found in the words of s along with the word it was found in
+func (options *Options) scanCorrelationID(s string) (string, string) {
+	var uniqueID, fullID string
+	for _, chunk := range stringsutil.SplitAny(s, "\r\n\t <>@:\"'/") {
+		for part := range stringsutil.SlideWithLength(chunk, options.GetIdLength()) {
+			normalizedPart := strings.ToLower(part)
+			if options.isCorrelationID(normalizedPart) {
+				uniqueID = normalizedPart
+				fullID = chunk
+			}
+		}
+	}
+	return uniqueID, fullID
+}
+
+const (
+	// CorrelationPositionAnywhere scans every label of a request for correlation ids
+	CorrelationPositionAnywhere = "anywhere"
+	// CorrelationPositionLeftmost only checks whether the left-most label is a correlation id
+	CorrelationPositionLeftmost = "leftmost"
+)
+
+// leftmostCorrelationID returns the normalized correlation id and the label it was
+// found in when the first label of s, delimited by any of seps, is a correlation id.
+func (options *Options) leftmostCorrelationID(s, seps string) (string, string, bool) {
+	label := s
+	if idx := strings.IndexAny(s, seps); idx >= 0 {
+		label = s[:idx]
+	}
+	normalized := strings.ToLower(label)
+	if !options.isCorrelationID(normalized) {
+		return "", "", false
+	}
+	return normalized, label, true
+}
+
+// deprecatedDomain returns the deprecated domain the host belongs to, if any
+func (options *Options) deprecatedDomain(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, domain := range options.DeprecatedDomains {
+		domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return domain
+		}
+	}
+	return ""
+}
+
+// exfilMinLength is the minimum length of encoded data attempted to be decoded
+const exfilMinLength = 4
+
+// decodeExfil base64 decodes the labels of host preceding the one containing
+// uniqueID, joined together, returning the decoded value if it's printable text.
+func decodeExfil(host, uniqueID string) string {
+	labels := strings.Split(host, ".")
+	var encoded string
+	for i, label := range labels {
+		if strings.Contains(strings.ToLower(label), uniqueID) {
+			encoded = strings.Join(labels[:i], "")
+			break
+		}
+	}
+	if len(encoded) < exfilMinLength {
+		return ""
+	}
+	for _, encoding := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		decoded, err := encoding.DecodeString(encoded)
+		if err == nil && isPrintableText(decoded) {
+			return string(decoded)
+		}
+	}
+	return ""
+}
+
+// isPrintableText returns true if data is non-empty utf8 made of printable characters and spaces
+func isPrintableText(data []byte) bool {
+	if len(data) == 0 || !utf8.Valid(data) {
+		return false
+	}
+	for _, r := range string(data) {
+		if !unicode.IsPrint(r) && !unicode.IsSpace(r) {
+			return false
+		}
+	}
+	return true
+}
+
 func formatAddress(host string, port int) string {
 	return net.JoinHostPort(host, strconv.Itoa(port))
 }
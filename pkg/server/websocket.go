@@ -0,0 +1,136 @@
+package server
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	// websocketCaptureTimeout bounds the time frames are captured for after the handshake
+	websocketCaptureTimeout = 10 * time.Second
+	websocketMaxFrames      = 32
+	websocketMaxPayload     = 64 * 1024
+)
+
+const (
+	websocketOpText   = 0x1
+	websocketOpBinary = 0x2
+	websocketOpClose  = 0x8
+)
+
+// isWebSocketUpgrade returns true if the request attempts a websocket upgrade,
+// with the Upgrade: websocket header and the upgrade token in the Connection header
+func isWebSocketUpgrade(r *http.Request) bool {
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		return false
+	}
+	for _, value := range r.Header.Values("Connection") {
+		for _, token := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// websocketSession is a websocket connection whose handshake was completed
+type websocketSession struct {
+	// frames are the data frames sent by the client
+	frames []string
+}
+
+// websocketAccept returns the Sec-WebSocket-Accept value for a client key
+func websocketAccept(key string) string {
+	hash := sha1.Sum([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+// serveWebSocket completes the websocket handshake and captures the data frames
+// sent by the client until it closes the connection or the capture times out.
+// An empty response and no session are returned when the connection can't be upgraded.
+func serveWebSocket(w http.ResponseWriter, r *http.Request) (string, *websocketSession) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	hijacker, ok := w.(http.Hijacker)
+	if key == "" || !ok {
+		return "", nil
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return "", nil
+	}
+	defer conn.Close()
+
+	session := &websocketSession{}
+	response := fmt.Sprintf("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n", websocketAccept(key))
+	if _, err := rw.WriteString(response); err != nil {
+		return response, session
+	}
+	if err := rw.Flush(); err != nil {
+		return response, session
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(websocketCaptureTimeout))
+	for len(session.frames) < websocketMaxFrames {
+		opcode, payload, err := readWebSocketFrame(rw.Reader)
+		if err != nil || opcode == websocketOpClose {
+			break
+		}
+		if opcode == websocketOpText || opcode == websocketOpBinary {
+			session.frames = append(session.frames, string(payload))
+		}
+	}
+	return response, session
+}
+
+// readWebSocketFrame reads and unmasks a single websocket frame
+func readWebSocketFrame(reader *bufio.Reader) (byte, []byte, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		return 0, nil, err
+	}
+	opcode := header[0] & 0x0f
+	masked := header[1]&0x80 != 0
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var extended [2]byte
+		if _, err := io.ReadFull(reader, extended[:]); err != nil {
+			return 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		if _, err := io.ReadFull(reader, extended[:]); err != nil {
+			return 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(extended[:])
+	}
+	if length > websocketMaxPayload {
+		return 0, nil, fmt.Errorf("websocket frame too large: %d", length)
+	}
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(reader, mask[:]); err != nil {
+			return 0, nil, err
+		}
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return opcode, payload, nil
+}
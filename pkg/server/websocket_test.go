@@ -0,0 +1,137 @@
+package server
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebSocketUpgradeAttempt(t *testing.T) {
+	opts := &Options{Domains: []string{"example.com"}, CorrelationIdLength: 10, CorrelationIdNonceLength: 3}
+	store := newTestStore(t, opts, "abcdefghij")
+	h := &HTTPServer{options: opts}
+
+	req := httptest.NewRequest("GET", "/chat", nil)
+	req.Host = "abcdefghijklm.example.com"
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	w := httptest.NewRecorder()
+	h.logger(http.HandlerFunc(h.defaultHandler)).ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Result().StatusCode, "upgrade should not be completed by default")
+
+	data, err := store.GetInteractionsWithIdForConsumer("abcdefghij", "consumer")
+	require.NoError(t, err)
+	require.Len(t, data, 1)
+	interaction := &Interaction{}
+	require.NoError(t, jsoniter.UnmarshalFromString(data[0], interaction))
+	require.True(t, interaction.WebSocket)
+	require.Equal(t, "http", interaction.Protocol, "uncompleted upgrades are http interactions")
+	require.Empty(t, interaction.WebSocketData)
+	require.Contains(t, interaction.RawRequest, "Upgrade: websocket")
+	require.Contains(t, interaction.RawRequest, "Sec-Websocket-Key: dGhlIHNhbXBsZSBub25jZQ==")
+}
+
+func TestWebSocketCapture(t *testing.T) {
+	opts := &Options{Domains: []string{"example.com"}, CorrelationIdLength: 10, CorrelationIdNonceLength: 3, CaptureWebSocket: true}
+	store := newTestStore(t, opts, "abcdefghij")
+	h := &HTTPServer{options: opts}
+
+	ts := httptest.NewServer(h.logger(http.HandlerFunc(h.defaultHandler)))
+	defer ts.Close()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(ts.URL, "http://"))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("GET /chat HTTP/1.1\r\nHost: abcdefghijklm.example.com\r\nConnection: Upgrade\r\nUpgrade: websocket\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n"))
+	require.NoError(t, err)
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+	require.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", resp.Header.Get("Sec-WebSocket-Accept"))
+
+	// masked text frame followed by a close frame
+	mask := []byte{0x37, 0xfa, 0x21, 0x3d}
+	payload := []byte("hello")
+	frame := []byte{0x81, 0x80 | byte(len(payload))}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	frame = append(frame, 0x88, 0x80, 0, 0, 0, 0)
+	_, err = conn.Write(frame)
+	require.NoError(t, err)
+
+	// the interaction is recorded once the capture is over
+	var data []string
+	require.Eventually(t, func() bool {
+		data, err = store.GetInteractionsWithIdForConsumer("abcdefghij", "consumer")
+		return err == nil && len(data) > 0
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Len(t, data, 1)
+	interaction := &Interaction{}
+	require.NoError(t, jsoniter.UnmarshalFromString(data[0], interaction))
+	require.True(t, interaction.WebSocket)
+	require.Equal(t, "websocket", interaction.Protocol)
+	require.Equal(t, []string{"hello"}, interaction.WebSocketData)
+	require.Contains(t, interaction.RawResponse, "101 Switching Protocols")
+}
+
+func TestIsWebSocketUpgrade(t *testing.T) {
+	for _, tc := range []struct {
+		connection, upgrade string
+		expected            bool
+	}{
+		{"Upgrade", "websocket", true},
+		{"keep-alive, Upgrade", "WebSocket", true},
+		{"", "websocket", false},
+		{"keep-alive", "websocket", false},
+		{"Upgrade", "h2c", false},
+	} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Connection", tc.connection)
+		req.Header.Set("Upgrade", tc.upgrade)
+		require.Equal(t, tc.expected, isWebSocketUpgrade(req), "%s / %s", tc.connection, tc.upgrade)
+	}
+}
+
+func TestWebSocketCaptureScanEverywhere(t *testing.T) {
+	opts := &Options{Domains: []string{"example.com"}, CorrelationIdLength: 10, CorrelationIdNonceLength: 3, CaptureWebSocket: true, ScanEverywhere: true}
+	store := newTestStore(t, opts, "abcdefghij")
+	h := &HTTPServer{options: opts}
+
+	ts := httptest.NewServer(h.logger(http.HandlerFunc(h.defaultHandler)))
+	defer ts.Close()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(ts.URL, "http://"))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// the correlation id is only in the path
+	_, err = conn.Write([]byte("GET /socket/abcdefghijklm HTTP/1.1\r\nHost: example.com\r\nConnection: Upgrade\r\nUpgrade: websocket\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n"))
+	require.NoError(t, err)
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+	_, err = conn.Write([]byte{0x88, 0x80, 0, 0, 0, 0})
+	require.NoError(t, err)
+
+	var data []string
+	require.Eventually(t, func() bool {
+		data, err = store.GetInteractionsWithIdForConsumer("abcdefghij", "consumer")
+		return err == nil && len(data) > 0
+	}, 5*time.Second, 10*time.Millisecond)
+	interaction := &Interaction{}
+	require.NoError(t, jsoniter.UnmarshalFromString(data[0], interaction))
+	require.Equal(t, "websocket", interaction.Protocol)
+	require.Equal(t, "abcdefghijklm", interaction.UniqueID)
+}
@@ -3,3 +3,12 @@ package storage
 import "github.com/projectdiscovery/utils/errkit"
 
 var ErrCorrelationIdNotFound = errkit.New("could not get correlation-id from cache")
+
+// ErrKeyExpired is returned for registrations older than the MaxKeyAge, which are removed
+var ErrKeyExpired = errkit.New("correlation-id registration key expired, register again")
+
+// ErrMemoryLimit is returned for the interactions rejected once the storage memory limit is reached
+var ErrMemoryLimit = errkit.New("storage memory limit reached")
+
+// ErrNotRegistered is returned when purging an id set with SetID, which has no secret key
+var ErrNotRegistered = errkit.New("correlation-id is not a registered session")
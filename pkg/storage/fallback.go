@@ -0,0 +1,261 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	permissionutil "github.com/projectdiscovery/utils/permission"
+)
+
+const (
+	fallbackFileName       = "interactions.spill"
+	fallbackReplayInterval = 30 * time.Second
+)
+
+// spilledInteraction is an interaction buffered on disk while the primary storage is unavailable
+type spilledInteraction struct {
+	ID     string `json:"id"`
+	Shared bool   `json:"shared,omitempty"`
+	Data   []byte `json:"data"`
+}
+
+// FallbackStorage wraps a storage spilling interactions to a local disk buffer
+// when the primary storage fails to store them. Spilled interactions are replayed
+// into the primary storage once it recovers, periodically and before polls.
+type FallbackStorage struct {
+	Storage
+
+	mu      sync.Mutex
+	path    string
+	pending atomic.Int64
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// NewFallbackStorage returns a storage spilling the interactions that primary
+// fails to store into a buffer file in dir.
+func NewFallbackStorage(primary Storage, dir string) (*FallbackStorage, error) {
+	if err := os.MkdirAll(dir, permissionutil.ConfigFolderPermission); err != nil {
+		return nil, errors.Wrap(err, "could not create fallback storage folder")
+	}
+	f := &FallbackStorage{
+		Storage: primary,
+		path:    filepath.Join(dir, fallbackFileName),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	// interactions spilled by a previous run are replayed as well
+	spilled, err := f.readSpilled()
+	if err != nil {
+		return nil, err
+	}
+	f.pending.Store(int64(len(spilled)))
+
+	go f.replayLoop()
+	return f, nil
+}
+
+// Pending returns the number of interactions waiting to be replayed.
+func (f *FallbackStorage) Pending() int {
+	return int(f.pending.Load())
+}
+
+// AddInteraction adds an interaction to the primary storage, spilling it to disk on failure.
+func (f *FallbackStorage) AddInteraction(correlationID string, data []byte) error {
+	return f.add(spilledInteraction{ID: correlationID, Data: data})
+}
+
+// AddInteractionWithId adds an interaction to the primary storage, spilling it to disk on failure.
+func (f *FallbackStorage) AddInteractionWithId(id string, data []byte) error {
+	return f.add(spilledInteraction{ID: id, Shared: true, Data: data})
+}
+
+// GetInteractions replays the spilled interactions before polling the primary storage.
+func (f *FallbackStorage) GetInteractions(correlationID, secret string) ([]string, string, error) {
+	_ = f.Replay()
+	return f.Storage.GetInteractions(correlationID, secret)
+}
+
+// GetInteractionsBatch replays the spilled interactions before polling the primary storage.
+func (f *FallbackStorage) GetInteractionsBatch(correlationID, secret string, limit int) ([]string, bool, string, error) {
+	_ = f.Replay()
+	return f.Storage.GetInteractionsBatch(correlationID, secret, limit)
+}
+
+// PeekInteractions replays the spilled interactions before peeking the primary storage.
+func (f *FallbackStorage) PeekInteractions(correlationID, secret string) ([]string, []string, string, error) {
+	_ = f.Replay()
+	return f.Storage.PeekInteractions(correlationID, secret)
+}
+
+// GetInteractionsWithIdForConsumer replays the spilled interactions before polling the primary storage.
+func (f *FallbackStorage) GetInteractionsWithIdForConsumer(id, consumerID string) ([]string, error) {
+	_ = f.Replay()
+	return f.Storage.GetInteractionsWithIdForConsumer(id, consumerID)
+}
+
+// Close replays the spilled interactions one last time and closes the primary storage.
+func (f *FallbackStorage) Close() error {
+	close(f.stop)
+	<-f.done
+	_ = f.Replay()
+	return f.Storage.Close()
+}
+
+func (f *FallbackStorage) add(interaction spilledInteraction) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// interactions are spilled after the pending ones to keep their order,
+	// they are replayed by the ticker and before polls
+	if f.pending.Load() > 0 {
+		return f.appendSpilled(interaction)
+	}
+	err := f.store(interaction)
+	if err == nil || rejected(err) {
+		return err
+	}
+	if spillErr := f.appendSpilled(interaction); spillErr != nil {
+		return errors.Wrapf(err, "could not spill interaction: %s", spillErr)
+	}
+	return nil
+}
+
+// rejected returns true for the errors of interactions the primary storage
+// refuses to store, which are not spilled as they would never be replayed
+func rejected(err error) bool {
+	return errors.Is(err, ErrCorrelationIdNotFound) || errors.Is(err, ErrMemoryLimit)
+}
+
+func (f *FallbackStorage) store(interaction spilledInteraction) error {
+	if len(interaction.Data) == 0 {
+		return nil
+	}
+	if interaction.Shared {
+		return f.Storage.AddInteractionWithId(interaction.ID, interaction.Data)
+	}
+	return f.Storage.AddInteraction(interaction.ID, interaction.Data)
+}
+
+// Replay stores the spilled interactions into the primary storage, keeping
+// the ones that still fail and returning the storage error. Interactions of
+// unknown ids or rejected by the memory limit are discarded.
+func (f *FallbackStorage) Replay() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.pending.Load() == 0 {
+		return nil
+	}
+	spilled, err := f.readSpilled()
+	if err != nil {
+		return err
+	}
+	var (
+		remaining []spilledInteraction
+		storeErr  error
+	)
+	for i, interaction := range spilled {
+		storeErr = f.store(interaction)
+		if storeErr != nil && !rejected(storeErr) {
+			// the primary storage is still unavailable, keep the order for the next attempt
+			remaining = spilled[i:]
+			break
+		}
+		storeErr = nil
+	}
+	if len(spilled) > 0 && len(remaining) == len(spilled) {
+		// nothing was replayed, the buffer file is left as is
+		return storeErr
+	}
+	if err := f.writeSpilled(remaining); err != nil {
+		return err
+	}
+	return storeErr
+}
+
+func (f *FallbackStorage) replayLoop() {
+	defer close(f.done)
+
+	ticker := time.NewTicker(fallbackReplayInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			_ = f.Replay()
+		case <-f.stop:
+			return
+		}
+	}
+}
+
+func (f *FallbackStorage) appendSpilled(interaction spilledInteraction) error {
+	data, err := json.Marshal(interaction)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, permissionutil.ConfigFilePermission)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	f.pending.Add(1)
+	return nil
+}
+
+func (f *FallbackStorage) readSpilled() ([]spilledInteraction, error) {
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var spilled []spilledInteraction
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, len(data)+1)
+	for scanner.Scan() {
+		var interaction spilledInteraction
+		if err := json.Unmarshal(scanner.Bytes(), &interaction); err != nil {
+			// skip partially written entries
+			continue
+		}
+		spilled = append(spilled, interaction)
+	}
+	return spilled, scanner.Err()
+}
+
+func (f *FallbackStorage) writeSpilled(spilled []spilledInteraction) error {
+	f.pending.Store(int64(len(spilled)))
+	if len(spilled) == 0 {
+		if err := os.Remove(f.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	var buffer bytes.Buffer
+	for _, interaction := range spilled {
+		data, err := json.Marshal(interaction)
+		if err != nil {
+			return err
+		}
+		buffer.Write(data)
+		buffer.WriteByte('\n')
+	}
+	tmpPath := f.path + ".tmp"
+	if err := os.WriteFile(tmpPath, buffer.Bytes(), permissionutil.ConfigFilePermission); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, f.path)
+}
@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// flakyStorage fails to store interactions while down
+type flakyStorage struct {
+	*StorageDB
+	down atomic.Bool
+}
+
+var errStorageDown = errors.New("storage unavailable")
+
+func (f *flakyStorage) AddInteraction(correlationID string, data []byte) error {
+	if f.down.Load() {
+		return errStorageDown
+	}
+	return f.StorageDB.AddInteraction(correlationID, data)
+}
+
+func (f *flakyStorage) AddInteractionWithId(id string, data []byte) error {
+	if f.down.Load() {
+		return errStorageDown
+	}
+	return f.StorageDB.AddInteractionWithId(id, data)
+}
+
+func TestFallbackStorage(t *testing.T) {
+	db, err := New(&Options{EvictionTTL: 1 * time.Hour})
+	require.Nil(t, err)
+	primary := &flakyStorage{StorageDB: db}
+	dir := t.TempDir()
+	fallback, err := NewFallbackStorage(primary, dir)
+	require.Nil(t, err)
+	defer fallback.Close()
+
+	require.Nil(t, fallback.SetID("shared"))
+	require.Nil(t, fallback.AddInteractionWithId("shared", []byte("before")))
+
+	// interactions are spilled while the storage is down
+	primary.down.Store(true)
+	require.Nil(t, fallback.AddInteractionWithId("shared", []byte("outage-1")))
+	require.Nil(t, fallback.AddInteractionWithId("shared", []byte("outage-2")))
+	require.Equal(t, 2, fallback.Pending())
+	require.Error(t, fallback.Replay(), "replay should fail while the storage is down")
+	require.Equal(t, 2, fallback.Pending())
+
+	// a poll during the outage still returns the stored interactions
+	data, err := fallback.GetInteractionsWithIdForConsumer("shared", "consumer")
+	require.Nil(t, err)
+	require.Equal(t, []string{"before"}, data)
+
+	// spilled interactions are replayed in order once the storage recovers,
+	// the new ones being queued behind them until then
+	primary.down.Store(false)
+	require.Nil(t, fallback.AddInteractionWithId("shared", []byte("after")))
+	require.Equal(t, 3, fallback.Pending())
+	data, err = fallback.GetInteractionsWithIdForConsumer("shared", "consumer")
+	require.Nil(t, err)
+	require.Equal(t, []string{"outage-1", "outage-2", "after"}, data)
+	require.Equal(t, 0, fallback.Pending())
+
+	// unknown ids are not an outage and are not spilled
+	require.ErrorIs(t, fallback.AddInteraction("unknown", []byte("data")), ErrCorrelationIdNotFound)
+	require.Equal(t, 0, fallback.Pending())
+}
+
+func TestFallbackStorageRestart(t *testing.T) {
+	dir := t.TempDir()
+
+	db, err := New(&Options{EvictionTTL: 1 * time.Hour})
+	require.Nil(t, err)
+	primary := &flakyStorage{StorageDB: db}
+	primary.down.Store(true)
+	fallback, err := NewFallbackStorage(primary, dir)
+	require.Nil(t, err)
+	require.Nil(t, fallback.AddInteractionWithId("shared", []byte("outage")))
+	require.Nil(t, fallback.Close())
+
+	// interactions spilled by a previous run are replayed by the next one
+	db, err = New(&Options{EvictionTTL: 1 * time.Hour})
+	require.Nil(t, err)
+	require.Nil(t, db.SetID("shared"))
+	fallback, err = NewFallbackStorage(db, dir)
+	require.Nil(t, err)
+	defer fallback.Close()
+	require.Equal(t, 1, fallback.Pending())
+	data, err := fallback.GetInteractionsWithIdForConsumer("shared", "consumer")
+	require.Nil(t, err)
+	require.Equal(t, []string{"outage"}, data)
+	require.Equal(t, 0, fallback.Pending())
+}
+
+func TestFallbackStorageMemoryLimit(t *testing.T) {
+	db, err := New(&Options{EvictionTTL: 1 * time.Hour, MaxStorageMemoryBytes: 10})
+	require.Nil(t, err)
+	primary := &flakyStorage{StorageDB: db}
+	fallback, err := NewFallbackStorage(primary, t.TempDir())
+	require.Nil(t, err)
+	defer fallback.Close()
+
+	// interactions rejected by the memory limit are not spilled
+	require.Nil(t, fallback.SetID("shared"))
+	require.Nil(t, fallback.AddInteractionWithId("shared", []byte("12345")))
+	require.ErrorIs(t, fallback.AddInteractionWithId("shared", []byte("0123456789")), ErrMemoryLimit)
+	require.Equal(t, 0, fallback.Pending())
+
+	// spilled interactions rejected on replay don't block the following ones
+	primary.down.Store(true)
+	require.Nil(t, fallback.AddInteractionWithId("shared", []byte("0123456789")))
+	require.Nil(t, fallback.AddInteractionWithId("shared", []byte("abcde")))
+	require.Equal(t, 2, fallback.Pending())
+	primary.down.Store(false)
+	require.Nil(t, fallback.Replay())
+	require.Equal(t, 0, fallback.Pending())
+	data, err := fallback.GetInteractionsWithIdForConsumer("shared", "consumer")
+	require.Nil(t, err)
+	require.Equal(t, []string{"12345", "abcde"}, data)
+}
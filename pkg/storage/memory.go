@@ -0,0 +1,186 @@
+package storage
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// MemoryPolicy is the backpressure applied to new interactions once the
+// buffered interactions of the in-memory storage reach the memory limit
+type MemoryPolicy int
+
+const (
+	MemoryPolicyReject MemoryPolicy = iota // reject the new interactions
+	MemoryPolicyEvict                      // evict the oldest buffered interactions
+)
+
+// memoryTracker accounts the approximate memory used by the interactions buffered in memory
+type memoryTracker struct {
+	max      int64
+	policy   MemoryPolicy
+	usage    atomic.Int64
+	rejected atomic.Uint64
+	evicted  atomic.Uint64
+
+	mu sync.Mutex
+	// queue holds the buffered interactions in the order they were added when
+	// evicting. Entries of the interactions removed since then are stale.
+	queue []memoryEntry
+	// compactAt is the queue length the stale entries are removed at
+	compactAt int
+}
+
+// memoryQueueCompactMin is the minimum queue length the stale entries are removed at
+const memoryQueueCompactMin = 1024
+
+// memoryEntry is the sequence number of an interaction buffered in the correlation data
+type memoryEntry struct {
+	value *CorrelationData
+	seq   uint64
+}
+
+func (e memoryEntry) stale() bool {
+	return e.seq < e.value.dropped.Load()
+}
+
+// push adds the interaction to the eviction queue, dropping the stale entries at its
+// front. The stale entries behind live ones, such as the unread interactions of
+// pinned ids, are removed once the queue doubled since the last compaction.
+func (m *memoryTracker) push(entry memoryEntry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for len(m.queue) > 0 && m.queue[0].stale() {
+		m.queue[0] = memoryEntry{}
+		m.queue = m.queue[1:]
+	}
+	m.queue = append(m.queue, entry)
+	if len(m.queue) >= max(m.compactAt, memoryQueueCompactMin) {
+		m.compact()
+	}
+}
+
+// compact removes the stale entries of the eviction queue. The caller must hold the lock.
+func (m *memoryTracker) compact() {
+	live := make([]memoryEntry, 0, len(m.queue))
+	for _, entry := range m.queue {
+		if !entry.stale() {
+			live = append(live, entry)
+		}
+	}
+	m.queue = live
+	m.compactAt = 2 * len(live)
+}
+
+// pop returns the oldest entry of the eviction queue
+func (m *memoryTracker) pop() (memoryEntry, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.queue) == 0 {
+		return memoryEntry{}, false
+	}
+	entry := m.queue[0]
+	m.queue[0] = memoryEntry{}
+	m.queue = m.queue[1:]
+	return entry, true
+}
+
+// reserveMemory applies the memory policy before buffering an interaction of the
+// given size, returning ErrMemoryLimit if it is rejected. It must be called
+// without holding any data lock, as evicting locks the evicted correlation data.
+func (s *StorageDB) reserveMemory(size int) error {
+	m := s.memory
+	if m == nil || m.max <= 0 {
+		return nil
+	}
+	for m.usage.Load()+int64(size) > m.max {
+		if m.policy != MemoryPolicyEvict || !s.evictOldest() {
+			m.rejected.Add(1)
+			return ErrMemoryLimit
+		}
+	}
+	return nil
+}
+
+// evictOldest drops the oldest buffered interaction, returning false if there is none
+func (s *StorageDB) evictOldest() bool {
+	for {
+		entry, ok := s.memory.pop()
+		if !ok {
+			return false
+		}
+		if entry.stale() {
+			continue
+		}
+		value := entry.value
+		value.Lock()
+		if len(value.Data) == 0 {
+			value.Unlock()
+			continue
+		}
+		s.dropData(value, 1)
+		if len(value.AddedAt) > 0 {
+			value.AddedAt = value.AddedAt[1:]
+		}
+		for cid, off := range value.ReadOffsets {
+			value.ReadOffsets[cid] = max(off-1, 0)
+		}
+		value.Unlock()
+		s.memory.evicted.Add(1)
+		return true
+	}
+}
+
+// appendData buffers an interaction in the correlation data. The caller must hold the data lock.
+func (s *StorageDB) appendData(value *CorrelationData, item string) {
+	value.Data = append(value.Data, item)
+	value.dataSize += int64(len(item))
+	seq := value.appended
+	value.appended++
+	if s.memory == nil {
+		return
+	}
+	s.memory.usage.Add(int64(len(item)))
+	if s.memory.max > 0 && s.memory.policy == MemoryPolicyEvict {
+		s.memory.push(memoryEntry{value: value, seq: seq})
+	}
+}
+
+// dropData removes the n oldest buffered interactions of the correlation data.
+// The caller must hold the data lock.
+func (s *StorageDB) dropData(value *CorrelationData, n int) {
+	n = min(n, len(value.Data))
+	var size int64
+	for _, item := range value.Data[:n] {
+		size += int64(len(item))
+	}
+	if n == len(value.Data) {
+		value.Data = nil
+	} else {
+		value.Data = value.Data[n:]
+	}
+	value.dropped.Add(uint64(n))
+	s.releaseMemory(value, size)
+}
+
+// replaceData replaces the buffered interactions of the correlation data with the
+// remaining ones. The caller must hold the data lock.
+func (s *StorageDB) replaceData(value *CorrelationData, remaining []string) {
+	var size int64
+	for _, item := range remaining {
+		size += int64(len(item))
+	}
+	// the removed interactions are accounted as the oldest ones
+	value.dropped.Add(uint64(max(len(value.Data)-len(remaining), 0)))
+	value.Data = remaining
+	s.releaseMemory(value, value.dataSize-size)
+}
+
+// releaseMemory accounts the size of the interactions removed from the correlation data
+func (s *StorageDB) releaseMemory(value *CorrelationData, size int64) {
+	value.dataSize -= size
+	if s.memory != nil {
+		s.memory.usage.Add(-size)
+	}
+}
@@ -10,11 +10,24 @@ const (
 )
 
 type Options struct {
-	DbPath                 string
-	EvictionTTL            time.Duration
-	MaxSize                int
-	MaxSharedInteractions  int
-	EvictionStrategy       EvictionStrategy
+	DbPath                string
+	EvictionTTL           time.Duration
+	MaxSize               int
+	MaxSharedInteractions int
+	EvictionStrategy      EvictionStrategy
+	// AESKeyRotationInterval is the maximum lifetime of a session AES key,
+	// after which a new key is generated on the next poll (0 disables rotation)
+	AESKeyRotationInterval time.Duration
+	// PinnedCorrelationIDs are exempt from the TTL and size eviction of the cache
+	PinnedCorrelationIDs []string
+	// MaxKeyAge is the maximum age of a registration, after which it is
+	// removed on the next poll and must be registered again (0 disables it)
+	MaxKeyAge time.Duration
+	// MaxStorageMemoryBytes is the maximum approximate size of the interactions buffered
+	// in memory, after which MemoryPolicy applies (0 for no limit, unused with disk storage)
+	MaxStorageMemoryBytes int64
+	// MemoryPolicy is the backpressure applied to new interactions at the memory limit
+	MemoryPolicy MemoryPolicy
 }
 
 func (options *Options) UseDisk() bool {
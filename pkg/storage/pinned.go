@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/goburrow/cache"
+)
+
+// pinnedCache is a cache keeping the values of pinned correlation IDs
+// outside of the underlying cache, exempting them from its eviction.
+type pinnedCache struct {
+	cache.Cache
+
+	mu     sync.RWMutex
+	ids    map[string]struct{}
+	values map[string]cache.Value
+}
+
+// newPinnedCache returns c with the given correlation IDs pinned
+func newPinnedCache(c cache.Cache, ids []string) *pinnedCache {
+	pinned := &pinnedCache{
+		Cache:  c,
+		ids:    make(map[string]struct{}, len(ids)),
+		values: make(map[string]cache.Value),
+	}
+	for _, id := range ids {
+		if id = strings.TrimSpace(id); id != "" {
+			pinned.ids[id] = struct{}{}
+		}
+	}
+	return pinned
+}
+
+// isPinned returns true if the key is a pinned correlation ID
+func (c *pinnedCache) isPinned(key cache.Key) bool {
+	id, ok := key.(string)
+	if !ok {
+		return false
+	}
+	_, ok = c.ids[id]
+	return ok
+}
+
+func (c *pinnedCache) GetIfPresent(key cache.Key) (cache.Value, bool) {
+	if !c.isPinned(key) {
+		return c.Cache.GetIfPresent(key)
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	value, ok := c.values[key.(string)]
+	return value, ok
+}
+
+func (c *pinnedCache) Put(key cache.Key, value cache.Value) {
+	if !c.isPinned(key) {
+		c.Cache.Put(key, value)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.values[key.(string)] = value
+}
+
+func (c *pinnedCache) Invalidate(key cache.Key) {
+	if !c.isPinned(key) {
+		c.Cache.Invalidate(key)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.values, key.(string))
+}
+
+func (c *pinnedCache) InvalidateAll() {
+	c.mu.Lock()
+	c.values = make(map[string]cache.Value)
+	c.mu.Unlock()
+	c.Cache.InvalidateAll()
+}
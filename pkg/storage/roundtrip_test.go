@@ -439,3 +439,150 @@ func TestCacheEvictionCleansLevelDB(t *testing.T) {
 	_, err = db.db.Get([]byte(correlationID), nil)
 	require.Error(t, err, "LevelDB entry should be deleted after cache eviction")
 }
+
+func TestAESKeyRotationDisk(t *testing.T) {
+	db, err := New(&Options{EvictionTTL: 1 * time.Hour, DbPath: t.TempDir(), AESKeyRotationInterval: time.Nanosecond})
+	require.NoError(t, err)
+	defer db.Close()
+
+	priv, pubKeyB64 := generateRSAKeyPair(t)
+	secret := uuid.New().String()
+	correlationID := xid.New().String()
+	require.NoError(t, db.SetIDPublicKey(correlationID, secret, pubKeyB64))
+
+	// interactions stored under the first key
+	require.NoError(t, db.AddInteraction(correlationID, []byte(`{"protocol":"dns"}`)))
+	require.NoError(t, db.AddInteraction(correlationID, []byte(`{"protocol":"http"}`)))
+	item, err := db.GetCacheItem(correlationID)
+	require.NoError(t, err)
+	firstKey := item.AESKeyEncrypted
+
+	// the key is rotated on poll even though interactions remain buffered
+	data, more, secondKey, err := db.GetInteractionsBatch(correlationID, secret, 1)
+	require.NoError(t, err)
+	require.True(t, more)
+	require.Len(t, data, 1)
+	require.NotEqual(t, firstKey, secondKey, "aes key was not rotated")
+	require.Equal(t, `{"protocol":"dns"}`, string(clientDecrypt(t, priv, secondKey, data[0])))
+
+	// a poll drains interactions stored under both keys, decrypted with the returned one
+	require.NoError(t, db.AddInteraction(correlationID, []byte(`{"protocol":"smtp"}`)))
+	data, thirdKey, err := db.GetInteractions(correlationID, secret)
+	require.NoError(t, err)
+	require.Len(t, data, 2)
+	require.Equal(t, secondKey, thirdKey, "aes key should not be rotated until the previous one is drained")
+	require.Equal(t, `{"protocol":"http"}`, string(clientDecrypt(t, priv, thirdKey, data[0])))
+	require.Equal(t, `{"protocol":"smtp"}`, string(clientDecrypt(t, priv, thirdKey, data[1])))
+
+	// once drained, the key is rotated again
+	require.NoError(t, db.AddInteraction(correlationID, []byte(`{"protocol":"ldap"}`)))
+	data, fourthKey, err := db.GetInteractions(correlationID, secret)
+	require.NoError(t, err)
+	require.Len(t, data, 1)
+	require.NotEqual(t, thirdKey, fourthKey, "aes key was not rotated")
+	require.Equal(t, `{"protocol":"ldap"}`, string(clientDecrypt(t, priv, fourthKey, data[0])))
+}
+
+func TestAESKeyRotationDisabled(t *testing.T) {
+	mem, err := New(&Options{EvictionTTL: 1 * time.Hour})
+	require.NoError(t, err)
+	defer mem.Close()
+
+	_, pubKeyB64 := generateRSAKeyPair(t)
+	secret := uuid.New().String()
+	correlationID := xid.New().String()
+	require.NoError(t, mem.SetIDPublicKey(correlationID, secret, pubKeyB64))
+
+	_, firstKey, err := mem.GetInteractions(correlationID, secret)
+	require.NoError(t, err)
+	_, secondKey, err := mem.GetInteractions(correlationID, secret)
+	require.NoError(t, err)
+	require.Equal(t, firstKey, secondKey, "aes key should not be rotated")
+}
+
+func TestMultiplePublicKeys(t *testing.T) {
+	for _, useDisk := range []bool{false, true} {
+		name := "memory"
+		options := &Options{EvictionTTL: 1 * time.Hour, AESKeyRotationInterval: time.Nanosecond}
+		if useDisk {
+			name = "disk"
+			options.DbPath = t.TempDir()
+		}
+		t.Run(name, func(t *testing.T) {
+			db, err := New(options)
+			require.NoError(t, err)
+			defer db.Close()
+
+			firstPriv, firstPubKeyB64 := generateRSAKeyPair(t)
+			secondPriv, secondPubKeyB64 := generateRSAKeyPair(t)
+			firstFingerprint, err := PublicKeyFingerprint(&firstPriv.PublicKey)
+			require.NoError(t, err)
+			secondFingerprint, err := PublicKeyFingerprint(&secondPriv.PublicKey)
+			require.NoError(t, err)
+
+			secret := uuid.New().String()
+			correlationID := xid.New().String()
+			require.NoError(t, db.SetIDPublicKey(correlationID, secret, firstPubKeyB64))
+			require.Error(t, db.AddPublicKey(correlationID, "wrong", secondPubKeyB64))
+			require.NoError(t, db.AddPublicKey(correlationID, secret, secondPubKeyB64))
+
+			require.NoError(t, db.AddInteraction(correlationID, []byte(`{"protocol":"dns"}`)))
+			data, aesKey, err := db.GetInteractions(correlationID, secret)
+			require.NoError(t, err)
+			require.Len(t, data, 1)
+
+			// the drained interactions can be decrypted with both keys even though the aes key was rotated
+			keys, err := db.GetAESKeys(correlationID, secret, aesKey)
+			require.NoError(t, err)
+			require.Len(t, keys, 2)
+			require.Equal(t, aesKey, keys[firstFingerprint])
+			require.Equal(t, `{"protocol":"dns"}`, string(clientDecrypt(t, firstPriv, keys[firstFingerprint], data[0])))
+			require.Equal(t, `{"protocol":"dns"}`, string(clientDecrypt(t, secondPriv, keys[secondFingerprint], data[0])))
+
+			// removing the primary key leaves the second key decrypting alone
+			require.NoError(t, db.RemovePublicKey(correlationID, secret, firstPubKeyB64))
+			require.Error(t, db.RemovePublicKey(correlationID, secret, secondPubKeyB64), "the last key should not be removable")
+			require.NoError(t, db.AddInteraction(correlationID, []byte(`{"protocol":"http"}`)))
+			data, aesKey, err = db.GetInteractions(correlationID, secret)
+			require.NoError(t, err)
+			require.Len(t, data, 1)
+			require.Equal(t, `{"protocol":"http"}`, string(clientDecrypt(t, secondPriv, aesKey, data[0])))
+			keys, err = db.GetAESKeys(correlationID, secret, aesKey)
+			require.NoError(t, err)
+			require.Equal(t, map[string]string{secondFingerprint: aesKey}, keys)
+		})
+	}
+}
+
+func TestMaxKeyAge(t *testing.T) {
+	db, err := New(&Options{EvictionTTL: 1 * time.Hour, MaxKeyAge: time.Hour})
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, pubKeyB64 := generateRSAKeyPair(t)
+	secret := uuid.New().String()
+	correlationID := xid.New().String()
+	require.NoError(t, db.SetIDPublicKey(correlationID, secret, pubKeyB64))
+
+	// a fresh registration is polled normally
+	require.NoError(t, db.AddInteraction(correlationID, []byte(`{"protocol":"dns"}`)))
+	data, _, err := db.GetInteractions(correlationID, secret)
+	require.NoError(t, err)
+	require.Len(t, data, 1)
+
+	// an aged registration is rejected and removed
+	item, err := db.GetCacheItem(correlationID)
+	require.NoError(t, err)
+	item.RegisteredAt = time.Now().Add(-2 * time.Hour)
+	_, _, err = db.GetInteractions(correlationID, secret)
+	require.ErrorIs(t, err, ErrKeyExpired)
+	_, _, _, err = db.PeekInteractions(correlationID, secret)
+	require.ErrorIs(t, err, ErrCorrelationIdNotFound)
+
+	// the correlation id can be registered again once the cache processed the removal
+	require.Eventually(t, func() bool {
+		require.NoError(t, db.SetIDPublicKey(correlationID, secret, pubKeyB64))
+		_, _, err = db.GetInteractions(correlationID, secret)
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+}
@@ -1,17 +1,30 @@
 // storage defines a storage mechanism
 package storage
 
+import "time"
+
 type Storage interface {
 	GetCacheMetrics() (*CacheMetrics, error)
 	SetIDPublicKey(correlationID, secretKey, publicKey string) error
+	AddPublicKey(correlationID, secretKey, publicKey string) error
+	RemovePublicKey(correlationID, secretKey, publicKey string) error
+	GetAESKeys(correlationID, secretKey, aesKeyEncrypted string) (map[string]string, error)
 	SetID(ID string) error
 	AddInteraction(correlationID string, data []byte) error
 	AddInteractionWithId(id string, data []byte) error
 	GetInteractions(correlationID, secret string) ([]string, string, error)
+	GetInteractionsBatch(correlationID, secret string, limit int) ([]string, bool, string, error)
+	PeekInteractions(correlationID, secret string) ([]string, []string, string, error)
+	AckInteractions(correlationID, secret string, tokens []string) error
 	GetInteractionsWithId(id string) ([]string, error)
 	GetInteractionsWithIdForConsumer(id, consumerID string) ([]string, error)
 	RemoveConsumer(id, consumerID string) error
 	RemoveID(correlationID, secret string) error
+	PurgeID(correlationID string) error
+	RemoveAll() ([]string, error)
+	SetRetention(correlationID string, retention time.Duration) error
+	SetMetadata(correlationID string, metadata map[string]string) error
 	GetCacheItem(token string) (*CorrelationData, error)
+	CorrelationIDs() []string
 	Close() error
 }
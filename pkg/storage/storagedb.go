@@ -7,9 +7,12 @@ import (
 	"crypto/rsa"
 	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/goburrow/cache"
@@ -29,6 +32,10 @@ type StorageDB struct {
 	cache   cache.Cache
 	db      *leveldb.DB
 	dbpath  string
+	// ids are the correlation data of the registered ids, by id
+	ids sync.Map
+	// memory accounts the interactions buffered in memory, nil with disk storage
+	memory *memoryTracker
 }
 
 // New creates a new storage instance for interactsh data.
@@ -50,11 +57,15 @@ func New(options *Options) (*StorageDB, error) {
 			cacheOptions = append(cacheOptions, cache.WithExpireAfterAccess(options.EvictionTTL))
 		}
 	}
-	if options.UseDisk() {
-		cacheOptions = append(cacheOptions, cache.WithRemovalListener(storageDB.OnCacheRemovalCallback))
+	cacheOptions = append(cacheOptions, cache.WithRemovalListener(storageDB.OnCacheRemovalCallback))
+	var cacheDb cache.Cache = cache.New(cacheOptions...)
+	if len(options.PinnedCorrelationIDs) > 0 {
+		cacheDb = newPinnedCache(cacheDb, options.PinnedCorrelationIDs)
 	}
-	cacheDb := cache.New(cacheOptions...)
 	storageDB.cache = cacheDb
+	if !options.UseDisk() {
+		storageDB.memory = &memoryTracker{max: options.MaxStorageMemoryBytes, policy: options.MemoryPolicy}
+	}
 
 	if options.UseDisk() {
 		// if the path exists we create a random temporary subfolder
@@ -78,11 +89,33 @@ func New(options *Options) (*StorageDB, error) {
 }
 
 func (s *StorageDB) OnCacheRemovalCallback(key cache.Key, value cache.Value) {
-	if k, ok := key.(string); ok {
+	k, ok := key.(string)
+	if !ok {
+		return
+	}
+	// the removal is notified asynchronously, so the id may already be registered again
+	s.ids.CompareAndDelete(k, value)
+	if data, ok := value.(*CorrelationData); ok {
+		data.Lock()
+		s.dropData(data, len(data.Data))
+		data.Unlock()
+	}
+	if s.Options.UseDisk() {
 		_ = s.db.Delete([]byte(k), &opt.WriteOptions{})
 	}
 }
 
+// CorrelationIDs returns the registered ids in sorted order
+func (s *StorageDB) CorrelationIDs() []string {
+	var ids []string
+	s.ids.Range(func(key, _ interface{}) bool {
+		ids = append(ids, key.(string))
+		return true
+	})
+	sort.Strings(ids)
+	return ids
+}
+
 func (s *StorageDB) GetCacheMetrics() (*CacheMetrics, error) {
 	info := &cache.Stats{}
 	s.cache.Stats(info)
@@ -95,6 +128,11 @@ func (s *StorageDB) GetCacheMetrics() (*CacheMetrics, error) {
 		TotalLoadTime:    info.TotalLoadTime,
 		EvictionCount:    info.EvictionCount,
 	}
+	if s.memory != nil {
+		cacheMetrics.MemoryUsage = s.memory.usage.Load()
+		cacheMetrics.MemoryRejected = s.memory.rejected.Load()
+		cacheMetrics.MemoryEvicted = s.memory.evicted.Load()
+	}
 
 	return cacheMetrics, nil
 }
@@ -110,20 +148,23 @@ func (s *StorageDB) SetIDPublicKey(correlationID, secretKey, publicKey string) e
 	if err != nil {
 		return errors.Wrap(err, "could not read public Key")
 	}
-	aesKey := make([]byte, 32)
-	if _, err := rand.Read(aesKey); err != nil {
-		return errors.Wrap(err, "could not generate AES key")
+	fingerprint, err := PublicKeyFingerprint(publicKeyData)
+	if err != nil {
+		return errors.Wrap(err, "could not read public Key")
 	}
-
-	ciphertext, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, publicKeyData, aesKey, []byte(""))
+	aesKey, aesKeyEncrypted, err := generateAESKey(publicKeyData)
 	if err != nil {
-		return errors.New("could not encrypt event data")
+		return err
 	}
 
 	data := &CorrelationData{
 		SecretKey:       secretKey,
 		AESKey:          aesKey,
-		AESKeyEncrypted: base64.StdEncoding.EncodeToString(ciphertext),
+		AESKeyEncrypted: aesKeyEncrypted,
+		AESKeyCreatedAt: time.Now(),
+		PublicKey:       publicKeyData,
+		PublicKeys:      map[string]*rsa.PublicKey{fingerprint: publicKeyData},
+		RegisteredAt:    time.Now(),
 	}
 	// Clear any stale data from a previous registration (e.g. after cache eviction
 	// and session restore). Old data would be encrypted with a different AES key
@@ -132,13 +173,247 @@ func (s *StorageDB) SetIDPublicKey(correlationID, secretKey, publicKey string) e
 		_ = s.db.Delete([]byte(correlationID), nil)
 	}
 	s.cache.Put(correlationID, data)
+	s.ids.Store(correlationID, data)
+	return nil
+}
+
+// generateAESKey generates a new AES key and returns it together with
+// its base64 encoded RSA-OAEP encryption under publicKey.
+func generateAESKey(publicKey *rsa.PublicKey) ([]byte, string, error) {
+	aesKey := make([]byte, 32)
+	if _, err := rand.Read(aesKey); err != nil {
+		return nil, "", errors.Wrap(err, "could not generate AES key")
+	}
+
+	aesKeyEncrypted, err := wrapAESKey(publicKey, aesKey)
+	if err != nil {
+		return nil, "", err
+	}
+	return aesKey, aesKeyEncrypted, nil
+}
+
+// wrapAESKey returns the base64 encoded RSA-OAEP encryption of aesKey under publicKey.
+func wrapAESKey(publicKey *rsa.PublicKey, aesKey []byte) (string, error) {
+	ciphertext, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, publicKey, aesKey, []byte(""))
+	if err != nil {
+		return "", errors.New("could not encrypt event data")
+	}
+	return base64.StdEncoding.EncodeToString(ciphertext), nil
+}
+
+// rotateAESKey replaces the AES key of the correlation data once it is older
+// than the configured rotation interval, whether interactions are buffered or not.
+// The interactions buffered on disk stay encrypted with the replaced key until
+// drained, and the key isn't rotated again until then. The caller must hold the
+// data lock.
+func (s *StorageDB) rotateAESKey(value *CorrelationData, id string) {
+	if s.Options.AESKeyRotationInterval <= 0 || value.PublicKey == nil || value.drainAESKeyCount > 0 {
+		return
+	}
+	if time.Since(value.AESKeyCreatedAt) < s.Options.AESKeyRotationInterval {
+		return
+	}
+	aesKey, aesKeyEncrypted, err := generateAESKey(value.PublicKey)
+	if err != nil {
+		// keep using the current key, rotation is retried on the next poll
+		return
+	}
+	if s.Options.UseDisk() {
+		if buffered := s.dataLen(value, id); buffered > 0 {
+			value.drainAESKey = value.AESKey
+			value.drainAESKeyCount = buffered
+		}
+	}
+	value.previousAESKey = value.AESKey
+	value.previousAESKeyEncrypted = value.AESKeyEncrypted
+	value.AESKey = aesKey
+	value.AESKeyEncrypted = aesKeyEncrypted
+	value.AESKeyCreatedAt = time.Now()
+}
+
+// reencryptDrainData returns the interactions stored on disk encrypted with the
+// current AES key, the first ones being encrypted with the key replaced by the last
+// rotation until drained. The caller must hold the data lock.
+func (s *StorageDB) reencryptDrainData(value *CorrelationData, data []string) ([]string, error) {
+	if value.drainAESKeyCount == 0 {
+		return data, nil
+	}
+	var errs []error
+	reencrypted := make([]string, len(data))
+	copy(reencrypted, data)
+	for i := 0; i < min(value.drainAESKeyCount, len(data)); i++ {
+		plainText, err := AESDecrypt(value.drainAESKey, data[i])
+		if err != nil {
+			errs = append(errs, errors.Wrap(err, "could not decrypt event data"))
+			continue
+		}
+		encryptedDataItem, err := AESEncrypt(value.AESKey, plainText)
+		if err != nil {
+			errs = append(errs, errors.Wrap(err, "could not encrypt event data"))
+			continue
+		}
+		reencrypted[i] = encryptedDataItem
+	}
+	return reencrypted, multierr.Combine(errs...)
+}
+
+// trimDrainAESKey forgets the n first interactions removed from the ones encrypted
+// with the key replaced by the last rotation. The caller must hold the data lock.
+func (s *StorageDB) trimDrainAESKey(value *CorrelationData, n int) {
+	value.drainAESKeyCount = max(value.drainAESKeyCount-n, 0)
+	if value.drainAESKeyCount == 0 {
+		value.drainAESKey = nil
+	}
+}
+
+// maxPublicKeys is the maximum number of public keys registered for a session
+const maxPublicKeys = 8
+
+// AddPublicKey registers an additional public key for a correlation ID, so that
+// the session AES key is also returned wrapped under it by GetAESKeys.
+func (s *StorageDB) AddPublicKey(correlationID, secretKey, publicKey string) error {
+	item, ok := s.cache.GetIfPresent(correlationID)
+	if !ok {
+		return ErrCorrelationIdNotFound
+	}
+	value, ok := item.(*CorrelationData)
+	if !ok {
+		return errors.New("invalid correlation-id cache value found")
+	}
+	if !strings.EqualFold(value.SecretKey, secretKey) {
+		return errors.New("invalid secret key passed for user")
+	}
+	publicKeyData, err := ParseB64RSAPublicKeyFromPEM(publicKey)
+	if err != nil {
+		return errors.Wrap(err, "could not read public Key")
+	}
+	fingerprint, err := PublicKeyFingerprint(publicKeyData)
+	if err != nil {
+		return errors.Wrap(err, "could not read public Key")
+	}
+
+	value.Lock()
+	defer value.Unlock()
+
+	if value.PublicKey == nil {
+		return errors.New("correlation-id has no public key")
+	}
+	if _, ok := value.PublicKeys[fingerprint]; ok {
+		return nil
+	}
+	if len(value.PublicKeys) >= maxPublicKeys {
+		return errors.Errorf("maximum number of public keys (%d) reached", maxPublicKeys)
+	}
+	value.PublicKeys[fingerprint] = publicKeyData
 	return nil
 }
 
+// RemovePublicKey removes a public key of a correlation ID. The last public key
+// can't be removed, the primary key is replaced by one of the remaining ones.
+func (s *StorageDB) RemovePublicKey(correlationID, secretKey, publicKey string) error {
+	item, ok := s.cache.GetIfPresent(correlationID)
+	if !ok {
+		return ErrCorrelationIdNotFound
+	}
+	value, ok := item.(*CorrelationData)
+	if !ok {
+		return errors.New("invalid correlation-id cache value found")
+	}
+	if !strings.EqualFold(value.SecretKey, secretKey) {
+		return errors.New("invalid secret key passed for user")
+	}
+	publicKeyData, err := ParseB64RSAPublicKeyFromPEM(publicKey)
+	if err != nil {
+		return errors.Wrap(err, "could not read public Key")
+	}
+	fingerprint, err := PublicKeyFingerprint(publicKeyData)
+	if err != nil {
+		return errors.Wrap(err, "could not read public Key")
+	}
+
+	value.Lock()
+	defer value.Unlock()
+
+	if _, ok := value.PublicKeys[fingerprint]; !ok {
+		return errors.New("public key is not registered")
+	}
+	if len(value.PublicKeys) == 1 {
+		return errors.New("the last public key can't be removed")
+	}
+	delete(value.PublicKeys, fingerprint)
+	if !value.PublicKey.Equal(publicKeyData) {
+		return nil
+	}
+
+	// the AES key is wrapped again under the new primary key
+	fingerprints := make([]string, 0, len(value.PublicKeys))
+	for fingerprint := range value.PublicKeys {
+		fingerprints = append(fingerprints, fingerprint)
+	}
+	sort.Strings(fingerprints)
+	primary := value.PublicKeys[fingerprints[0]]
+	aesKeyEncrypted, err := wrapAESKey(primary, value.AESKey)
+	if err != nil {
+		return err
+	}
+	value.PublicKey = primary
+	value.AESKeyEncrypted = aesKeyEncrypted
+	value.previousAESKey = nil
+	value.previousAESKeyEncrypted = ""
+	return nil
+}
+
+// GetAESKeys returns the session AES key encrypted as aesKeyEncrypted, as returned
+// by GetInteractions, wrapped under each public key of the correlation ID by fingerprint.
+func (s *StorageDB) GetAESKeys(correlationID, secretKey, aesKeyEncrypted string) (map[string]string, error) {
+	item, ok := s.cache.GetIfPresent(correlationID)
+	if !ok {
+		return nil, ErrCorrelationIdNotFound
+	}
+	value, ok := item.(*CorrelationData)
+	if !ok {
+		return nil, errors.New("invalid correlation-id cache value found")
+	}
+	if !strings.EqualFold(value.SecretKey, secretKey) {
+		return nil, errors.New("invalid secret key passed for user")
+	}
+
+	value.Lock()
+	defer value.Unlock()
+
+	// the key may have been rotated since the interactions were drained
+	var aesKey []byte
+	switch aesKeyEncrypted {
+	case value.AESKeyEncrypted:
+		aesKey = value.AESKey
+	case value.previousAESKeyEncrypted:
+		aesKey = value.previousAESKey
+	}
+	if len(aesKey) == 0 {
+		return nil, errors.New("aes key not found")
+	}
+
+	keys := make(map[string]string, len(value.PublicKeys))
+	for fingerprint, publicKey := range value.PublicKeys {
+		// the primary key already wraps both the current and the previous key
+		if publicKey.Equal(value.PublicKey) {
+			keys[fingerprint] = aesKeyEncrypted
+			continue
+		}
+		wrapped, err := wrapAESKey(publicKey, aesKey)
+		if err != nil {
+			return nil, err
+		}
+		keys[fingerprint] = wrapped
+	}
+	return keys, nil
+}
+
 func (s *StorageDB) SetID(ID string) error {
 	data := &CorrelationData{}
 
 	s.cache.Put(ID, data)
+	s.ids.Store(ID, data)
 	return nil
 }
 
@@ -159,6 +434,10 @@ func (s *StorageDB) AddInteraction(correlationID string, data []byte) error {
 	}
 
 	if s.Options.UseDisk() {
+		// the AES key may be rotated on poll, so encrypt while holding the lock
+		value.Lock()
+		defer value.Unlock()
+
 		ct := string(data)
 		if len(value.AESKey) > 0 {
 			var err error
@@ -168,19 +447,84 @@ func (s *StorageDB) AddInteraction(correlationID string, data []byte) error {
 			}
 		}
 
-		value.Lock()
 		existingData, _ := s.db.Get([]byte(correlationID), nil)
 		_ = s.db.Put([]byte(correlationID), AppendMany("\n", existingData, []byte(ct)), nil)
-		value.Unlock()
+		s.trackRetention(value, correlationID)
 	} else {
+		if err := s.reserveMemory(len(data)); err != nil {
+			return err
+		}
 		value.Lock()
-		value.Data = append(value.Data, string(data))
+		s.appendData(value, string(data))
+		s.trackRetention(value, correlationID)
 		value.Unlock()
 	}
 
 	return nil
 }
 
+// trackRetention records the time of the interaction just added and expires the
+// interactions older than the retention. The caller must hold the data lock.
+func (s *StorageDB) trackRetention(value *CorrelationData, id string) {
+	if value.Retention <= 0 {
+		return
+	}
+	value.AddedAt = append(value.AddedAt, time.Now())
+	s.expireInteractions(value, id)
+}
+
+// expireInteractions drops the buffered interactions older than the retention
+// of the correlation data. The caller must hold the data lock.
+func (s *StorageDB) expireInteractions(value *CorrelationData, id string) {
+	if value.Retention <= 0 {
+		return
+	}
+	expired := 0
+	for _, addedAt := range value.AddedAt {
+		if time.Since(addedAt) <= value.Retention {
+			break
+		}
+		expired++
+	}
+	if expired == 0 {
+		return
+	}
+	value.AddedAt = value.AddedAt[expired:]
+	s.applyTrim(value, id, expired)
+}
+
+// SetRetention sets the maximum age of the buffered interactions of a correlation ID.
+func (s *StorageDB) SetRetention(correlationID string, retention time.Duration) error {
+	item, ok := s.cache.GetIfPresent(correlationID)
+	if !ok {
+		return ErrCorrelationIdNotFound
+	}
+	value, ok := item.(*CorrelationData)
+	if !ok {
+		return errors.New("invalid correlation-id cache value found")
+	}
+	value.Lock()
+	value.Retention = retention
+	value.Unlock()
+	return nil
+}
+
+// SetMetadata sets the client metadata of a correlation ID.
+func (s *StorageDB) SetMetadata(correlationID string, metadata map[string]string) error {
+	item, ok := s.cache.GetIfPresent(correlationID)
+	if !ok {
+		return ErrCorrelationIdNotFound
+	}
+	value, ok := item.(*CorrelationData)
+	if !ok {
+		return errors.New("invalid correlation-id cache value found")
+	}
+	value.Lock()
+	value.Metadata = metadata
+	value.Unlock()
+	return nil
+}
+
 // AddInteractionWithId adds an interaction data to the id bucket
 func (s *StorageDB) AddInteractionWithId(id string, data []byte) error {
 	if len(data) == 0 {
@@ -197,6 +541,10 @@ func (s *StorageDB) AddInteractionWithId(id string, data []byte) error {
 	}
 
 	if s.Options.UseDisk() {
+		// the AES key may be rotated on poll, so encrypt while holding the lock
+		value.Lock()
+		defer value.Unlock()
+
 		ct := string(data)
 		if len(value.AESKey) > 0 {
 			var err error
@@ -206,13 +554,14 @@ func (s *StorageDB) AddInteractionWithId(id string, data []byte) error {
 			}
 		}
 
-		value.Lock()
 		existingData, _ := s.db.Get([]byte(id), nil)
 		_ = s.db.Put([]byte(id), AppendMany("\n", existingData, []byte(ct)), nil)
-		value.Unlock()
 	} else {
+		if err := s.reserveMemory(len(data)); err != nil {
+			return err
+		}
 		value.Lock()
-		value.Data = append(value.Data, string(data))
+		s.appendData(value, string(data))
 		value.Unlock()
 	}
 
@@ -233,8 +582,191 @@ func (s *StorageDB) GetInteractions(correlationID, secret string) ([]string, str
 	if !strings.EqualFold(value.SecretKey, secret) {
 		return nil, "", errors.New("invalid secret key passed for user")
 	}
-	data, err := s.getInteractions(value, correlationID)
-	return data, value.AESKeyEncrypted, err
+	if s.keyExpired(value, correlationID) {
+		return nil, "", ErrKeyExpired
+	}
+	data, _, aesKeyEncrypted, err := s.getInteractions(value, correlationID, 0)
+	return data, aesKeyEncrypted, err
+}
+
+// GetInteractionsBatch returns at most limit interactions for a correlationID, all of
+// them when limit is not positive, and removes them from the storage. The remaining
+// ones are left for the next polls, it also returns whether any of them remain
+// along with the AES Encrypted Key for the IDs.
+func (s *StorageDB) GetInteractionsBatch(correlationID, secret string, limit int) ([]string, bool, string, error) {
+	item, ok := s.cache.GetIfPresent(correlationID)
+	if !ok {
+		return nil, false, "", ErrCorrelationIdNotFound
+	}
+	value, ok := item.(*CorrelationData)
+	if !ok {
+		return nil, false, "", errors.New("invalid correlation-id cache value found")
+	}
+	if !strings.EqualFold(value.SecretKey, secret) {
+		return nil, false, "", errors.New("invalid secret key passed for user")
+	}
+	if s.keyExpired(value, correlationID) {
+		return nil, false, "", ErrKeyExpired
+	}
+	return s.getInteractions(value, correlationID, limit)
+}
+
+// keyExpired removes the registration of a correlation ID older than the MaxKeyAge
+func (s *StorageDB) keyExpired(value *CorrelationData, correlationID string) bool {
+	if s.Options.MaxKeyAge <= 0 || value.RegisteredAt.IsZero() || time.Since(value.RegisteredAt) <= s.Options.MaxKeyAge {
+		return false
+	}
+	value.Lock()
+	s.dropData(value, len(value.Data))
+	value.Unlock()
+	s.cache.Invalidate(correlationID)
+	s.ids.CompareAndDelete(correlationID, value)
+	if s.Options.UseDisk() {
+		_ = s.db.Delete([]byte(correlationID), nil)
+	}
+	return true
+}
+
+// PeekInteractions returns the interactions for a correlationID without removing
+// them, along with their delivery tokens and the AES Encrypted Key for the IDs.
+// The interactions are removed once their tokens are passed to AckInteractions.
+func (s *StorageDB) PeekInteractions(correlationID, secret string) ([]string, []string, string, error) {
+	item, ok := s.cache.GetIfPresent(correlationID)
+	if !ok {
+		return nil, nil, "", ErrCorrelationIdNotFound
+	}
+	value, ok := item.(*CorrelationData)
+	if !ok {
+		return nil, nil, "", errors.New("invalid correlation-id cache value found")
+	}
+	if !strings.EqualFold(value.SecretKey, secret) {
+		return nil, nil, "", errors.New("invalid secret key passed for user")
+	}
+	if s.keyExpired(value, correlationID) {
+		return nil, nil, "", ErrKeyExpired
+	}
+
+	value.Lock()
+	defer value.Unlock()
+
+	s.rotateAESKey(value, correlationID)
+	s.expireInteractions(value, correlationID)
+	stored, err := s.storedInteractions(value, correlationID)
+	if err != nil || len(stored) == 0 {
+		return nil, nil, value.AESKeyEncrypted, err
+	}
+
+	var errs []error
+	data := make([]string, len(stored))
+	tokens := make([]string, len(stored))
+	for i, dataItem := range stored {
+		tokens[i] = deliveryToken(dataItem)
+	}
+	// disk data is encrypted when added
+	if s.Options.UseDisk() {
+		data, err = s.reencryptDrainData(value, stored)
+		return data, tokens, value.AESKeyEncrypted, err
+	}
+	for i, dataItem := range stored {
+		encryptedDataItem, err := AESEncrypt(value.AESKey, []byte(dataItem))
+		if err != nil {
+			errs = append(errs, errors.Wrap(err, "could not encrypt event data"))
+			data[i] = dataItem
+		} else {
+			data[i] = encryptedDataItem
+		}
+	}
+	return data, tokens, value.AESKeyEncrypted, multierr.Combine(errs...)
+}
+
+// AckInteractions removes the interactions of a correlationID whose delivery
+// tokens, returned by PeekInteractions, were received by the client.
+func (s *StorageDB) AckInteractions(correlationID, secret string, tokens []string) error {
+	item, ok := s.cache.GetIfPresent(correlationID)
+	if !ok {
+		return ErrCorrelationIdNotFound
+	}
+	value, ok := item.(*CorrelationData)
+	if !ok {
+		return errors.New("invalid correlation-id cache value found")
+	}
+	if !strings.EqualFold(value.SecretKey, secret) {
+		return errors.New("invalid secret key passed for user")
+	}
+
+	value.Lock()
+	defer value.Unlock()
+
+	stored, err := s.storedInteractions(value, correlationID)
+	if err != nil {
+		return err
+	}
+	acked := make(map[string]int, len(tokens))
+	for _, token := range tokens {
+		acked[token]++
+	}
+	var (
+		remaining []string
+		addedAt   []time.Time
+		drained   int
+	)
+	for i, dataItem := range stored {
+		if token := deliveryToken(dataItem); acked[token] > 0 {
+			acked[token]--
+			if i < value.drainAESKeyCount {
+				drained++
+			}
+			continue
+		}
+		remaining = append(remaining, dataItem)
+		if i < len(value.AddedAt) {
+			addedAt = append(addedAt, value.AddedAt[i])
+		}
+	}
+	if len(value.AddedAt) > 0 {
+		value.AddedAt = addedAt
+	}
+
+	switch {
+	case s.Options.UseDisk():
+		if len(remaining) == 0 {
+			_ = s.db.Delete([]byte(correlationID), nil)
+		} else {
+			_ = s.db.Put([]byte(correlationID), []byte(strings.Join(remaining, "\n")), nil)
+		}
+	default:
+		s.replaceData(value, remaining)
+	}
+	s.trimDrainAESKey(value, drained)
+	return nil
+}
+
+// storedInteractions returns the buffered interactions of the id as they are
+// stored. The caller must hold the data lock.
+func (s *StorageDB) storedInteractions(value *CorrelationData, id string) ([]string, error) {
+	if !s.Options.UseDisk() {
+		return value.Data, nil
+	}
+	raw, err := s.db.Get([]byte(id), nil)
+	if err != nil {
+		if errors.Is(err, leveldb.ErrNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var data []string
+	for _, d := range bytes.Split(raw, []byte("\n")) {
+		if len(d) > 0 {
+			data = append(data, string(d))
+		}
+	}
+	return data, nil
+}
+
+// deliveryToken identifies a stored interaction in poll acknowledgments
+func deliveryToken(dataItem string) string {
+	hash := sha256.Sum256([]byte(dataItem))
+	return hex.EncodeToString(hash[:16])
 }
 
 // GetInteractions returns the interactions for a id and empty the cache
@@ -247,7 +779,8 @@ func (s *StorageDB) GetInteractionsWithId(id string) ([]string, error) {
 	if !ok {
 		return nil, errors.New("invalid id cache value found")
 	}
-	return s.getInteractions(value, id)
+	data, _, _, err := s.getInteractions(value, id, 0)
+	return data, err
 }
 
 // GetInteractionsWithIdForConsumer returns unseen interactions for a consumer
@@ -363,7 +896,7 @@ func (s *StorageDB) evictStaleConsumers(value *CorrelationData, id string) {
 	}
 
 	if len(value.ReadOffsets) == 0 {
-		value.Data = nil
+		s.dropData(value, len(value.Data))
 		if s.Options.UseDisk() {
 			_ = s.db.Delete([]byte(id), nil)
 		}
@@ -399,12 +932,9 @@ func (s *StorageDB) applyTrim(value *CorrelationData, id string, trimCount int)
 			_ = s.db.Put([]byte(id), []byte(strings.Join(remaining, "\n")), nil)
 		}
 	default:
-		if trimCount >= len(value.Data) {
-			value.Data = nil
-		} else {
-			value.Data = value.Data[trimCount:]
-		}
+		s.dropData(value, trimCount)
 	}
+	s.trimDrainAESKey(value, trimCount)
 
 	for cid, off := range value.ReadOffsets {
 		value.ReadOffsets[cid] = max(off-trimCount, 0)
@@ -442,9 +972,10 @@ func (s *StorageDB) RemoveID(correlationID, secret string) error {
 		return errors.New("invalid secret key passed for deregister")
 	}
 	value.Lock()
-	value.Data = nil
+	s.dropData(value, len(value.Data))
 	value.Unlock()
 	s.cache.Invalidate(correlationID)
+	s.ids.CompareAndDelete(correlationID, value)
 
 	if s.Options.UseDisk() {
 		return s.db.Delete([]byte(correlationID), nil)
@@ -452,6 +983,66 @@ func (s *StorageDB) RemoveID(correlationID, secret string) error {
 	return nil
 }
 
+// PurgeID removes a registered correlation ID with its keys and buffered interactions
+// without verifying the secret key. The ids set with SetID, which have no secret key,
+// are rejected with ErrNotRegistered.
+func (s *StorageDB) PurgeID(correlationID string) error {
+	item, ok := s.cache.GetIfPresent(correlationID)
+	if !ok {
+		return ErrCorrelationIdNotFound
+	}
+	value, ok := item.(*CorrelationData)
+	if !ok {
+		return errors.New("invalid correlation-id cache value found")
+	}
+	value.Lock()
+	if value.SecretKey == "" {
+		value.Unlock()
+		return ErrNotRegistered
+	}
+	s.dropData(value, len(value.Data))
+	value.SecretKey = ""
+	value.AESKey = nil
+	value.AESKeyEncrypted = ""
+	value.PublicKey = nil
+	value.PublicKeys = nil
+	value.previousAESKey = nil
+	value.previousAESKeyEncrypted = ""
+	value.drainAESKey = nil
+	value.drainAESKeyCount = 0
+	value.Unlock()
+	s.cache.Invalidate(correlationID)
+	s.ids.CompareAndDelete(correlationID, value)
+
+	if s.Options.UseDisk() {
+		return s.db.Delete([]byte(correlationID), nil)
+	}
+	return nil
+}
+
+// RemoveAll removes all the registered correlation IDs with their keys and buffered
+// interactions, returning the removed IDs. The ids set with SetID, which have no
+// secret key, are kept.
+func (s *StorageDB) RemoveAll() ([]string, error) {
+	var (
+		removed []string
+		errs    []error
+	)
+	s.ids.Range(func(key, item interface{}) bool {
+		correlationID := key.(string)
+		if err := s.PurgeID(correlationID); err != nil {
+			if !errors.Is(err, ErrCorrelationIdNotFound) && !errors.Is(err, ErrNotRegistered) {
+				errs = append(errs, errors.Wrapf(err, "could not remove %s", correlationID))
+			}
+			return true
+		}
+		removed = append(removed, correlationID)
+		return true
+	})
+	sort.Strings(removed)
+	return removed, multierr.Combine(errs...)
+}
+
 // GetCacheItem returns an item as is
 func (s *StorageDB) GetCacheItem(token string) (*CorrelationData, error) {
 	item, ok := s.cache.GetIfPresent(token)
@@ -465,18 +1056,27 @@ func (s *StorageDB) GetCacheItem(token string) (*CorrelationData, error) {
 	return value, nil
 }
 
-func (s *StorageDB) getInteractions(correlationData *CorrelationData, id string) ([]string, error) {
+// getInteractions removes and returns at most limit buffered interactions of the id,
+// all of them when limit is not positive, along with whether more remain buffered and
+// the encrypted AES key they can be decrypted with. The AES key is rotated before
+// draining, so drained interactions always match the returned key.
+func (s *StorageDB) getInteractions(correlationData *CorrelationData, id string, limit int) ([]string, bool, string, error) {
 	correlationData.Lock()
 	defer correlationData.Unlock()
 
+	s.rotateAESKey(correlationData, id)
+	aesKey, aesKeyEncrypted := correlationData.AESKey, correlationData.AESKeyEncrypted
+	s.expireInteractions(correlationData, id)
+
 	switch {
 	case s.Options.UseDisk():
 		data, err := s.db.Get([]byte(id), nil)
 		if err != nil {
 			if errors.Is(err, leveldb.ErrNotFound) {
 				err = nil
+				correlationData.AddedAt = nil
 			}
-			return nil, err
+			return nil, false, aesKeyEncrypted, err
 		}
 		var dataString []string
 		for _, d := range bytes.Split(data, []byte("\n")) {
@@ -485,19 +1085,31 @@ func (s *StorageDB) getInteractions(correlationData *CorrelationData, id string)
 			}
 			dataString = append(dataString, string(d))
 		}
-		_ = s.db.Delete([]byte(id), nil)
-		return dataString, nil
+		n := batchSize(len(dataString), limit)
+		more := n < len(dataString)
+		if more {
+			_ = s.db.Put([]byte(id), []byte(strings.Join(dataString[n:], "\n")), nil)
+		} else {
+			_ = s.db.Delete([]byte(id), nil)
+		}
+		s.trimAddedAt(correlationData, n, more)
+		drained, err := s.reencryptDrainData(correlationData, dataString[:n])
+		s.trimDrainAESKey(correlationData, n)
+		return drained, more, aesKeyEncrypted, err
 	default:
 		// in memory data
 		var errs []error
-		data := correlationData.Data
-		correlationData.Data = nil
+		n := batchSize(len(correlationData.Data), limit)
+		data := correlationData.Data[:n]
+		s.dropData(correlationData, n)
+		more := len(correlationData.Data) > 0
+		s.trimAddedAt(correlationData, n, more)
 		if len(data) == 0 {
-			return nil, nil
+			return nil, false, aesKeyEncrypted, nil
 		}
 
 		for i, dataItem := range data {
-			encryptedDataItem, err := AESEncrypt(correlationData.AESKey, []byte(dataItem))
+			encryptedDataItem, err := AESEncrypt(aesKey, []byte(dataItem))
 			if err != nil {
 				errs = append(errs, errors.Wrap(err, "could not encrypt event data"))
 				data[i] = dataItem
@@ -505,8 +1117,30 @@ func (s *StorageDB) getInteractions(correlationData *CorrelationData, id string)
 				data[i] = encryptedDataItem
 			}
 		}
-		return data, multierr.Combine(errs...)
+		return data, more, aesKeyEncrypted, multierr.Combine(errs...)
+	}
+}
+
+// trimAddedAt forgets the insertion times of the n interactions removed from the
+// correlation data. The caller must hold the data lock.
+func (s *StorageDB) trimAddedAt(correlationData *CorrelationData, n int, more bool) {
+	if !more {
+		correlationData.AddedAt = nil
+		return
+	}
+	if n < len(correlationData.AddedAt) {
+		correlationData.AddedAt = correlationData.AddedAt[n:]
+	} else {
+		correlationData.AddedAt = nil
+	}
+}
+
+// batchSize returns the number of interactions out of total returned for the limit
+func batchSize(total, limit int) int {
+	if limit <= 0 || limit > total {
+		return total
 	}
+	return limit
 }
 
 func (s *StorageDB) Close() error {
@@ -376,3 +376,341 @@ func TestFixedEvictionStrategy(t *testing.T) {
 	_, ok = mem.cache.GetIfPresent("test-fixed")
 	require.False(t, ok)
 }
+
+func TestPinnedCorrelationIDs(t *testing.T) {
+	for name, options := range map[string]*Options{
+		"memory": {EvictionTTL: 100 * time.Millisecond, PinnedCorrelationIDs: []string{"pinned"}},
+		"disk":   {EvictionTTL: 100 * time.Millisecond, PinnedCorrelationIDs: []string{"pinned"}, DbPath: t.TempDir()},
+	} {
+		t.Run(name, func(t *testing.T) {
+			db, err := New(options)
+			require.Nil(t, err)
+			defer db.Close()
+
+			_, pubKey := generateRSAKeyPair(t)
+			for _, id := range []string{"pinned", "other"} {
+				require.Nil(t, db.SetIDPublicKey(id, "secret", pubKey))
+				require.Nil(t, db.AddInteraction(id, []byte("interaction")))
+			}
+
+			time.Sleep(200 * time.Millisecond)
+			_, ok := db.cache.GetIfPresent("other")
+			require.False(t, ok, "unpinned correlation-id should be evicted")
+			_, _, err = db.GetInteractions("other", "secret")
+			require.ErrorIs(t, err, ErrCorrelationIdNotFound)
+
+			data, _, err := db.GetInteractions("pinned", "secret")
+			require.Nil(t, err)
+			require.Len(t, data, 1, "pinned correlation-id should keep its interactions")
+
+			// pinned correlation-ids can still be deregistered
+			require.Nil(t, db.RemoveID("pinned", "secret"))
+			_, ok = db.cache.GetIfPresent("pinned")
+			require.False(t, ok)
+		})
+	}
+}
+
+func TestPurgeID(t *testing.T) {
+	db, err := New(&Options{EvictionTTL: 1 * time.Hour, DbPath: t.TempDir()})
+	require.Nil(t, err)
+	defer db.Close()
+
+	_, pubKey := generateRSAKeyPair(t)
+	correlationID := xid.New().String()
+	require.Nil(t, db.SetIDPublicKey(correlationID, "secret", pubKey))
+	require.Nil(t, db.AddInteraction(correlationID, []byte("interaction")))
+
+	// ids without secret key, such as the token one, are not purged
+	require.Nil(t, db.SetID("token"))
+	require.ErrorIs(t, db.PurgeID("token"), ErrNotRegistered)
+	_, err = db.GetCacheItem("token")
+	require.Nil(t, err, "token id should be kept")
+
+	require.Nil(t, db.PurgeID(correlationID))
+	_, ok := db.cache.GetIfPresent(correlationID)
+	require.False(t, ok, "correlation-id should be removed from cache")
+	_, err = db.db.Get([]byte(correlationID), nil)
+	require.Error(t, err, "interactions should be removed from disk")
+
+	require.ErrorIs(t, db.PurgeID(correlationID), ErrCorrelationIdNotFound)
+}
+
+func TestRemoveAll(t *testing.T) {
+	for name, options := range map[string]*Options{
+		"memory": {EvictionTTL: 1 * time.Hour},
+		"disk":   {EvictionTTL: 1 * time.Hour, DbPath: t.TempDir()},
+	} {
+		t.Run(name, func(t *testing.T) {
+			db, err := New(options)
+			require.Nil(t, err)
+			defer db.Close()
+
+			_, pubKey := generateRSAKeyPair(t)
+			ids := []string{"aaaaaaaaaa", "bbbbbbbbbb"}
+			for _, id := range ids {
+				require.Nil(t, db.SetIDPublicKey(id, "secret", pubKey))
+				require.Nil(t, db.AddInteraction(id, []byte("interaction")))
+			}
+			// ids without secret key, such as the token one, are kept
+			require.Nil(t, db.SetID("token"))
+			require.Nil(t, db.AddInteractionWithId("token", []byte("interaction")))
+
+			removed, err := db.RemoveAll()
+			require.Nil(t, err)
+			require.Equal(t, ids, removed)
+			require.Equal(t, []string{"token"}, db.CorrelationIDs())
+			for _, id := range ids {
+				_, err := db.GetCacheItem(id)
+				require.Error(t, err, "correlation id should be removed")
+				if options.UseDisk() {
+					_, err = db.db.Get([]byte(id), nil)
+					require.Error(t, err, "interactions should be removed from disk")
+				}
+			}
+
+			removed, err = db.RemoveAll()
+			require.Nil(t, err)
+			require.Empty(t, removed)
+		})
+	}
+}
+
+func TestCorrelationIDs(t *testing.T) {
+	db, err := New(&Options{EvictionTTL: 1 * time.Hour, MaxSize: 2})
+	require.Nil(t, err)
+	defer db.Close()
+
+	_, pubKey := generateRSAKeyPair(t)
+	require.Nil(t, db.SetIDPublicKey("bbbbbbbbbb", "secret", pubKey))
+	require.Nil(t, db.SetID("aaaaaaaaaa"))
+	require.Equal(t, []string{"aaaaaaaaaa", "bbbbbbbbbb"}, db.CorrelationIDs())
+
+	require.Nil(t, db.PurgeID("bbbbbbbbbb"))
+	require.Equal(t, []string{"aaaaaaaaaa"}, db.CorrelationIDs())
+
+	// the ids evicted from the cache are dropped as well
+	require.Nil(t, db.SetID("cccccccccc"))
+	require.Nil(t, db.SetID("dddddddddd"))
+	require.Eventually(t, func() bool {
+		return len(db.CorrelationIDs()) == 2
+	}, time.Second, 10*time.Millisecond)
+	require.NotContains(t, db.CorrelationIDs(), "aaaaaaaaaa")
+}
+
+func TestMaxStorageMemoryReject(t *testing.T) {
+	db, err := New(&Options{EvictionTTL: 1 * time.Hour, MaxStorageMemoryBytes: 10})
+	require.Nil(t, err)
+	defer db.Close()
+
+	require.Nil(t, db.SetID("aaaaaaaaaa"))
+	require.Nil(t, db.AddInteraction("aaaaaaaaaa", []byte("12345")))
+	require.Nil(t, db.AddInteraction("aaaaaaaaaa", []byte("67890")))
+	require.ErrorIs(t, db.AddInteraction("aaaaaaaaaa", []byte("x")), ErrMemoryLimit)
+
+	metrics, err := db.GetCacheMetrics()
+	require.Nil(t, err)
+	require.Equal(t, int64(10), metrics.MemoryUsage)
+	require.Equal(t, uint64(1), metrics.MemoryRejected)
+
+	// consumed interactions release their memory once compacted
+	data, err := db.GetInteractionsWithIdForConsumer("aaaaaaaaaa", "consumer")
+	require.Nil(t, err)
+	require.Equal(t, []string{"12345", "67890"}, data)
+	require.Nil(t, db.RemoveConsumer("aaaaaaaaaa", "consumer"))
+	require.Nil(t, db.AddInteraction("aaaaaaaaaa", []byte("x")))
+	metrics, err = db.GetCacheMetrics()
+	require.Nil(t, err)
+	require.Equal(t, int64(1), metrics.MemoryUsage)
+}
+
+func TestMaxStorageMemoryEvict(t *testing.T) {
+	db, err := New(&Options{EvictionTTL: 1 * time.Hour, MaxStorageMemoryBytes: 10, MemoryPolicy: MemoryPolicyEvict})
+	require.Nil(t, err)
+	defer db.Close()
+
+	require.Nil(t, db.SetID("aaaaaaaaaa"))
+	require.Nil(t, db.SetID("bbbbbbbbbb"))
+	require.Nil(t, db.AddInteraction("aaaaaaaaaa", []byte("first")))
+	require.Nil(t, db.AddInteraction("bbbbbbbbbb", []byte("second")))
+	// the oldest interaction is evicted to make room for the new one
+	require.Nil(t, db.AddInteraction("bbbbbbbbbb", []byte("abc")))
+
+	metrics, err := db.GetCacheMetrics()
+	require.Nil(t, err)
+	require.Equal(t, int64(9), metrics.MemoryUsage)
+	require.Equal(t, uint64(1), metrics.MemoryEvicted)
+	require.Zero(t, metrics.MemoryRejected)
+
+	data, err := db.GetInteractionsWithIdForConsumer("aaaaaaaaaa", "consumer")
+	require.Nil(t, err)
+	require.Empty(t, data)
+	data, err = db.GetInteractionsWithIdForConsumer("bbbbbbbbbb", "consumer")
+	require.Nil(t, err)
+	require.Equal(t, []string{"second", "abc"}, data)
+
+	// interactions larger than the limit are still rejected
+	require.ErrorIs(t, db.AddInteraction("aaaaaaaaaa", []byte("0123456789a")), ErrMemoryLimit)
+}
+
+func TestMaxStorageMemoryEvictQueueCompaction(t *testing.T) {
+	db, err := New(&Options{EvictionTTL: time.Hour, MaxStorageMemoryBytes: 1 << 20, MemoryPolicy: MemoryPolicyEvict})
+	require.Nil(t, err)
+	defer db.Close()
+
+	// an unread interaction stays at the front of the eviction queue
+	require.Nil(t, db.SetID("aaaaaaaaaa"))
+	require.Nil(t, db.AddInteraction("aaaaaaaaaa", []byte("interaction")))
+	_, pubKey := generateRSAKeyPair(t)
+	require.Nil(t, db.SetIDPublicKey("bbbbbbbbbb", "secret", pubKey))
+	for i := 0; i < 10*memoryQueueCompactMin; i++ {
+		require.Nil(t, db.AddInteraction("bbbbbbbbbb", []byte("interaction")))
+		data, _, err := db.GetInteractions("bbbbbbbbbb", "secret")
+		require.Nil(t, err)
+		require.Len(t, data, 1)
+	}
+
+	db.memory.mu.Lock()
+	queued := len(db.memory.queue)
+	db.memory.mu.Unlock()
+	require.LessOrEqual(t, queued, memoryQueueCompactMin, "stale entries should be removed from the eviction queue")
+}
+
+func TestSessionRetention(t *testing.T) {
+	for name, options := range map[string]*Options{
+		"memory": {EvictionTTL: 1 * time.Hour},
+		"disk":   {EvictionTTL: 1 * time.Hour, DbPath: t.TempDir()},
+	} {
+		t.Run(name, func(t *testing.T) {
+			db, err := New(options)
+			require.Nil(t, err)
+			defer db.Close()
+
+			_, pubKey := generateRSAKeyPair(t)
+			shortID, longID, defaultID := xid.New().String(), xid.New().String(), xid.New().String()
+			for _, id := range []string{shortID, longID, defaultID} {
+				require.Nil(t, db.SetIDPublicKey(id, "secret", pubKey))
+			}
+			require.Nil(t, db.SetRetention(shortID, 50*time.Millisecond))
+			require.Nil(t, db.SetRetention(longID, 1*time.Hour))
+			require.ErrorIs(t, db.SetRetention(xid.New().String(), time.Hour), ErrCorrelationIdNotFound)
+
+			for _, id := range []string{shortID, longID, defaultID} {
+				require.Nil(t, db.AddInteraction(id, []byte("old")))
+			}
+			time.Sleep(100 * time.Millisecond)
+			for _, id := range []string{shortID, longID, defaultID} {
+				require.Nil(t, db.AddInteraction(id, []byte("new")))
+			}
+
+			data, _, err := db.GetInteractions(shortID, "secret")
+			require.Nil(t, err)
+			require.Len(t, data, 1, "expired interaction should be evicted")
+			data, _, err = db.GetInteractions(longID, "secret")
+			require.Nil(t, err)
+			require.Len(t, data, 2)
+			data, _, err = db.GetInteractions(defaultID, "secret")
+			require.Nil(t, err)
+			require.Len(t, data, 2, "interactions without retention should be kept")
+
+			// expiry also applies at poll time
+			require.Nil(t, db.AddInteraction(shortID, []byte("late")))
+			time.Sleep(100 * time.Millisecond)
+			data, _, err = db.GetInteractions(shortID, "secret")
+			require.Nil(t, err)
+			require.Empty(t, data)
+		})
+	}
+}
+
+func TestGetInteractionsBatch(t *testing.T) {
+	for name, options := range map[string]*Options{
+		"memory": {EvictionTTL: 1 * time.Hour, AESKeyRotationInterval: time.Nanosecond},
+		"disk":   {EvictionTTL: 1 * time.Hour, AESKeyRotationInterval: time.Nanosecond, DbPath: t.TempDir()},
+	} {
+		t.Run(name, func(t *testing.T) {
+			db, err := New(options)
+			require.Nil(t, err)
+			defer db.Close()
+
+			priv, pubKey := generateRSAKeyPair(t)
+			correlationID := xid.New().String()
+			require.Nil(t, db.SetIDPublicKey(correlationID, "secret", pubKey))
+			for _, interaction := range []string{"first", "second", "third"} {
+				require.Nil(t, db.AddInteraction(correlationID, []byte(interaction)))
+			}
+
+			data, more, aesKey, err := db.GetInteractionsBatch(correlationID, "secret", 2)
+			require.Nil(t, err)
+			require.True(t, more)
+			require.Len(t, data, 2)
+			require.Equal(t, "second", string(clientDecrypt(t, priv, aesKey, data[1])))
+
+			// the remaining interactions are decrypted with the key returned with them
+			data, more, aesKey, err = db.GetInteractionsBatch(correlationID, "secret", 2)
+			require.Nil(t, err)
+			require.False(t, more)
+			require.Len(t, data, 1)
+			require.Equal(t, "third", string(clientDecrypt(t, priv, aesKey, data[0])))
+
+			data, more, _, err = db.GetInteractionsBatch(correlationID, "secret", 2)
+			require.Nil(t, err)
+			require.False(t, more)
+			require.Empty(t, data)
+		})
+	}
+}
+
+func TestPeekAckInteractions(t *testing.T) {
+	for name, options := range map[string]*Options{
+		"memory": {EvictionTTL: 1 * time.Hour, AESKeyRotationInterval: time.Nanosecond},
+		"disk":   {EvictionTTL: 1 * time.Hour, AESKeyRotationInterval: time.Nanosecond, DbPath: t.TempDir()},
+	} {
+		t.Run(name, func(t *testing.T) {
+			db, err := New(options)
+			require.Nil(t, err)
+			defer db.Close()
+
+			priv, pubKey := generateRSAKeyPair(t)
+			correlationID := xid.New().String()
+			require.Nil(t, db.SetIDPublicKey(correlationID, "secret", pubKey))
+			for _, interaction := range []string{"first", "second"} {
+				require.Nil(t, db.AddInteraction(correlationID, []byte(interaction)))
+			}
+
+			_, _, _, err = db.PeekInteractions(correlationID, "wrong")
+			require.Error(t, err)
+
+			data, tokens, aesKey, err := db.PeekInteractions(correlationID, "secret")
+			require.Nil(t, err)
+			require.Len(t, data, 2)
+			require.Len(t, tokens, 2)
+			require.Equal(t, "first", string(clientDecrypt(t, priv, aesKey, data[0])))
+
+			// unacknowledged interactions are delivered again with the same tokens
+			redelivered, redeliveredTokens, redeliveredKey, err := db.PeekInteractions(correlationID, "secret")
+			require.Nil(t, err)
+			require.Equal(t, tokens, redeliveredTokens)
+			require.Equal(t, "second", string(clientDecrypt(t, priv, redeliveredKey, redelivered[1])))
+
+			require.Error(t, db.AckInteractions(correlationID, "wrong", tokens))
+			require.Nil(t, db.AckInteractions(correlationID, "secret", tokens[:1]))
+			data, tokens, aesKey, err = db.PeekInteractions(correlationID, "secret")
+			require.Nil(t, err)
+			require.Len(t, data, 1)
+			require.Equal(t, "second", string(clientDecrypt(t, priv, aesKey, data[0])))
+
+			require.Nil(t, db.AckInteractions(correlationID, "secret", tokens))
+			data, tokens, newKey, err := db.PeekInteractions(correlationID, "secret")
+			require.Nil(t, err)
+			require.Empty(t, data)
+			require.Empty(t, tokens)
+			require.NotEqual(t, aesKey, newKey, "key should be rotated once the acknowledged interactions are drained")
+
+			require.Nil(t, db.AddInteraction(correlationID, []byte("third")))
+			data, _, newKey, err = db.PeekInteractions(correlationID, "secret")
+			require.Nil(t, err)
+			require.Equal(t, "third", string(clientDecrypt(t, priv, newKey, data[0])))
+		})
+	}
+}
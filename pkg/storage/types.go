@@ -1,7 +1,9 @@
 package storage
 
 import (
+	"crypto/rsa"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -14,6 +16,9 @@ type CacheMetrics struct {
 	LoadErrorCount   uint64        `json:"load-error-count"`
 	TotalLoadTime    time.Duration `json:"total-load-time"`
 	EvictionCount    uint64        `json:"eviction-count"`
+	MemoryUsage      int64         `json:"memory-usage"`
+	MemoryRejected   uint64        `json:"memory-rejected,omitempty"`
+	MemoryEvicted    uint64        `json:"memory-evicted,omitempty"`
 }
 
 // CorrelationData is the data for a correlation-id.
@@ -27,6 +32,34 @@ type CorrelationData struct {
 	AESKeyEncrypted string `json:"aes-key"`
 	// decrypted AES key for signing
 	AESKey []byte `json:"-"`
+	// AESKeyCreatedAt is the time the current AES key was generated
+	AESKeyCreatedAt time.Time `json:"-"`
+	// PublicKey is the client public key used to wrap rotated AES keys
+	PublicKey   *rsa.PublicKey       `json:"-"`
 	ReadOffsets map[string]int       `json:"-"`
 	LastSeen    map[string]time.Time `json:"-"`
+	// Retention is the maximum age of buffered interactions (0 keeps them until polled)
+	Retention time.Duration `json:"-"`
+	// AddedAt holds the time each buffered interaction was added when a retention is set
+	AddedAt []time.Time `json:"-"`
+	// PublicKeys are the public keys registered for the session by fingerprint, including PublicKey
+	PublicKeys map[string]*rsa.PublicKey `json:"-"`
+	// RegisteredAt is the time the correlation ID was registered with its public key
+	RegisteredAt time.Time `json:"-"`
+	// Metadata is the free-form metadata sent by the client at registration
+	Metadata map[string]string `json:"-"`
+	// previousAESKey is the AES key replaced by the last rotation, kept to wrap it
+	// under the additional public keys for the interactions drained before the rotation
+	previousAESKey          []byte
+	previousAESKeyEncrypted string
+	// drainAESKey is the AES key the first drainAESKeyCount interactions buffered on
+	// disk are encrypted with, kept after a rotation until they are drained
+	drainAESKey      []byte
+	drainAESKeyCount int
+	// dataSize is the size in bytes of the buffered interactions
+	dataSize int64
+	// appended and dropped are the sequence numbers of the next interaction buffered
+	// and of the oldest one still buffered, dropped is read without the lock on eviction
+	appended uint64
+	dropped  atomic.Uint64
 }
@@ -6,8 +6,10 @@ import (
 	"crypto/cipher"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/x509"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/pem"
 	"errors"
 	"io"
@@ -39,6 +41,16 @@ func ParseB64RSAPublicKeyFromPEM(pubPEM string) (*rsa.PublicKey, error) {
 	return nil, errors.New("key type is not RSA")
 }
 
+// PublicKeyFingerprint returns the hex encoded sha256 of the PKIX encoding of a public key
+func PublicKeyFingerprint(publicKey *rsa.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:]), nil
+}
+
 // AESEncrypt encrypts a message using AES and puts IV at the beginning of ciphertext.
 func AESEncrypt(key []byte, message []byte) (string, error) {
 	block, err := aes.NewCipher(key)
@@ -58,6 +70,25 @@ func AESEncrypt(key []byte, message []byte) (string, error) {
 	return string(encMessage), nil
 }
 
+// AESDecrypt decrypts a message encrypted by AESEncrypt.
+func AESDecrypt(key []byte, message string) ([]byte, error) {
+	cipherText, err := base64.StdEncoding.DecodeString(message)
+	if err != nil {
+		return nil, err
+	}
+	if len(cipherText) < aes.BlockSize {
+		return nil, errors.New("ciphertext block size is too small")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	plainText := make([]byte, len(cipherText)-aes.BlockSize)
+	stream := cipher.NewCTR(block, cipherText[:aes.BlockSize])
+	stream.XORKeyStream(plainText, cipherText[aes.BlockSize:])
+	return plainText, nil
+}
+
 func AppendMany(sep string, slices ...[]byte) []byte {
 	var final [][]byte
 	for _, slice := range slices {